@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration",
+	Long: `Print the configuration in effect after defaults, the config file and
+flags such as --output-dir are combined.
+
+Secrets are redacted. Settings read from environment variables (*_env) show
+the variable name and whether it is set, never its value.`,
+	RunE: runConfigShow,
+}
+
+var configShowFormat string
+
+func init() {
+	configShowCmd.Flags().StringVar(&configShowFormat, "format", "yaml", "output format: yaml or json")
+	configCmd.AddCommand(configShowCmd)
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	// Not loadConfig: showing the config must not create the data directories
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", i18n.T("errors.config_not_found", cfgFile), err)
+	}
+	cfg.ApplyOutputDir(outputDir)
+
+	effective := cfg.Effective()
+	switch configShowFormat {
+	case "yaml":
+		data, err := yaml.Marshal(effective)
+		if err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		fmt.Print(string(data))
+	case "json":
+		data, err := json.MarshalIndent(effective, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("--format must be yaml or json, got %q", configShowFormat)
+	}
+	return nil
+}
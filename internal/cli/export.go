@@ -2,10 +2,14 @@
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/aligundogdu/matrixmigrate/internal/config"
 	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
 	"github.com/aligundogdu/matrixmigrate/internal/migration"
 )
 
@@ -20,31 +24,78 @@ Available subcommands:
   messages     - Export all messages (posts)`,
 }
 
+// exportForce re-runs an already completed export without asking and runs
+// exports whose prerequisite steps aren't marked completed
+var exportForce bool
+
+// Teams and channels to export, overriding mattermost.include_teams and
+// mattermost.include_channels
+var (
+	exportTeams    []string
+	exportChannels []string
+)
+
+// Message creation time bounds for export messages
+var (
+	exportSince string
+	exportUntil string
+)
+
 var exportAssetsCmd = &cobra.Command{
 	Use:   "assets",
 	Short: "Export users, teams, and channels from Mattermost",
-	Long:  `Export users, teams, and channels from Mattermost database to a compressed JSON file.`,
-	RunE:  runExportAssets,
+	Long: `Export users, teams, and channels from Mattermost database to a compressed JSON file.
+
+Use --team and --channel (by name or ID, repeatable) to export only some
+teams or channels instead of the whole instance. Export memberships with
+the same flags so they match.
+  matrixmigrate export assets --team engineering
+  matrixmigrate export memberships --team engineering
+
+Use --since for a delta export after the initial migration: only users and
+channels created or updated since then are exported, so the next import
+assets only has the new ones to create. It accepts the same times as
+export messages --since. Teams are always exported in full.
+  matrixmigrate export assets --since 2024-06-01`,
+	RunE:  notifying(runExportAssets),
 }
 
 var exportMembershipsCmd = &cobra.Command{
 	Use:   "memberships",
 	Short: "Export team and channel memberships from Mattermost",
 	Long:  `Export team and channel memberships from Mattermost database to a compressed JSON file.`,
-	RunE:  runExportMemberships,
+	RunE:  notifying(runExportMemberships),
 }
 
 var exportMessagesCmd = &cobra.Command{
 	Use:   "messages",
 	Short: "Export all messages from Mattermost",
-	Long:  `Export all messages (posts) from Mattermost database to a compressed JSON file.`,
-	RunE:  runExportMessages,
+	Long: `Export all messages (posts) from Mattermost database to a compressed JSON file.
+
+Use --since and --until to export only messages created in a time window.
+Both accept an RFC 3339 time, a date (YYYY-MM-DD), a Unix epoch in seconds
+or milliseconds, or a number of days before now such as 90d. --since is
+inclusive and --until is exclusive.`,
+	RunE:  notifying(runExportMessages),
 }
 
 func init() {
 	exportCmd.AddCommand(exportAssetsCmd)
 	exportCmd.AddCommand(exportMembershipsCmd)
 	exportCmd.AddCommand(exportMessagesCmd)
+
+	exportAssetsCmd.Flags().BoolVar(&exportForce, "force", false, "export again even if assets were already exported")
+	exportMembershipsCmd.Flags().BoolVar(&exportForce, "force", false, "run even if the prerequisite steps are not marked completed")
+	exportMessagesCmd.Flags().BoolVar(&exportForce, "force", false, "run even if the prerequisite steps are not marked completed")
+
+	for _, cmd := range []*cobra.Command{exportAssetsCmd, exportMembershipsCmd} {
+		cmd.Flags().StringSliceVar(&exportTeams, "team", nil, "export only this team, by name or ID (repeatable)")
+		cmd.Flags().StringSliceVar(&exportChannels, "channel", nil, "export only this channel, by name or ID (repeatable)")
+	}
+
+	exportAssetsCmd.Flags().StringVar(&exportSince, "since", "", "only export users and channels created or updated at or after this time (RFC3339|epoch)")
+	exportMessagesCmd.Flags().StringVar(&exportSince, "since", "", "only export messages created at or after this time (RFC3339|epoch)")
+	exportMessagesCmd.Flags().StringVar(&exportUntil, "until", "", "only export messages created before this time (RFC3339|epoch)")
 }
 
 func runExportAssets(cmd *cobra.Command, args []string) error {
@@ -52,6 +103,12 @@ func runExportAssets(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	applyExportFilter(cfg)
+
+	assetsRange, err := migration.ParseMessageRange(exportSince, "", time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
 
 	printInfo(i18n.T("messages.migration_started"))
 
@@ -61,10 +118,25 @@ func runExportAssets(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create orchestrator: %w", err)
 	}
 	defer orch.Close()
+	orch.SetRunOptions(migration.RunOptions{AssetsSince: assetsRange.Since})
+
+	// A new export replaces the completed one as import input; make sure that's intended
+	state := orch.GetState()
+	if state.IsStepCompleted(migration.StepExportAssets) && !exportForce {
+		printWarning("Assets were already exported to %s", state.GetStepOutputFile(migration.StepExportAssets))
+		printWarning("A new export will become the one used by import assets.")
+		if batch {
+			return fmt.Errorf("export_assets is already completed, use --force to export again")
+		}
+		if !confirm("Export again?") {
+			printInfo("Export cancelled")
+			return nil
+		}
+	}
 
 	// Connect to Mattermost
 	printInfo(i18n.T("progress.connecting", "Mattermost"))
-	if err := orch.ConnectMattermost(); err != nil {
+	if err := orch.ConnectMattermost(connectProgress); err != nil {
 		return err
 	}
 	printSuccess(i18n.T("progress.connected", "Mattermost"))
@@ -79,24 +151,49 @@ func runExportAssets(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	result, err := orch.ExportAssets(progress)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	result, err := orch.ExportAssets(ctx, withMetrics(progress))
 	if err != nil {
-		return err
+		return interrupted(err)
 	}
+	notifyResult = result
 
 	printSuccess(i18n.T("messages.file_saved", result.OutputFile))
 	printInfo(fmt.Sprintf("  Users: %d, Teams: %d, Channels: %d", 
 		result.UsersExported, result.TeamsExported, result.ChannelsExported))
+	if result.UsersExcluded > 0 {
+		printInfo(fmt.Sprintf("  Users excluded by skip_users: %d", result.UsersExcluded))
+	}
 	printSuccess(i18n.T("messages.step_completed", "export_assets"))
 
 	return nil
 }
 
+// applyExportFilter replaces the configured teams and channels with the
+// --team and --channel flags, if given
+func applyExportFilter(cfg *config.Config) {
+	if len(exportTeams) > 0 {
+		cfg.Mattermost.IncludeTeams = exportTeams
+	}
+	if len(exportChannels) > 0 {
+		cfg.Mattermost.IncludeChannels = exportChannels
+	}
+	if len(cfg.Mattermost.IncludeTeams) > 0 {
+		printInfo(fmt.Sprintf("Exporting only teams: %s", strings.Join(cfg.Mattermost.IncludeTeams, ", ")))
+	}
+	if len(cfg.Mattermost.IncludeChannels) > 0 {
+		printInfo(fmt.Sprintf("Exporting only channels: %s", strings.Join(cfg.Mattermost.IncludeChannels, ", ")))
+	}
+}
+
 func runExportMemberships(cmd *cobra.Command, args []string) error {
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
+	applyExportFilter(cfg)
 
 	printInfo(i18n.T("messages.migration_started"))
 
@@ -106,17 +203,16 @@ func runExportMemberships(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create orchestrator: %w", err)
 	}
 	defer orch.Close()
+	orch.SetRunOptions(migration.RunOptions{Force: exportForce})
 
 	// Check prerequisites
-	state := orch.GetState()
-	canRun, reason := state.CanRunStep(migration.StepExportMemberships)
-	if !canRun {
-		return fmt.Errorf("cannot run step: %s", reason)
+	if err := orch.CheckCanRunStep(migration.StepExportMemberships); err != nil {
+		return fmt.Errorf("%w (use --force to run anyway)", err)
 	}
 
 	// Connect to Mattermost
 	printInfo(i18n.T("progress.connecting", "Mattermost"))
-	if err := orch.ConnectMattermost(); err != nil {
+	if err := orch.ConnectMattermost(connectProgress); err != nil {
 		return err
 	}
 	printSuccess(i18n.T("progress.connected", "Mattermost"))
@@ -131,14 +227,21 @@ func runExportMemberships(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	result, err := orch.ExportMemberships(progress)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	result, err := orch.ExportMemberships(ctx, withMetrics(progress))
 	if err != nil {
-		return err
+		return interrupted(err)
 	}
+	notifyResult = result
 
 	printSuccess(i18n.T("messages.file_saved", result.OutputFile))
 	printInfo(fmt.Sprintf("  Team memberships: %d, Channel memberships: %d", 
 		result.TeamMembershipsExported, result.ChannelMembershipsExported))
+	if result.MembershipsExcluded > 0 {
+		printInfo(fmt.Sprintf("  Memberships excluded by skip_users: %d", result.MembershipsExcluded))
+	}
 	printSuccess(i18n.T("messages.step_completed", "export_memberships"))
 
 	return nil
@@ -150,6 +253,11 @@ func runExportMessages(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	msgRange, err := migration.ParseMessageRange(exportSince, exportUntil, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --since/--until: %w", err)
+	}
+
 	printInfo(i18n.T("messages.migration_started"))
 
 	// Create orchestrator
@@ -158,17 +266,16 @@ func runExportMessages(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create orchestrator: %w", err)
 	}
 	defer orch.Close()
+	orch.SetRunOptions(migration.RunOptions{MessageRange: msgRange, Force: exportForce})
 
 	// Check prerequisites
-	state := orch.GetState()
-	canRun, reason := state.CanRunStep(migration.StepExportMessages)
-	if !canRun {
-		return fmt.Errorf("cannot run step: %s", reason)
+	if err := orch.CheckCanRunStep(migration.StepExportMessages); err != nil {
+		return fmt.Errorf("%w (use --force to run anyway)", err)
 	}
 
 	// Connect to Mattermost
 	printInfo(i18n.T("progress.connecting", "Mattermost"))
-	if err := orch.ConnectMattermost(); err != nil {
+	if err := orch.ConnectMattermost(connectProgress); err != nil {
 		return err
 	}
 	printSuccess(i18n.T("progress.connected", "Mattermost"))
@@ -183,13 +290,23 @@ func runExportMessages(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	result, err := orch.ExportMessages(progress)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	result, err := orch.ExportMessages(ctx, matrix.ImportProgressCallback(withMetrics(progress)))
 	if err != nil {
-		return err
+		return interrupted(err)
 	}
+	notifyResult = result
 
 	printSuccess(i18n.T("messages.file_saved", result.OutputFile))
 	printInfo(fmt.Sprintf("  Messages exported: %d", result.MessagesExported))
+	if !msgRange.IsZero() {
+		printInfo(fmt.Sprintf("  Range: %s", msgRange))
+	}
+	if result.MessagesExcluded > 0 {
+		printInfo(fmt.Sprintf("  Messages excluded by skip_users: %d", result.MessagesExcluded))
+	}
 	printInfo(fmt.Sprintf("  Files exported: %d", result.FilesExported))
 	printSuccess(i18n.T("messages.step_completed", "export_messages"))
 
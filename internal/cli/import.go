@@ -2,10 +2,13 @@
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/aligundogdu/matrixmigrate/internal/config"
 	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
 	"github.com/aligundogdu/matrixmigrate/internal/migration"
 )
 
@@ -23,17 +26,58 @@ Available subcommands:
 var importAssetsCmd = &cobra.Command{
 	Use:   "assets",
 	Short: "Import users, spaces, and rooms to Matrix",
-	Long:  `Create users, spaces, and rooms in Matrix based on exported Mattermost data.`,
-	RunE:  runImportAssets,
+	Long: `Create users, spaces, and rooms in Matrix based on exported Mattermost data.
+
+Use --only to import a subset of asset types, e.g. to provision accounts first:
+  matrixmigrate import assets --only users
+
+Or leave out single phases with --skip-users, --skip-spaces and --skip-rooms,
+e.g. when accounts are provisioned by another process:
+  matrixmigrate import assets --skip-users
+
+Skipped phases keep their entries from the previous mapping, so the new
+mapping stays complete. With --skip-users, users that already exist on the
+homeserver under their Mattermost username are added to the mapping.
+
+Use --update-existing to sync renamed or re-described channels to rooms
+that were imported in an earlier run.`,
+	RunE:  notifying(runImportAssets),
 }
 
+var (
+	importOnly           []string
+	importUpdateExisting bool
+	importSkipUsers      bool
+	importSkipSpaces     bool
+	importSkipRooms      bool
+)
+
+// importForce runs an import step even if its prerequisites aren't completed
+var importForce bool
+
 var importMembershipsCmd = &cobra.Command{
 	Use:   "memberships",
 	Short: "Apply memberships in Matrix",
-	Long:  `Add users to spaces and rooms in Matrix based on Mattermost memberships.`,
-	RunE:  runImportMemberships,
+	Long: `Add users to spaces and rooms in Matrix based on Mattermost memberships.
+
+Use --delta to keep Matrix in sync while both servers are in use: the
+current memberships are read from Mattermost and compared with the last
+export, and only the changes are applied. Users who joined a team or
+channel are invited, users who left are removed from the space or room.
+  matrixmigrate import memberships --delta
+
+Use --validate-mapping to first check that every mapped space and room
+still exists. Rooms deleted after the asset import are listed up front
+instead of failing each invite.`,
+	RunE:  notifying(runImportMemberships),
 }
 
+// importDelta syncs membership changes since the last export
+var importDelta bool
+
+// importValidateMapping checks the mapped rooms before importing memberships
+var importValidateMapping bool
+
 var importMessagesCmd = &cobra.Command{
 	Use:   "messages",
 	Short: "Import messages to Matrix",
@@ -43,17 +87,106 @@ This command requires Application Service (AS) configuration to preserve
 original message timestamps. Without AS, messages will be imported with
 current timestamps.
 
-Requires: appservice.enabled=true and MATRIX_AS_TOKEN env var`,
-	RunE:  runImportMessages,
+Requires: appservice.enabled=true and MATRIX_AS_TOKEN env var
+
+Messages already in the message mapping are always skipped, so an
+interrupted import can simply be run again. Use --resume to make sure it
+continues: it fails instead of starting over when no mapping is found.
+  matrixmigrate import messages --resume`,
+	RunE:  notifying(runImportMessages),
 }
 
+// importResume requires the message mapping of an earlier import
+var importResume bool
+
 func init() {
 	importCmd.AddCommand(importAssetsCmd)
 	importCmd.AddCommand(importMembershipsCmd)
 	importCmd.AddCommand(importMessagesCmd)
+
+	importAssetsCmd.Flags().StringSliceVar(&importOnly, "only", nil, "import only these asset types (users, spaces, rooms)")
+	importAssetsCmd.Flags().BoolVar(&importUpdateExisting, "update-existing", false, "update name, topic and history visibility of already imported rooms")
+	importAssetsCmd.Flags().BoolVar(&importSkipUsers, "skip-users", false, "don't create users; map those that already exist on the homeserver")
+	importAssetsCmd.Flags().BoolVar(&importSkipSpaces, "skip-spaces", false, "don't create spaces for teams")
+	importAssetsCmd.Flags().BoolVar(&importSkipRooms, "skip-rooms", false, "don't create rooms for channels")
+	importMembershipsCmd.Flags().BoolVar(&importDelta, "delta", false, "apply only the membership changes since the last export, including removals")
+	importMembershipsCmd.Flags().BoolVar(&importValidateMapping, "validate-mapping", false, "check that every mapped space and room still exists before inviting anyone")
+	importMessagesCmd.Flags().BoolVar(&importResume, "resume", false, "continue an interrupted import; fail if there is no message mapping to resume from")
+
+	for _, cmd := range []*cobra.Command{importAssetsCmd, importMembershipsCmd, importMessagesCmd} {
+		cmd.Flags().BoolVar(&importForce, "force", false, "run even if the prerequisite steps are not marked completed")
+	}
+}
+
+// confirmImport shows what an import is about to change and asks to go on.
+// --yes and --dry-run skip the question; batch mode can't ask, so it needs
+// --yes. It returns false, with an error in batch mode, if the import
+// should not run.
+func confirmImport(summary string) (bool, error) {
+	if assumeYes || dryRun {
+		return true, nil
+	}
+	printWarning("%s", summary)
+	if batch {
+		return false, fmt.Errorf("imports change the homeserver; use --yes to run them in batch mode")
+	}
+	if !confirm(i18n.T("messages.confirm_proceed")) {
+		printInfo("Import cancelled")
+		return false, nil
+	}
+	return true, nil
+}
+
+// parseImportOnly converts the --only flag into importer options
+func parseImportOnly(only []string) (matrix.ImportAssetsOptions, error) {
+	if len(only) == 0 {
+		return matrix.DefaultImportAssetsOptions(), nil
+	}
+
+	var opts matrix.ImportAssetsOptions
+	for _, item := range only {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "users":
+			opts.Users = true
+		case "spaces":
+			opts.Spaces = true
+		case "rooms":
+			opts.Rooms = true
+		default:
+			return opts, fmt.Errorf("invalid --only value %q (expected users, spaces or rooms)", item)
+		}
+	}
+	return opts, nil
+}
+
+// applyImportSkips removes the phases given with --skip-* from the options
+func applyImportSkips(opts matrix.ImportAssetsOptions) (matrix.ImportAssetsOptions, error) {
+	if importSkipUsers {
+		// Users provisioned elsewhere are still mapped if they exist
+		opts.Users = false
+		opts.LinkExistingUsers = true
+	}
+	if importSkipSpaces {
+		opts.Spaces = false
+	}
+	if importSkipRooms {
+		opts.Rooms = false
+	}
+	if !opts.Users && !opts.Spaces && !opts.Rooms {
+		return opts, fmt.Errorf("--only and --skip-* leave nothing to import")
+	}
+	return opts, nil
 }
 
 func runImportAssets(cmd *cobra.Command, args []string) error {
+	opts, err := parseImportOnly(importOnly)
+	if err != nil {
+		return err
+	}
+	if opts, err = applyImportSkips(opts); err != nil {
+		return err
+	}
+
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
@@ -67,17 +200,28 @@ func runImportAssets(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create orchestrator: %w", err)
 	}
 	defer orch.Close()
+	orch.SetRunOptions(migration.RunOptions{UpdateExisting: importUpdateExisting, Force: importForce, DryRun: dryRun})
 
 	// Check prerequisites
-	state := orch.GetState()
-	canRun, reason := state.CanRunStep(migration.StepImportAssets)
-	if !canRun {
-		return fmt.Errorf("cannot run step: %s", reason)
+	if err := orch.CheckCanRunStep(migration.StepImportAssets); err != nil {
+		return fmt.Errorf("%w (use --force to run anyway)", err)
 	}
 
+	summary := fmt.Sprintf("This imports assets to %s.", cfg.Matrix.Homeserver)
+	if plan, err := orch.PlanAssets(opts); err == nil {
+		summary = fmt.Sprintf("About to create %d users, %d spaces and %d rooms on %s (%d users, %d spaces, %d rooms already imported).",
+			plan.Users, plan.Spaces, plan.Rooms, cfg.Matrix.Homeserver, plan.UsersMapped, plan.SpacesMapped, plan.RoomsMapped)
+	}
+	if ok, err := confirmImport(summary); !ok {
+		return err
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
 	// Connect to Matrix
 	printInfo(i18n.T("progress.connecting", "Matrix"))
-	if err := orch.ConnectMatrix(); err != nil {
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
 		return err
 	}
 	printSuccess(i18n.T("progress.connected", "Matrix"))
@@ -92,23 +236,77 @@ func runImportAssets(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	result, err := orch.ImportAssets(progress)
+	result, err := orch.ImportAssetsWithOptions(ctx, opts, withMetrics(progress))
 	if err != nil {
-		return err
+		return interrupted(err)
 	}
+	notifyResult = result
 
-	printSuccess(i18n.T("messages.mapping_saved", result.OutputFile))
+	if result.DryRun {
+		printWarning("Dry run: nothing was changed on the homeserver and no mapping was saved; counts show what the import would do")
+	} else {
+		printSuccess(i18n.T("messages.mapping_saved", result.OutputFile))
+	}
 	printInfo(fmt.Sprintf("  Users: created=%d, skipped=%d, failed=%d", 
 		result.UsersCreated, result.UsersSkipped, result.UsersFailed))
+	if result.UsersDeactivated > 0 {
+		printInfo(fmt.Sprintf("  Users created deactivated (deleted in Mattermost): %d", result.UsersDeactivated))
+	}
+	if result.UsersInvalid > 0 {
+		printInfo(fmt.Sprintf("  Users skipped with invalid usernames: %d (see the log for details)", result.UsersInvalid))
+	}
+	if result.UsersDuplicateEmail > 0 {
+		printInfo(fmt.Sprintf("  Users with a duplicate email: %d (duplicate_email_policy: %s)",
+			result.UsersDuplicateEmail, cfg.Matrix.Users.DuplicateEmailPolicy))
+	}
+	if result.UsersExcluded > 0 {
+		printInfo(fmt.Sprintf("  Users excluded by skip_users: %d", result.UsersExcluded))
+	}
 	printInfo(fmt.Sprintf("  Spaces: created=%d, skipped=%d, failed=%d", 
 		result.SpacesCreated, result.SpacesSkipped, result.SpacesFailed))
 	printInfo(fmt.Sprintf("  Rooms: created=%d, skipped=%d, failed=%d, linked=%d", 
 		result.RoomsCreated, result.RoomsSkipped, result.RoomsFailed, result.RoomsLinked))
+	if result.RoomsSkippedEmpty > 0 {
+		printInfo(fmt.Sprintf("  Rooms skipped without messages: %d (skip_empty_channels)", result.RoomsSkippedEmpty))
+	}
+	if result.DirectRoomsCreated > 0 {
+		printInfo(fmt.Sprintf("  Direct message rooms created: %d", result.DirectRoomsCreated))
+	}
+	if importUpdateExisting {
+		printInfo(fmt.Sprintf("  Rooms updated: %d", result.RoomsUpdated))
+	}
+	printReconciliation(result.Reconciliation)
+	if result.DryRun {
+		return nil
+	}
+	if result.Partial {
+		printWarning("Partial import: run 'import assets' again for the remaining asset types to complete this step")
+		return nil
+	}
 	printSuccess(i18n.T("messages.step_completed", "import_assets"))
 
 	return nil
 }
 
+// printReconciliation prints the Mattermost and Matrix counts side by side,
+// warning about items that didn't make it
+func printReconciliation(rows []migration.ReconcileRow) {
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Println()
+	printInfo("Reconciliation (Mattermost -> Matrix):")
+	fmt.Printf("  %-8s %10s %10s %10s %10s\n", "", "Mattermost", "Excluded", "In Matrix", "Missing")
+	for _, row := range rows {
+		fmt.Printf("  %-8s %10d %10d %10d %10d\n", row.Kind, row.Source, row.Excluded, row.Migrated, row.Missing())
+	}
+	for _, row := range rows {
+		if missing := row.Missing(); missing > 0 {
+			printWarning(fmt.Sprintf("%d %s are not in Matrix; see the log for the failed or skipped ones", missing, row.Kind))
+		}
+	}
+}
+
 func runImportMemberships(cmd *cobra.Command, args []string) error {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -123,17 +321,32 @@ func runImportMemberships(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create orchestrator: %w", err)
 	}
 	defer orch.Close()
+	orch.SetRunOptions(migration.RunOptions{Force: importForce, DryRun: dryRun, ValidateMapping: importValidateMapping})
+
+	if importDelta {
+		return runSyncMemberships(cfg, orch)
+	}
 
 	// Check prerequisites
-	state := orch.GetState()
-	canRun, reason := state.CanRunStep(migration.StepImportMemberships)
-	if !canRun {
-		return fmt.Errorf("cannot run step: %s", reason)
+	if err := orch.CheckCanRunStep(migration.StepImportMemberships); err != nil {
+		return fmt.Errorf("%w (use --force to run anyway)", err)
+	}
+
+	summary := fmt.Sprintf("This applies memberships on %s.", cfg.Matrix.Homeserver)
+	if plan, err := orch.PlanMemberships(); err == nil {
+		summary = fmt.Sprintf("About to apply %d team and %d channel memberships on %s.",
+			plan.TeamMappable, plan.ChannelMappable, cfg.Matrix.Homeserver)
+	}
+	if ok, err := confirmImport(summary); !ok {
+		return err
 	}
 
+	ctx, stop := interruptContext()
+	defer stop()
+
 	// Connect to Matrix
 	printInfo(i18n.T("progress.connecting", "Matrix"))
-	if err := orch.ConnectMatrix(); err != nil {
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
 		return err
 	}
 	printSuccess(i18n.T("progress.connected", "Matrix"))
@@ -148,19 +361,75 @@ func runImportMemberships(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	result, err := orch.ImportMemberships(progress)
+	result, err := orch.ImportMemberships(ctx, withMetrics(progress))
 	if err != nil {
-		return err
+		return interrupted(err)
 	}
+	notifyResult = result
 
-	printInfo(fmt.Sprintf("  Members: added=%d, skipped=%d, failed=%d", 
-		result.MembersAdded, result.MembersSkipped, result.MembersFailed))
+	printInfo(fmt.Sprintf("  Members: added=%d, skipped=%d, failed=%d, admins promoted=%d", 
+		result.MembersAdded, result.MembersSkipped, result.MembersFailed, result.MembersPromoted))
+	if result.DryRun {
+		printWarning("Dry run: nothing was changed on the homeserver; counts show what the import would do")
+		return nil
+	}
 	printSuccess(i18n.T("messages.step_completed", "import_memberships"))
 	printSuccess(i18n.T("messages.migration_completed"))
 
 	return nil
 }
 
+// runSyncMemberships applies the membership changes since the last export
+func runSyncMemberships(cfg *config.Config, orch *migration.Orchestrator) error {
+	summary := fmt.Sprintf("This adds and removes members on %s to match Mattermost.", cfg.Matrix.Homeserver)
+	if ok, err := confirmImport(summary); !ok {
+		return err
+	}
+
+	printInfo(i18n.T("progress.connecting", "Mattermost"))
+	if err := orch.ConnectMattermost(connectProgress); err != nil {
+		return err
+	}
+	printSuccess(i18n.T("progress.connected", "Mattermost"))
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	printInfo(i18n.T("progress.connecting", "Matrix"))
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
+		return err
+	}
+	printSuccess(i18n.T("progress.connected", "Matrix"))
+
+	progress := func(stage string, current, total int, item string) {
+		if total > 0 {
+			printProgress("%s: %d/%d", stage, current, total)
+		} else {
+			printProgress("%s...", stage)
+		}
+	}
+
+	result, err := orch.SyncMemberships(ctx, withMetrics(progress))
+	if err != nil {
+		return interrupted(err)
+	}
+	notifyResult = result
+
+	printInfo(fmt.Sprintf("  Members: added=%d, removed=%d, skipped=%d, failed=%d, admins promoted=%d",
+		result.MembersAdded, result.MembersRemoved, result.MembersSkipped, result.MembersFailed, result.MembersPromoted))
+	if result.DryRun {
+		printWarning("Dry run: nothing was changed on the homeserver; counts show what the sync would do")
+		return nil
+	}
+	if result.MembersFailed > 0 {
+		printWarning("Some changes failed; run the sync again to retry them (see the log for details)")
+		return nil
+	}
+	printSuccess(fmt.Sprintf("Memberships in sync; the next sync compares with %s", result.OutputFile))
+
+	return nil
+}
+
 func runImportMessages(cmd *cobra.Command, args []string) error {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -181,17 +450,19 @@ func runImportMessages(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create orchestrator: %w", err)
 	}
 	defer orch.Close()
+	orch.SetRunOptions(migration.RunOptions{Force: importForce, DryRun: dryRun, Resume: importResume})
 
 	// Check prerequisites
-	state := orch.GetState()
-	canRun, reason := state.CanRunStep(migration.StepImportMessages)
-	if !canRun {
-		return fmt.Errorf("cannot run step: %s", reason)
+	if err := orch.CheckCanRunStep(migration.StepImportMessages); err != nil {
+		return fmt.Errorf("%w (use --force to run anyway)", err)
 	}
 
+	ctx, stop := interruptContext()
+	defer stop()
+
 	// Connect to Matrix
 	printInfo(i18n.T("progress.connecting", "Matrix"))
-	if err := orch.ConnectMatrix(); err != nil {
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
 		return err
 	}
 	printSuccess(i18n.T("progress.connected", "Matrix"))
@@ -203,17 +474,27 @@ func runImportMessages(cmd *cobra.Command, args []string) error {
 		printProgress("Messages: %d/%d (%.1f%%) - %s", current, total, percent, status)
 	}
 
-	result, err := orch.ImportMessages(progress)
+	result, err := orch.ImportMessages(ctx, withMessageMetrics(progress))
 	if err != nil {
-		return err
+		return interrupted(err)
 	}
+	notifyResult = result
 
-	printInfo(fmt.Sprintf("  Messages: imported=%d, skipped=%d, failed=%d", 
+	printInfo(fmt.Sprintf("  Messages: imported=%d, skipped (already imported)=%d, failed=%d", 
 		result.MessagesImported, result.MessagesSkipped, result.MessagesFailed))
 	printInfo(fmt.Sprintf("  Replies: imported=%d, failed=%d", 
 		result.RepliesImported, result.RepliesFailed))
-	printInfo(fmt.Sprintf("  Files: linked=%d, uploaded=%d, skipped=%d",
-		result.FilesLinked, result.FilesUploaded, result.FilesSkipped))
+	printInfo(fmt.Sprintf("  Files: linked=%d, uploaded=%d, skipped=%d, failed=%d",
+		result.FilesLinked, result.FilesUploaded, result.FilesSkipped, result.FilesFailed))
+	if result.MessagesOversize > 0 {
+		printInfo(fmt.Sprintf("  Oversized messages (%s): %d", cfg.GetOversizePolicy(), result.MessagesOversize))
+	}
+	if result.MessagesEncrypted > 0 {
+		printWarning(fmt.Sprintf("  Messages skipped in encrypted rooms: %d (%d rooms)", result.MessagesEncrypted, result.RoomsEncrypted))
+	}
+	if result.PinnedEvents > 0 {
+		printInfo(fmt.Sprintf("  Pinned events restored: %d", result.PinnedEvents))
+	}
 	
 	if result.MappingFile != "" {
 		printSuccess(i18n.T("messages.mapping_saved", result.MappingFile))
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect FILE",
+	Short: "Show the records of an export file",
+	Long: `Decode an export file (.json.gz) and print its records, without having to
+gunzip it and query it by hand. Asset, membership and message exports are
+all understood.
+
+Without a flag a summary of the file is printed. --users, --teams and
+--channels list those records; --team limits channels to one team (ID or
+name). --id looks a record up by ID, username, email or name and shows
+what the file holds about it, e.g. a user's memberships and post count.
+
+inspect only reads the file; no configuration or connection is needed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInspect,
+}
+
+var (
+	inspectUsers    bool
+	inspectTeams    bool
+	inspectChannels bool
+	inspectTeam     string
+	inspectID       string
+	inspectLimit    int
+	inspectJSON     bool
+)
+
+func init() {
+	inspectCmd.Flags().BoolVar(&inspectUsers, "users", false, "list users")
+	inspectCmd.Flags().BoolVar(&inspectTeams, "teams", false, "list teams")
+	inspectCmd.Flags().BoolVar(&inspectChannels, "channels", false, "list channels")
+	inspectCmd.Flags().StringVar(&inspectTeam, "team", "", "only list channels of this team (ID or name)")
+	inspectCmd.Flags().StringVar(&inspectID, "id", "", "find records by ID, username, email or name")
+	inspectCmd.Flags().IntVar(&inspectLimit, "limit", 0, "max records listed per kind (0: all)")
+	inspectCmd.Flags().BoolVar(&inspectJSON, "json", false, "print the selected records as JSON")
+}
+
+// inspectExport holds the records of any export file; kinds the file
+// doesn't contain stay empty
+type inspectExport struct {
+	ExportedAt     int64                      `json:"exported_at"`
+	Version        string                     `json:"version"`
+	Users          []mattermost.User          `json:"users,omitempty"`
+	Teams          []mattermost.Team          `json:"teams,omitempty"`
+	Channels       []mattermost.Channel       `json:"channels,omitempty"`
+	TeamMembers    []mattermost.TeamMember    `json:"team_members,omitempty"`
+	ChannelMembers []mattermost.ChannelMember `json:"channel_members,omitempty"`
+	Posts          []mattermost.Post          `json:"posts,omitempty"`
+	Files          []mattermost.FileInfo      `json:"files,omitempty"`
+	Emojis         []mattermost.Emoji         `json:"emojis,omitempty"`
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	if inspectLimit < 0 {
+		return fmt.Errorf("--limit must not be negative")
+	}
+
+	var export inspectExport
+	if err := archive.LoadGzipJSON(args[0], &export); err != nil {
+		return fmt.Errorf("failed to load %s: %w", args[0], err)
+	}
+
+	selected := &inspectExport{ExportedAt: export.ExportedAt, Version: export.Version}
+	switch {
+	case inspectID != "":
+		selected = export.find(inspectID)
+	case inspectUsers || inspectTeams || inspectChannels || inspectTeam != "":
+		if inspectUsers {
+			selected.Users = export.Users
+		}
+		if inspectTeams {
+			selected.Teams = export.Teams
+		}
+		if inspectChannels || inspectTeam != "" {
+			channels, err := export.channelsOfTeam(inspectTeam)
+			if err != nil {
+				return err
+			}
+			selected.Channels = channels
+		}
+	default:
+		if inspectJSON {
+			return fmt.Errorf("--json needs --users, --teams, --channels or --id")
+		}
+		export.printSummary(args[0])
+		return nil
+	}
+	selected.limit(inspectLimit)
+
+	if inspectJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(selected)
+	}
+
+	if inspectID != "" && selected.empty() {
+		return fmt.Errorf("no record matches %q in %s", inspectID, args[0])
+	}
+	export.printRecords(selected)
+	return nil
+}
+
+// printSummary prints what kind of export the file is and how many records it holds
+func (e *inspectExport) printSummary(file string) {
+	printInfo("%s", file)
+	if e.ExportedAt > 0 {
+		fmt.Printf("  Exported:  %s (version %s)\n", time.UnixMilli(e.ExportedAt).Format(time.RFC3339), e.Version)
+	}
+	counts := []struct {
+		name string
+		n    int
+	}{
+		{"Users", len(e.Users)},
+		{"Teams", len(e.Teams)},
+		{"Channels", len(e.Channels)},
+		{"Team members", len(e.TeamMembers)},
+		{"Channel members", len(e.ChannelMembers)},
+		{"Posts", len(e.Posts)},
+		{"Files", len(e.Files)},
+		{"Custom emojis", len(e.Emojis)},
+	}
+	for _, c := range counts {
+		if c.n > 0 {
+			fmt.Printf("  %-16s %d\n", c.name+":", c.n)
+		}
+	}
+	if e.empty() {
+		printWarning("The file holds no known records; is it a matrixmigrate export?")
+	}
+}
+
+// find returns the records whose ID, username, email or name is key, along
+// with the memberships and posts that refer to them
+func (e *inspectExport) find(key string) *inspectExport {
+	found := &inspectExport{ExportedAt: e.ExportedAt, Version: e.Version}
+	ids := make(map[string]bool)
+
+	for _, user := range e.Users {
+		if user.ID == key || strings.EqualFold(user.Username, key) || strings.EqualFold(user.Email, key) {
+			found.Users = append(found.Users, user)
+			ids[user.ID] = true
+		}
+	}
+	for _, team := range e.Teams {
+		if team.ID == key || strings.EqualFold(team.Name, key) {
+			found.Teams = append(found.Teams, team)
+			ids[team.ID] = true
+		}
+	}
+	for _, channel := range e.Channels {
+		if channel.ID == key || strings.EqualFold(channel.Name, key) {
+			found.Channels = append(found.Channels, channel)
+			ids[channel.ID] = true
+		}
+	}
+	// Membership and message exports only know IDs
+	ids[key] = true
+
+	for _, member := range e.TeamMembers {
+		if ids[member.TeamID] || ids[member.UserID] {
+			found.TeamMembers = append(found.TeamMembers, member)
+		}
+	}
+	for _, member := range e.ChannelMembers {
+		if ids[member.ChannelID] || ids[member.UserID] {
+			found.ChannelMembers = append(found.ChannelMembers, member)
+		}
+	}
+	for _, post := range e.Posts {
+		if post.ID == key || ids[post.UserID] || ids[post.ChannelID] {
+			found.Posts = append(found.Posts, post)
+		}
+	}
+	for _, file := range e.Files {
+		if file.ID == key || file.PostID == key {
+			found.Files = append(found.Files, file)
+		}
+	}
+	return found
+}
+
+// channelsOfTeam returns the channels of a team given by ID or name; an
+// empty team returns all channels
+func (e *inspectExport) channelsOfTeam(team string) ([]mattermost.Channel, error) {
+	if team == "" {
+		return e.Channels, nil
+	}
+
+	teamID := ""
+	for _, t := range e.Teams {
+		if t.ID == team || strings.EqualFold(t.Name, team) {
+			teamID = t.ID
+			break
+		}
+	}
+	if teamID == "" {
+		// Teams may have been left out of the file; treat the value as an ID
+		if len(e.Teams) > 0 {
+			return nil, fmt.Errorf("no team %q in the file", team)
+		}
+		teamID = team
+	}
+
+	var channels []mattermost.Channel
+	for _, channel := range e.Channels {
+		if channel.TeamID == teamID {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+// limit keeps at most n records of each kind; 0 keeps all
+func (e *inspectExport) limit(n int) {
+	if n == 0 {
+		return
+	}
+	e.Users = e.Users[:min(n, len(e.Users))]
+	e.Teams = e.Teams[:min(n, len(e.Teams))]
+	e.Channels = e.Channels[:min(n, len(e.Channels))]
+	e.TeamMembers = e.TeamMembers[:min(n, len(e.TeamMembers))]
+	e.ChannelMembers = e.ChannelMembers[:min(n, len(e.ChannelMembers))]
+	e.Posts = e.Posts[:min(n, len(e.Posts))]
+	e.Files = e.Files[:min(n, len(e.Files))]
+}
+
+// empty returns true if the export holds no records
+func (e *inspectExport) empty() bool {
+	return len(e.Users)+len(e.Teams)+len(e.Channels)+len(e.TeamMembers)+
+		len(e.ChannelMembers)+len(e.Posts)+len(e.Files) == 0
+}
+
+// printRecords prints the selected records, resolving IDs to names where
+// the full export knows them
+func (e *inspectExport) printRecords(selected *inspectExport) {
+	usernames := make(map[string]string, len(e.Users))
+	for _, user := range e.Users {
+		usernames[user.ID] = user.Username
+	}
+	teamNames := make(map[string]string, len(e.Teams))
+	for _, team := range e.Teams {
+		teamNames[team.ID] = team.Name
+	}
+	channelNames := make(map[string]string, len(e.Channels))
+	for _, channel := range e.Channels {
+		channelNames[channel.ID] = channel.Name
+	}
+	name := func(names map[string]string, id string) string {
+		if n, ok := names[id]; ok {
+			return fmt.Sprintf("%s (%s)", n, id)
+		}
+		return id
+	}
+
+	if len(selected.Users) > 0 {
+		printInfo("Users: %d", len(selected.Users))
+		for _, user := range selected.Users {
+			flags := ""
+			if user.IsDeleted() {
+				flags += " [deleted]"
+			}
+			if user.AuthService != "" {
+				flags += " [sso:" + user.AuthService + "]"
+			}
+			if strings.Contains(user.Roles, "system_admin") {
+				flags += " [admin]"
+			}
+			fmt.Printf("  %-26s %-24s %s%s\n", user.ID, user.Username, user.Email, flags)
+		}
+	}
+
+	if len(selected.Teams) > 0 {
+		printInfo("Teams: %d", len(selected.Teams))
+		for _, team := range selected.Teams {
+			flags := ""
+			if team.IsDeleted() {
+				flags += " [deleted]"
+			}
+			if team.IsOpen() {
+				flags += " [open]"
+			} else if team.IsOpenInvite() {
+				flags += " [open invite]"
+			}
+			fmt.Printf("  %-26s %-24s %s%s\n", team.ID, team.Name, team.DisplayName, flags)
+		}
+	}
+
+	if len(selected.Channels) > 0 {
+		printInfo("Channels: %d", len(selected.Channels))
+		for _, channel := range selected.Channels {
+			flags := ""
+			if channel.IsDeleted() {
+				flags += " [deleted]"
+			}
+			if !channel.IsPublic() {
+				flags += " [type " + channel.Type + "]"
+			}
+			team := "-"
+			if channel.TeamID != "" {
+				team = name(teamNames, channel.TeamID)
+			}
+			fmt.Printf("  %-26s %-24s team %s, %d messages%s\n", channel.ID, channel.Name, team, channel.TotalMsgCount, flags)
+		}
+	}
+
+	if len(selected.TeamMembers) > 0 {
+		printInfo("Team memberships: %d", len(selected.TeamMembers))
+		for _, member := range selected.TeamMembers {
+			flags := ""
+			if member.IsDeleted() {
+				flags += " [left]"
+			}
+			if member.IsAdmin() {
+				flags += " [admin]"
+			}
+			fmt.Printf("  %s in %s%s\n", name(usernames, member.UserID), name(teamNames, member.TeamID), flags)
+		}
+	}
+
+	if len(selected.ChannelMembers) > 0 {
+		printInfo("Channel memberships: %d", len(selected.ChannelMembers))
+		for _, member := range selected.ChannelMembers {
+			flags := ""
+			if member.IsAdmin() {
+				flags += " [admin]"
+			}
+			fmt.Printf("  %s in %s%s\n", name(usernames, member.UserID), name(channelNames, member.ChannelID), flags)
+		}
+	}
+
+	if len(selected.Posts) > 0 {
+		printInfo("Posts: %d", len(selected.Posts))
+		for _, post := range selected.Posts {
+			message := strings.ReplaceAll(post.Message, "\n", " ")
+			if len([]rune(message)) > 60 {
+				message = string([]rune(message)[:60]) + "…"
+			}
+			fmt.Printf("  %-26s %s %s in %s: %s\n", post.ID, time.UnixMilli(post.CreateAt).Format("2006-01-02 15:04"),
+				name(usernames, post.UserID), name(channelNames, post.ChannelID), message)
+		}
+	}
+
+	if len(selected.Files) > 0 {
+		printInfo("Files: %d", len(selected.Files))
+		for _, file := range selected.Files {
+			fmt.Printf("  %-26s %s (%d bytes) post %s\n", file.ID, file.Name, file.Size, file.PostID)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+var mergeAssetsCmd = &cobra.Command{
+	Use:   "merge-assets FILE FILE... -o OUTPUT",
+	Short: "Merge asset exports of several Mattermost instances",
+	Long: `Merge the asset exports (users, teams, channels) of several Mattermost
+instances into one file for import assets.
+
+The same record found in several files is kept once. Different records with
+the same ID are collisions; the merge fails until they are resolved, e.g. by
+giving each file an ID prefix with --prefix (one per file, in order). Prefixed
+IDs no longer match the membership and message exports of that file.
+
+With --use the merged file becomes the export assets output, so the next
+import assets imports it.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runMergeAssets,
+}
+
+var (
+	mergeOutput   string
+	mergePrefixes []string
+	mergeUse      bool
+)
+
+func init() {
+	mergeAssetsCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "merged asset file to write (.json.gz)")
+	mergeAssetsCmd.Flags().StringSliceVar(&mergePrefixes, "prefix", nil, "ID prefix per input file, in order (e.g. a_,b_)")
+	mergeAssetsCmd.Flags().BoolVar(&mergeUse, "use", false, "use the merged file as the export assets output")
+	mergeAssetsCmd.MarkFlagRequired("output")
+}
+
+func runMergeAssets(cmd *cobra.Command, args []string) error {
+	if len(mergePrefixes) > 0 && len(mergePrefixes) != len(args) {
+		return fmt.Errorf("--prefix needs one prefix per input file (%d files, %d prefixes)", len(args), len(mergePrefixes))
+	}
+
+	sources := make([]mattermost.AssetSource, 0, len(args))
+	for i, file := range args {
+		var assets mattermost.Assets
+		if err := archive.LoadGzipJSON(file, &assets); err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		src := mattermost.AssetSource{Name: filepath.Base(file), Assets: &assets}
+		if len(mergePrefixes) > 0 {
+			src.Prefix = mergePrefixes[i]
+		}
+		printInfo(fmt.Sprintf("%s: %d users, %d teams, %d channels",
+			src.Name, len(assets.Users), len(assets.Teams), len(assets.Channels)))
+		sources = append(sources, src)
+	}
+
+	merged, report := mattermost.MergeAssets(sources)
+
+	for _, conflict := range report.NameConflicts {
+		printWarning("%s", conflict)
+	}
+	if len(report.Collisions) > 0 {
+		for _, collision := range report.Collisions {
+			printError("%s", collision)
+		}
+		return fmt.Errorf("%d ID collisions, nothing written; use --prefix to keep the sources apart", len(report.Collisions))
+	}
+
+	if err := archive.SaveGzipJSON(mergeOutput, merged); err != nil {
+		return fmt.Errorf("failed to save merged assets: %w", err)
+	}
+
+	printSuccess("Merged assets saved: %s", mergeOutput)
+	printInfo(fmt.Sprintf("  Users: %d, Teams: %d, Channels: %d",
+		len(merged.Users), len(merged.Teams), len(merged.Channels)))
+	if report.Duplicates > 0 {
+		printInfo(fmt.Sprintf("  Duplicates kept once: %d", report.Duplicates))
+	}
+	if len(report.NameConflicts) > 0 {
+		printWarning("%d name conflicts: these records would map to the same Matrix user or space", len(report.NameConflicts))
+	}
+
+	if !mergeUse {
+		return nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	orch, err := migration.NewOrchestrator(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create orchestrator: %w", err)
+	}
+	defer orch.Close()
+
+	outputFile, err := filepath.Abs(mergeOutput)
+	if err != nil {
+		return err
+	}
+	orch.GetState().CompleteStep(migration.StepExportAssets, outputFile)
+	if err := orch.SaveState(); err != nil {
+		return err
+	}
+	printSuccess("import assets will now use %s", outputFile)
+	return nil
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+// defaultMetricsLinger covers at least one scrape at Prometheus' default interval
+const defaultMetricsLinger = time.Minute
+
+// metricsAddr is the listen address of the metrics endpoint (empty: disabled)
+var metricsAddr string
+
+// metricsLinger is how long the metrics endpoint stays up after the command finishes
+var metricsLinger time.Duration
+
+// metrics collects progress for the metrics endpoint; nil unless --metrics-addr is set
+var metrics *migration.Metrics
+
+// startMetrics starts the metrics endpoint if --metrics-addr is set and
+// returns the function stopping it. The returned function keeps serving for
+// --metrics-linger first, since the result is only recorded at the very end.
+func startMetrics() (stop func(), err error) {
+	if metricsAddr == "" {
+		return func() {}, nil
+	}
+
+	metrics = migration.NewMetrics()
+	stop, err = migration.ServeMetrics(metricsAddr, metrics)
+	if err != nil {
+		metrics = nil
+		return nil, err
+	}
+	printInfo("Serving metrics on %s/metrics", metricsAddr)
+	return func() {
+		if metricsLinger > 0 {
+			printInfo("Serving final metrics for %s before exiting", metricsLinger)
+			time.Sleep(metricsLinger)
+		}
+		stop()
+	}, nil
+}
+
+// withMetrics also reports an operation's progress to the metrics endpoint
+func withMetrics(progress migration.ProgressCallback) migration.ProgressCallback {
+	if metrics == nil {
+		return progress
+	}
+	return func(stage string, current, total int, item string) {
+		metrics.SetProgress(stage, current, total)
+		progress(stage, current, total, item)
+	}
+}
+
+// withMessageMetrics also reports message import progress and outcomes to the metrics endpoint
+func withMessageMetrics(progress matrix.MessageImportCallback) matrix.MessageImportCallback {
+	if metrics == nil {
+		return progress
+	}
+	return func(current, total int, channelName, status string) {
+		metrics.SetProgress("messages", current, total)
+		metrics.ObserveMessage(status)
+		progress(current, total, channelName, status)
+	}
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+// notifyResult holds the result of the running command for the completion
+// notification; run functions set it once their operation succeeds
+var notifyResult interface{}
+
+// notifying wraps a batch command so that notify.webhook_url receives a
+// summary when it finishes or fails. It also serves --metrics-addr while
+// the command runs.
+func notifying(run func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		startedAt := time.Now()
+		notifyResult = nil
+
+		stopMetrics, err := startMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+
+		err = run(cmd, args)
+		if metrics != nil {
+			metrics.RecordResult(notifyResult)
+		}
+		sendCompletionNotification(cmd.CommandPath(), startedAt, err)
+		return err
+	}
+}
+
+// sendCompletionNotification posts the command outcome and migration state
+// to the configured webhook. Failures are reported but never change the
+// command's result.
+func sendCompletionNotification(command string, startedAt time.Time, runErr error) {
+	cfg, err := config.Load(cfgFile)
+	if err != nil || cfg.Notify.WebhookURL == "" {
+		return
+	}
+	cfg.ApplyOutputDir(outputDir)
+
+	state, err := migration.LoadState(cfg.Data.StateFile)
+	if err != nil {
+		printWarning("Could not load state for notification: %v", err)
+		state = nil
+	}
+
+	n := migration.NewNotification(command, startedAt, notifyResult, state, runErr)
+	if err := migration.SendNotification(cfg.Notify, n); err != nil {
+		printWarning("Completion notification failed: %v", err)
+	}
+}
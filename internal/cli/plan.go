@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Preview an import without connecting",
+	Long:  `Preview what an import step would do using only the export and mapping files.`,
+}
+
+var planMembershipsCmd = &cobra.Command{
+	Use:   "memberships",
+	Short: "Preview the membership import",
+	Long: `Load the exported memberships and the asset mapping and report how many
+memberships can be mapped to Matrix users and rooms, and why the others
+would be skipped. No connection to Mattermost or Matrix is made.`,
+	RunE: runPlanMemberships,
+}
+
+func init() {
+	planCmd.AddCommand(planMembershipsCmd)
+}
+
+func runPlanMemberships(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	orch, err := migration.NewOrchestrator(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create orchestrator: %w", err)
+	}
+	defer orch.Close()
+
+	plan, err := orch.PlanMemberships()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("  %-22s %10s %12s\n", "", "Mappable", "Unmappable")
+	fmt.Printf("  %-22s %10d %12d\n", "Team memberships", plan.TeamMappable, plan.TeamUnmappable)
+	fmt.Printf("  %-22s %10d %12d\n", "Channel memberships", plan.ChannelMappable, plan.ChannelUnmappable)
+	fmt.Println()
+
+	if len(plan.SkipReasons) == 0 {
+		printSuccess("All memberships can be mapped")
+		return nil
+	}
+
+	reasons := make([]string, 0, len(plan.SkipReasons))
+	for reason := range plan.SkipReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	printWarning("Memberships that would be skipped:")
+	for _, reason := range reasons {
+		fmt.Printf("  %-30s %d\n", reason, plan.SkipReasons[reason])
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+var resetCmd = &cobra.Command{
+	Use:   "reset <step|all>",
+	Short: "Mark a migration step as not run",
+	Long: `Set a completed, failed or cancelled step back to pending, so it can be
+run again, e.g. after fixing the cause of a partially failed import:
+  matrixmigrate reset import_assets
+
+Steps: ` + stepNames() + `, or all.
+
+Only the state changes: export files, mappings and the homeserver are left
+as they are, so an import run again still skips what it already created.
+Completed steps that depend on the reset one are listed; reset them too
+before running them again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReset,
+}
+
+// stepNames returns the names of all steps, comma separated
+func stepNames() string {
+	names := make([]string, len(migration.AllSteps))
+	for i, step := range migration.AllSteps {
+		names[i] = string(step)
+	}
+	return strings.Join(names, ", ")
+}
+
+func runReset(cmd *cobra.Command, args []string) error {
+	all := args[0] == "all"
+	var step migration.StepName
+	if !all {
+		var err error
+		if step, err = migration.ParseStepName(args[0]); err != nil {
+			return fmt.Errorf("%w (expected one of %s, or all)", err, stepNames())
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	orch, err := migration.NewOrchestrator(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create orchestrator: %w", err)
+	}
+	defer orch.Close()
+
+	question := fmt.Sprintf("Reset %s to pending?", step)
+	if all {
+		question = "Reset all steps to pending?"
+	}
+	if !assumeYes {
+		if batch {
+			return fmt.Errorf("reset discards migration progress; use --yes to run it in batch mode")
+		}
+		if !confirm(question) {
+			printInfo("Reset cancelled")
+			return nil
+		}
+	}
+
+	if all {
+		if err := orch.ResetAllSteps(); err != nil {
+			return err
+		}
+		printSuccess("All steps reset to pending")
+		return nil
+	}
+
+	stale, err := orch.ResetStep(step)
+	if err != nil {
+		return err
+	}
+	printSuccess("%s reset to pending", step)
+	for _, name := range stale {
+		printWarning("%s is completed but its prerequisites no longer are; run 'reset %s' to run it again too", name, name)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Run the next migration steps that haven't completed",
+	Long: `Run the migration from where it stopped: the state is inspected, the
+first step that isn't completed and whose prerequisites are met is run,
+and this repeats until every step is done, a step can't run yet or a
+step fails. Completed and skipped steps are never run again, so the
+command can simply be repeated after an interruption.
+  matrixmigrate --batch --yes resume
+
+Imports still need --yes in batch mode. With --dry-run the first import
+only shows what it would do, and resume stops there.`,
+	RunE: notifying(runResume),
+}
+
+// resumeSteps are the migration steps in the order resume runs them
+var resumeSteps = []struct {
+	name migration.StepName
+	run  func(cmd *cobra.Command, args []string) error
+}{
+	{migration.StepExportAssets, runExportAssets},
+	{migration.StepImportAssets, runImportAssets},
+	{migration.StepExportMemberships, runExportMemberships},
+	{migration.StepImportMemberships, runImportMemberships},
+	{migration.StepExportMessages, runExportMessages},
+	{migration.StepImportMessages, runImportMessages},
+}
+
+func runResume(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	ran := 0
+	for {
+		state, err := migration.LoadState(cfg.Data.StateFile)
+		if err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+
+		next, blocked := nextResumeStep(state)
+		if next < 0 {
+			if blocked != "" {
+				printWarning("No step can run: %s", blocked)
+			} else if ran == 0 {
+				printSuccess("All migration steps are already completed")
+			}
+			return nil
+		}
+
+		step := resumeSteps[next]
+		fmt.Println()
+		printInfo("Running %s", step.name)
+		if err := step.run(cmd, args); err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+		ran++
+
+		// A step that returns without completing (a dry run, a partial
+		// import or a declined confirmation) would only run again
+		state, err = migration.LoadState(cfg.Data.StateFile)
+		if err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+		if status := state.GetStep(step.name).Status; status != migration.StatusCompleted && status != migration.StatusSkipped {
+			printWarning("%s did not complete (status: %s); stopping", step.name, status)
+			return nil
+		}
+	}
+}
+
+// nextResumeStep returns the index in resumeSteps of the first step that
+// isn't completed or skipped and can run. It returns -1 when there is none,
+// with the reason the first unfinished step can't run.
+func nextResumeStep(state *migration.MigrationState) (int, string) {
+	blocked := ""
+	for i, step := range resumeSteps {
+		status := state.GetStep(step.name).Status
+		if status == migration.StatusCompleted || status == migration.StatusSkipped {
+			continue
+		}
+		if ok, reason := state.CanRunStep(step.name); ok {
+			return i, ""
+		} else if blocked == "" {
+			blocked = fmt.Sprintf("%s: %s", step.name, reason)
+		}
+	}
+	return -1, blocked
+}
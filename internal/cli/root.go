@@ -1,22 +1,43 @@
 ﻿package cli
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
 	"github.com/aligundogdu/matrixmigrate/internal/config"
 	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
 	"github.com/aligundogdu/matrixmigrate/internal/tui"
 	"github.com/aligundogdu/matrixmigrate/internal/version"
 )
 
 var (
-	cfgFile  string
-	language string
-	batch    bool
-	verbose  bool
+	cfgFile   string
+	language  string
+	localeDir string
+	batch     bool
+	verbose   bool
+
+	outputDir string
+
+	forceHomeserver bool
+
+	dryRun bool
+
+	assumeYes bool
+
+	// Reproducible runs for testing
+	seed          uint64
+	deterministic bool
 )
 
 var rootCmd = &cobra.Command{
@@ -43,9 +64,20 @@ Examples:
   matrixmigrate test matrix`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		// Initialize i18n
+		if localeDir != "" {
+			i18n.SetLocaleDir(localeDir)
+		}
 		if err := i18n.Init(language); err != nil {
 			return fmt.Errorf("failed to initialize i18n: %w", err)
 		}
+
+		if cmd.Flags().Changed("seed") && !deterministic {
+			return fmt.Errorf("--seed only takes effect with --deterministic")
+		}
+		if deterministic {
+			matrix.SetDeterministicSeed(seed)
+			printWarning("Deterministic mode (seed %d): generated passwords are predictable, use for testing only", seed)
+		}
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -60,6 +92,10 @@ Examples:
 			}
 			return err
 		}
+		cfg.ApplyOutputDir(outputDir)
+		if forceHomeserver {
+			cfg.Matrix.ForceHomeserver = true
+		}
 
 		// Override language from config if not set via flag
 		if language == "en" && cfg.Language != "" {
@@ -79,7 +115,7 @@ Examples:
 		}
 
 		// Start TUI
-		return tui.Run(cfg)
+		return tui.Run(cfg, migration.RunOptions{DryRun: dryRun})
 	},
 }
 
@@ -90,15 +126,33 @@ func Execute() error {
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is ./config.yaml)")
-	rootCmd.PersistentFlags().StringVarP(&language, "lang", "l", "en", "interface language (en, tr)")
+	rootCmd.PersistentFlags().StringVarP(&language, "lang", "l", "en", "interface language (en, tr, de, fr, or a <lang>.yaml in the locale directory)")
+	rootCmd.PersistentFlags().StringVar(&localeDir, "locale-dir", "", "directory of custom locale files (default is ./locales next to the binary)")
 	rootCmd.PersistentFlags().BoolVar(&batch, "batch", false, "run in batch mode (non-interactive)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+	rootCmd.PersistentFlags().StringVar(&outputDir, "output-dir", "", "override data directories (assets, mappings, state) for this run")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "show what imports would create, without changing anything on the homeserver")
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "run imports without asking for confirmation (required in batch mode)")
+	rootCmd.PersistentFlags().BoolVar(&forceHomeserver, "force-homeserver", false, "use the configured matrix.homeserver as is, without auto-detection")
+	rootCmd.PersistentFlags().Uint64Var(&seed, "seed", 1, "seed for generated values in --deterministic mode")
+	rootCmd.PersistentFlags().BoolVar(&deterministic, "deterministic", false, "make generated values reproducible from --seed (testing only)")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address during export/import (e.g. :9090)")
+	rootCmd.PersistentFlags().DurationVar(&metricsLinger, "metrics-linger", defaultMetricsLinger, "keep serving metrics this long after the command finishes so the final counters can be scraped")
 
 	// Add subcommands
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(testCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(usersCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(resetCmd)
+	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(selftestCmd)
+	rootCmd.AddCommand(mergeAssetsCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -117,6 +171,10 @@ func loadConfig() (*config.Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", i18n.T("errors.config_not_found", cfgFile), err)
 	}
+	cfg.ApplyOutputDir(outputDir)
+	if forceHomeserver {
+		cfg.Matrix.ForceHomeserver = true
+	}
 
 	if err := cfg.EnsureDataDirs(); err != nil {
 		return nil, err
@@ -125,6 +183,33 @@ func loadConfig() (*config.Config, error) {
 	return cfg, nil
 }
 
+// interruptContext returns a context that is cancelled by the first SIGINT
+// or SIGTERM, so a running step stops after its current request and keeps
+// what it completed. A second signal terminates the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			printWarning("Interrupted, stopping after the current request (press Ctrl+C again to quit now)")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+// interrupted explains an error caused by interruptContext
+func interrupted(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("interrupted; completed work was saved, run the command again to continue: %w", err)
+	}
+	return err
+}
+
 // printError prints an error message
 func printError(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
@@ -145,9 +230,23 @@ func printWarning(format string, args ...interface{}) {
 	fmt.Printf("⚠ "+format+"\n", args...)
 }
 
+// confirm asks a yes/no question on stdin; anything but y/yes is a no
+func confirm(question string) bool {
+	fmt.Printf("? %s [y/N]: ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 // printProgress prints a progress message
 func printProgress(format string, args ...interface{}) {
 	if verbose {
 		fmt.Printf("  "+format+"\n", args...)
 	}
 }
+
+// connectProgress prints the steps of connecting to a server, even without
+// --verbose, since SSH setup can take several seconds on slow links
+func connectProgress(stage string, current, total int, item string) {
+	fmt.Printf("  %s\n", stage)
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run a small synthetic migration against the homeserver",
+	Long: `Check the tool and the homeserver configuration end to end before
+migrating real data: two users, a space and a room with generated
+selftest-* names are imported to the configured homeserver, the users are
+invited, the result is verified, and everything is removed again: the room
+and space are deleted as undo does, the users are deactivated (Synapse
+can't delete accounts).
+
+Without --matrix-only the assets are first exported from Mattermost,
+without saving them, to check that side too. The migration state,
+mappings and export files are not touched. Use a staging homeserver where
+possible; in batch mode --yes is required.
+  matrixmigrate selftest --matrix-only`,
+	RunE: runSelftest,
+}
+
+// selftestMatrixOnly skips the Mattermost export
+var selftestMatrixOnly bool
+
+func init() {
+	selftestCmd.Flags().BoolVar(&selftestMatrixOnly, "matrix-only", false, "don't connect to Mattermost; only test the import to Matrix")
+}
+
+func runSelftest(cmd *cobra.Command, args []string) error {
+	if dryRun {
+		return fmt.Errorf("selftest creates and deletes test data; it can't run with --dry-run")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	orch, err := migration.NewOrchestrator(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create orchestrator: %w", err)
+	}
+	defer orch.Close()
+
+	summary := fmt.Sprintf("This creates two test users, a space and a room on %s, then deletes the rooms and deactivates the users.", cfg.Matrix.Homeserver)
+	if ok, err := confirmImport(summary); !ok {
+		return err
+	}
+
+	if !selftestMatrixOnly {
+		printInfo(i18n.T("progress.connecting", "Mattermost"))
+		if err := orch.ConnectMattermost(connectProgress); err != nil {
+			return err
+		}
+		printSuccess(i18n.T("progress.connected", "Mattermost"))
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	printInfo(i18n.T("progress.connecting", "Matrix"))
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
+		return err
+	}
+	printSuccess(i18n.T("progress.connected", "Matrix"))
+
+	fmt.Println()
+	fmt.Println(testHeaderStyle.Render("Self-test"))
+	fmt.Println()
+	result, err := orch.SelfTest(ctx, migration.SelfTestOptions{MatrixOnly: selftestMatrixOnly}, func(server string, step *migration.TestStep) {
+		printStep(step)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println(strings.Repeat("─", 50))
+	if !result.AllPassed {
+		fmt.Println(testFailedStyle.Render("✗ Self-test failed"))
+		return fmt.Errorf("self-test failed")
+	}
+	fmt.Println(testPassedStyle.Render("✓ Self-test passed"))
+	return nil
+}
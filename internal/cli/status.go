@@ -77,6 +77,9 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	summary := state.Summary()
 	fmt.Printf("  Summary: %d completed, %d pending, %d failed\n",
 		summary.Completed, summary.Pending, summary.Failed)
+	if summary.Cancelled > 0 {
+		fmt.Printf("  Cancelled: %d (run the steps again to continue)\n", summary.Cancelled)
+	}
 
 	if state.IsComplete() {
 		fmt.Println()
@@ -98,6 +101,8 @@ func getStatusIcon(status string) string {
 		return "●"
 	case "failed":
 		return "✗"
+	case "cancelled":
+		return "⊗"
 	case "skipped":
 		return "⊘"
 	default:
@@ -115,6 +120,8 @@ func getStatusText(status string, locale *i18n.Locale) string {
 		return locale.Status.Completed
 	case "failed":
 		return locale.Status.Failed
+	case "cancelled":
+		return locale.Status.Cancelled
 	case "skipped":
 		return locale.Status.Skipped
 	default:
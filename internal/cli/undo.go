@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Delete migrated rooms from Matrix",
+	Long: `Delete the rooms created by import assets, as recorded in the asset mapping.
+
+Rooms are deleted with the asynchronous Synapse Admin API: each delete is
+polled until it completes. --concurrency limits how many rooms are deleted
+at once; every request also goes through the configured rate limit.
+Deleted rooms are removed from the mapping, so import assets recreates them.`,
+	RunE: runUndo,
+}
+
+var (
+	undoConcurrency  int
+	undoPollInterval time.Duration
+	undoTimeout      time.Duration
+	undoPurge        bool
+	undoSpaces       bool
+)
+
+func init() {
+	undoCmd.Flags().IntVar(&undoConcurrency, "concurrency", 4, "number of rooms deleted in parallel")
+	undoCmd.Flags().DurationVar(&undoPollInterval, "poll-interval", 2*time.Second, "delay between delete status checks")
+	undoCmd.Flags().DurationVar(&undoTimeout, "timeout", 10*time.Minute, "max time to wait for a single room")
+	undoCmd.Flags().BoolVar(&undoPurge, "purge", true, "also remove the rooms' history from the database")
+	undoCmd.Flags().BoolVar(&undoSpaces, "spaces", false, "also delete the spaces created for teams")
+}
+
+func runUndo(cmd *cobra.Command, args []string) error {
+	if undoConcurrency <= 0 {
+		return fmt.Errorf("--concurrency must be positive")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	orch, err := migration.NewOrchestrator(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create orchestrator: %w", err)
+	}
+	defer orch.Close()
+
+	mappingFile := orch.GetState().GetStepOutputFile(migration.StepImportAssets)
+	if mappingFile == "" {
+		return fmt.Errorf("no mapping file found from import assets step")
+	}
+	printWarning("This deletes the migrated rooms in %s from Matrix, including their messages.", mappingFile)
+	if !assumeYes {
+		if batch {
+			return fmt.Errorf("undo deletes the migrated rooms; use --yes to run it in batch mode")
+		}
+		if !confirm("Delete the migrated rooms?") {
+			printInfo("Undo cancelled")
+			return nil
+		}
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	printInfo(i18n.T("progress.connecting", "Matrix"))
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
+		return err
+	}
+	printSuccess(i18n.T("progress.connected", "Matrix"))
+
+	// Deletes can take a while, so progress is shown without --verbose too
+	progress := func(stage string, current, total int, item string) {
+		fmt.Printf("  Deleting %s: %d/%d (%s)\n", stage, current, total, item)
+	}
+
+	result, err := orch.UndoRooms(ctx, migration.UndoOptions{
+		Concurrency:   undoConcurrency,
+		PollInterval:  undoPollInterval,
+		Timeout:       undoTimeout,
+		Purge:         undoPurge,
+		IncludeSpaces: undoSpaces,
+	}, progress)
+	if err != nil {
+		return interrupted(err)
+	}
+
+	printInfo(fmt.Sprintf("  Rooms deleted: %d", result.RoomsDeleted))
+	if undoSpaces {
+		printInfo(fmt.Sprintf("  Spaces deleted: %d", result.SpacesDeleted))
+	}
+	if result.Failed > 0 {
+		roomIDs := make([]string, 0, len(result.Failures))
+		for roomID := range result.Failures {
+			roomIDs = append(roomIDs, roomID)
+		}
+		sort.Strings(roomIDs)
+		for _, roomID := range roomIDs {
+			printWarning("%s: %s", roomID, result.Failures[roomID])
+		}
+		return fmt.Errorf("%d deletes failed; run undo again to retry them", result.Failed)
+	}
+
+	printSuccess("Mapping updated: %s", result.MappingFile)
+	return nil
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Inspect users on the Matrix server",
+	Long:  `Inspect users on the Matrix server via the Synapse Admin API.`,
+}
+
+var usersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List Matrix users",
+	Long: `List users on the Matrix server, one page at a time.
+
+Each page ends with the token of the next page; pass it to --from to continue
+where a previous listing stopped.`,
+	RunE: runUsersList,
+}
+
+var (
+	usersListFrom  string
+	usersListLimit int
+	usersListAll   bool
+)
+
+func init() {
+	usersCmd.AddCommand(usersListCmd)
+
+	usersListCmd.Flags().StringVar(&usersListFrom, "from", "", "pagination token to start from (printed after each page)")
+	usersListCmd.Flags().IntVar(&usersListLimit, "limit", 100, "number of users per page")
+	usersListCmd.Flags().BoolVar(&usersListAll, "all", false, "keep fetching until the last page")
+}
+
+func runUsersList(cmd *cobra.Command, args []string) error {
+	if usersListLimit <= 0 {
+		return fmt.Errorf("--limit must be positive")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	orch, err := migration.NewOrchestrator(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create orchestrator: %w", err)
+	}
+	defer orch.Close()
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	printInfo(i18n.T("progress.connecting", "Matrix"))
+	if err := orch.ConnectMatrix(ctx, connectProgress); err != nil {
+		return err
+	}
+	printSuccess(i18n.T("progress.connected", "Matrix"))
+
+	from := usersListFrom
+	total := 0
+	for {
+		users, next, err := orch.ListMatrixUsers(ctx, from, usersListLimit)
+		if err != nil {
+			return fmt.Errorf("failed to list users: %w", err)
+		}
+
+		for _, user := range users {
+			flags := ""
+			if user.Admin {
+				flags += " [admin]"
+			}
+			if user.Deactivated {
+				flags += " [deactivated]"
+			}
+			fmt.Printf("  %-40s %s%s\n", user.UserID, user.DisplayName, flags)
+		}
+		total += len(users)
+
+		if next == "" {
+			printSuccess("Listed %d users (end of list)", total)
+			return nil
+		}
+		if !usersListAll {
+			printInfo("Listed %d users; continue with --from %s", total, next)
+			return nil
+		}
+		from = next
+	}
+}
@@ -1,10 +1,13 @@
 ﻿package config
 
 import (
+	"compress/gzip"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/spf13/viper"
 )
@@ -15,14 +18,55 @@ type Config struct {
 	Mattermost MattermostConfig `mapstructure:"mattermost"`
 	Matrix     MatrixConfig     `mapstructure:"matrix"`
 	Data       DataConfig       `mapstructure:"data"`
+	Notify     NotifyConfig     `mapstructure:"notify"`
+	Messages   MessagesConfig   `mapstructure:"messages"`
+	Import     ImportConfig     `mapstructure:"import"`
+
+	// File is the config file the settings were read from; empty if none
+	File string `mapstructure:"-"`
+}
+
+// ImportConfig holds asset import settings
+type ImportConfig struct {
+	SkipEmptyChannels bool `mapstructure:"skip_empty_channels"` // Don't create rooms for channels without messages
+	DirectMessages    bool `mapstructure:"direct_messages"`     // Migrate direct and group messages as Matrix DMs
+}
+
+// MessagesConfig holds message import settings
+type MessagesConfig struct {
+	OversizePolicy string `mapstructure:"oversize_policy"` // Bodies over max_body_bytes: split, truncate or skip (default: split)
+	MaxBodyBytes   int    `mapstructure:"max_body_bytes"`  // Largest body sent as one event, JSON-encoded (default: 32000)
+}
+
+// NotifyConfig holds completion notification settings for batch runs
+type NotifyConfig struct {
+	WebhookURL string `mapstructure:"webhook_url"` // Receives a JSON POST when a batch command finishes or fails
+	TimeoutSec int    `mapstructure:"timeout_sec"` // Request timeout in seconds (default: 10)
 }
 
 // MattermostConfig holds Mattermost server configuration
 type MattermostConfig struct {
 	SSH        SSHConfig      `mapstructure:"ssh"`
 	ConfigPath string         `mapstructure:"config_path"` // Path to config.json on remote server
+	EnvCommand string         `mapstructure:"env_command"` // Prints the server's environment, for ${env:VAR} in config.json
 	Database   DatabaseConfig `mapstructure:"database"`    // Optional: manual override
 	Files      FilesConfig    `mapstructure:"files"`       // File/attachment settings
+
+	// Export deleted users and import them as deactivated Matrix accounts
+	IncludeDeleted bool `mapstructure:"include_deleted"`
+
+	// Database connections the export may open at once; above 1, channels'
+	// posts are exported concurrently by as many workers (default: 4)
+	DBMaxOpenConns int `mapstructure:"db_max_open_conns"`
+
+	// Teams and channels to export, by name or ID (default: all). Channels of
+	// other teams are left out when teams are selected
+	IncludeTeams    []string `mapstructure:"include_teams"`
+	IncludeChannels []string `mapstructure:"include_channels"`
+
+	// Users excluded from the migration, by username or email
+	SkipUsers        []string `mapstructure:"skip_users"`
+	SkipUserMessages bool     `mapstructure:"skip_user_messages"` // Also drop their messages (default: keep them)
 }
 
 // FilesConfig holds file attachment migration settings
@@ -49,7 +93,29 @@ type MatrixConfig struct {
 	API        APIConfig        `mapstructure:"api"`
 	Auth       AuthConfig       `mapstructure:"auth"`       // Username/password auth for Matrix API
 	Homeserver string           `mapstructure:"homeserver"`
+	HomeserverStrict bool       `mapstructure:"homeserver_strict"` // Fail instead of using the detected homeserver on mismatch
+	ForceHomeserver bool        `mapstructure:"force_homeserver"`  // Skip homeserver auto-detection and trust the configured value
+	AliasTemplate      string   `mapstructure:"alias_template"`       // Go template for room alias localparts (default: mm_{{.Channel.ID}})
+	SpaceAliasTemplate string   `mapstructure:"space_alias_template"` // Go template for space alias localparts (default: mm_team_{{.Team.ID}})
+	OrphanChannelPolicy string  `mapstructure:"orphan_channel_policy"` // Channels whose team wasn't imported: skip, import_flat or uncategorized
+	NameAliases bool             `mapstructure:"name_aliases"` // Give rooms a canonical alias from their channel name
+	ChildOrder string            `mapstructure:"child_order"` // Order of rooms in their space: creation, alphabetical or none (default: none)
+	InviteForbiddenPolicy string `mapstructure:"invite_forbidden_policy"` // Invites rejected as forbidden: fail or skip (default: fail)
+	RoomVersion string           `mapstructure:"room_version"` // Room version of created rooms and spaces (default: server default)
+	AdminPowerLevel int          `mapstructure:"admin_power_level"` // Power level of team and channel admins (default: 50, 0: not promoted)
+	AuditLog   string           `mapstructure:"audit_log"`   // JSON lines file recording every mutating API call (optional)
+	ExtraHeaders map[string]string `mapstructure:"extra_headers"` // Headers added to every Matrix API request
+	HTTPProxy  string           `mapstructure:"http_proxy"`  // Outbound proxy URL (default: HTTP_PROXY/HTTPS_PROXY)
+	ArchivedRoomsReadonly bool  `mapstructure:"archived_rooms_readonly"` // Import archived channels as read-only rooms (needs include_deleted)
+	Users      UserCreationConfig `mapstructure:"users"`     // Extra fields for accounts created by import assets
+	CreatorInTopic bool         `mapstructure:"creator_in_topic"` // Append "Created by <user>" to room topics
+	DefaultRoomCreator string   `mapstructure:"default_room_creator"` // Credited for channels whose creator wasn't migrated, e.g. bots (default: admin user)
+	HistoryVisibility string    `mapstructure:"history_visibility"` // History visibility of created rooms: shared or world_readable (default: shared)
+	EncryptRooms bool           `mapstructure:"encrypt_rooms"` // Create rooms with end-to-end encryption; their messages are not imported
+	PublishPublicRooms bool     `mapstructure:"publish_public_rooms"` // List rooms of public channels in the room directory
 	RateLimit  RateLimitConfig  `mapstructure:"rate_limit"`  // Rate limiting configuration
+	RoomCreationPacingMs int    `mapstructure:"room_creation_pacing_ms"` // Least delay between creating two rooms or spaces (0 = none)
+	RoomCreationJitterMs int    `mapstructure:"room_creation_jitter_ms"` // Random extra delay of up to this much between room creations
 	AppService AppServiceConfig `mapstructure:"appservice"`  // Application Service for message import
 }
 
@@ -60,6 +126,22 @@ type AppServiceConfig struct {
 	HSTokenEnv string `mapstructure:"hs_token_env"`  // Env var for HS token (optional)
 }
 
+// UserCreationConfig holds the optional Synapse admin API fields set on created users
+type UserCreationConfig struct {
+	UserType      string `mapstructure:"user_type"`      // Synapse user type, e.g. "bot" or "support" (default: regular user)
+	LogoutDevices bool   `mapstructure:"logout_devices"` // Log out devices when the password changes (default: true)
+	SSO           SSOConfig `mapstructure:"sso"`         // Link created users to an SSO provider
+	DuplicateEmailPolicy string `mapstructure:"duplicate_email_policy"` // Users sharing an email: first, skip_duplicates or error (default: first)
+	MaxDisplayNameLength int    `mapstructure:"max_displayname_length"` // Truncate longer display names, in characters (default: 100, 0: no limit)
+}
+
+// SSOConfig links migrated users to an SSO identity so they can log in via SSO
+type SSOConfig struct {
+	AuthProvider    string `mapstructure:"auth_provider"`     // Synapse auth provider ID, e.g. "oidc-keycloak" (empty: disabled)
+	ExternalIDField string `mapstructure:"external_id_field"` // email, username, id or auth_data (default: email)
+	WithoutPassword bool   `mapstructure:"without_password"`  // Don't set a generated password on linked users
+}
+
 // RateLimitConfig holds rate limiting configuration for Matrix API
 type RateLimitConfig struct {
 	RequestsPerSecond float64 `mapstructure:"requests_per_second"` // Max requests per second (0 = no limit)
@@ -75,15 +157,76 @@ type SSHConfig struct {
 	KeyPath       string `mapstructure:"key_path"`       // Optional: path to SSH key
 	PassphraseEnv string `mapstructure:"passphrase_env"` // Optional: env var for key passphrase
 	PasswordEnv   string `mapstructure:"password_env"`   // Optional: env var for SSH password
+
+	// Host key verification
+	KnownHostsPath  string `mapstructure:"known_hosts_path"`  // known_hosts file with the server's key (default: ~/.ssh/known_hosts)
+	InsecureHostKey bool   `mapstructure:"insecure_host_key"` // Accept any host key (not recommended)
+
+	JumpHost JumpHostConfig `mapstructure:"jump_host"` // Bastion the server is reached through (optional)
+
+	// Remote command limits (used when reading files such as config.json)
+	CommandTimeoutSec int `mapstructure:"command_timeout_sec"` // Max seconds a remote command may run (default: 30)
+	MaxReadSizeKB     int `mapstructure:"max_read_size_kb"`    // Max size of a remote file read in KB (default: 10240)
+
+	LocalPort int `mapstructure:"local_port"` // Fixed local port of the SSH tunnel (default: any free port)
+}
+
+// JumpHostConfig holds the SSH bastion a server is reached through
+type JumpHostConfig struct {
+	Host            string `mapstructure:"host"` // Empty: connect directly
+	Port            int    `mapstructure:"port"`
+	User            string `mapstructure:"user"`
+	KeyPath         string `mapstructure:"key_path"`
+	PassphraseEnv   string `mapstructure:"passphrase_env"`
+	PasswordEnv     string `mapstructure:"password_env"`
+	KnownHostsPath  string `mapstructure:"known_hosts_path"`
+	InsecureHostKey bool   `mapstructure:"insecure_host_key"`
+}
+
+// SSHConfig returns the jump host as an SSH server config
+func (j JumpHostConfig) SSHConfig() SSHConfig {
+	port := j.Port
+	if port == 0 {
+		port = 22
+	}
+	return SSHConfig{
+		Host:            j.Host,
+		Port:            port,
+		User:            j.User,
+		KeyPath:         j.KeyPath,
+		PassphraseEnv:   j.PassphraseEnv,
+		PasswordEnv:     j.PasswordEnv,
+		KnownHostsPath:  j.KnownHostsPath,
+		InsecureHostKey: j.InsecureHostKey,
+	}
+}
+
+// Passphrase returns the jump host key passphrase from environment
+func (j JumpHostConfig) Passphrase() string {
+	if j.PassphraseEnv == "" {
+		return ""
+	}
+	return os.Getenv(j.PassphraseEnv)
 }
 
-// DatabaseConfig holds PostgreSQL connection configuration (optional manual override)
+// Password returns the jump host SSH password from environment
+func (j JumpHostConfig) Password() string {
+	if j.PasswordEnv == "" {
+		return ""
+	}
+	return os.Getenv(j.PasswordEnv)
+}
+
+// DatabaseConfig holds database connection configuration (optional manual override)
 type DatabaseConfig struct {
+	Driver      string `mapstructure:"driver"` // postgres or mysql (default: postgres)
 	Host        string `mapstructure:"host"`
 	Port        int    `mapstructure:"port"`
 	Name        string `mapstructure:"name"`
 	User        string `mapstructure:"user"`
 	PasswordEnv string `mapstructure:"password_env"`
+	SSLMode     string `mapstructure:"sslmode"`     // TLS mode, e.g. require or verify-ca; overrides the one in config.json (default: disable)
+	SSLRootCert string `mapstructure:"sslrootcert"` // Local CA certificate file to verify the database server with
 }
 
 // APIConfig holds Matrix API configuration
@@ -97,6 +240,10 @@ type APIConfig struct {
 type AuthConfig struct {
 	Username    string `mapstructure:"username"`     // Admin username
 	PasswordEnv string `mapstructure:"password_env"` // Env var for password
+
+	// Retry while the homeserver is unreachable or returns 502/503/504
+	LoginAttempts      int `mapstructure:"login_attempts"`        // Login attempts (default: 3)
+	LoginRetryDelaySec int `mapstructure:"login_retry_delay_sec"` // Seconds between attempts (default: 2)
 }
 
 // DataConfig holds data storage paths
@@ -104,6 +251,14 @@ type DataConfig struct {
 	AssetsDir   string `mapstructure:"assets_dir"`
 	MappingsDir string `mapstructure:"mappings_dir"`
 	StateFile   string `mapstructure:"state_file"`
+	FileMode    string `mapstructure:"file_mode"` // Octal permissions for data files (default: 0600)
+	DirMode     string `mapstructure:"dir_mode"`  // Octal permissions for data directories (default: 0700)
+	GzipLevel   int    `mapstructure:"gzip_level"` // Compression level of .json.gz exports, 1 (fastest) to 9 (smallest); 0 uses the gzip default
+	FileNaming  string `mapstructure:"file_naming"` // "timestamped" (default) or "fixed": names of exports, mappings and reports
+
+	MessageMappingBackend      string `mapstructure:"message_mapping_backend"`       // "memory", "bolt" or "auto" (default: auto)
+	MessageMappingMemoryLimit  int    `mapstructure:"message_mapping_memory_limit"`  // Posts above which "auto" switches to bolt (default: 200000)
+	MessageMappingSaveInterval int    `mapstructure:"message_mapping_save_interval"` // Posts between saves of the memory mapping (default: 1000)
 }
 
 // Load loads configuration from the specified file or default locations
@@ -141,6 +296,7 @@ func Load(cfgFile string) (*Config, error) {
 
 	// Expand paths
 	cfg.expandPaths()
+	cfg.File = v.ConfigFileUsed()
 
 	// Validate config
 	if err := cfg.Validate(); err != nil {
@@ -157,9 +313,36 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("mattermost.config_path", "/opt/mattermost/config/config.json")
 	v.SetDefault("mattermost.database.host", "localhost")
 	v.SetDefault("mattermost.database.port", 5432)
+	v.SetDefault("mattermost.database.driver", "postgres")
+	v.SetDefault("mattermost.db_max_open_conns", 4)
+	v.SetDefault("mattermost.ssh.command_timeout_sec", 30)
+	v.SetDefault("mattermost.ssh.max_read_size_kb", 10240)
 	v.SetDefault("matrix.ssh.port", 22)
+	v.SetDefault("mattermost.ssh.known_hosts_path", "~/.ssh/known_hosts")
+	v.SetDefault("matrix.ssh.known_hosts_path", "~/.ssh/known_hosts")
+	v.SetDefault("matrix.ssh.command_timeout_sec", 30)
+	v.SetDefault("matrix.ssh.max_read_size_kb", 10240)
 	v.SetDefault("matrix.api.base_url", "http://localhost:8008")
 	v.SetDefault("matrix.api.port", 8008) // Synapse API port for SSH tunnel
+	v.SetDefault("matrix.homeserver_strict", false)
+	v.SetDefault("matrix.force_homeserver", false)
+	v.SetDefault("matrix.orphan_channel_policy", "import_flat")
+	v.SetDefault("matrix.child_order", "none")
+	v.SetDefault("matrix.name_aliases", true)
+	v.SetDefault("matrix.history_visibility", "shared")
+	v.SetDefault("matrix.invite_forbidden_policy", "fail")
+	v.SetDefault("matrix.admin_power_level", 50)
+	v.SetDefault("matrix.users.logout_devices", true)
+	v.SetDefault("matrix.users.sso.external_id_field", "email")
+	v.SetDefault("matrix.users.duplicate_email_policy", "first")
+	v.SetDefault("matrix.users.max_displayname_length", 100)
+	v.SetDefault("matrix.auth.login_attempts", 3)
+	v.SetDefault("matrix.auth.login_retry_delay_sec", 2)
+	// Message import defaults
+	v.SetDefault("messages.oversize_policy", "split")
+	v.SetDefault("messages.max_body_bytes", 32000)
+	// Completion notification defaults
+	v.SetDefault("notify.timeout_sec", 10)
 	// Rate limiting defaults - conservative values to avoid 429 errors
 	v.SetDefault("matrix.rate_limit.requests_per_second", 5.0)  // 5 req/sec (200ms between requests)
 	v.SetDefault("matrix.rate_limit.max_retries", 5)            // 5 retries before giving up
@@ -167,6 +350,57 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("data.assets_dir", "./data/assets")
 	v.SetDefault("data.mappings_dir", "./data/mappings")
 	v.SetDefault("data.state_file", "./data/state.json")
+	v.SetDefault("data.file_mode", "0600")
+	v.SetDefault("data.dir_mode", "0700")
+	v.SetDefault("data.file_naming", "timestamped")
+	v.SetDefault("data.message_mapping_backend", "auto")
+	v.SetDefault("data.message_mapping_memory_limit", 200000)
+	v.SetDefault("data.message_mapping_save_interval", 1000)
+}
+
+// SaveSettings writes values, by config key (e.g. "matrix.homeserver"), to
+// the config file at path. The other settings of the file are kept, but it
+// is rewritten without its comments; the previous version is copied to
+// path.bak first.
+func SaveSettings(path string, values map[string]interface{}) error {
+	if path == "" {
+		return fmt.Errorf("no config file was loaded; create config.yaml to save settings")
+	}
+
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	// Fail before changing anything if the file can't be written
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("config file %s is not writable: %w", path, err)
+	}
+	f.Close()
+	if err := os.WriteFile(path+".bak", original, 0600); err != nil {
+		return fmt.Errorf("failed to back up config file: %w", err)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	for key, value := range values {
+		v.Set(key, value)
+	}
+	if err := v.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// SetDataDirs sets the data directories and state file, expanding ~ and
+// environment variables as Load does
+func (c *Config) SetDataDirs(assetsDir, mappingsDir, stateFile string) {
+	c.Data.AssetsDir = expandPath(assetsDir)
+	c.Data.MappingsDir = expandPath(mappingsDir)
+	c.Data.StateFile = expandPath(stateFile)
 }
 
 // loadDefaults creates a config with default values
@@ -183,11 +417,30 @@ func loadDefaults(v *viper.Viper) (*Config, error) {
 func (c *Config) expandPaths() {
 	c.Mattermost.SSH.KeyPath = expandPath(c.Mattermost.SSH.KeyPath)
 	c.Matrix.SSH.KeyPath = expandPath(c.Matrix.SSH.KeyPath)
+	c.Mattermost.SSH.KnownHostsPath = expandPath(c.Mattermost.SSH.KnownHostsPath)
+	c.Matrix.SSH.KnownHostsPath = expandPath(c.Matrix.SSH.KnownHostsPath)
+	for _, jump := range []*JumpHostConfig{&c.Mattermost.SSH.JumpHost, &c.Matrix.SSH.JumpHost} {
+		jump.KeyPath = expandPath(jump.KeyPath)
+		jump.KnownHostsPath = expandPath(jump.KnownHostsPath)
+	}
+	c.Matrix.AuditLog = expandPath(c.Matrix.AuditLog)
 	c.Data.AssetsDir = expandPath(c.Data.AssetsDir)
 	c.Data.MappingsDir = expandPath(c.Data.MappingsDir)
 	c.Data.StateFile = expandPath(c.Data.StateFile)
 }
 
+// ApplyOutputDir points all data locations at dir (used by --output-dir).
+// Assets go to dir/assets, mappings to dir/mappings and state to dir/state.json.
+func (c *Config) ApplyOutputDir(dir string) {
+	if dir == "" {
+		return
+	}
+	dir = expandPath(dir)
+	c.Data.AssetsDir = filepath.Join(dir, "assets")
+	c.Data.MappingsDir = filepath.Join(dir, "mappings")
+	c.Data.StateFile = filepath.Join(dir, "state.json")
+}
+
 // expandPath expands ~ to home directory and resolves environment variables
 func expandPath(path string) string {
 	if path == "" {
@@ -223,6 +476,34 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for prefix, port := range map[string]int{
+		"mattermost.ssh.local_port": c.Mattermost.SSH.LocalPort,
+		"matrix.ssh.local_port":     c.Matrix.SSH.LocalPort,
+	} {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("%s: must be between 1 and 65535, got %d", prefix, port)
+		}
+	}
+	if c.Mattermost.SSH.LocalPort != 0 && c.Mattermost.SSH.LocalPort == c.Matrix.SSH.LocalPort {
+		return fmt.Errorf("mattermost.ssh.local_port and matrix.ssh.local_port must differ, both are %d", c.Matrix.SSH.LocalPort)
+	}
+
+	// Validate jump hosts if configured
+	for prefix, jump := range map[string]JumpHostConfig{
+		"mattermost.ssh.jump_host": c.Mattermost.SSH.JumpHost,
+		"matrix.ssh.jump_host":     c.Matrix.SSH.JumpHost,
+	} {
+		if jump.Host == "" {
+			continue
+		}
+		if jump.User == "" {
+			return fmt.Errorf("%s.user is required", prefix)
+		}
+		if jump.KeyPath == "" && jump.PasswordEnv == "" {
+			return fmt.Errorf("%s: either key_path or password_env is required", prefix)
+		}
+	}
+
 	// Validate Matrix config if SSH host is provided
 	if c.Matrix.SSH.Host != "" {
 		if c.Matrix.SSH.User == "" {
@@ -245,6 +526,132 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate data permissions
+	if _, err := parseFileMode(c.Data.FileMode); err != nil {
+		return fmt.Errorf("data.file_mode: %w", err)
+	}
+	if _, err := parseFileMode(c.Data.DirMode); err != nil {
+		return fmt.Errorf("data.dir_mode: %w", err)
+	}
+	if c.Data.GzipLevel < 0 || c.Data.GzipLevel > 9 {
+		return fmt.Errorf("data.gzip_level: must be between 1 and 9, got %d", c.Data.GzipLevel)
+	}
+	// Validate alias templates
+	if _, err := template.New("alias_template").Parse(c.Matrix.AliasTemplate); err != nil {
+		return fmt.Errorf("matrix.alias_template: %w", err)
+	}
+	if _, err := template.New("space_alias_template").Parse(c.Matrix.SpaceAliasTemplate); err != nil {
+		return fmt.Errorf("matrix.space_alias_template: %w", err)
+	}
+
+	switch c.Matrix.OrphanChannelPolicy {
+	case "", "skip", "import_flat", "uncategorized":
+	default:
+		return fmt.Errorf("matrix.orphan_channel_policy: must be skip, import_flat or uncategorized, got %q", c.Matrix.OrphanChannelPolicy)
+	}
+
+	if c.Matrix.RoomCreationPacingMs < 0 {
+		return fmt.Errorf("matrix.room_creation_pacing_ms: must not be negative, got %d", c.Matrix.RoomCreationPacingMs)
+	}
+	if c.Matrix.RoomCreationJitterMs < 0 {
+		return fmt.Errorf("matrix.room_creation_jitter_ms: must not be negative, got %d", c.Matrix.RoomCreationJitterMs)
+	}
+
+	switch c.Matrix.ChildOrder {
+	case "", "creation", "alphabetical", "none":
+	default:
+		return fmt.Errorf("matrix.child_order: must be creation, alphabetical or none, got %q", c.Matrix.ChildOrder)
+	}
+
+	switch c.Matrix.HistoryVisibility {
+	case "", "shared", "world_readable":
+	default:
+		return fmt.Errorf("matrix.history_visibility: must be shared or world_readable, got %q", c.Matrix.HistoryVisibility)
+	}
+
+	switch c.Mattermost.Database.Driver {
+	case "", "postgres", "mysql":
+	default:
+		return fmt.Errorf("mattermost.database.driver: must be postgres or mysql, got %q", c.Mattermost.Database.Driver)
+	}
+
+	switch c.Matrix.InviteForbiddenPolicy {
+	case "", "fail", "skip":
+	default:
+		return fmt.Errorf("matrix.invite_forbidden_policy: must be fail or skip, got %q", c.Matrix.InviteForbiddenPolicy)
+	}
+
+	if c.Matrix.AdminPowerLevel < 0 || c.Matrix.AdminPowerLevel > 100 {
+		return fmt.Errorf("matrix.admin_power_level: must be between 0 and 100, got %d", c.Matrix.AdminPowerLevel)
+	}
+
+	switch c.Matrix.RoomVersion {
+	case "", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11":
+	default:
+		return fmt.Errorf("matrix.room_version: must be a stable room version (1 to 11), got %q", c.Matrix.RoomVersion)
+	}
+
+	switch c.Matrix.Users.DuplicateEmailPolicy {
+	case "", "first", "skip_duplicates", "error":
+	default:
+		return fmt.Errorf("matrix.users.duplicate_email_policy: must be first, skip_duplicates or error, got %q", c.Matrix.Users.DuplicateEmailPolicy)
+	}
+
+	if c.Matrix.Users.MaxDisplayNameLength < 0 {
+		return fmt.Errorf("matrix.users.max_displayname_length: must not be negative, got %d", c.Matrix.Users.MaxDisplayNameLength)
+	}
+
+	switch c.Matrix.Users.SSO.ExternalIDField {
+	case "", "email", "username", "id", "auth_data":
+	default:
+		return fmt.Errorf("matrix.users.sso.external_id_field: must be email, username, id or auth_data, got %q", c.Matrix.Users.SSO.ExternalIDField)
+	}
+
+	switch c.Mattermost.Database.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full",
+		"true", "false", "skip-verify", "preferred":
+	default:
+		return fmt.Errorf("mattermost.database.sslmode: must be disable, allow, prefer, require, verify-ca or verify-full (MySQL also: true, false, skip-verify, preferred), got %q", c.Mattermost.Database.SSLMode)
+	}
+	if c.Mattermost.Database.SSLRootCert != "" {
+		if _, err := os.Stat(c.Mattermost.Database.SSLRootCert); err != nil {
+			return fmt.Errorf("mattermost.database.sslrootcert: %w", err)
+		}
+	}
+
+	if c.Mattermost.DBMaxOpenConns < 1 {
+		return fmt.Errorf("mattermost.db_max_open_conns: must be at least 1, got %d", c.Mattermost.DBMaxOpenConns)
+	}
+
+	switch c.Data.FileNaming {
+	case "", "timestamped", "fixed":
+	default:
+		return fmt.Errorf("data.file_naming: must be timestamped or fixed, got %q", c.Data.FileNaming)
+	}
+
+	if c.Data.MessageMappingSaveInterval < 0 {
+		return fmt.Errorf("data.message_mapping_save_interval: must not be negative, got %d", c.Data.MessageMappingSaveInterval)
+	}
+
+	switch c.Data.MessageMappingBackend {
+	case "", "auto", "memory", "bolt":
+	default:
+		return fmt.Errorf("data.message_mapping_backend: must be memory, bolt or auto, got %q", c.Data.MessageMappingBackend)
+	}
+
+	switch c.Messages.OversizePolicy {
+	case "", "split", "truncate", "skip":
+	default:
+		return fmt.Errorf("messages.oversize_policy: must be split, truncate or skip, got %q", c.Messages.OversizePolicy)
+	}
+	if c.Messages.MaxBodyBytes < 0 {
+		return fmt.Errorf("messages.max_body_bytes: must not be negative")
+	}
+
+	if url := c.Notify.WebhookURL; url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("notify.webhook_url: must be an http(s) URL, got %q", url)
+	}
+
 	return nil
 }
 
@@ -314,19 +721,106 @@ func (c *Config) GetSSHPassword(server string) string {
 	return os.Getenv(envVar)
 }
 
+// GetDataFileMode returns the permissions for data files (default: 0600)
+func (c *Config) GetDataFileMode() os.FileMode {
+	mode, err := parseFileMode(c.Data.FileMode)
+	if err != nil || mode == 0 {
+		return 0600
+	}
+	return mode
+}
+
+// GetDataDirMode returns the permissions for data directories (default: 0700)
+func (c *Config) GetDataDirMode() os.FileMode {
+	mode, err := parseFileMode(c.Data.DirMode)
+	if err != nil || mode == 0 {
+		return 0700
+	}
+	return mode
+}
+
+// GetGzipLevel returns the compression level for .json.gz exports (default: gzip.DefaultCompression)
+func (c *Config) GetGzipLevel() int {
+	if c.Data.GzipLevel < 1 || c.Data.GzipLevel > 9 {
+		return gzip.DefaultCompression
+	}
+	return c.Data.GzipLevel
+}
+
+// GetOrphanChannelPolicy returns the policy for channels whose team wasn't imported (default: import_flat)
+func (c *Config) GetOrphanChannelPolicy() string {
+	if c.Matrix.OrphanChannelPolicy == "" {
+		return "import_flat"
+	}
+	return c.Matrix.OrphanChannelPolicy
+}
+
+// GetOversizePolicy returns the policy for message bodies over max_body_bytes
+func (c *Config) GetOversizePolicy() string {
+	if c.Messages.OversizePolicy == "" {
+		return "split"
+	}
+	return c.Messages.OversizePolicy
+}
+
+// ImportArchivedChannels returns true if archived teams and channels should be
+// imported, which happens only when their rooms will be made read-only
+func (c *Config) ImportArchivedChannels() bool {
+	return c.Mattermost.IncludeDeleted && c.Matrix.ArchivedRoomsReadonly
+}
+
+// UseFixedFileNames returns true if data files are named without a
+// timestamp, each run overwriting the files of the previous one
+func (c *Config) UseFixedFileNames() bool {
+	return c.Data.FileNaming == "fixed"
+}
+
+// UseBoltMessageMapping returns true if the message mapping for an import of
+// postCount posts should be kept on disk instead of in memory
+func (c *Config) UseBoltMessageMapping(postCount int) bool {
+	switch c.Data.MessageMappingBackend {
+	case "bolt":
+		return true
+	case "memory":
+		return false
+	}
+	limit := c.Data.MessageMappingMemoryLimit
+	if limit <= 0 {
+		limit = 200000
+	}
+	return postCount > limit
+}
+
+// parseFileMode parses an octal permission string such as "0600"; empty means unset
+func parseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid octal permissions %q", value)
+	}
+	return os.FileMode(mode), nil
+}
+
 // EnsureDataDirs creates data directories if they don't exist
 func (c *Config) EnsureDataDirs() error {
 	dirs := []string{c.Data.AssetsDir, c.Data.MappingsDir}
+	dirMode := c.GetDataDirMode()
 
 	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err := os.MkdirAll(dir, dirMode); err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
+		// Tighten permissions of directories created by older versions
+		if err := os.Chmod(dir, dirMode); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", dir, err)
+		}
 	}
 
 	// Ensure state file directory exists
 	stateDir := filepath.Dir(c.Data.StateFile)
-	if err := os.MkdirAll(stateDir, 0755); err != nil {
+	if err := os.MkdirAll(stateDir, dirMode); err != nil {
 		return fmt.Errorf("failed to create state directory %s: %w", stateDir, err)
 	}
 
@@ -336,13 +830,18 @@ func (c *Config) EnsureDataDirs() error {
 // MattermostDSN returns the PostgreSQL connection string for Mattermost
 func (c *Config) MattermostDSN() string {
 	password := c.GetMattermostDBPassword()
+	sslMode := c.Mattermost.Database.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
 	return fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		c.Mattermost.Database.Host,
 		c.Mattermost.Database.Port,
 		c.Mattermost.Database.User,
 		password,
 		c.Mattermost.Database.Name,
+		sslMode,
 	)
 }
 
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// RedactedValue replaces secrets in the output of Effective
+const RedactedValue = "<redacted>"
+
+// secretKeys are config keys whose values are secrets themselves
+var secretKeys = map[string]bool{
+	"webhook_url":   true, // Slack and hookshot webhook URLs embed their token
+	"extra_headers": true, // Usually carry auth headers for a proxy
+}
+
+// Effective returns the configuration as a tree of config keys, as it is in
+// effect after defaults, the config file and flags are combined. Secrets are
+// redacted: *_env keys show the variable name and whether it is set, never
+// its value.
+func (c *Config) Effective() map[string]interface{} {
+	return effectiveValue(reflect.ValueOf(*c), "").(map[string]interface{})
+}
+
+// effectiveValue converts a config value to maps, slices and plain values
+func effectiveValue(v reflect.Value, key string) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			out[name] = effectiveValue(v.Field(i), name)
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			name := fmt.Sprint(iter.Key().Interface())
+			if secretKeys[key] {
+				out[name] = RedactedValue
+				continue
+			}
+			out[name] = effectiveValue(iter.Value(), name)
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = effectiveValue(v.Index(i), key)
+		}
+		return out
+	case reflect.String:
+		return redactString(key, v.String())
+	default:
+		return v.Interface()
+	}
+}
+
+// redactString hides the secret parts of a string value
+func redactString(key, value string) string {
+	if value == "" {
+		return value
+	}
+	switch {
+	case strings.HasSuffix(key, "_env"):
+		if _, ok := os.LookupEnv(value); ok {
+			return value + " (set)"
+		}
+		return value + " (not set)"
+	case secretKeys[key], strings.Contains(key, "password"), strings.Contains(key, "token"), strings.Contains(key, "secret"):
+		return RedactedValue
+	case key == "http_proxy":
+		// Proxy URLs may carry credentials
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = url.User("redacted")
+			return u.String()
+		}
+	}
+	return value
+}
@@ -3,6 +3,10 @@
 import (
 	"embed"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -45,6 +49,7 @@ type MenuStrings struct {
 	TestMatrix        string `yaml:"test_matrix"`
 	Settings          string `yaml:"settings"`
 	Status            string `yaml:"status"`
+	Logs              string `yaml:"logs"`
 	Quit              string `yaml:"quit"`
 	Back              string `yaml:"back"`
 	Confirm           string `yaml:"confirm"`
@@ -107,6 +112,7 @@ type StatusStrings struct {
 	InProgress     string `yaml:"in_progress"`
 	Completed      string `yaml:"completed"`
 	Failed         string `yaml:"failed"`
+	Cancelled      string `yaml:"cancelled"`
 	Skipped        string `yaml:"skipped"`
 	LastRun        string `yaml:"last_run"`
 	Never          string `yaml:"never"`
@@ -169,12 +175,26 @@ type HelpStrings struct {
 
 var (
 	currentLocale *Locale
+	currentLang   string
 	defaultLang   = "en"
-	supportedLang = []string{"en", "tr"}
+	localeDir     string // External locale directory; see SetLocaleDir
 	mu            sync.RWMutex
 )
 
-// Init initializes the i18n system with the specified language
+// SetLocaleDir sets the directory searched for external locale files
+// (<lang>.yaml). Without it, the locales directory next to the executable
+// is used. It takes effect with the next Init.
+func SetLocaleDir(dir string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	localeDir = dir
+}
+
+// Init initializes the i18n system with the specified language. The
+// embedded default language is loaded first, then the embedded file of
+// lang and then an external <lang>.yaml, each over the previous one, so an
+// external file only needs the keys it changes.
 func Init(lang string) error {
 	mu.Lock()
 	defer mu.Unlock()
@@ -185,38 +205,108 @@ func Init(lang string) error {
 		lang = defaultLang
 	}
 
-	// Load locale file
-	data, err := localesFS.ReadFile(fmt.Sprintf("locales/%s.yaml", lang))
+	data, err := localesFS.ReadFile(fmt.Sprintf("locales/%s.yaml", defaultLang))
 	if err != nil {
-		// Fallback to default language
-		data, err = localesFS.ReadFile(fmt.Sprintf("locales/%s.yaml", defaultLang))
-		if err != nil {
-			return fmt.Errorf("failed to load locale file: %w", err)
-		}
+		return fmt.Errorf("failed to load locale file: %w", err)
 	}
-
 	locale := &Locale{}
 	if err := yaml.Unmarshal(data, locale); err != nil {
 		return fmt.Errorf("failed to parse locale file: %w", err)
 	}
 
+	if lang != defaultLang {
+		if data, err := localesFS.ReadFile(fmt.Sprintf("locales/%s.yaml", lang)); err == nil {
+			if err := yaml.Unmarshal(data, locale); err != nil {
+				return fmt.Errorf("failed to parse locale file: %w", err)
+			}
+		}
+	}
+
+	if dir := externalLocaleDir(); dir != "" {
+		path := filepath.Join(dir, lang+".yaml")
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to load locale file %s: %w", path, err)
+		}
+		if err == nil {
+			if err := yaml.Unmarshal(data, locale); err != nil {
+				return fmt.Errorf("failed to parse locale file %s: %w", path, err)
+			}
+		}
+	}
+
 	currentLocale = locale
+	currentLang = lang
 	return nil
 }
 
+// externalLocaleDir returns the directory of external locale files: the
+// one set with SetLocaleDir, or the locales directory next to the
+// executable ("" if that can't be determined)
+func externalLocaleDir() string {
+	if localeDir != "" {
+		return localeDir
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(exe), "locales")
+}
+
+// CurrentLanguage returns the code of the current language
+func CurrentLanguage() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if currentLang == "" {
+		return defaultLang
+	}
+	return currentLang
+}
+
 // isSupported checks if a language is supported
 func isSupported(lang string) bool {
-	for _, l := range supportedLang {
-		if l == lang {
-			return true
-		}
-	}
-	return false
+	return slices.Contains(supportedLanguages(), lang)
 }
 
-// GetSupportedLanguages returns a list of supported language codes
+// GetSupportedLanguages returns a list of supported language codes: the
+// embedded languages, then those only found in the external locale directory
 func GetSupportedLanguages() []string {
-	return supportedLang
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return supportedLanguages()
+}
+
+// supportedLanguages lists the embedded and external locale files; the
+// caller holds mu
+func supportedLanguages() []string {
+	var langs []string
+	embedded, _ := fs.Glob(localesFS, "locales/*.yaml")
+	for _, path := range embedded {
+		langs = append(langs, strings.TrimSuffix(filepath.Base(path), ".yaml"))
+	}
+
+	dir := externalLocaleDir()
+	if dir == "" {
+		return langs
+	}
+	// A missing or unreadable directory just has no languages
+	entries, _ := os.ReadDir(dir)
+	var external []string
+	for _, entry := range entries {
+		// Language codes are lower case, as Init looks them up
+		name, ok := strings.CutSuffix(entry.Name(), ".yaml")
+		if !ok || entry.IsDir() || name != strings.ToLower(name) {
+			continue
+		}
+		if !slices.Contains(langs, name) && !slices.Contains(external, name) {
+			external = append(external, name)
+		}
+	}
+	slices.Sort(external)
+	return append(langs, external...)
 }
 
 // Current returns the current locale
@@ -314,6 +404,8 @@ func getMenuString(l *Locale, key string) string {
 		return l.Menu.Settings
 	case "status":
 		return l.Menu.Status
+	case "logs":
+		return l.Menu.Logs
 	case "quit":
 		return l.Menu.Quit
 	case "back":
@@ -432,6 +524,8 @@ func getStatusString(l *Locale, key string) string {
 		return l.Status.Completed
 	case "failed":
 		return l.Status.Failed
+	case "cancelled":
+		return l.Status.Cancelled
 	case "skipped":
 		return l.Status.Skipped
 	case "last_run":
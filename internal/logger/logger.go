@@ -11,6 +11,7 @@ import (
 // Logger provides file-based logging
 type Logger struct {
 	file   *os.File
+	path   string
 	mu     sync.Mutex
 	closed bool
 }
@@ -40,7 +41,7 @@ func Init(dataDir string) error {
 			return
 		}
 
-		instance = &Logger{file: file}
+		instance = &Logger{file: file, path: logPath}
 
 		// Write session header
 		instance.writeHeader()
@@ -48,6 +49,14 @@ func Init(dataDir string) error {
 	return initErr
 }
 
+// Path returns the path of the log file, or "" if the logger is not initialized
+func Path() string {
+	if instance == nil {
+		return ""
+	}
+	return instance.path
+}
+
 // Close closes the logger
 func Close() {
 	if instance != nil && !instance.closed {
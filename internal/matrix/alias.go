@@ -0,0 +1,119 @@
+package matrix
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+)
+
+// Default alias templates; stable aliases let re-runs find rooms created by a previous partial run
+const (
+	DefaultRoomAliasTemplate  = "mm_{{.Channel.ID}}"
+	DefaultSpaceAliasTemplate = "mm_team_{{.Team.ID}}"
+)
+
+// directRoomAlias returns the alias localpart of a direct or group message
+// room. It doesn't follow the room template: DM rooms have no team or name.
+func directRoomAlias(channel mattermost.Channel) string {
+	return "mm_dm_" + channel.ID
+}
+
+// maxAliasLocalpartLength keeps the full alias well below Matrix's 255 byte limit
+const maxAliasLocalpartLength = 200
+
+// AliasData is the data available to alias templates.
+// Channel is empty when generating a space alias; Team is empty for
+// channels without a team.
+type AliasData struct {
+	Team    mattermost.Team
+	Channel mattermost.Channel
+}
+
+// AliasGenerator builds the deterministic alias localparts used when
+// creating rooms and spaces and when resolving ones that already exist
+type AliasGenerator struct {
+	room  *template.Template
+	space *template.Template
+}
+
+// NewAliasGenerator parses the room and space alias templates.
+// Empty templates fall back to the defaults.
+func NewAliasGenerator(roomTemplate, spaceTemplate string) (*AliasGenerator, error) {
+	if roomTemplate == "" {
+		roomTemplate = DefaultRoomAliasTemplate
+	}
+	if spaceTemplate == "" {
+		spaceTemplate = DefaultSpaceAliasTemplate
+	}
+
+	room, err := template.New("room_alias").Option("missingkey=error").Parse(roomTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid room alias template: %w", err)
+	}
+	space, err := template.New("space_alias").Option("missingkey=error").Parse(spaceTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid space alias template: %w", err)
+	}
+
+	return &AliasGenerator{room: room, space: space}, nil
+}
+
+// defaultAliasGenerator is used when no templates are configured
+var defaultAliasGenerator, _ = NewAliasGenerator("", "")
+
+// SpaceAlias returns the alias localpart for a team's space
+func (g *AliasGenerator) SpaceAlias(team mattermost.Team) string {
+	alias, err := g.render(g.space, AliasData{Team: team})
+	if err != nil {
+		logger.Warn("Space alias template failed for team %s, using default alias: %v", team.ID, err)
+		return "mm_team_" + team.ID
+	}
+	return alias
+}
+
+// RoomAlias returns the alias localpart for a channel's room
+func (g *AliasGenerator) RoomAlias(channel mattermost.Channel, team mattermost.Team) string {
+	alias, err := g.render(g.room, AliasData{Team: team, Channel: channel})
+	if err != nil {
+		logger.Warn("Room alias template failed for channel %s, using default alias: %v", channel.ID, err)
+		return "mm_" + channel.ID
+	}
+	return alias
+}
+
+// render executes tmpl and sanitizes the result into a valid alias localpart
+func (g *AliasGenerator) render(tmpl *template.Template, data AliasData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	alias := SanitizeAliasLocalpart(buf.String())
+	if alias == "" {
+		return "", fmt.Errorf("template produced an empty alias")
+	}
+	return alias, nil
+}
+
+// SanitizeAliasLocalpart turns s into a valid room alias localpart:
+// lowercase, with characters outside [a-z0-9._=-] replaced by '_'
+func SanitizeAliasLocalpart(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '=', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	alias := b.String()
+	if len(alias) > maxAliasLocalpartLength {
+		alias = alias[:maxAliasLocalpartLength]
+	}
+	return alias
+}
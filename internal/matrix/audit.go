@@ -0,0 +1,101 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+// AuditEntry is one mutating API call recorded in the audit log
+type AuditEntry struct {
+	Timestamp string `json:"timestamp"`        // RFC 3339, UTC
+	Method    string `json:"method"`           // HTTP method
+	Endpoint  string `json:"endpoint"`         // Request path without query string
+	Target    string `json:"target,omitempty"` // User, room or alias the call acted on
+	Status    int    `json:"status"`           // HTTP status code (0 if the request failed)
+	Error     string `json:"error,omitempty"`  // Transport error, if any
+}
+
+// AuditLog appends API calls that change the homeserver to a JSON lines file
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if needed
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), archive.DirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, archive.FileMode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &AuditLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record appends an entry to the audit log
+func (a *AuditLog) Record(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enc.Encode(entry)
+}
+
+// Close closes the audit log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// audit records a mutating API call in the audit log, if one is set
+func (c *Client) audit(method, endpoint string, statusCode int, reqErr error) {
+	if c.auditLog == nil || method == "GET" || method == "HEAD" {
+		return
+	}
+
+	path := endpoint
+	if idx := strings.Index(path, "?"); idx != -1 {
+		path = path[:idx]
+	}
+
+	entry := AuditEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Method:    method,
+		Endpoint:  path,
+		Target:    auditTarget(path),
+		Status:    statusCode,
+	}
+	if reqErr != nil {
+		entry.Error = reqErr.Error()
+	}
+
+	if err := c.auditLog.Record(entry); err != nil {
+		logger.Error("Failed to write audit log entry for %s %s: %v", method, path, err)
+	}
+}
+
+// auditTarget returns the first user ID, room ID or alias in an endpoint path
+func auditTarget(path string) string {
+	for _, segment := range strings.Split(path, "/") {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(decoded, "@") || strings.HasPrefix(decoded, "!") || strings.HasPrefix(decoded, "#") {
+			return decoded
+		}
+	}
+	return ""
+}
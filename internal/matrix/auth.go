@@ -3,15 +3,21 @@ package matrix
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/aligundogdu/matrixmigrate/internal/logger"
 )
 
+// loginEndpoint is the client-server API path used for password login
+const loginEndpoint = "/_matrix/client/v3/login"
+
 // LoginRequest represents a Matrix login request
 type LoginRequest struct {
 	Type       string `json:"type"`
@@ -38,15 +44,39 @@ type LoginFlowsResponse struct {
 	} `json:"flows"`
 }
 
+// LoginOptions configures how Login talks to the homeserver
+type LoginOptions struct {
+	MaxRetries int           // Retries on 429 (default: 5)
+	BaseDelay  time.Duration // Base delay for exponential backoff (default: 2s)
+	Attempts   int           // Attempts while the homeserver is unreachable or returns 502/503/504 (default: 1)
+	RetryDelay time.Duration // Delay between those attempts (default: 2s)
+	HTTP       HTTPOptions   // Extra headers and proxy
+}
+
 // Login authenticates with Matrix and returns an access token
 func Login(baseURL, username, password string) (*LoginResponse, error) {
-	return LoginWithRetry(baseURL, username, password, 5, 2*time.Second)
+	return LoginWithOptions(baseURL, username, password, LoginOptions{})
 }
 
 // LoginWithRetry authenticates with Matrix with retry support for rate limiting
 func LoginWithRetry(baseURL, username, password string, maxRetries int, baseDelay time.Duration) (*LoginResponse, error) {
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
+	return LoginWithOptions(baseURL, username, password, LoginOptions{MaxRetries: maxRetries, BaseDelay: baseDelay})
+}
+
+// LoginWithOptions authenticates with Matrix using the given options
+func LoginWithOptions(baseURL, username, password string, opts LoginOptions) (*LoginResponse, error) {
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 5
+	}
+	baseDelay := opts.BaseDelay
+	if baseDelay <= 0 {
+		baseDelay = 2 * time.Second
+	}
+
+	httpClient, err := opts.HTTP.newHTTPClient(30 * time.Second)
+	if err != nil {
+		return nil, err
 	}
 
 	// Prepare login request
@@ -63,20 +93,13 @@ func LoginWithRetry(baseURL, username, password string, maxRetries int, baseDela
 		return nil, fmt.Errorf("failed to marshal login request: %w", err)
 	}
 
-	loginURL := baseURL + "/_matrix/client/v3/login"
+	loginURL := baseURL + loginEndpoint
 	
 	var lastErr error
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		// Send login request
-		resp, err := httpClient.Post(loginURL, "application/json", bytes.NewReader(reqBody))
-		if err != nil {
-			return nil, fmt.Errorf("login request failed: %w", err)
-		}
-
-		respBody, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		resp, respBody, err := postLogin(httpClient, loginURL, reqBody, opts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read response: %w", err)
+			return nil, err
 		}
 
 		var loginResp LoginResponse
@@ -87,7 +110,7 @@ func LoginWithRetry(baseURL, username, password string, maxRetries int, baseDela
 		// Handle rate limiting (429) with exponential backoff
 		if resp.StatusCode == http.StatusTooManyRequests {
 			if attempt >= maxRetries {
-				return nil, fmt.Errorf("login failed after %d retries: %s - %s", maxRetries, loginResp.Errcode, loginResp.Error)
+				return nil, fmt.Errorf("login failed after %d retries: %w", maxRetries, newAPIError("POST", loginEndpoint, resp.StatusCode, loginResp.Errcode, loginResp.Error))
 			}
 			
 			// Try to use Retry-After header if present
@@ -110,12 +133,12 @@ func LoginWithRetry(baseURL, username, password string, maxRetries int, baseDela
 			
 			logger.Warn("Login rate limit hit (429), waiting %v before retry %d/%d", retryAfter, attempt+1, maxRetries)
 			time.Sleep(retryAfter)
-			lastErr = fmt.Errorf("%s - %s", loginResp.Errcode, loginResp.Error)
+			lastErr = newAPIError("POST", loginEndpoint, resp.StatusCode, loginResp.Errcode, loginResp.Error)
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("login failed: %s - %s", loginResp.Errcode, loginResp.Error)
+			return nil, fmt.Errorf("login failed: %w", newAPIError("POST", loginEndpoint, resp.StatusCode, loginResp.Errcode, loginResp.Error))
 		}
 
 		if loginResp.AccessToken == "" {
@@ -125,7 +148,70 @@ func LoginWithRetry(baseURL, username, password string, maxRetries int, baseDela
 		return &loginResp, nil
 	}
 
-	return nil, fmt.Errorf("login failed after retries: %v", lastErr)
+	return nil, fmt.Errorf("login failed after retries: %w", lastErr)
+}
+
+// postLogin sends the login request and returns the response and its body.
+// Connection failures and gateway errors, typical right after the tunnel is
+// opened or while Synapse is starting, are retried up to opts.Attempts times;
+// any other response is returned as is.
+func postLogin(httpClient *http.Client, loginURL string, reqBody []byte, opts LoginOptions) (*http.Response, []byte, error) {
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	retryDelay := opts.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = 2 * time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest("POST", loginURL, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create login request: %w", err)
+		}
+		opts.HTTP.applyHeaders(req)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			respBody, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if readErr != nil {
+				return nil, nil, fmt.Errorf("failed to read response: %w", readErr)
+			}
+			if !isTransientLoginStatus(resp.StatusCode) {
+				return resp, respBody, nil
+			}
+			err = fmt.Errorf("homeserver unavailable (%d)", resp.StatusCode)
+		} else if !isTransientLoginError(err) {
+			return nil, nil, fmt.Errorf("login request failed: %w", err)
+		}
+
+		if attempt >= attempts {
+			return nil, nil, fmt.Errorf("login request failed after %d attempt(s): %w", attempt, err)
+		}
+		logger.Warn("Login attempt %d/%d failed: %v, retrying in %v", attempt, attempts, err, retryDelay)
+		time.Sleep(retryDelay)
+	}
+}
+
+// isTransientLoginStatus reports whether a status means the homeserver is
+// not ready yet (reverse proxy up, Synapse not)
+func isTransientLoginStatus(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// isTransientLoginError reports whether a transport error is worth retrying
+func isTransientLoginError(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 // CheckLoginFlows checks available login methods
@@ -134,7 +220,7 @@ func CheckLoginFlows(baseURL string) (*LoginFlowsResponse, error) {
 		Timeout: 10 * time.Second,
 	}
 
-	resp, err := httpClient.Get(baseURL + "/_matrix/client/v3/login")
+	resp, err := httpClient.Get(baseURL + loginEndpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get login flows: %w", err)
 	}
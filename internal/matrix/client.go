@@ -2,11 +2,13 @@
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,15 +43,25 @@ type Client struct {
 	// Application Service support
 	asToken    string // AS token for message import with timestamps
 	
-	// Rate limiting
-	lastRequest     time.Time
-	rateLimit       time.Duration
+	// Rate limiting; the limiter may be shared with other clients
+	limiter         *RateLimiter
 	maxRetries      int
 	retryBaseDelay  time.Duration
-	mu              sync.Mutex
 	
 	// Transaction ID counter for messages
+	mu         sync.Mutex
 	txnCounter int64
+
+	// auditLog records mutating API calls (optional)
+	auditLog *AuditLog
+
+	// httpOptions holds extra headers and proxy settings
+	httpOptions HTTPOptions
+
+	// Whether the Synapse admin v2 API is served, once a request has shown it
+	adminV2Mu    sync.Mutex
+	adminV2Known bool
+	adminV2      bool
 }
 
 // NewClient creates a new Matrix API client with default rate limiting
@@ -59,11 +71,6 @@ func NewClient(baseURL, adminToken, homeserver string) *Client {
 
 // NewClientWithRateLimit creates a new Matrix API client with custom rate limiting
 func NewClientWithRateLimit(baseURL, adminToken, homeserver string, rlConfig RateLimitConfig) *Client {
-	var rateLimit time.Duration
-	if rlConfig.RequestsPerSecond > 0 {
-		rateLimit = time.Duration(float64(time.Second) / rlConfig.RequestsPerSecond)
-	}
-	
 	maxRetries := rlConfig.MaxRetries
 	if maxRetries <= 0 {
 		maxRetries = 5
@@ -81,17 +88,44 @@ func NewClientWithRateLimit(baseURL, adminToken, homeserver string, rlConfig Rat
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		rateLimit:      rateLimit,
+		limiter:        NewRateLimiter(rlConfig.RequestsPerSecond),
 		maxRetries:     maxRetries,
 		retryBaseDelay: retryBaseDelay,
 	}
 }
 
+// SetRateLimiter makes the client share limiter with other clients, so that
+// their combined request rate stays within the limiter's
+func (c *Client) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+}
+
+// RateLimiter returns the client's rate limiter, for sharing with other clients
+func (c *Client) RateLimiter() *RateLimiter {
+	return c.limiter
+}
+
 // SetHomeserver updates the homeserver domain
 func (c *Client) SetHomeserver(homeserver string) {
 	c.homeserver = homeserver
 }
 
+// SetHTTPOptions sets extra request headers and the outbound proxy
+func (c *Client) SetHTTPOptions(opts HTTPOptions) error {
+	httpClient, err := opts.newHTTPClient(c.httpClient.Timeout)
+	if err != nil {
+		return err
+	}
+	c.httpClient = httpClient
+	c.httpOptions = opts
+	return nil
+}
+
+// SetAuditLog sets the log that mutating API calls are recorded in
+func (c *Client) SetAuditLog(auditLog *AuditLog) {
+	c.auditLog = auditLog
+}
+
 // GetHomeserver returns the current homeserver domain
 func (c *Client) GetHomeserver() string {
 	return c.homeserver
@@ -99,8 +133,8 @@ func (c *Client) GetHomeserver() string {
 
 // DetectHomeserver detects the homeserver from the authenticated user ID
 // Returns the detected homeserver or error
-func (c *Client) DetectHomeserver() (string, error) {
-	resp, err := c.WhoAmI()
+func (c *Client) DetectHomeserver(ctx context.Context) (string, error) {
+	resp, err := c.WhoAmI(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to get current user: %w", err)
 	}
@@ -127,92 +161,38 @@ func (c *Client) DetectHomeserver() (string, error) {
 }
 
 // doRequest performs an HTTP request to the Matrix API with rate limiting
-func (c *Client) doRequest(method, endpoint string, body interface{}) ([]byte, int, error) {
-	return c.doRequestWithRetry(method, endpoint, body, 0)
+func (c *Client) doRequest(ctx context.Context, method, endpoint string, body interface{}) ([]byte, int, error) {
+	return c.doRequestWithToken(ctx, method, endpoint, body, c.adminToken)
 }
 
-// doRequestWithRetry performs an HTTP request with retry logic for rate limiting
-func (c *Client) doRequestWithRetry(method, endpoint string, body interface{}, retryCount int) ([]byte, int, error) {
-	// Rate limiting: ensure minimum time between requests
-	c.mu.Lock()
-	if c.rateLimit > 0 {
-		elapsed := time.Since(c.lastRequest)
-		if elapsed < c.rateLimit {
-			sleepTime := c.rateLimit - elapsed
-			time.Sleep(sleepTime)
-		}
-	}
-	c.lastRequest = time.Now()
-	c.mu.Unlock()
-
-	var reqBody io.Reader
-	if body != nil {
-		jsonBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to marshal request body: %w", err)
-		}
-		reqBody = bytes.NewReader(jsonBody)
-	}
-
-	reqURL := c.baseURL + endpoint
-	req, err := http.NewRequest(method, reqURL, reqBody)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.adminToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+// WhoAmI returns the current user ID for the admin token
+func (c *Client) WhoAmI(ctx context.Context) (*WhoAmIResponse, error) {
+	body, statusCode, err := c.doRequest(ctx, "GET", "/_matrix/client/v3/account/whoami", nil)
 	if err != nil {
-		return nil, 0, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
+	var resp WhoAmIResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	// Handle rate limiting (429) with exponential backoff
-	if resp.StatusCode == http.StatusTooManyRequests {
-		if retryCount >= c.maxRetries {
-			return nil, resp.StatusCode, fmt.Errorf("rate limit exceeded after %d retries", c.maxRetries)
-		}
-		
-		// Try to use Retry-After header if present
-		var retryAfter time.Duration
-		if retryAfterStr := resp.Header.Get("Retry-After"); retryAfterStr != "" {
-			// Retry-After can be in seconds (integer) or HTTP-date format
-			if seconds, err := strconv.Atoi(retryAfterStr); err == nil {
-				retryAfter = time.Duration(seconds) * time.Second
-			}
-		}
-		
-		// If no Retry-After header, use exponential backoff
-		if retryAfter == 0 {
-			// Exponential backoff: base * 2^retryCount (e.g., 2s, 4s, 8s, 16s, 32s)
-			retryAfter = c.retryBaseDelay * time.Duration(1<<uint(retryCount))
-		}
-		
-		// Cap the delay at 60 seconds
-		if retryAfter > 60*time.Second {
-			retryAfter = 60 * time.Second
-		}
-		
-		logger.Warn("Rate limit hit (429), waiting %v before retry %d/%d", retryAfter, retryCount+1, c.maxRetries)
-		time.Sleep(retryAfter)
-		
-		// Retry
-		return c.doRequestWithRetry(method, endpoint, body, retryCount+1)
+	if statusCode != http.StatusOK {
+		return nil, newAPIError("GET", "/_matrix/client/v3/account/whoami", statusCode, resp.Errcode, resp.Error)
 	}
 
-	return respBody, resp.StatusCode, nil
+	return &resp, nil
 }
 
-// WhoAmI returns the current user ID for the admin token
-func (c *Client) WhoAmI() (*WhoAmIResponse, error) {
-	body, statusCode, err := c.doRequest("GET", "/_matrix/client/v3/account/whoami", nil)
+// AppServiceWhoAmI returns the user the Application Service token acts as
+// (its sender_localpart user), verifying the homeserver accepts the token
+func (c *Client) AppServiceWhoAmI(ctx context.Context) (*WhoAmIResponse, error) {
+	if c.asToken == "" {
+		return nil, fmt.Errorf("no Application Service token configured")
+	}
+
+	endpoint := "/_matrix/client/v3/account/whoami"
+	body, statusCode, err := c.doRequestWithToken(ctx, "GET", endpoint, nil, c.asToken)
 	if err != nil {
 		return nil, err
 	}
@@ -223,26 +203,102 @@ func (c *Client) WhoAmI() (*WhoAmIResponse, error) {
 	}
 
 	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Errcode, resp.Error)
+		return nil, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
 	return &resp, nil
 }
 
 // TestConnection tests the API connection
-func (c *Client) TestConnection() error {
-	_, err := c.WhoAmI()
+func (c *Client) TestConnection(ctx context.Context) error {
+	_, err := c.WhoAmI(ctx)
 	return err
 }
 
+// AdminV2Available reports whether the homeserver serves the Synapse admin
+// v2 API. Synapse versions without it only have v1 endpoints, and reverse
+// proxies often don't forward /_synapse/admin at all. The answer is probed
+// once and cached.
+func (c *Client) AdminV2Available(ctx context.Context) (bool, error) {
+	c.adminV2Mu.Lock()
+	known, available := c.adminV2Known, c.adminV2
+	c.adminV2Mu.Unlock()
+	if known {
+		return available, nil
+	}
+
+	endpoint := "/_synapse/admin/v2/users?limit=1"
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	if isUnrecognizedEndpoint(statusCode, body) {
+		c.setAdminV2(false)
+		return false, nil
+	}
+	if statusCode != http.StatusOK {
+		var resp UserResponse
+		json.Unmarshal(body, &resp)
+		return false, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	c.setAdminV2(true)
+	return true, nil
+}
+
+// setAdminV2 records whether the admin v2 API is served
+func (c *Client) setAdminV2(available bool) {
+	c.adminV2Mu.Lock()
+	defer c.adminV2Mu.Unlock()
+
+	if !available && (!c.adminV2Known || c.adminV2) {
+		logger.Warn("%v; user lookups fall back to the client API, user creation is not possible", ErrAdminV2Unavailable)
+	}
+	c.adminV2Known = true
+	c.adminV2 = available
+}
+
+// adminV2Missing returns true if a request has shown that the admin v2 API is not served
+func (c *Client) adminV2Missing() bool {
+	c.adminV2Mu.Lock()
+	defer c.adminV2Mu.Unlock()
+	return c.adminV2Known && !c.adminV2
+}
+
+// isUnrecognizedEndpoint returns true if a response says that the endpoint
+// itself doesn't exist, rather than the user or room it names. Synapse
+// answers unknown paths with M_UNRECOGNIZED; proxies that don't forward a
+// path answer with a page that isn't a Matrix error.
+func isUnrecognizedEndpoint(statusCode int, body []byte) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed:
+	default:
+		return false
+	}
+
+	var resp struct {
+		Errcode string `json:"errcode"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return statusCode != http.StatusBadRequest
+	}
+	return resp.Errcode == "M_UNRECOGNIZED"
+}
+
 // CreateUser creates or updates a user via the Admin API
-func (c *Client) CreateUser(username string, req *CreateUserRequest) (*UserResponse, error) {
+func (c *Client) CreateUser(ctx context.Context, username string, req *CreateUserRequest) (*UserResponse, error) {
 	userID := fmt.Sprintf("@%s:%s", username, c.homeserver)
 	endpoint := fmt.Sprintf("/_synapse/admin/v2/users/%s", url.PathEscape(userID))
 
+	// The v1 registration API needs the registration shared secret instead
+	// of an admin token, so there is nothing to fall back to
+	if c.adminV2Missing() {
+		return nil, fmt.Errorf("failed to create user %s: %w", username, ErrAdminV2Unavailable)
+	}
+
 	logger.Info("Creating user: %s (endpoint: %s)", username, endpoint)
 
-	body, statusCode, err := c.doRequest("PUT", endpoint, req)
+	body, statusCode, err := c.doRequest(ctx, "PUT", endpoint, req)
 	if err != nil {
 		logger.Error("HTTP request failed for user '%s': %v", username, err)
 		return nil, err
@@ -250,6 +306,11 @@ func (c *Client) CreateUser(username string, req *CreateUserRequest) (*UserRespo
 
 	logger.Info("CreateUser response for '%s': status=%d", username, statusCode)
 
+	if isUnrecognizedEndpoint(statusCode, body) {
+		c.setAdminV2(false)
+		return nil, fmt.Errorf("failed to create user %s: %w", username, ErrAdminV2Unavailable)
+	}
+
 	var resp UserResponse
 	if err := json.Unmarshal(body, &resp); err != nil {
 		logger.Error("Failed to parse response for user '%s': %v (body: %s)", username, err, string(body))
@@ -264,22 +325,32 @@ func (c *Client) CreateUser(username string, req *CreateUserRequest) (*UserRespo
 			return &resp, nil
 		}
 		logger.Error("API error for user '%s': status=%d, errcode=%s, error=%s", username, statusCode, resp.Errcode, resp.Error)
-		return nil, fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return nil, newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
 	resp.UserID = userID
 	return &resp, nil
 }
 
-// GetUser gets user info via the Admin API
-func (c *Client) GetUser(userID string) (*UserResponse, error) {
+// GetUser gets user info via the Admin API. Without the admin v2 API only
+// the user's profile is available; Admin and Deactivated are then unknown.
+func (c *Client) GetUser(ctx context.Context, userID string) (*UserResponse, error) {
+	if c.adminV2Missing() {
+		return c.getUserProfile(ctx, userID)
+	}
+
 	endpoint := fmt.Sprintf("/_synapse/admin/v2/users/%s", url.PathEscape(userID))
 
-	body, statusCode, err := c.doRequest("GET", endpoint, nil)
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if isUnrecognizedEndpoint(statusCode, body) {
+		c.setAdminV2(false)
+		return c.getUserProfile(ctx, userID)
+	}
+
 	var resp UserResponse
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
@@ -290,122 +361,521 @@ func (c *Client) GetUser(userID string) (*UserResponse, error) {
 	}
 
 	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return nil, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// getUserProfile looks a user up via the client profile API, which every
+// Synapse version serves. The r0 path is used because old versions don't
+// know v3.
+func (c *Client) getUserProfile(ctx context.Context, userID string) (*UserResponse, error) {
+	endpoint := fmt.Sprintf("/_matrix/client/r0/profile/%s", url.PathEscape(userID))
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp UserResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if statusCode == http.StatusNotFound {
+		return nil, nil // User doesn't exist
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	resp.Name = userID
+	resp.UserID = userID
+	return &resp, nil
+}
+
+// UserExists checks if a user exists
+func (c *Client) UserExists(ctx context.Context, username string) (bool, error) {
+	userID := fmt.Sprintf("@%s:%s", username, c.homeserver)
+	logger.Info("Checking if user exists: %s", userID)
+	user, err := c.GetUser(ctx, userID)
+	if err != nil {
+		logger.Error("UserExists check failed for '%s': %v", username, err)
+		return false, err
+	}
+	exists := user != nil
+	logger.Info("User '%s' exists: %v", username, exists)
+	return exists, nil
+}
+
+// ListUsers returns one page of users via the Admin API, starting at the
+// pagination token from ("" for the first page). The returned token is
+// passed as from to fetch the next page and is "" after the last page.
+// Deactivated users are included; guests are not.
+func (c *Client) ListUsers(ctx context.Context, from string, limit int) ([]User, string, error) {
+	params := url.Values{}
+	if from != "" {
+		params.Set("from", from)
+	}
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("guests", "false")
+	params.Set("deactivated", "true")
+	endpoint := "/_synapse/admin/v2/users?" + params.Encode()
+
+	if c.adminV2Missing() {
+		return nil, "", fmt.Errorf("failed to list users: %w", ErrAdminV2Unavailable)
+	}
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if isUnrecognizedEndpoint(statusCode, body) {
+		c.setAdminV2(false)
+		return nil, "", fmt.Errorf("failed to list users: %w", ErrAdminV2Unavailable)
+	}
+
+	var resp ListUsersResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, "", newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	users := make([]User, 0, len(resp.Users))
+	for _, u := range resp.Users {
+		users = append(users, User{
+			UserID:      u.Name,
+			DisplayName: u.DisplayName,
+			Admin:       bool(u.Admin),
+			Deactivated: bool(u.Deactivated),
+		})
+	}
+
+	nextToken := resp.NextToken.String()
+	if len(users) == 0 {
+		nextToken = ""
+	}
+	return users, nextToken, nil
+}
+
+// ListAllUserIDs pages through the Admin API user list and returns the set of all user IDs
+func (c *Client) ListAllUserIDs(ctx context.Context, pageSize int) (map[string]bool, error) {
+	userIDs := make(map[string]bool)
+	from := ""
+	for {
+		users, next, err := c.ListUsers(ctx, from, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			userIDs[user.UserID] = true
+		}
+
+		if next == "" || next == from {
+			return userIDs, nil
+		}
+		from = next
+	}
+}
+
+// CreateRoom creates a new room
+func (c *Client) CreateRoom(ctx context.Context, req *CreateRoomRequest) (*CreateRoomResponse, error) {
+	body, statusCode, err := c.doRequest(ctx, "POST", "/_matrix/client/v3/createRoom", req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp CreateRoomResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Alias already taken (e.g. by a previous partial run): reuse that room
+	if resp.Errcode == "M_ROOM_IN_USE" && req.RoomAliasName != "" {
+		alias := c.FormatRoomAlias(req.RoomAliasName)
+		roomID, err := c.ResolveAlias(ctx, alias)
+		if err != nil {
+			return nil, fmt.Errorf("alias %s is in use but could not be resolved: %w", alias, err)
+		}
+		if roomID != "" {
+			return &CreateRoomResponse{RoomID: roomID, Existing: true}, nil
+		}
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, newAPIError("POST", "/_matrix/client/v3/createRoom", statusCode, resp.Errcode, resp.Error)
 	}
 
 	return &resp, nil
 }
 
-// UserExists checks if a user exists
-func (c *Client) UserExists(username string) (bool, error) {
-	userID := fmt.Sprintf("@%s:%s", username, c.homeserver)
-	logger.Info("Checking if user exists: %s", userID)
-	user, err := c.GetUser(userID)
-	if err != nil {
-		logger.Error("UserExists check failed for '%s': %v", username, err)
-		return false, err
+// ResolveAlias returns the room ID an alias points to, or "" if the alias does not exist
+func (c *Client) ResolveAlias(ctx context.Context, alias string) (string, error) {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/directory/room/%s", url.PathEscape(alias))
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if statusCode == http.StatusNotFound {
+		return "", nil
+	}
+
+	var resp ResolveAliasResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if statusCode != http.StatusOK {
+		return "", newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return resp.RoomID, nil
+}
+
+// CreateAlias makes alias point to a room. Returns ErrAliasInUse if the
+// alias already exists, whichever room it points to.
+func (c *Client) CreateAlias(ctx context.Context, alias, roomID string) error {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/directory/room/%s", url.PathEscape(alias))
+
+	body, statusCode, err := c.doRequest(ctx, "PUT", endpoint, map[string]string{"room_id": roomID})
+	if err != nil {
+		return err
+	}
+
+	if statusCode == http.StatusConflict {
+		return ErrAliasInUse
+	}
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return nil
+}
+
+// SetCanonicalAlias makes alias the canonical alias of a room. The previous
+// canonical alias is kept as an alternative alias. The alias must already
+// point to the room.
+func (c *Client) SetCanonicalAlias(ctx context.Context, roomID, alias string) error {
+	var content CanonicalAliasContent
+	if _, err := c.GetStateEvent(ctx, roomID, EventTypeCanonicalAlias, "", &content); err != nil {
+		return fmt.Errorf("failed to read canonical alias: %w", err)
+	}
+	if content.Alias == alias {
+		return nil
+	}
+
+	if content.Alias != "" && !slices.Contains(content.AltAliases, content.Alias) {
+		content.AltAliases = append(content.AltAliases, content.Alias)
+	}
+	content.AltAliases = slices.DeleteFunc(content.AltAliases, func(a string) bool { return a == alias })
+	content.Alias = alias
+
+	return c.SetStateEvent(ctx, roomID, EventTypeCanonicalAlias, "", &content)
+}
+
+// FormatRoomAlias formats an alias localpart as a full room alias
+func (c *Client) FormatRoomAlias(localpart string) string {
+	return fmt.Sprintf("#%s:%s", localpart, c.homeserver)
+}
+
+// CreateSpace creates a new space (a room with m.space type)
+func (c *Client) CreateSpace(ctx context.Context, opts RoomOptions) (*CreateRoomResponse, error) {
+	req := newCreateRoomRequest(opts)
+	req.CreationContent = map[string]interface{}{
+		"type": SpaceType,
+	}
+
+	return c.CreateRoom(ctx, req)
+}
+
+// CreateRegularRoom creates a regular room (not a space)
+func (c *Client) CreateRegularRoom(ctx context.Context, opts RoomOptions) (*CreateRoomResponse, error) {
+	return c.CreateRoom(ctx, newCreateRoomRequest(opts))
+}
+
+// newCreateRoomRequest builds the createRoom request shared by spaces and rooms
+func newCreateRoomRequest(opts RoomOptions) *CreateRoomRequest {
+	visibility := VisibilityPrivate
+	if opts.Listed {
+		visibility = VisibilityPublic
+	}
+	preset := PresetPrivateChat
+	if opts.Public {
+		preset = PresetPublicChat
+	}
+	if opts.Direct {
+		preset = PresetTrustedPrivateChat
+	}
+
+	initialState := opts.InitialState
+	if opts.JoinRule != "" {
+		initialState = append(initialState[:len(initialState):len(initialState)], StateEvent{
+			Type:    EventTypeJoinRules,
+			Content: JoinRulesContent{JoinRule: opts.JoinRule},
+		})
+	}
+	if opts.Encrypted {
+		initialState = append(initialState[:len(initialState):len(initialState)], StateEvent{
+			Type:    EventTypeEncryption,
+			Content: EncryptionContent{Algorithm: EncryptionAlgorithmMegolm},
+		})
+	}
+
+	return &CreateRoomRequest{
+		Name:          opts.Name,
+		Topic:         opts.Topic,
+		RoomAliasName: opts.AliasName,
+		Visibility:    string(visibility),
+		Preset:        string(preset),
+		InitialState:  initialState,
+		RoomVersion:   opts.RoomVersion,
+		IsDirect:      opts.Direct,
+		Invite:        opts.Invite,
+	}
+}
+
+// SetRoomVisibility publishes a room to the room directory ("public") or
+// removes it from there ("private")
+func (c *Client) SetRoomVisibility(ctx context.Context, roomID string, visibility string) error {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/directory/list/room/%s", url.PathEscape(roomID))
+
+	body, statusCode, err := c.doRequest(ctx, "PUT", endpoint, &RoomVisibilityRequest{Visibility: visibility})
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return nil
+}
+
+// InviteUser invites a user to a room
+func (c *Client) InviteUser(ctx context.Context, roomID, userID string) error {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/invite", url.PathEscape(roomID))
+
+	req := &InviteRequest{
+		UserID: userID,
+	}
+
+	body, statusCode, err := c.doRequest(ctx, "POST", endpoint, req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		apiErr := newAPIError("POST", endpoint, statusCode, resp.Errcode, resp.Error)
+
+		// M_FORBIDDEN is also returned when the user already joined. Tell it
+		// apart from a missing permission by the message, or else by the
+		// room's member list.
+		if statusCode == http.StatusForbidden && resp.Errcode == "M_FORBIDDEN" {
+			if strings.Contains(resp.Error, "already in the room") {
+				return ErrAlreadyInRoom
+			}
+			members, err := c.GetRoomMembers(ctx, roomID)
+			if err == nil && slices.Contains(members, userID) {
+				return ErrAlreadyInRoom
+			}
+		}
+		return apiErr
+	}
+
+	return nil
+}
+
+// KickUser removes a user from a room, or withdraws their invite
+func (c *Client) KickUser(ctx context.Context, roomID, userID, reason string) error {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/kick", url.PathEscape(roomID))
+
+	body, statusCode, err := c.doRequest(ctx, "POST", endpoint, &KickRequest{UserID: userID, Reason: reason})
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		if statusCode == http.StatusForbidden && strings.Contains(resp.Error, "not in the room") {
+			return ErrNotInRoom
+		}
+		return newAPIError("POST", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return nil
+}
+
+// DeactivateUser deactivates an account via the Admin API, erasing its
+// profile if erase is set. Synapse can't delete accounts; a deactivated
+// one can't log in and its user ID can't be registered again.
+func (c *Client) DeactivateUser(ctx context.Context, userID string, erase bool) error {
+	endpoint := fmt.Sprintf("/_synapse/admin/v1/deactivate/%s", url.PathEscape(userID))
+
+	body, statusCode, err := c.doRequest(ctx, "POST", endpoint, &DeactivateRequest{Erase: erase})
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return newAPIError("POST", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return nil
+}
+
+// ForceLeave makes a user leave a room, or reject their invite, by acting
+// as the user through the Application Service. Unlike KickUser it works in
+// rooms where the admin user has no power (or no membership). The user must
+// be in the appservice namespace, which users created by the import are.
+func (c *Client) ForceLeave(ctx context.Context, roomID, userID, reason string) error {
+	if c.asToken == "" {
+		return fmt.Errorf("no Application Service token configured")
+	}
+
+	params := url.Values{}
+	params.Set("user_id", userID)
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/leave?%s", url.PathEscape(roomID), params.Encode())
+
+	body, statusCode, err := c.doRequestWithToken(ctx, "POST", endpoint, &LeaveRequest{Reason: reason}, c.asToken)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		if statusCode == http.StatusForbidden && strings.Contains(resp.Error, "not in room") {
+			return ErrNotInRoom
+		}
+		if statusCode == http.StatusNotFound {
+			return ErrNotInRoom
+		}
+		return newAPIError("POST", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return nil
+}
+
+// GetDirectRooms returns the m.direct account data of a user: the user's
+// direct message rooms by the other user. It acts as the user and needs an
+// AS token.
+func (c *Client) GetDirectRooms(ctx context.Context, userID string) (map[string][]string, error) {
+	if c.asToken == "" {
+		return nil, fmt.Errorf("no Application Service token configured")
 	}
-	exists := user != nil
-	logger.Info("User '%s' exists: %v", username, exists)
-	return exists, nil
-}
 
-// CreateRoom creates a new room
-func (c *Client) CreateRoom(req *CreateRoomRequest) (*CreateRoomResponse, error) {
-	body, statusCode, err := c.doRequest("POST", "/_matrix/client/v3/createRoom", req)
+	params := url.Values{}
+	params.Set("user_id", userID)
+	endpoint := fmt.Sprintf("/_matrix/client/v3/user/%s/account_data/%s?%s",
+		url.PathEscape(userID), AccountDataTypeDirect, params.Encode())
+
+	body, statusCode, err := c.doRequestWithToken(ctx, "GET", endpoint, nil, c.asToken)
 	if err != nil {
 		return nil, err
 	}
 
-	var resp CreateRoomResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	rooms := make(map[string][]string)
+	if statusCode == http.StatusNotFound {
+		return rooms, nil
 	}
-
 	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return nil, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
-	return &resp, nil
-}
-
-// CreateSpace creates a new space (a room with m.space type)
-func (c *Client) CreateSpace(name, topic string, public bool) (*CreateRoomResponse, error) {
-	visibility := VisibilityPrivate
-	preset := PresetPrivateChat
-	if public {
-		visibility = VisibilityPublic
-		preset = PresetPublicChat
+	if err := json.Unmarshal(body, &rooms); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
+	return rooms, nil
+}
 
-	req := &CreateRoomRequest{
-		Name:       name,
-		Topic:      topic,
-		Visibility: string(visibility),
-		Preset:     string(preset),
-		CreationContent: map[string]interface{}{
-			"type": SpaceType,
-		},
+// SetDirectRooms replaces the m.direct account data of a user. It acts as
+// the user and needs an AS token.
+func (c *Client) SetDirectRooms(ctx context.Context, userID string, rooms map[string][]string) error {
+	if c.asToken == "" {
+		return fmt.Errorf("no Application Service token configured")
 	}
 
-	return c.CreateRoom(req)
-}
+	params := url.Values{}
+	params.Set("user_id", userID)
+	endpoint := fmt.Sprintf("/_matrix/client/v3/user/%s/account_data/%s?%s",
+		url.PathEscape(userID), AccountDataTypeDirect, params.Encode())
 
-// CreateRegularRoom creates a regular room (not a space)
-func (c *Client) CreateRegularRoom(name, topic string, public bool) (*CreateRoomResponse, error) {
-	visibility := VisibilityPrivate
-	preset := PresetPrivateChat
-	if public {
-		visibility = VisibilityPublic
-		preset = PresetPublicChat
+	body, statusCode, err := c.doRequestWithToken(ctx, "PUT", endpoint, rooms, c.asToken)
+	if err != nil {
+		return err
 	}
 
-	req := &CreateRoomRequest{
-		Name:       name,
-		Topic:      topic,
-		Visibility: string(visibility),
-		Preset:     string(preset),
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
-	return c.CreateRoom(req)
+	return nil
 }
 
-// InviteUser invites a user to a room
-func (c *Client) InviteUser(roomID, userID string) error {
-	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/invite", url.PathEscape(roomID))
+// GetRoomMembers returns the user IDs of a room's joined members via the Admin API
+func (c *Client) GetRoomMembers(ctx context.Context, roomID string) ([]string, error) {
+	endpoint := fmt.Sprintf("/_synapse/admin/v1/rooms/%s/members", url.PathEscape(roomID))
 
-	req := &InviteRequest{
-		UserID: userID,
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	body, statusCode, err := c.doRequest("POST", endpoint, req)
-	if err != nil {
-		return err
+	var resp RoomMembersResponse
+	json.Unmarshal(body, &resp)
+	if statusCode != http.StatusOK {
+		return nil, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
+	return resp.Members, nil
+}
 
-	if statusCode == http.StatusForbidden {
-		// User might already be in the room
-		var resp GenericResponse
-		json.Unmarshal(body, &resp)
-		if resp.Errcode == "M_FORBIDDEN" {
-			return nil // Already a member, not an error
-		}
+// RoomExists returns true if the homeserver knows the room, via the Admin API.
+// Rooms deleted (and purged) after the import no longer exist.
+func (c *Client) RoomExists(ctx context.Context, roomID string) (bool, error) {
+	endpoint := fmt.Sprintf("/_synapse/admin/v1/rooms/%s", url.PathEscape(roomID))
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return false, err
 	}
 
+	if statusCode == http.StatusNotFound {
+		return false, nil
+	}
 	if statusCode != http.StatusOK {
 		var resp GenericResponse
 		json.Unmarshal(body, &resp)
-		return fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return false, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
-
-	return nil
+	return true, nil
 }
 
 // JoinRoom makes the admin user join a room (needed before inviting others in some cases)
-func (c *Client) JoinRoom(roomID string) error {
+func (c *Client) JoinRoom(ctx context.Context, roomID string) error {
 	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/join", url.PathEscape(roomID))
 
-	body, statusCode, err := c.doRequest("POST", endpoint, &JoinRequest{})
+	body, statusCode, err := c.doRequest(ctx, "POST", endpoint, &JoinRequest{})
 	if err != nil {
 		return err
 	}
@@ -413,14 +883,14 @@ func (c *Client) JoinRoom(roomID string) error {
 	if statusCode != http.StatusOK {
 		var resp GenericResponse
 		json.Unmarshal(body, &resp)
-		return fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return newAPIError("POST", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
 	return nil
 }
 
 // AddRoomToSpace adds a room as a child of a space
-func (c *Client) AddRoomToSpace(spaceID, roomID string, suggested bool) error {
+func (c *Client) AddRoomToSpace(ctx context.Context, spaceID, roomID string, suggested bool, order string) error {
 	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/state/%s/%s",
 		url.PathEscape(spaceID),
 		EventTypeSpaceChild,
@@ -429,9 +899,10 @@ func (c *Client) AddRoomToSpace(spaceID, roomID string, suggested bool) error {
 	content := &SpaceChildContent{
 		Via:       []string{c.homeserver},
 		Suggested: suggested,
+		Order:     order,
 	}
 
-	body, statusCode, err := c.doRequest("PUT", endpoint, content)
+	body, statusCode, err := c.doRequest(ctx, "PUT", endpoint, content)
 	if err != nil {
 		return err
 	}
@@ -439,14 +910,14 @@ func (c *Client) AddRoomToSpace(spaceID, roomID string, suggested bool) error {
 	if statusCode != http.StatusOK {
 		var resp GenericResponse
 		json.Unmarshal(body, &resp)
-		return fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
 	return nil
 }
 
 // SetRoomParent sets the parent space for a room
-func (c *Client) SetRoomParent(roomID, spaceID string, canonical bool) error {
+func (c *Client) SetRoomParent(ctx context.Context, roomID, spaceID string, canonical bool) error {
 	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/state/%s/%s",
 		url.PathEscape(roomID),
 		EventTypeSpaceParent,
@@ -457,7 +928,106 @@ func (c *Client) SetRoomParent(roomID, spaceID string, canonical bool) error {
 		Canonical: canonical,
 	}
 
-	body, statusCode, err := c.doRequest("PUT", endpoint, content)
+	body, statusCode, err := c.doRequest(ctx, "PUT", endpoint, content)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	return nil
+}
+
+// GetRoomState returns the current state events of a room
+func (c *Client) GetRoomState(ctx context.Context, roomID string) ([]RoomStateEvent, error) {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/state", url.PathEscape(roomID))
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return nil, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	var events []RoomStateEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetSpaceChildren returns the IDs of the rooms linked to a space, read
+// from its m.space.child state in a single request. Children without a
+// "via" have been removed and are not included.
+func (c *Client) GetSpaceChildren(ctx context.Context, spaceID string) (map[string]bool, error) {
+	events, err := c.GetRoomState(ctx, spaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string]bool)
+	for _, event := range events {
+		if event.Type != EventTypeSpaceChild {
+			continue
+		}
+		var content SpaceChildContent
+		if err := json.Unmarshal(event.Content, &content); err != nil {
+			continue
+		}
+		if len(content.Via) > 0 {
+			children[event.StateKey] = true
+		}
+	}
+	return children, nil
+}
+
+// GetStateEvent reads the content of a state event into content.
+// Returns false if the room has no such state event.
+func (c *Client) GetStateEvent(ctx context.Context, roomID, eventType, stateKey string, content interface{}) (bool, error) {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/state/%s/%s",
+		url.PathEscape(roomID),
+		url.PathEscape(eventType),
+		url.PathEscape(stateKey))
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if statusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if statusCode != http.StatusOK {
+		var resp GenericResponse
+		json.Unmarshal(body, &resp)
+		return false, newAPIError("GET", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+
+	if err := json.Unmarshal(body, content); err != nil {
+		return false, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return true, nil
+}
+
+// SetStateEvent sends a state event to a room
+func (c *Client) SetStateEvent(ctx context.Context, roomID, eventType, stateKey string, content interface{}) error {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/state/%s/%s",
+		url.PathEscape(roomID),
+		url.PathEscape(eventType),
+		url.PathEscape(stateKey))
+
+	body, statusCode, err := c.doRequest(ctx, "PUT", endpoint, content)
 	if err != nil {
 		return err
 	}
@@ -465,12 +1035,195 @@ func (c *Client) SetRoomParent(roomID, spaceID string, canonical bool) error {
 	if statusCode != http.StatusOK {
 		var resp GenericResponse
 		json.Unmarshal(body, &resp)
-		return fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 
 	return nil
 }
 
+// SetRoomWritable undoes SetRoomReadOnly, lowering the power level needed
+// to send messages back to 0. Rooms that aren't read-only are left as they are.
+func (c *Client) SetRoomWritable(ctx context.Context, roomID string) error {
+	content := map[string]interface{}{}
+	found, err := c.GetStateEvent(ctx, roomID, EventTypePowerLevels, "", &content)
+	if err != nil {
+		return fmt.Errorf("failed to read power levels: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("room %s has no power levels", roomID)
+	}
+
+	if level, _ := content["events_default"].(float64); level != ReadOnlyPowerLevel {
+		return nil
+	}
+	if events, ok := content["events"].(map[string]interface{}); ok {
+		delete(events, EventTypeRoomMessage)
+	}
+	content["events_default"] = 0
+
+	return c.SetStateEvent(ctx, roomID, EventTypePowerLevels, "", content)
+}
+
+// SetRoomReadOnly raises the power level needed to send messages to
+// ReadOnlyPowerLevel, leaving the rest of the power levels untouched
+func (c *Client) SetRoomReadOnly(ctx context.Context, roomID string) error {
+	content := map[string]interface{}{}
+	found, err := c.GetStateEvent(ctx, roomID, EventTypePowerLevels, "", &content)
+	if err != nil {
+		return fmt.Errorf("failed to read power levels: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("room %s has no power levels", roomID)
+	}
+
+	events, _ := content["events"].(map[string]interface{})
+	if events == nil {
+		events = map[string]interface{}{}
+	}
+	events[EventTypeRoomMessage] = ReadOnlyPowerLevel
+	content["events"] = events
+	content["events_default"] = ReadOnlyPowerLevel
+
+	return c.SetStateEvent(ctx, roomID, EventTypePowerLevels, "", content)
+}
+
+// RoomEncrypted returns true if end-to-end encryption is enabled in a room
+func (c *Client) RoomEncrypted(ctx context.Context, roomID string) (bool, error) {
+	var content EncryptionContent
+	found, err := c.GetStateEvent(ctx, roomID, EventTypeEncryption, "", &content)
+	if err != nil {
+		return false, err
+	}
+	return found && content.Algorithm != "", nil
+}
+
+// SetPowerLevel gives a user the power level level in a room, merging it into
+// the room's power levels. A user's higher level is never lowered, so room
+// creators and server admins keep theirs. changed is false when the user
+// already had the level or a higher one.
+func (c *Client) SetPowerLevel(ctx context.Context, roomID, userID string, level int) (changed bool, err error) {
+	content := map[string]interface{}{}
+	found, err := c.GetStateEvent(ctx, roomID, EventTypePowerLevels, "", &content)
+	if err != nil {
+		return false, fmt.Errorf("failed to read power levels: %w", err)
+	}
+	if !found {
+		return false, fmt.Errorf("room %s has no power levels", roomID)
+	}
+
+	users, _ := content["users"].(map[string]interface{})
+	if users == nil {
+		users = map[string]interface{}{}
+	}
+	if current, ok := users[userID].(float64); ok && int(current) >= level {
+		return false, nil
+	}
+	users[userID] = level
+	content["users"] = users
+
+	if err := c.SetStateEvent(ctx, roomID, EventTypePowerLevels, "", content); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteRoom schedules the deletion of a room via the Admin API v2 and
+// returns the delete ID to poll with GetRoomDeleteStatus. Local members are
+// removed and the room's local aliases are deleted.
+func (c *Client) DeleteRoom(ctx context.Context, roomID string, purge bool) (string, error) {
+	endpoint := fmt.Sprintf("/_synapse/admin/v2/rooms/%s", url.PathEscape(roomID))
+
+	body, statusCode, err := c.doRequest(ctx, "DELETE", endpoint, &DeleteRoomRequest{Purge: purge})
+	if err != nil {
+		return "", err
+	}
+
+	var resp DeleteRoomResponse
+	json.Unmarshal(body, &resp)
+	if statusCode != http.StatusOK {
+		return "", newAPIError("DELETE", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+	if resp.DeleteID == "" {
+		return "", fmt.Errorf("delete of room %s returned no delete_id", roomID)
+	}
+	return resp.DeleteID, nil
+}
+
+// GetRoomDeleteStatus returns the status of a delete started by DeleteRoom
+func (c *Client) GetRoomDeleteStatus(ctx context.Context, deleteID string) (*RoomDeleteStatus, error) {
+	endpoint := fmt.Sprintf("/_synapse/admin/v2/rooms/delete_status/%s", url.PathEscape(deleteID))
+
+	body, statusCode, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status RoomDeleteStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return nil, newAPIError("GET", endpoint, statusCode, status.Errcode, status.Error)
+	}
+	return &status, nil
+}
+
+// GetRoomName returns the current name of a room ("" if it has none)
+func (c *Client) GetRoomName(ctx context.Context, roomID string) (string, error) {
+	var content RoomNameContent
+	if _, err := c.GetStateEvent(ctx, roomID, EventTypeRoomName, "", &content); err != nil {
+		return "", err
+	}
+	return content.Name, nil
+}
+
+// SetRoomName sets the name of a room
+func (c *Client) SetRoomName(ctx context.Context, roomID, name string) error {
+	return c.SetStateEvent(ctx, roomID, EventTypeRoomName, "", &RoomNameContent{Name: name})
+}
+
+// GetRoomTopic returns the current topic of a room ("" if it has none)
+func (c *Client) GetRoomTopic(ctx context.Context, roomID string) (string, error) {
+	var content RoomTopicContent
+	if _, err := c.GetStateEvent(ctx, roomID, EventTypeRoomTopic, "", &content); err != nil {
+		return "", err
+	}
+	return content.Topic, nil
+}
+
+// SetRoomTopic sets the topic of a room
+func (c *Client) SetRoomTopic(ctx context.Context, roomID, topic string) error {
+	return c.SetStateEvent(ctx, roomID, EventTypeRoomTopic, "", &RoomTopicContent{Topic: topic})
+}
+
+// GetHistoryVisibility returns the history visibility of a room (empty if not set)
+func (c *Client) GetHistoryVisibility(ctx context.Context, roomID string) (string, error) {
+	var content HistoryVisibilityContent
+	if _, err := c.GetStateEvent(ctx, roomID, EventTypeHistoryVisibility, "", &content); err != nil {
+		return "", err
+	}
+	return content.HistoryVisibility, nil
+}
+
+// SetHistoryVisibility sets who can read the history of a room
+func (c *Client) SetHistoryVisibility(ctx context.Context, roomID, visibility string) error {
+	return c.SetStateEvent(ctx, roomID, EventTypeHistoryVisibility, "", &HistoryVisibilityContent{HistoryVisibility: visibility})
+}
+
+// PinEvent adds an event to the pinned events of a room, keeping the
+// events already pinned. Pinning an event twice is a no-op.
+func (c *Client) PinEvent(ctx context.Context, roomID, eventID string) error {
+	var content PinnedEventsContent
+	if _, err := c.GetStateEvent(ctx, roomID, EventTypePinnedEvents, "", &content); err != nil {
+		return fmt.Errorf("failed to read pinned events: %w", err)
+	}
+	if slices.Contains(content.Pinned, eventID) {
+		return nil
+	}
+	content.Pinned = append(content.Pinned, eventID)
+	return c.SetStateEvent(ctx, roomID, EventTypePinnedEvents, "", &content)
+}
+
 // FormatUserID formats a username as a full Matrix user ID
 func (c *Client) FormatUserID(username string) string {
 	return fmt.Sprintf("@%s:%s", username, c.homeserver)
@@ -511,15 +1264,15 @@ type SendMessageResponse struct {
 }
 
 // SendMessage sends a message to a room (without timestamp - uses current time)
-func (c *Client) SendMessage(roomID, message string) (*SendMessageResponse, error) {
-	return c.SendMessageWithTimestamp(roomID, message, 0, "")
+func (c *Client) SendMessage(ctx context.Context, roomID, message string) (*SendMessageResponse, error) {
+	return c.SendMessageWithTimestamp(ctx, roomID, message, 0, "")
 }
 
 // SendMessageWithTimestamp sends a message to a room with a specific timestamp
 // This requires an Application Service token to be set
 // If timestamp is 0, uses current time
 // If senderUserID is provided, the message will appear as sent by that user (requires AS)
-func (c *Client) SendMessageWithTimestamp(roomID, message string, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
+func (c *Client) SendMessageWithTimestamp(ctx context.Context, roomID, message string, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
 	txnID := c.getNextTxnID()
 	
 	// Build endpoint
@@ -556,7 +1309,7 @@ func (c *Client) SendMessageWithTimestamp(roomID, message string, timestamp int6
 	}
 	
 	// Make request
-	body, statusCode, err := c.doRequestWithToken("PUT", endpoint, req, token)
+	body, statusCode, err := c.doRequestWithToken(ctx, "PUT", endpoint, req, token)
 	if err != nil {
 		return nil, err
 	}
@@ -567,14 +1320,14 @@ func (c *Client) SendMessageWithTimestamp(roomID, message string, timestamp int6
 	}
 	
 	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return nil, newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 	
 	return &resp, nil
 }
 
 // SendReplyWithTimestamp sends a reply to a message with a specific timestamp
-func (c *Client) SendReplyWithTimestamp(roomID, message string, replyToEventID string, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
+func (c *Client) SendReplyWithTimestamp(ctx context.Context, roomID, message string, replyToEventID string, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
 	txnID := c.getNextTxnID()
 	
 	// Build endpoint
@@ -613,7 +1366,83 @@ func (c *Client) SendReplyWithTimestamp(roomID, message string, replyToEventID s
 		token = c.asToken
 	}
 	
-	body, statusCode, err := c.doRequestWithToken("PUT", endpoint, content, token)
+	body, statusCode, err := c.doRequestWithToken(ctx, "PUT", endpoint, content, token)
+	if err != nil {
+		return nil, err
+	}
+	
+	var resp SendMessageResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	
+	if statusCode != http.StatusOK {
+		return nil, newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
+	}
+	
+	return &resp, nil
+}
+
+// RelatesTo is the m.relates_to block linking an event to another one
+type RelatesTo struct {
+	RelType       string     `json:"rel_type,omitempty"`        // e.g. m.thread
+	EventID       string     `json:"event_id,omitempty"`        // Thread root for m.thread
+	IsFallingBack bool       `json:"is_falling_back,omitempty"` // InReplyTo is only a fallback for clients without threads
+	InReplyTo     *InReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+// InReplyTo names the event a message replies to
+type InReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// ThreadRelation returns the relation of an event in the thread of
+// rootEventID. Clients without thread support show the event as a reply to
+// fallbackEventID, normally the thread's latest event.
+func ThreadRelation(rootEventID, fallbackEventID string) *RelatesTo {
+	if fallbackEventID == "" {
+		fallbackEventID = rootEventID
+	}
+	return &RelatesTo{
+		RelType:       "m.thread",
+		EventID:       rootEventID,
+		IsFallingBack: true,
+		InReplyTo:     &InReplyTo{EventID: fallbackEventID},
+	}
+}
+
+// SendThreadMessageWithTimestamp sends a message into the thread of
+// rootEventID (m.thread relation), with an m.in_reply_to fallback to
+// fallbackEventID for clients that don't display threads
+func (c *Client) SendThreadMessageWithTimestamp(ctx context.Context, roomID, message, rootEventID, fallbackEventID string, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
+	txnID := c.getNextTxnID()
+	
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		url.PathEscape(roomID), url.PathEscape(txnID))
+	
+	params := url.Values{}
+	if timestamp > 0 && c.asToken != "" {
+		params.Set("ts", strconv.FormatInt(timestamp, 10))
+	}
+	if senderUserID != "" && c.asToken != "" {
+		params.Set("user_id", senderUserID)
+	}
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	
+	content := map[string]interface{}{
+		"msgtype":      "m.text",
+		"body":         message,
+		"m.relates_to": ThreadRelation(rootEventID, fallbackEventID),
+	}
+	
+	token := c.adminToken
+	if c.asToken != "" {
+		token = c.asToken
+	}
+	
+	body, statusCode, err := c.doRequestWithToken(ctx, "PUT", endpoint, content, token)
 	if err != nil {
 		return nil, err
 	}
@@ -624,30 +1453,31 @@ func (c *Client) SendReplyWithTimestamp(roomID, message string, replyToEventID s
 	}
 	
 	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return nil, newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 	
 	return &resp, nil
 }
 
-// doRequestWithToken performs an HTTP request with a specific token
-func (c *Client) doRequestWithToken(method, endpoint string, body interface{}, token string) ([]byte, int, error) {
-	return c.doRequestWithTokenAndRetry(method, endpoint, body, token, 0)
+// doRequestWithToken performs an HTTP request with a specific token.
+// Mutating requests are recorded in the audit log, if one is set.
+func (c *Client) doRequestWithToken(ctx context.Context, method, endpoint string, body interface{}, token string) ([]byte, int, error) {
+	respBody, statusCode, err := c.doRequestWithTokenAndRetry(ctx, method, endpoint, body, token, 0)
+	c.audit(method, endpoint, statusCode, err)
+	return respBody, statusCode, err
 }
 
 // doRequestWithTokenAndRetry performs an HTTP request with retry logic
-func (c *Client) doRequestWithTokenAndRetry(method, endpoint string, body interface{}, token string, retryCount int) ([]byte, int, error) {
+func (c *Client) doRequestWithTokenAndRetry(ctx context.Context, method, endpoint string, body interface{}, token string, retryCount int) ([]byte, int, error) {
+	// Don't start requests once the run is cancelled
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
 	// Rate limiting
-	c.mu.Lock()
-	if c.rateLimit > 0 {
-		elapsed := time.Since(c.lastRequest)
-		if elapsed < c.rateLimit {
-			sleepTime := c.rateLimit - elapsed
-			time.Sleep(sleepTime)
-		}
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, 0, err
 	}
-	c.lastRequest = time.Now()
-	c.mu.Unlock()
 
 	var reqBody io.Reader
 	if body != nil {
@@ -659,11 +1489,12 @@ func (c *Client) doRequestWithTokenAndRetry(method, endpoint string, body interf
 	}
 
 	reqURL := c.baseURL + endpoint
-	req, err := http.NewRequest(method, reqURL, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	c.httpOptions.applyHeaders(req)
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
@@ -700,9 +1531,13 @@ func (c *Client) doRequestWithTokenAndRetry(method, endpoint string, body interf
 		}
 		
 		logger.Warn("Rate limit hit (429), waiting %v before retry %d/%d", retryAfter, retryCount+1, c.maxRetries)
-		time.Sleep(retryAfter)
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(retryAfter):
+		}
 		
-		return c.doRequestWithTokenAndRetry(method, endpoint, body, token, retryCount+1)
+		return c.doRequestWithTokenAndRetry(ctx, method, endpoint, body, token, retryCount+1)
 	}
 
 	return respBody, resp.StatusCode, nil
@@ -717,24 +1552,22 @@ type UploadMediaResponse struct {
 
 // UploadMedia uploads a file to Matrix media repository
 // Returns the mxc:// URI for the uploaded file
-func (c *Client) UploadMedia(data []byte, filename, contentType string) (*UploadMediaResponse, error) {
+func (c *Client) UploadMedia(ctx context.Context, reader io.Reader, filename, contentType string) (string, error) {
 	endpoint := fmt.Sprintf("/_matrix/media/v3/upload?filename=%s", url.QueryEscape(filename))
 	
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	// Rate limiting
-	c.mu.Lock()
-	if c.rateLimit > 0 {
-		elapsed := time.Since(c.lastRequest)
-		if elapsed < c.rateLimit {
-			time.Sleep(c.rateLimit - elapsed)
-		}
+	if err := c.limiter.Wait(ctx); err != nil {
+		return "", err
 	}
-	c.lastRequest = time.Now()
-	c.mu.Unlock()
 	
 	reqURL := c.baseURL + endpoint
-	req, err := http.NewRequest("POST", reqURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create upload request: %w", err)
+		return "", fmt.Errorf("failed to create upload request: %w", err)
 	}
 	
 	token := c.adminToken
@@ -742,30 +1575,36 @@ func (c *Client) UploadMedia(data []byte, filename, contentType string) (*Upload
 		token = c.asToken
 	}
 	
+	c.httpOptions.applyHeaders(req)
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", contentType)
 	
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("upload request failed: %w", err)
+		c.audit("POST", endpoint, 0, err)
+		return "", fmt.Errorf("upload request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	c.audit("POST", endpoint, resp.StatusCode, nil)
 	
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read upload response: %w", err)
+		return "", fmt.Errorf("failed to read upload response: %w", err)
 	}
 	
 	var result UploadMediaResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse upload response: %w", err)
+		return "", fmt.Errorf("failed to parse upload response: %w", err)
 	}
 	
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("upload failed (%d): %s - %s", resp.StatusCode, result.Errcode, result.Error)
+		return "", fmt.Errorf("upload failed: %w", newAPIError("POST", endpoint, resp.StatusCode, result.Errcode, result.Error))
+	}
+	if !strings.HasPrefix(result.ContentURI, "mxc://") {
+		return "", fmt.Errorf("upload returned an invalid content URI %q", result.ContentURI)
 	}
 	
-	return &result, nil
+	return result.ContentURI, nil
 }
 
 // FileMessageContent represents a file message content
@@ -775,6 +1614,7 @@ type FileMessageContent struct {
 	URL      string         `json:"url,omitempty"`     // mxc:// URI (for uploaded files)
 	Filename string         `json:"filename,omitempty"`
 	Info     *FileInfo      `json:"info,omitempty"`
+	RelatesTo *RelatesTo    `json:"m.relates_to,omitempty"` // Thread or reply the file belongs to
 }
 
 // FileInfo contains metadata about the file
@@ -797,7 +1637,7 @@ type ThumbnailInfo struct {
 }
 
 // SendFileMessage sends a file message to a room
-func (c *Client) SendFileMessage(roomID string, content *FileMessageContent, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
+func (c *Client) SendFileMessage(ctx context.Context, roomID string, content *FileMessageContent, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
 	txnID := c.getNextTxnID()
 	
 	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
@@ -819,7 +1659,7 @@ func (c *Client) SendFileMessage(roomID string, content *FileMessageContent, tim
 		token = c.asToken
 	}
 	
-	body, statusCode, err := c.doRequestWithToken("PUT", endpoint, content, token)
+	body, statusCode, err := c.doRequestWithToken(ctx, "PUT", endpoint, content, token)
 	if err != nil {
 		return nil, err
 	}
@@ -830,7 +1670,7 @@ func (c *Client) SendFileMessage(roomID string, content *FileMessageContent, tim
 	}
 	
 	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (%d): %s - %s", statusCode, resp.Errcode, resp.Error)
+		return nil, newAPIError("PUT", endpoint, statusCode, resp.Errcode, resp.Error)
 	}
 	
 	return &resp, nil
@@ -839,7 +1679,7 @@ func (c *Client) SendFileMessage(roomID string, content *FileMessageContent, tim
 // SendFileLink sends a message with a file link (external URL)
 // Note: Matrix doesn't support external URLs directly in file messages,
 // so we send as a text message with a markdown link
-func (c *Client) SendFileLink(roomID, filename, fileURL, mimeType string, fileSize int64, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
+func (c *Client) SendFileLink(ctx context.Context, roomID, filename, fileURL, mimeType string, fileSize int64, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
 	// Determine emoji based on file type
 	emoji := "📎"
 	if strings.HasPrefix(mimeType, "image/") {
@@ -852,11 +1692,11 @@ func (c *Client) SendFileLink(roomID, filename, fileURL, mimeType string, fileSi
 	
 	message := fmt.Sprintf("%s [%s](%s)", emoji, filename, fileURL)
 	
-	return c.SendMessageWithTimestamp(roomID, message, timestamp, senderUserID)
+	return c.SendMessageWithTimestamp(ctx, roomID, message, timestamp, senderUserID)
 }
 
 // SendUploadedFile sends a file that was already uploaded to Matrix
-func (c *Client) SendUploadedFile(roomID, mxcURI, filename, mimeType string, fileSize int64, width, height int, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
+func (c *Client) SendUploadedFile(ctx context.Context, roomID, mxcURI, filename, mimeType string, fileSize int64, width, height int, timestamp int64, senderUserID string) (*SendMessageResponse, error) {
 	msgType := "m.file"
 	if strings.HasPrefix(mimeType, "image/") {
 		msgType = "m.image"
@@ -882,5 +1722,5 @@ func (c *Client) SendUploadedFile(roomID, mxcURI, filename, mimeType string, fil
 		content.Info.Height = height
 	}
 	
-	return c.SendFileMessage(roomID, content, timestamp, senderUserID)
+	return c.SendFileMessage(ctx, roomID, content, timestamp, senderUserID)
 }
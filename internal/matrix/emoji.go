@@ -0,0 +1,172 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+)
+
+const (
+	// RoomEmotesEventType is the state event holding a room's custom emotes (MSC2545)
+	RoomEmotesEventType = "im.ponies.room_emotes"
+
+	// roomEmotesStateKey is the state key of the pack holding Mattermost's emojis
+	roomEmotesStateKey = "mattermost"
+)
+
+// RoomEmotes is the content of an im.ponies.room_emotes state event
+type RoomEmotes struct {
+	Pack   EmotePack             `json:"pack"`
+	Images map[string]EmoteImage `json:"images"` // key: shortcode without colons
+}
+
+// EmotePack describes an emote pack
+type EmotePack struct {
+	DisplayName string   `json:"display_name,omitempty"`
+	Usage       []string `json:"usage,omitempty"` // "emoticon" and/or "sticker"
+}
+
+// EmoteImage is a single emote of a pack
+type EmoteImage struct {
+	URL  string    `json:"url"` // mxc:// URI
+	Body string    `json:"body,omitempty"`
+	Info *FileInfo `json:"info,omitempty"`
+}
+
+// EmojiFetcher returns the image of a Mattermost custom emoji
+type EmojiFetcher func(emoji *mattermost.Emoji) ([]byte, error)
+
+// EmojiImportStats holds statistics about a custom emoji import
+type EmojiImportStats struct {
+	EmojisUploaded int `json:"emojis_uploaded"`
+	EmojisFailed   int `json:"emojis_failed"`
+	RoomsUpdated   int `json:"rooms_updated"` // Rooms whose emote pack was created or extended
+}
+
+// emojiPattern matches emoji shortcodes like :party_parrot: in messages
+var emojiPattern = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// UsedEmojis returns the custom emojis used in the posts of each channel, by channel ID
+func UsedEmojis(posts []mattermost.Post, emojis []mattermost.Emoji) map[string][]mattermost.Emoji {
+	byName := make(map[string]mattermost.Emoji, len(emojis))
+	for _, emoji := range emojis {
+		byName[emoji.Name] = emoji
+	}
+
+	seen := make(map[string]map[string]bool)
+	used := make(map[string][]mattermost.Emoji)
+	for _, post := range posts {
+		for _, match := range emojiPattern.FindAllStringSubmatch(post.Message, -1) {
+			emoji, ok := byName[match[1]]
+			if !ok || seen[post.ChannelID][emoji.Name] {
+				continue
+			}
+			if seen[post.ChannelID] == nil {
+				seen[post.ChannelID] = make(map[string]bool)
+			}
+			seen[post.ChannelID][emoji.Name] = true
+			used[post.ChannelID] = append(used[post.ChannelID], emoji)
+		}
+	}
+	return used
+}
+
+// ImportCustomEmojis makes the custom emojis used in each channel available
+// in its room: each emoji is uploaded once and added to the room's
+// "mattermost" emote pack (im.ponies.room_emotes), so clients supporting
+// custom emotes can show and send them. Emojis already in a room's pack are
+// left alone, so re-runs upload nothing new. Failures are logged and skipped.
+func (i *Importer) ImportCustomEmojis(ctx context.Context, emojis []mattermost.Emoji, posts []mattermost.Post, channelToRoom map[string]string, fetch EmojiFetcher) *EmojiImportStats {
+	stats := &EmojiImportStats{}
+	used := UsedEmojis(posts, emojis)
+
+	channelIDs := make([]string, 0, len(used))
+	for channelID := range used {
+		channelIDs = append(channelIDs, channelID)
+	}
+	sort.Strings(channelIDs)
+
+	uploaded := make(map[string]EmoteImage) // emoji name -> uploaded image
+	failed := make(map[string]bool)         // emoji names that can't be uploaded
+
+	for _, channelID := range channelIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		roomID, ok := channelToRoom[channelID]
+		if !ok {
+			continue
+		}
+
+		var pack RoomEmotes
+		if _, err := i.client.GetStateEvent(ctx, roomID, RoomEmotesEventType, roomEmotesStateKey, &pack); err != nil {
+			logger.Warn("Could not read the emote pack of room %s: %v", roomID, err)
+			continue
+		}
+		if pack.Images == nil {
+			pack.Images = make(map[string]EmoteImage)
+		}
+		if pack.Pack.DisplayName == "" {
+			pack.Pack = EmotePack{DisplayName: "Mattermost", Usage: []string{"emoticon"}}
+		}
+
+		added := 0
+		for _, emoji := range used[channelID] {
+			if _, exists := pack.Images[emoji.Name]; exists || failed[emoji.Name] {
+				continue
+			}
+
+			image, ok := uploaded[emoji.Name]
+			if !ok {
+				var err error
+				image, err = i.uploadEmoji(ctx, &emoji, fetch)
+				if err != nil {
+					logger.Warn("Skipping custom emoji :%s: - %v", emoji.Name, err)
+					failed[emoji.Name] = true
+					stats.EmojisFailed++
+					continue
+				}
+				uploaded[emoji.Name] = image
+				stats.EmojisUploaded++
+			}
+			pack.Images[emoji.Name] = image
+			added++
+		}
+		if added == 0 {
+			continue
+		}
+
+		if err := i.client.SetStateEvent(ctx, roomID, RoomEmotesEventType, roomEmotesStateKey, &pack); err != nil {
+			logger.Warn("Could not update the emote pack of room %s: %v", roomID, err)
+			continue
+		}
+		stats.RoomsUpdated++
+	}
+
+	return stats
+}
+
+// uploadEmoji uploads the image of a custom emoji to the media repository
+func (i *Importer) uploadEmoji(ctx context.Context, emoji *mattermost.Emoji, fetch EmojiFetcher) (EmoteImage, error) {
+	data, err := fetch(emoji)
+	if err != nil {
+		return EmoteImage{}, err
+	}
+
+	mimeType := http.DetectContentType(data)
+	mxcURI, err := i.client.UploadMedia(ctx, bytes.NewReader(data), emoji.Name, mimeType)
+	if err != nil {
+		return EmoteImage{}, err
+	}
+
+	return EmoteImage{
+		URL:  mxcURI,
+		Body: ":" + emoji.Name + ":",
+		Info: &FileInfo{MimeType: mimeType, Size: int64(len(data))},
+	}, nil
+}
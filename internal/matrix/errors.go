@@ -0,0 +1,94 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrAlreadyInRoom is returned by InviteUser when the user is already a
+// member of the room
+var ErrAlreadyInRoom = errors.New("user is already in the room")
+
+// ErrNotInRoom is returned by KickUser and ForceLeave when the user is not a member of
+// the room (any more)
+var ErrNotInRoom = errors.New("user is not in the room")
+
+// ErrAliasInUse is returned by CreateAlias when the alias already exists
+var ErrAliasInUse = errors.New("room alias already exists")
+
+// ErrAdminV2Unavailable is returned when the homeserver doesn't serve the
+// Synapse admin v2 API, which user creation and listing depend on
+var ErrAdminV2Unavailable = errors.New("Synapse admin v2 API not available — upgrade Synapse or enable the admin API (/_synapse/admin)")
+
+// APIError is returned when the homeserver answers a request with a Matrix
+// error response. It keeps enough context to explain the failure to the user.
+type APIError struct {
+	StatusCode int    // HTTP status code
+	Errcode    string // Matrix errcode, e.g. M_FORBIDDEN
+	Message    string // Human readable error from the homeserver
+	Method     string // HTTP method of the failed request
+	Endpoint   string // Request path, without the homeserver URL
+}
+
+// newAPIError creates an APIError for a failed request
+func newAPIError(method, endpoint string, statusCode int, errcode, message string) *APIError {
+	return &APIError{
+		StatusCode: statusCode,
+		Errcode:    errcode,
+		Message:    message,
+		Method:     method,
+		Endpoint:   endpoint,
+	}
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (%d): %s - %s", e.StatusCode, e.Errcode, e.Message)
+}
+
+// Remediation returns a short hint on how to resolve the error, or an empty
+// string when there is nothing specific to suggest
+func (e *APIError) Remediation() string {
+	switch e.Errcode {
+	case "M_UNKNOWN_TOKEN", "M_MISSING_TOKEN":
+		return "The access token was rejected. Check the token in matrix.api.admin_token_env or the matrix.auth credentials."
+	case "M_FORBIDDEN":
+		return "The account is not allowed to do this. Make sure it is a Synapse server admin (and, for appservice requests, that the user is in the appservice namespace)."
+	case "M_LIMIT_EXCEEDED":
+		return "The homeserver is rate limiting requests. Lower matrix.rate_limit.requests_per_second or relax the rc_* limits for the admin user in Synapse."
+	case "M_USER_IN_USE":
+		return "The user already exists. Re-run the import so the existing user is mapped, or use --skip-users to only link users provisioned elsewhere."
+	case "M_ROOM_IN_USE":
+		return "The room alias is already taken. Re-run the import so the existing room is reused, or change matrix.alias_template."
+	case "M_EXCLUSIVE":
+		return "The ID is reserved by an application service namespace. Use a different localpart or set matrix.appservice.as_token_env."
+	case "M_TOO_LARGE":
+		return "The upload exceeds the homeserver limit. Raise max_upload_size in homeserver.yaml or lower mattermost.files.max_upload_size_mb so larger files are linked instead."
+	case "M_NOT_FOUND", "M_UNRECOGNIZED":
+		return "The resource or endpoint was not found. Check matrix.homeserver and that the Synapse admin API is reachable."
+	}
+
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return "The access token was rejected. Check the token in matrix.api.admin_token_env or the matrix.auth credentials."
+	case e.StatusCode == http.StatusForbidden:
+		return "The account is not allowed to do this. Make sure it is a Synapse server admin."
+	case e.StatusCode == http.StatusTooManyRequests:
+		return "The homeserver is rate limiting requests. Lower matrix.rate_limit.requests_per_second."
+	case e.StatusCode == http.StatusNotFound:
+		return "The resource or endpoint was not found. Check matrix.homeserver and that the Synapse admin API is reachable."
+	case e.StatusCode >= 500:
+		return "The homeserver failed to handle the request. Check the Synapse logs for details."
+	}
+	return ""
+}
+
+// AsAPIError returns the APIError wrapped in err, if any
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
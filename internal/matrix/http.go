@@ -0,0 +1,118 @@
+package matrix
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPOptions configures how requests reach the homeserver
+type HTTPOptions struct {
+	// ExtraHeaders are added to every request (e.g. for an auth proxy)
+	ExtraHeaders map[string]string
+
+	// Proxy is the outbound proxy URL. Empty uses HTTP_PROXY/HTTPS_PROXY
+	// from the environment. Either way, loopback hosts (such as the SSH
+	// tunnel) and hosts in NO_PROXY are reached directly.
+	Proxy string
+}
+
+// newHTTPClient creates an HTTP client that uses the configured proxy
+func (o HTTPOptions) newHTTPClient(timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.Proxy != "" {
+		proxyURL, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		noProxy := os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypassProxy(req.URL, noProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// bypassProxy reports whether u should be requested without the proxy,
+// following the rules of http.ProxyFromEnvironment: loopback hosts are never
+// proxied, and noProxy is a comma separated list of hosts, domain suffixes,
+// IPs or CIDR ranges, optionally with a port, or "*" for all hosts.
+func bypassProxy(u *url.URL, noProxy string) bool {
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		// ".example.org" matches subdomains only, "example.org" the domain too
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+		} else if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyHeaders adds the extra headers to req
+func (o HTTPOptions) applyHeaders(req *http.Request) {
+	for name, value := range o.ExtraHeaders {
+		req.Header.Set(name, value)
+	}
+}
@@ -1,8 +1,17 @@
 ﻿package matrix
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aligundogdu/matrixmigrate/internal/logger"
 	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
@@ -10,25 +19,446 @@ import (
 
 // Importer handles importing data to Matrix
 type Importer struct {
-	client *Client
+	client  *Client
+	options ImporterOptions
+
+	// deactivations collects audit records for accounts created deactivated
+	deactivations []DeactivationRecord
+
+	// uncategorizedSpaceID is the space orphan rooms are linked to, created on first use
+	uncategorizedSpaceID string
+
+	// lastRoomCreated is when the last room or space was created, for RoomCreationPacing
+	lastRoomCreated time.Time
+
+	// roomAliases maps channel IDs to the name aliases of their rooms
+	roomAliases map[string]string
+
+	// directRooms collects the m.direct entries of the imported direct
+	// messages: user ID -> other user ID -> room IDs
+	directRooms map[string]map[string][]string
+}
+
+// ImporterOptions holds configurable importer behavior
+type ImporterOptions struct {
+	// ImportDeletedUsers creates deleted Mattermost users as deactivated accounts
+	ImportDeletedUsers bool
+
+	// UpdateExisting updates the name and topic of already imported rooms
+	// when they changed in Mattermost
+	UpdateExisting bool
+
+	// Aliases generates room and space aliases (default: mm_<id> scheme)
+	Aliases *AliasGenerator
+
+	// OrphanChannelPolicy controls channels whose team has no space
+	// (default: OrphanPolicyImportFlat)
+	OrphanChannelPolicy string
+
+	// ChildOrder orders the rooms of a space (default: ChildOrderNone)
+	ChildOrder string
+
+	// NameAliases gives rooms an alias made from their channel name, e.g.
+	// #town-square:server, as their canonical alias
+	NameAliases bool
+
+	// ImportArchived imports deleted teams and channels instead of skipping
+	// them; their rooms are made read-only with LockRooms
+	ImportArchived bool
+
+	// MaxMessageBytes is the largest message body sent as a single event
+	// (default: DefaultMaxMessageBytes)
+	MaxMessageBytes int
+
+	// OversizePolicy controls longer bodies (default: OversizePolicySplit)
+	OversizePolicy string
+
+	// CreatorInTopic appends the channel creator to room topics
+	CreatorInTopic bool
+
+	// DefaultRoomCreator is credited, as user ID or localpart, for channels
+	// whose creator wasn't imported, such as bots (default: the admin user)
+	DefaultRoomCreator string
+
+	// PublishPublicRooms lists the rooms of public channels in the room
+	// directory, including rooms imported by earlier runs
+	PublishPublicRooms bool
+
+	// UserType is the Synapse user type of created accounts (default: regular user)
+	UserType string
+
+	// LogoutDevices is sent as logout_devices when creating users (nil: not sent)
+	LogoutDevices *bool
+
+	// SSOAuthProvider links created users to this SSO provider via
+	// external_ids (empty: no link)
+	SSOAuthProvider string
+
+	// SSOExternalIDField is the Mattermost user field used as the external
+	// ID: email, username, id or auth_data (default: email)
+	SSOExternalIDField string
+
+	// SSOWithoutPassword creates SSO-linked users without a password
+	SSOWithoutPassword bool
+
+	// DuplicateEmailPolicy controls users sharing an email address when the
+	// email is bound to accounts (default: DuplicateEmailFirst)
+	DuplicateEmailPolicy string
+
+	// MaxDisplayNameLength truncates longer display names of created users,
+	// in characters, ending them with an ellipsis (0: no limit)
+	MaxDisplayNameLength int
+
+	// DirectMessages imports direct and group messages as direct message
+	// rooms with only their participants; otherwise direct messages are
+	// skipped and group messages become regular private rooms
+	DirectMessages bool
+
+	// SkipEmptyChannels doesn't create rooms for channels without messages
+	SkipEmptyChannels bool
+
+	// InviteForbiddenPolicy controls invites rejected with M_FORBIDDEN for a
+	// user who isn't in the room yet (default: InviteForbiddenFail)
+	InviteForbiddenPolicy string
+
+	// RoomVersion is the room version of created rooms and spaces (empty:
+	// the server's default)
+	RoomVersion string
+
+	// RoomCreationPacing is the least time between creating two rooms or
+	// spaces, on top of the request rate limit; creating a room costs the
+	// homeserver much more than other requests (0: no pacing)
+	RoomCreationPacing time.Duration
+
+	// RoomCreationJitter adds a random delay of up to this much to the pacing
+	RoomCreationJitter time.Duration
+
+	// HistoryVisibility is the history visibility of created rooms, so members
+	// invited after the message import can read it (empty: server default)
+	HistoryVisibility string
+
+	// EncryptRooms enables end-to-end encryption in created rooms. Messages
+	// can't be imported into encrypted rooms, so their history is skipped.
+	EncryptRooms bool
+
+	// AdminPowerLevel is the power level team and channel admins get in
+	// their spaces and rooms (0: admins become plain members)
+	AdminPowerLevel int
+
+	// CheckConnection is called before each asset import stage and every
+	// connectionCheckInterval messages; an error stops the import, so a
+	// dead connection fails it with a clear cause (nil: never checked)
+	CheckConnection func() error
+
+	// DryRun logs what users, spaces, rooms and memberships would be created
+	// and counts them, without changing anything on the homeserver. Rooms
+	// that would be created get placeholder IDs.
+	DryRun bool
+}
+
+// Policies for invites the homeserver rejects as forbidden. Users that are
+// already in the room are always counted as skipped.
+const (
+	InviteForbiddenFail = "fail" // Count the membership as failed
+	InviteForbiddenSkip = "skip" // Count it as skipped, as older versions did
+)
+
+// Policies for users sharing an email address
+const (
+	DuplicateEmailFirst = "first"           // Only the first active user gets the email binding
+	DuplicateEmailSkip  = "skip_duplicates" // Users after the first are not created
+	DuplicateEmailError = "error"           // Fail the user import
+)
+
+// Mattermost user fields usable as SSO external IDs
+const (
+	ExternalIDFieldEmail    = "email"
+	ExternalIDFieldUsername = "username"
+	ExternalIDFieldID       = "id"
+	ExternalIDFieldAuthData = "auth_data"
+)
+
+// usesEmail returns true if created users are bound to their email address
+func (i *Importer) usesEmail() bool {
+	if i.options.SSOAuthProvider == "" {
+		return false
+	}
+	field := i.options.SSOExternalIDField
+	return field == "" || field == ExternalIDFieldEmail
+}
+
+// duplicateEmailUsers returns the users that may not be bound to their email
+// because an earlier user has the same address, by Mattermost user ID.
+// The first active user of each address keeps it; if all are deleted, the
+// first one does. Addresses are compared case-insensitively.
+func duplicateEmailUsers(users []mattermost.User) map[string]string {
+	groups := make(map[string][]mattermost.User)
+	var order []string
+	for _, user := range users {
+		email := strings.ToLower(strings.TrimSpace(user.Email))
+		if email == "" {
+			continue
+		}
+		if _, ok := groups[email]; !ok {
+			order = append(order, email)
+		}
+		groups[email] = append(groups[email], user)
+	}
+
+	duplicates := make(map[string]string)
+	for _, email := range order {
+		group := groups[email]
+		if len(group) < 2 {
+			continue
+		}
+		owner := group[0].ID
+		for _, user := range group {
+			if !user.IsDeleted() {
+				owner = user.ID
+				break
+			}
+		}
+		for _, user := range group {
+			if user.ID != owner {
+				duplicates[user.ID] = email
+			}
+		}
+	}
+	return duplicates
+}
+
+// displayName returns the display name of a created user: the full name,
+// or the username if the user has none, truncated to MaxDisplayNameLength
+func (i *Importer) displayName(user mattermost.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.Username
+	}
+
+	max := i.options.MaxDisplayNameLength
+	if max <= 0 || utf8.RuneCountInString(name) <= max {
+		return name
+	}
+	runes := []rune(name)
+	truncated := strings.TrimSpace(string(runes[:max-1])) + "…"
+	logger.Warn("Display name of user '%s' is longer than %d characters, truncated to '%s'", user.Username, max, truncated)
+	return truncated
+}
+
+// ssoExternalID returns the external ID of user at the configured SSO
+// provider, or "" if the user has no value in the configured field
+func (i *Importer) ssoExternalID(user mattermost.User) string {
+	switch i.options.SSOExternalIDField {
+	case ExternalIDFieldUsername:
+		return user.Username
+	case ExternalIDFieldID:
+		return user.ID
+	case ExternalIDFieldAuthData:
+		return strings.TrimSpace(user.AuthData)
+	default:
+		return strings.TrimSpace(user.Email)
+	}
+}
+
+// Policies for channels whose team was not imported as a space
+const (
+	OrphanPolicySkip          = "skip"          // Don't import the channel
+	OrphanPolicyImportFlat    = "import_flat"   // Import the room without a parent space
+	OrphanPolicyUncategorized = "uncategorized" // Link the room to an "Uncategorized" space
+)
+
+// Orders of the rooms in a space
+const (
+	ChildOrderNone         = "none"         // No order; clients pick one
+	ChildOrderCreation     = "creation"     // Order in which the channels were created
+	ChildOrderAlphabetical = "alphabetical" // Channel display names, case-insensitive
+)
+
+// childOrders returns the m.space.child order of each channel for policy, a
+// zero-padded rank so clients sort the rooms by it. Returns nil for ChildOrderNone.
+func childOrders(channels []mattermost.Channel, policy string) map[string]string {
+	var less func(a, b mattermost.Channel) bool
+	switch policy {
+	case ChildOrderCreation:
+		less = func(a, b mattermost.Channel) bool { return a.CreateAt < b.CreateAt }
+	case ChildOrderAlphabetical:
+		less = func(a, b mattermost.Channel) bool {
+			return strings.ToLower(a.DisplayName) < strings.ToLower(b.DisplayName)
+		}
+	default:
+		return nil
+	}
+
+	sorted := make([]mattermost.Channel, len(channels))
+	copy(sorted, channels)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		if less(sorted[a], sorted[b]) {
+			return true
+		}
+		if less(sorted[b], sorted[a]) {
+			return false
+		}
+		return sorted[a].ID < sorted[b].ID
+	})
+
+	orders := make(map[string]string, len(sorted))
+	for rank, channel := range sorted {
+		orders[channel.ID] = fmt.Sprintf("%06d", rank)
+	}
+	return orders
 }
 
+// uncategorizedSpaceAlias is the alias localpart of the space orphan rooms are linked to
+const uncategorizedSpaceAlias = "mm_uncategorized"
+
 // NewImporter creates a new importer
 func NewImporter(client *Client) *Importer {
-	return &Importer{client: client}
+	return NewImporterWithOptions(client, ImporterOptions{})
+}
+
+// NewImporterWithOptions creates a new importer with the given options
+func NewImporterWithOptions(client *Client, options ImporterOptions) *Importer {
+	if options.Aliases == nil {
+		options.Aliases = defaultAliasGenerator
+	}
+	if options.MaxMessageBytes <= 0 {
+		options.MaxMessageBytes = DefaultMaxMessageBytes
+	}
+	if options.OversizePolicy == "" {
+		options.OversizePolicy = OversizePolicySplit
+	}
+	return &Importer{client: client, options: options}
+}
+
+// Deactivations returns the audit records of accounts created deactivated
+func (i *Importer) Deactivations() []DeactivationRecord {
+	return i.deactivations
+}
+
+// RoomAliases returns the name aliases of the rooms, including those added
+// by earlier runs (channel ID -> alias)
+func (i *Importer) RoomAliases() map[string]string {
+	return i.roomAliases
+}
+
+// dryRunRoomPrefix starts the placeholder IDs of rooms a dry run would create
+const dryRunRoomPrefix = "!dry-run-"
+
+// dryRunRoomID returns the placeholder ID a dry run gives a room or space
+// that would be created with the given alias localpart
+func dryRunRoomID(aliasName, homeserver string) string {
+	return dryRunRoomPrefix + aliasName + ":" + homeserver
+}
+
+// createRoom creates a space or a regular room. In a dry run nothing is
+// created: a room already using the alias is reported as existing, as
+// CreateRoom would, and any other room gets a placeholder ID.
+func (i *Importer) createRoom(ctx context.Context, opts RoomOptions, space bool) (*CreateRoomResponse, error) {
+	opts.RoomVersion = i.options.RoomVersion
+	if !i.options.DryRun {
+		if err := i.paceRoomCreation(ctx); err != nil {
+			return nil, err
+		}
+		defer func() { i.lastRoomCreated = time.Now() }()
+		if space {
+			return i.client.CreateSpace(ctx, opts)
+		}
+		return i.client.CreateRegularRoom(ctx, opts)
+	}
+
+	if opts.AliasName != "" {
+		roomID, err := i.client.ResolveAlias(ctx, i.client.FormatRoomAlias(opts.AliasName))
+		if err != nil {
+			return nil, err
+		}
+		if roomID != "" {
+			return &CreateRoomResponse{RoomID: roomID, Existing: true}, nil
+		}
+	}
+	return &CreateRoomResponse{RoomID: dryRunRoomID(opts.AliasName, i.client.homeserver)}, nil
+}
+
+// paceRoomCreation waits until RoomCreationPacing, plus a random jitter, has
+// passed since the last room was created. It returns early with the
+// context's error once ctx is cancelled.
+func (i *Importer) paceRoomCreation(ctx context.Context) error {
+	if i.lastRoomCreated.IsZero() || (i.options.RoomCreationPacing <= 0 && i.options.RoomCreationJitter <= 0) {
+		return nil
+	}
+	delay := i.options.RoomCreationPacing
+	if i.options.RoomCreationJitter > 0 {
+		delay += time.Duration(randomIndex(int(i.options.RoomCreationJitter/time.Millisecond)+1)) * time.Millisecond
+	}
+	wait := time.Until(i.lastRoomCreated.Add(delay))
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
 }
 
 // ImportProgressCallback is called to report import progress
 type ImportProgressCallback func(stage string, current, total int, item string)
 
-// GenerateRandomPassword generates a random password for new users
-func GenerateRandomPassword() string {
-	// In production, use crypto/rand for secure random password
-	return "ChangeMe123!" // Placeholder - users should change this
+// listUsersPageSize is the page size used when listing existing users
+const listUsersPageSize = 500
+
+// maxUserIDLength is the maximum length of a full Matrix user ID
+const maxUserIDLength = 255
+
+// validateUserLocalpart checks that a Mattermost username can be used as a
+// Matrix user ID localpart on the given homeserver
+func validateUserLocalpart(username, homeserver string) error {
+	if strings.TrimSpace(username) == "" {
+		return fmt.Errorf("username is empty")
+	}
+	for _, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '=', r == '-', r == '/', r == '+':
+		default:
+			return fmt.Errorf("username contains %q, which is not allowed in a Matrix user ID", r)
+		}
+	}
+	if n := len("@" + username + ":" + homeserver); n > maxUserIDLength {
+		return fmt.Errorf("user ID would be %d characters, the maximum is %d", n, maxUserIDLength)
+	}
+	return nil
+}
+
+// LinkExistingUsers maps Mattermost users to Matrix accounts of the same
+// username that already exist on the homeserver, without creating any.
+// Returns the merged mapping and the number of users newly linked.
+func (i *Importer) LinkExistingUsers(ctx context.Context, users []mattermost.User, existingMapping map[string]string) (map[string]string, int, error) {
+	mapping := copyMapping(existingMapping)
+
+	existingUsers, err := i.client.ListAllUserIDs(ctx, listUsersPageSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list existing users: %w", err)
+	}
+
+	linked := 0
+	for _, user := range users {
+		if _, exists := mapping[user.ID]; exists {
+			continue
+		}
+		userID := i.client.FormatUserID(user.Username)
+		if !existingUsers[userID] {
+			logger.Info("User '%s' does not exist on the homeserver, not linked", user.Username)
+			continue
+		}
+		mapping[user.ID] = userID
+		linked++
+	}
+	return mapping, linked, nil
 }
 
 // ImportUsers imports users from Mattermost to Matrix
-func (i *Importer) ImportUsers(users []mattermost.User, existingMapping map[string]string, progress ImportProgressCallback) (map[string]string, *ImportStats, error) {
+func (i *Importer) ImportUsers(ctx context.Context, users []mattermost.User, existingMapping map[string]string, progress ImportProgressCallback) (map[string]string, *ImportStats, error) {
 	mapping := make(map[string]string)
 	stats := &ImportStats{}
 	total := len(users)
@@ -41,15 +471,42 @@ func (i *Importer) ImportUsers(users []mattermost.User, existingMapping map[stri
 	}
 	logger.Info("Existing mappings copied: %d entries", len(existingMapping))
 
+	// Fetch all existing users once instead of checking each user individually
+	existingUsers, err := i.client.ListAllUserIDs(ctx, listUsersPageSize)
+	if err != nil {
+		logger.Warn("Could not list existing users, checking each user individually: %v", err)
+		existingUsers = nil
+	} else {
+		logger.Info("Found %d existing users on the homeserver", len(existingUsers))
+	}
+
+	// Several users with one email address can't all be bound to it
+	var emailDuplicates map[string]string
+	if i.usesEmail() {
+		emailDuplicates = duplicateEmailUsers(users)
+		for _, user := range users {
+			if email, ok := emailDuplicates[user.ID]; ok {
+				logger.Warn("User '%s' shares the email %s with another user", user.Username, email)
+			}
+		}
+		if len(emailDuplicates) > 0 && i.options.DuplicateEmailPolicy == DuplicateEmailError {
+			return nil, stats, fmt.Errorf("%d users share an email address with another user (duplicate_email_policy: error)", len(emailDuplicates))
+		}
+	}
+
+	adminV2Checked := false
 	for idx, user := range users {
+		if err := ctx.Err(); err != nil {
+			return mapping, stats, err
+		}
 		logger.Info("Processing user %d/%d: %s (ID: %s)", idx+1, total, user.Username, user.ID)
 		
 		if progress != nil {
 			progress("users", idx+1, total, user.Username)
 		}
 
-		// Skip deleted users
-		if user.IsDeleted() {
+		// Skip deleted users unless they are imported as deactivated accounts
+		if user.IsDeleted() && !i.options.ImportDeletedUsers {
 			logger.Info("User '%s' is deleted, skipping", user.Username)
 			stats.UsersSkipped++
 			continue
@@ -62,11 +519,29 @@ func (i *Importer) ImportUsers(users []mattermost.User, existingMapping map[stri
 			continue
 		}
 
+		_, duplicateEmail := emailDuplicates[user.ID]
+		if duplicateEmail {
+			stats.UsersDuplicateEmail++
+			if i.options.DuplicateEmailPolicy == DuplicateEmailSkip {
+				logger.Info("User '%s' has a duplicate email, skipping", user.Username)
+				stats.UsersSkipped++
+				continue
+			}
+		}
+
+		// Skip usernames that can't form a valid Matrix ID; creating them would fail anyway
+		if err := validateUserLocalpart(user.Username, i.client.homeserver); err != nil {
+			logger.Warn("User %q (ID: %s) has an invalid username, skipping: %v", user.Username, user.ID, err)
+			stats.UsersInvalid++
+			continue
+		}
+
 		// Try to check if user exists, but don't fail if check fails
 		// (some Matrix servers only allow checking local users)
 		exists := false
-		existsCheck, err := i.client.UserExists(user.Username)
-		if err != nil {
+		if existingUsers != nil {
+			exists = existingUsers[i.client.FormatUserID(user.Username)]
+		} else if existsCheck, err := i.client.UserExists(ctx, user.Username); err != nil {
 			// If check fails with "Can only look up local users", ignore it
 			// CreateUser is idempotent anyway, so we can just try to create
 			if strings.Contains(err.Error(), "Can only look up local users") {
@@ -86,20 +561,61 @@ func (i *Importer) ImportUsers(users []mattermost.User, existingMapping map[stri
 			continue
 		}
 
-		// Create the user (CreateUser is idempotent - if user exists, it will update)
-		displayName := strings.TrimSpace(user.FirstName + " " + user.LastName)
-		if displayName == "" {
-			displayName = user.Username
+		// Without the admin v2 API no user can be created; stop before failing
+		// each one. Checked only once a user needs creating, so runs where every
+		// user is already mapped or present still succeed.
+		if !adminV2Checked {
+			adminV2Checked = true
+			if available, err := i.client.AdminV2Available(ctx); err != nil {
+				logger.Warn("Could not check for the Synapse admin v2 API: %v", err)
+			} else if !available {
+				return mapping, stats, ErrAdminV2Unavailable
+			}
 		}
 
+		// Create the user (CreateUser is idempotent - if user exists, it will update)
+		displayName := i.displayName(user)
+
 		req := &CreateUserRequest{
 			Password:    GenerateRandomPassword(),
 			DisplayName: displayName,
 			Admin:       false,
 			Deactivated: false,
 		}
+		if i.options.UserType != "" {
+			userType := i.options.UserType
+			req.UserType = &userType
+		}
+		req.LogoutDevices = i.options.LogoutDevices
+		if i.options.SSOAuthProvider != "" && duplicateEmail {
+			logger.Info("User '%s' has a duplicate email, creating without an external ID", user.Username)
+		} else if i.options.SSOAuthProvider != "" {
+			if externalID := i.ssoExternalID(user); externalID != "" {
+				req.ExternalIDs = []ExternalID{{AuthProvider: i.options.SSOAuthProvider, ExternalID: externalID}}
+				if i.options.SSOWithoutPassword {
+					req.Password = ""
+				}
+			} else {
+				logger.Warn("User '%s' has no SSO external ID, creating without one", user.Username)
+			}
+		}
+		if user.IsDeleted() {
+			// Deactivated accounts cannot log in, so no password is set
+			req.Password = ""
+			req.Deactivated = true
+		}
+
+		if i.options.DryRun {
+			logger.Info("[dry run] Would create user '%s' -> %s", user.Username, i.client.FormatUserID(user.Username))
+			mapping[user.ID] = i.client.FormatUserID(user.Username)
+			stats.UsersCreated++
+			if user.IsDeleted() {
+				stats.UsersDeactivated++
+			}
+			continue
+		}
 
-		resp, err := i.client.CreateUser(user.Username, req)
+		resp, err := i.client.CreateUser(ctx, user.Username, req)
 		if err != nil {
 			// Check if error is because user already exists
 			if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "M_USER_IN_USE") {
@@ -117,13 +633,27 @@ func (i *Importer) ImportUsers(users []mattermost.User, existingMapping map[stri
 
 		mapping[user.ID] = resp.UserID
 		stats.UsersCreated++
+
+		if user.IsDeleted() {
+			deletedAt := time.UnixMilli(user.DeleteAt).UTC()
+			i.deactivations = append(i.deactivations, DeactivationRecord{
+				MattermostUserID: user.ID,
+				Username:         user.Username,
+				MatrixUserID:     resp.UserID,
+				DeletedAt:        user.DeleteAt,
+				ImportedAt:       time.Now().UnixMilli(),
+				Reason:           fmt.Sprintf("deleted in Mattermost at %s", deletedAt.Format(time.RFC3339)),
+			})
+			stats.UsersDeactivated++
+			logger.Info("User '%s' created deactivated (deleted in Mattermost at %s)", user.Username, deletedAt.Format(time.RFC3339))
+		}
 	}
 
 	return mapping, stats, nil
 }
 
 // ImportTeamsAsSpaces imports teams from Mattermost as Matrix spaces
-func (i *Importer) ImportTeamsAsSpaces(teams []mattermost.Team, existingMapping map[string]string, progress ImportProgressCallback) (map[string]string, *ImportStats, error) {
+func (i *Importer) ImportTeamsAsSpaces(ctx context.Context, teams []mattermost.Team, existingMapping map[string]string, progress ImportProgressCallback) (map[string]string, *ImportStats, error) {
 	mapping := make(map[string]string)
 	stats := &ImportStats{}
 	total := len(teams)
@@ -134,12 +664,15 @@ func (i *Importer) ImportTeamsAsSpaces(teams []mattermost.Team, existingMapping
 	}
 
 	for idx, team := range teams {
+		if err := ctx.Err(); err != nil {
+			return mapping, stats, err
+		}
 		if progress != nil {
 			progress("spaces", idx+1, total, team.DisplayName)
 		}
 
 		// Skip deleted teams
-		if team.IsDeleted() {
+		if team.IsDeleted() && !i.options.ImportArchived {
 			stats.SpacesSkipped++
 			continue
 		}
@@ -152,23 +685,157 @@ func (i *Importer) ImportTeamsAsSpaces(teams []mattermost.Team, existingMapping
 		}
 
 		// Create space
-		resp, err := i.client.CreateSpace(team.DisplayName, team.Description, team.IsOpen())
+		public, joinRule := i.spaceAccess(team)
+		resp, err := i.createRoom(ctx, RoomOptions{
+			Name:      team.DisplayName,
+			Topic:     team.Description,
+			AliasName: i.options.Aliases.SpaceAlias(team),
+			Public:    public,
+			Listed:    public,
+			JoinRule:  joinRule,
+		}, true)
 		if err != nil {
 			logger.Error("Failed to create space '%s': %v", team.DisplayName, err)
 			stats.SpacesFailed++
 			continue
 		}
 
-		logger.Success("Created space '%s' -> %s", team.DisplayName, resp.RoomID)
 		mapping[team.ID] = resp.RoomID
+		if resp.Existing {
+			logger.Info("Space '%s' already exists (alias in use) -> %s, skipped", team.DisplayName, resp.RoomID)
+			stats.SpacesSkipped++
+			continue
+		}
+
+		if i.options.DryRun {
+			logger.Info("[dry run] Would create space '%s'", team.DisplayName)
+		} else {
+			logger.Success("Created space '%s' -> %s", team.DisplayName, resp.RoomID)
+		}
 		stats.SpacesCreated++
 	}
 
 	return mapping, stats, nil
 }
 
+// spaceAccess returns how users may join the space of a team, following the
+// team's invite policy: open-invite teams get a public space, or a knockable
+// one if only some email domains may join (Matrix can't check those, so
+// members approve the requests). Other teams get an invite-only space.
+func (i *Importer) spaceAccess(team mattermost.Team) (public bool, joinRule string) {
+	if !team.IsOpenInvite() {
+		return false, ""
+	}
+	if strings.TrimSpace(team.AllowedDomains) == "" {
+		return true, ""
+	}
+	// Knocking needs room version 7; older versions stay invite-only
+	if version, err := strconv.Atoi(i.options.RoomVersion); err == nil && version < 7 {
+		logger.Warn("Space '%s' is limited to domains %s; room version %s can't knock, so it is invite-only", team.DisplayName, team.AllowedDomains, i.options.RoomVersion)
+		return false, ""
+	}
+	return false, JoinRuleKnock
+}
+
+// RoomImportContext carries the other assets rooms are derived from
+type RoomImportContext struct {
+	Teams        map[string]mattermost.Team // Mattermost team ID -> team
+	SpaceMapping map[string]string          // Mattermost team ID -> Matrix space ID
+	Usernames    map[string]string          // Mattermost user ID -> username
+	UserMapping  map[string]string          // Mattermost user ID -> Matrix user ID
+
+	// DefaultCreator is credited for channels whose creator has no Matrix user
+	DefaultCreator string
+}
+
+// NewRoomImportContext builds a room import context from exported assets
+// and the spaces and users imported for them
+func NewRoomImportContext(assets *mattermost.Assets, spaceMapping, userMapping map[string]string) RoomImportContext {
+	teams := make(map[string]mattermost.Team, len(assets.Teams))
+	for _, team := range assets.Teams {
+		teams[team.ID] = team
+	}
+	usernames := make(map[string]string, len(assets.Users))
+	for _, user := range assets.Users {
+		usernames[user.ID] = user.Username
+	}
+	return RoomImportContext{Teams: teams, SpaceMapping: spaceMapping, Usernames: usernames, UserMapping: userMapping}
+}
+
+// channelCreator returns the creator of a channel, or nil if it's unknown
+func (rctx RoomImportContext) channelCreator(channel mattermost.Channel) *MattermostCreatorContent {
+	if channel.CreatorID == "" {
+		return nil
+	}
+	creator := &MattermostCreatorContent{
+		MattermostUserID: channel.CreatorID,
+		Username:         rctx.Usernames[channel.CreatorID],
+		UserID:           rctx.UserMapping[channel.CreatorID],
+	}
+	if creator.UserID == "" && rctx.DefaultCreator != "" {
+		creator.UserID = rctx.DefaultCreator
+		creator.Substitute = true
+	}
+	return creator
+}
+
+// defaultRoomCreator returns the Matrix user credited for channels whose
+// creator wasn't imported: DefaultRoomCreator, or else the admin user
+func (i *Importer) defaultRoomCreator(ctx context.Context) string {
+	if creator := i.options.DefaultRoomCreator; creator != "" {
+		if strings.HasPrefix(creator, "@") {
+			return creator
+		}
+		return i.client.FormatUserID(creator)
+	}
+	resp, err := i.client.WhoAmI(ctx)
+	if err != nil {
+		logger.Warn("Could not look up the admin user to credit for channels created by unmigrated users: %v", err)
+		return ""
+	}
+	return resp.UserID
+}
+
+// roomTopic returns the topic of the room for a channel: its purpose or
+// header, followed by the creator if CreatorInTopic is set
+func (i *Importer) roomTopic(channel mattermost.Channel, rctx RoomImportContext) string {
+	topic := channel.Purpose
+	if topic == "" {
+		topic = channel.Header
+	}
+	if !i.options.CreatorInTopic {
+		return topic
+	}
+
+	creator := rctx.channelCreator(channel)
+	if creator == nil {
+		return topic
+	}
+	// A bot's username says more than the user standing in for it
+	name := creator.UserID
+	if name == "" || (creator.Substitute && creator.Username != "") {
+		name = creator.Username
+	}
+	if name == "" {
+		return topic
+	}
+	if topic == "" {
+		return "Created by " + name
+	}
+	return topic + "\n\nCreated by " + name
+}
+
+// isOrphanChannel returns true if the channel belongs to a team that has no space
+func isOrphanChannel(channel mattermost.Channel, spaceMapping map[string]string) bool {
+	if channel.TeamID == "" {
+		return false
+	}
+	_, exists := spaceMapping[channel.TeamID]
+	return !exists
+}
+
 // ImportChannelsAsRooms imports channels from Mattermost as Matrix rooms
-func (i *Importer) ImportChannelsAsRooms(channels []mattermost.Channel, existingMapping map[string]string, progress ImportProgressCallback) (map[string]string, *ImportStats, error) {
+func (i *Importer) ImportChannelsAsRooms(ctx context.Context, channels []mattermost.Channel, existingMapping map[string]string, rctx RoomImportContext, progress ImportProgressCallback) (map[string]string, *ImportStats, error) {
 	mapping := make(map[string]string)
 	stats := &ImportStats{}
 	total := len(channels)
@@ -178,162 +845,841 @@ func (i *Importer) ImportChannelsAsRooms(channels []mattermost.Channel, existing
 		mapping[k] = v
 	}
 
+	if i.options.EncryptRooms {
+		logger.Warn("Rooms are created with end-to-end encryption; the message import will skip their history")
+	}
+
+	if i.roomAliases == nil {
+		i.roomAliases = make(map[string]string)
+	}
+	if rctx.DefaultCreator == "" {
+		rctx.DefaultCreator = i.defaultRoomCreator(ctx)
+	}
+	var nameAliases map[string]string
+	if i.options.NameAliases {
+		nameAliases = nameAliasLocalparts(channels, rctx.Teams)
+	}
+
 	for idx, channel := range channels {
+		if err := ctx.Err(); err != nil {
+			return mapping, stats, err
+		}
 		if progress != nil {
 			progress("rooms", idx+1, total, channel.DisplayName)
 		}
 
 		// Skip deleted channels
-		if channel.IsDeleted() {
+		if channel.IsDeleted() && !i.options.ImportArchived {
 			stats.RoomsSkipped++
 			continue
 		}
 
-		// Skip direct messages (2-person DMs)
-		if channel.IsDirect() {
+		// Direct messages (2-person DMs) are only imported as DM rooms
+		if channel.IsDirect() && !i.options.DirectMessages {
 			stats.RoomsSkipped++
 			continue
 		}
+		if i.options.DirectMessages && (channel.IsDirect() || channel.IsGroup()) {
+			i.importDirectRoom(ctx, channel, existingMapping, mapping, rctx, stats)
+			continue
+		}
+
+		topic := i.roomTopic(channel, rctx)
 
 		// Skip if already imported (exists in mapping)
-		if _, exists := existingMapping[channel.ID]; exists {
+		if roomID, exists := existingMapping[channel.ID]; exists {
+			i.publishRoom(ctx, channel, roomID)
+			i.addNameAlias(ctx, channel, roomID, nameAliases)
+			if i.options.UpdateExisting {
+				updated, err := i.updateRoomDetails(ctx, roomID, channel.DisplayName, topic)
+				if err != nil {
+					logger.Error("Failed to update room '%s': %v", channel.DisplayName, err)
+				} else if updated {
+					logger.Success("Updated room '%s' (%s)", channel.DisplayName, roomID)
+					stats.RoomsUpdated++
+				}
+			}
 			logger.Info("Room '%s' already imported, skipped", channel.DisplayName)
 			stats.RoomsSkipped++
 			continue
 		}
 
+		// Skip channels that never had a message when configured
+		if i.options.SkipEmptyChannels && channel.TotalMsgCount == 0 {
+			logger.Info("Room '%s' has no messages, skipped", channel.DisplayName)
+			stats.RoomsSkipped++
+			stats.RoomsSkippedEmpty++
+			continue
+		}
+
+		// Apply the orphan policy to channels whose team has no space
+		if i.options.OrphanChannelPolicy == OrphanPolicySkip && isOrphanChannel(channel, rctx.SpaceMapping) {
+			logger.Info("Room '%s' belongs to team %s which was not imported, skipped", channel.DisplayName, channel.TeamID)
+			stats.RoomsSkipped++
+			stats.RoomsSkippedOrphan++
+			continue
+		}
+
 		// Create room
-		topic := channel.Purpose
-		if topic == "" {
-			topic = channel.Header
+		opts := RoomOptions{
+			Name:      channel.DisplayName,
+			Topic:     topic,
+			AliasName: i.options.Aliases.RoomAlias(channel, rctx.Teams[channel.TeamID]),
+			Public:    channel.IsPublic(),
+			Encrypted: i.options.EncryptRooms,
+		}
+		if creator := rctx.channelCreator(channel); creator != nil {
+			if creator.Substitute {
+				logger.Info("Room '%s': creator %s was not migrated, crediting %s", channel.DisplayName, channel.CreatorID, creator.UserID)
+			}
+			opts.InitialState = append(opts.InitialState, StateEvent{Type: EventTypeMattermostCreator, Content: creator})
+		}
+		if i.options.HistoryVisibility != "" {
+			opts.InitialState = append(opts.InitialState, StateEvent{
+				Type:    EventTypeHistoryVisibility,
+				Content: HistoryVisibilityContent{HistoryVisibility: i.options.HistoryVisibility},
+			})
 		}
 
-		resp, err := i.client.CreateRegularRoom(channel.DisplayName, topic, channel.IsPublic())
+		resp, err := i.createRoom(ctx, opts, false)
 		if err != nil {
 			logger.Error("Failed to create room '%s': %v", channel.DisplayName, err)
 			stats.RoomsFailed++
 			continue
 		}
 
-		logger.Success("Created room '%s' -> %s", channel.DisplayName, resp.RoomID)
 		mapping[channel.ID] = resp.RoomID
+		i.publishRoom(ctx, channel, resp.RoomID)
+		i.addNameAlias(ctx, channel, resp.RoomID, nameAliases)
+		if resp.Existing {
+			logger.Info("Room '%s' already exists (alias in use) -> %s, skipped", channel.DisplayName, resp.RoomID)
+			stats.RoomsSkipped++
+			continue
+		}
+
+		if i.options.DryRun {
+			logger.Info("[dry run] Would create room '%s'", channel.DisplayName)
+		} else {
+			logger.Success("Created room '%s' -> %s", channel.DisplayName, resp.RoomID)
+		}
 		stats.RoomsCreated++
 	}
 
+	i.tagDirectRooms(ctx)
 	return mapping, stats, nil
 }
 
+// importDirectRoom creates the room of a direct or group message: an
+// unnamed is_direct room, in no space, with only the participants invited.
+// The room is recorded for the participants' m.direct account data.
+func (i *Importer) importDirectRoom(ctx context.Context, channel mattermost.Channel, existingMapping, mapping map[string]string, rctx RoomImportContext, stats *ImportStats) {
+	var members []string
+	for _, userID := range channel.Members {
+		if matrixID, ok := rctx.UserMapping[userID]; ok && !slices.Contains(members, matrixID) {
+			members = append(members, matrixID)
+		}
+	}
+	label := directRoomLabel(channel, rctx)
+
+	if roomID, exists := existingMapping[channel.ID]; exists {
+		i.recordDirectRoom(roomID, members)
+		logger.Info("Direct message %s already imported, skipped", label)
+		stats.RoomsSkipped++
+		return
+	}
+	if i.options.SkipEmptyChannels && channel.TotalMsgCount == 0 {
+		logger.Info("Direct message %s has no messages, skipped", label)
+		stats.RoomsSkipped++
+		stats.RoomsSkippedEmpty++
+		return
+	}
+	if len(members) == 0 {
+		if len(channel.Members) == 0 {
+			logger.Warn("Direct message %s has no exported members; export assets again with import.direct_messages, skipped", label)
+		} else {
+			logger.Info("Direct message %s has no migrated participants, skipped", label)
+		}
+		stats.RoomsSkipped++
+		return
+	}
+
+	// The fixed alias lets a re-run after an interrupted import, which saved
+	// no mapping, find the room. It isn't made the canonical alias, as
+	// clients would show it instead of the participants' names.
+	alias := i.client.FormatRoomAlias(directRoomAlias(channel))
+	if roomID, err := i.client.ResolveAlias(ctx, alias); err != nil {
+		logger.Error("Failed to look up direct message %s: %v", label, err)
+		stats.RoomsFailed++
+		return
+	} else if roomID != "" {
+		mapping[channel.ID] = roomID
+		i.recordDirectRoom(roomID, members)
+		logger.Info("Direct message %s already exists (alias in use) -> %s, skipped", label, roomID)
+		stats.RoomsSkipped++
+		return
+	}
+
+	opts := RoomOptions{
+		Direct:    true,
+		Invite:    members,
+		Encrypted: i.options.EncryptRooms,
+	}
+	if i.options.HistoryVisibility != "" {
+		opts.InitialState = append(opts.InitialState, StateEvent{
+			Type:    EventTypeHistoryVisibility,
+			Content: HistoryVisibilityContent{HistoryVisibility: i.options.HistoryVisibility},
+		})
+	}
+
+	resp, err := i.createRoom(ctx, opts, false)
+	if err != nil {
+		logger.Error("Failed to create direct message %s: %v", label, err)
+		stats.RoomsFailed++
+		return
+	}
+	if i.options.DryRun {
+		// Placeholder IDs without an alias would all be the same
+		resp.RoomID = dryRunRoomID("dm_"+channel.ID, i.client.homeserver)
+		logger.Info("[dry run] Would create direct message %s", label)
+	} else {
+		logger.Success("Created direct message %s -> %s", label, resp.RoomID)
+		if err := i.client.CreateAlias(ctx, alias, resp.RoomID); err != nil {
+			logger.Warn("Could not add alias %s to direct message %s: %v", alias, label, err)
+		}
+	}
+	mapping[channel.ID] = resp.RoomID
+	i.recordDirectRoom(resp.RoomID, members)
+	stats.DirectRoomsCreated++
+}
+
+// directRoomLabel names a direct or group message in log messages by its
+// participants' usernames
+func directRoomLabel(channel mattermost.Channel, rctx RoomImportContext) string {
+	names := make([]string, 0, len(channel.Members))
+	for _, userID := range channel.Members {
+		if username, ok := rctx.Usernames[userID]; ok {
+			names = append(names, username)
+		} else {
+			names = append(names, userID)
+		}
+	}
+	if len(names) == 0 {
+		return channel.ID
+	}
+	sort.Strings(names)
+	return "'" + strings.Join(names, ", ") + "'"
+}
+
+// recordDirectRoom adds a direct message room to the m.direct entries of
+// each participant, listed under every other participant
+func (i *Importer) recordDirectRoom(roomID string, members []string) {
+	if i.directRooms == nil {
+		i.directRooms = make(map[string]map[string][]string)
+	}
+	for _, userID := range members {
+		for _, otherID := range members {
+			if otherID == userID {
+				continue
+			}
+			if i.directRooms[userID] == nil {
+				i.directRooms[userID] = make(map[string][]string)
+			}
+			i.directRooms[userID][otherID] = append(i.directRooms[userID][otherID], roomID)
+		}
+	}
+}
+
+// tagDirectRooms adds the recorded direct message rooms to the m.direct
+// account data of their participants, keeping the entries already there.
+// Account data can only be written as the user, so this needs the AS token;
+// without it clients still see the rooms, from is_direct on the invites.
+func (i *Importer) tagDirectRooms(ctx context.Context) {
+	if len(i.directRooms) == 0 {
+		return
+	}
+	if i.options.DryRun {
+		logger.Info("[dry run] Would set m.direct for %d users", len(i.directRooms))
+		return
+	}
+	if !i.client.HasASToken() {
+		logger.Warn("m.direct not set for %d users: it needs the Application Service token (appservice.enabled)", len(i.directRooms))
+		return
+	}
+
+	userIDs := make([]string, 0, len(i.directRooms))
+	for userID := range i.directRooms {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	failed := 0
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		rooms, err := i.client.GetDirectRooms(ctx, userID)
+		if err == nil {
+			changed := false
+			for otherID, roomIDs := range i.directRooms[userID] {
+				for _, roomID := range roomIDs {
+					if !slices.Contains(rooms[otherID], roomID) {
+						rooms[otherID] = append(rooms[otherID], roomID)
+						changed = true
+					}
+				}
+			}
+			if changed {
+				err = i.client.SetDirectRooms(ctx, userID, rooms)
+			}
+		}
+		if err != nil {
+			logger.Warn("Failed to set m.direct for %s: %v", userID, err)
+			failed++
+		}
+	}
+	if failed == 0 {
+		logger.Info("Set m.direct for %d users", len(userIDs))
+	}
+}
+
+// maxAliasSuffix is the highest number appended to a name alias that is taken
+const maxAliasSuffix = 20
+
+// nameAliasLocalparts returns the alias localpart of each channel made from
+// its name. Names used in several teams are prefixed with the team name, so
+// each room gets its own alias. Direct and group messages get none.
+func nameAliasLocalparts(channels []mattermost.Channel, teams map[string]mattermost.Team) map[string]string {
+	nameTeams := make(map[string]map[string]bool) // localpart -> teams using it
+	for _, channel := range channels {
+		if channel.IsDirect() || channel.IsGroup() {
+			continue
+		}
+		name := SanitizeAliasLocalpart(channel.Name)
+		if nameTeams[name] == nil {
+			nameTeams[name] = make(map[string]bool)
+		}
+		nameTeams[name][channel.TeamID] = true
+	}
+
+	localparts := make(map[string]string)
+	for _, channel := range channels {
+		if channel.IsDirect() || channel.IsGroup() {
+			continue
+		}
+		name := SanitizeAliasLocalpart(channel.Name)
+		if team, ok := teams[channel.TeamID]; ok && len(nameTeams[name]) > 1 {
+			name = SanitizeAliasLocalpart(team.Name + "-" + channel.Name)
+		}
+		if name != "" {
+			localparts[channel.ID] = name
+		}
+	}
+	return localparts
+}
+
+// addNameAlias gives a room the alias of its channel name, unless it has one
+// already, and records it. Failures are logged; the room is usable without.
+func (i *Importer) addNameAlias(ctx context.Context, channel mattermost.Channel, roomID string, localparts map[string]string) {
+	localpart, ok := localparts[channel.ID]
+	if !ok || i.roomAliases[channel.ID] != "" {
+		return
+	}
+	if i.options.DryRun {
+		logger.Info("[dry run] Would add alias %s to room '%s'", i.client.FormatRoomAlias(localpart), channel.DisplayName)
+		return
+	}
+
+	alias, err := i.assignAlias(ctx, roomID, localpart)
+	if err != nil {
+		logger.Warn("Could not add an alias to room '%s': %v", channel.DisplayName, err)
+		return
+	}
+	logger.Info("Room '%s' alias: %s", channel.DisplayName, alias)
+	i.roomAliases[channel.ID] = alias
+}
+
+// assignAlias points the alias localpart at a room and makes it the room's
+// canonical alias. If the alias belongs to another room, -2, -3, ... is
+// appended until a free one is found. Returns the full alias.
+func (i *Importer) assignAlias(ctx context.Context, roomID, localpart string) (string, error) {
+	for n := 1; n <= maxAliasSuffix; n++ {
+		candidate := localpart
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d", localpart, n)
+		}
+		alias := i.client.FormatRoomAlias(candidate)
+
+		err := i.client.CreateAlias(ctx, alias, roomID)
+		if errors.Is(err, ErrAliasInUse) {
+			owner, resolveErr := i.client.ResolveAlias(ctx, alias)
+			if resolveErr != nil {
+				return "", fmt.Errorf("alias %s is in use but could not be resolved: %w", alias, resolveErr)
+			}
+			if owner != roomID {
+				continue
+			}
+		} else if err != nil {
+			return "", fmt.Errorf("failed to create alias %s: %w", alias, err)
+		}
+
+		if n > 1 {
+			logger.Warn("Alias %s belongs to another room, using %s", i.client.FormatRoomAlias(localpart), alias)
+		}
+		if err := i.client.SetCanonicalAlias(ctx, roomID, alias); err != nil {
+			return "", fmt.Errorf("failed to set canonical alias %s: %w", alias, err)
+		}
+		return alias, nil
+	}
+	return "", fmt.Errorf("aliases %s to %s-%d all belong to other rooms", i.client.FormatRoomAlias(localpart), localpart, maxAliasSuffix)
+}
+
+// publishRoom lists the room of a public channel in the room directory when
+// PublishPublicRooms is set. A failure only logs a warning: the room itself
+// was imported.
+func (i *Importer) publishRoom(ctx context.Context, channel mattermost.Channel, roomID string) {
+	if !i.options.PublishPublicRooms || !channel.IsPublic() || channel.IsDeleted() {
+		return
+	}
+	if i.options.DryRun {
+		logger.Info("[dry run] Would publish room '%s' to the room directory", channel.DisplayName)
+		return
+	}
+	if err := i.client.SetRoomVisibility(ctx, roomID, string(VisibilityPublic)); err != nil {
+		logger.Warn("Failed to publish room '%s' to the room directory: %v", channel.DisplayName, err)
+		return
+	}
+	logger.Info("Published room '%s' to the room directory", channel.DisplayName)
+}
+
+// UnlockRooms lets normal members send to the given rooms again, undoing
+// LockRooms. Returns the number of rooms unlocked and failed.
+func (i *Importer) UnlockRooms(ctx context.Context, roomIDs []string) (unlocked, failed int) {
+	for _, roomID := range roomIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := i.client.SetRoomWritable(ctx, roomID); err != nil {
+			logger.Error("Failed to make room %s writable: %v", roomID, err)
+			failed++
+			continue
+		}
+		unlocked++
+	}
+	return unlocked, failed
+}
+
+// LockRooms makes the given rooms read-only for normal members.
+// Returns the number of rooms locked and failed.
+func (i *Importer) LockRooms(ctx context.Context, roomIDs []string) (locked, failed int) {
+	for _, roomID := range roomIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := i.client.SetRoomReadOnly(ctx, roomID); err != nil {
+			logger.Error("Failed to make room %s read-only: %v", roomID, err)
+			failed++
+			continue
+		}
+		logger.Success("Room %s is now read-only", roomID)
+		locked++
+	}
+	return locked, failed
+}
+
+// updateRoomDetails sets the room name and topic if they differ from the source.
+// Returns true if anything was changed (in a dry run: would be changed).
+func (i *Importer) updateRoomDetails(ctx context.Context, roomID, name, topic string) (bool, error) {
+	updated := false
+
+	currentName, err := i.client.GetRoomName(ctx, roomID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read room name: %w", err)
+	}
+	if currentName != name && i.options.DryRun {
+		logger.Info("[dry run] Would rename room %s to '%s'", roomID, name)
+		updated = true
+	} else if currentName != name {
+		if err := i.client.SetRoomName(ctx, roomID, name); err != nil {
+			return false, fmt.Errorf("failed to set room name: %w", err)
+		}
+		updated = true
+	}
+
+	currentTopic, err := i.client.GetRoomTopic(ctx, roomID)
+	if err != nil {
+		return updated, fmt.Errorf("failed to read room topic: %w", err)
+	}
+	if currentTopic != topic && i.options.DryRun {
+		logger.Info("[dry run] Would change the topic of room %s", roomID)
+		updated = true
+	} else if currentTopic != topic {
+		if err := i.client.SetRoomTopic(ctx, roomID, topic); err != nil {
+			return updated, fmt.Errorf("failed to set room topic: %w", err)
+		}
+		updated = true
+	}
+
+	if i.options.HistoryVisibility == "" {
+		return updated, nil
+	}
+	currentVisibility, err := i.client.GetHistoryVisibility(ctx, roomID)
+	if err != nil {
+		return updated, fmt.Errorf("failed to read history visibility: %w", err)
+	}
+	if currentVisibility != i.options.HistoryVisibility && i.options.DryRun {
+		logger.Info("[dry run] Would set the history visibility of room %s to %s", roomID, i.options.HistoryVisibility)
+		updated = true
+	} else if currentVisibility != i.options.HistoryVisibility {
+		if err := i.client.SetHistoryVisibility(ctx, roomID, i.options.HistoryVisibility); err != nil {
+			return updated, fmt.Errorf("failed to set history visibility: %w", err)
+		}
+		updated = true
+	}
+
+	return updated, nil
+}
+
+// Reasons a membership cannot be imported
+const (
+	SkipReasonDeleted          = "membership deleted"
+	SkipReasonUserNotMapped    = "user not in mapping"
+	SkipReasonTeamNotMapped    = "team not in mapping"
+	SkipReasonChannelNotMapped = "channel not in mapping"
+)
+
+// MembershipPair is a membership resolved to the Matrix user and room (or space) to invite
+type MembershipPair struct {
+	Index  int    // Position in the input memberships
+	UserID string // Matrix user ID
+	RoomID string // Matrix room or space ID
+	Admin  bool   // Team or channel admin in Mattermost
+}
+
+// MembershipSkip is a membership that cannot be imported
+type MembershipSkip struct {
+	Index    int    // Position in the input memberships
+	UserID   string // Mattermost user ID
+	TargetID string // Mattermost team or channel ID
+	Reason   string // One of the SkipReason constants
+}
+
+// membershipRef is the part of a team or channel membership needed to resolve it
+type membershipRef struct {
+	UserID   string
+	TargetID string
+	Deleted  bool
+	Admin    bool
+}
+
+// teamMembershipRefs converts team memberships for resolveMemberships
+func teamMembershipRefs(memberships []mattermost.TeamMember) []membershipRef {
+	refs := make([]membershipRef, len(memberships))
+	for idx, m := range memberships {
+		refs[idx] = membershipRef{UserID: m.UserID, TargetID: m.TeamID, Deleted: m.IsDeleted(), Admin: m.IsAdmin()}
+	}
+	return refs
+}
+
+// channelMembershipRefs converts channel memberships for resolveMemberships
+func channelMembershipRefs(memberships []mattermost.ChannelMember) []membershipRef {
+	refs := make([]membershipRef, len(memberships))
+	for idx, m := range memberships {
+		refs[idx] = membershipRef{UserID: m.UserID, TargetID: m.ChannelID, Admin: m.IsAdmin()}
+	}
+	return refs
+}
+
+// resolveMemberships maps memberships to the Matrix users and rooms they should
+// be imported as. It has no side effects, so it is shared by the import and the
+// offline plan. targetNotMapped is the skip reason used for a missing room or space.
+func resolveMemberships(
+	memberships []membershipRef,
+	userMapping, roomMapping map[string]string,
+	targetNotMapped string,
+) (resolved []MembershipPair, skips []MembershipSkip) {
+	for idx, m := range memberships {
+		skip := MembershipSkip{Index: idx, UserID: m.UserID, TargetID: m.TargetID}
+
+		if m.Deleted {
+			skip.Reason = SkipReasonDeleted
+			skips = append(skips, skip)
+			continue
+		}
+
+		userID, userExists := userMapping[m.UserID]
+		if !userExists {
+			skip.Reason = SkipReasonUserNotMapped
+			skips = append(skips, skip)
+			continue
+		}
+
+		roomID, roomExists := roomMapping[m.TargetID]
+		if !roomExists {
+			skip.Reason = targetNotMapped
+			skips = append(skips, skip)
+			continue
+		}
+
+		resolved = append(resolved, MembershipPair{Index: idx, UserID: userID, RoomID: roomID, Admin: m.Admin})
+	}
+
+	return resolved, skips
+}
+
+// MembershipPlan summarizes how memberships would be imported, without importing them
+type MembershipPlan struct {
+	TeamMappable      int            `json:"team_mappable"`
+	TeamUnmappable    int            `json:"team_unmappable"`
+	ChannelMappable   int            `json:"channel_mappable"`
+	ChannelUnmappable int            `json:"channel_unmappable"`
+	SkipReasons       map[string]int `json:"skip_reasons"` // Skip reason -> count
+}
+
+// PlanMemberships resolves memberships against the asset mappings and counts
+// how many can be imported and why the others would be skipped. It makes no API calls.
+func PlanMemberships(
+	memberships *mattermost.Memberships,
+	userMapping, spaceMapping, roomMapping map[string]string,
+) *MembershipPlan {
+	plan := &MembershipPlan{SkipReasons: make(map[string]int)}
+
+	resolved, skips := resolveMemberships(teamMembershipRefs(memberships.TeamMembers), userMapping, spaceMapping, SkipReasonTeamNotMapped)
+	plan.TeamMappable = len(resolved)
+	plan.TeamUnmappable = len(skips)
+	for _, skip := range skips {
+		plan.SkipReasons[skip.Reason]++
+	}
+
+	resolved, skips = resolveMemberships(channelMembershipRefs(memberships.ChannelMembers), userMapping, roomMapping, SkipReasonChannelNotMapped)
+	plan.ChannelMappable = len(resolved)
+	plan.ChannelUnmappable = len(skips)
+	for _, skip := range skips {
+		plan.SkipReasons[skip.Reason]++
+	}
+
+	return plan
+}
+
 // ApplyTeamMemberships invites users to spaces based on team memberships
 func (i *Importer) ApplyTeamMemberships(
+	ctx context.Context,
 	memberships []mattermost.TeamMember,
 	userMapping map[string]string,
 	spaceMapping map[string]string,
 	progress ImportProgressCallback,
+) (*ImportStats, error) {
+	logger.Info("Starting team membership import: %d memberships to process", len(memberships))
+
+	resolved, skips := resolveMemberships(teamMembershipRefs(memberships), userMapping, spaceMapping, SkipReasonTeamNotMapped)
+	stats, err := i.applyMemberships(ctx, "team_memberships", "space", len(memberships), resolved, skips, progress)
+	if err != nil {
+		return stats, err
+	}
+
+	logger.Info("Team membership import completed: added=%d, skipped=%d, failed=%d, promoted=%d", 
+		stats.MembersAdded, stats.MembersSkipped, stats.MembersFailed, stats.MembersPromoted)
+
+	return stats, nil
+}
+
+// ApplyChannelMemberships invites users to rooms based on channel memberships
+func (i *Importer) ApplyChannelMemberships(
+	ctx context.Context,
+	memberships []mattermost.ChannelMember,
+	userMapping map[string]string,
+	roomMapping map[string]string,
+	progress ImportProgressCallback,
+) (*ImportStats, error) {
+	logger.Info("Starting channel membership import: %d memberships to process", len(memberships))
+
+	resolved, skips := resolveMemberships(channelMembershipRefs(memberships), userMapping, roomMapping, SkipReasonChannelNotMapped)
+	stats, err := i.applyMemberships(ctx, "channel_memberships", "room", len(memberships), resolved, skips, progress)
+	if err != nil {
+		return stats, err
+	}
+
+	logger.Info("Channel membership import completed: added=%d, skipped=%d, failed=%d, promoted=%d", 
+		stats.MembersAdded, stats.MembersSkipped, stats.MembersFailed, stats.MembersPromoted)
+
+	return stats, nil
+}
+
+// applyMemberships logs the skipped memberships and invites the resolved ones,
+// promoting admins. It stops with the context's error once ctx is cancelled.
+func (i *Importer) applyMemberships(
+	ctx context.Context,
+	stage, kind string,
+	total int,
+	resolved []MembershipPair,
+	skips []MembershipSkip,
+	progress ImportProgressCallback,
 ) (*ImportStats, error) {
 	stats := &ImportStats{}
-	total := len(memberships)
 
-	logger.Info("Starting team membership import: %d memberships to process", total)
+	for _, skip := range skips {
+		logger.Warn("Membership %d/%d skipped: %s (user %s, target %s)", skip.Index+1, total, skip.Reason, skip.UserID, skip.TargetID)
+		stats.MembersSkipped++
+	}
 
-	for idx, membership := range memberships {
+	for idx, pair := range resolved {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
 		if progress != nil {
-			progress("team_memberships", idx+1, total, "")
+			progress(stage, len(skips)+idx+1, total, "")
 		}
 
-		// Skip deleted memberships
-		if membership.IsDeleted() {
-			logger.Info("Team membership %d/%d: deleted, skipping", idx+1, total)
-			stats.MembersSkipped++
+		if i.options.DryRun {
+			logger.Info("[dry run] Membership %d/%d: would invite %s to %s %s", pair.Index+1, total, pair.UserID, kind, pair.RoomID)
+			stats.MembersAdded++
+			if i.promotes(pair) {
+				logger.Info("[dry run] Membership %d/%d: would give %s power level %d", pair.Index+1, total, pair.UserID, i.options.AdminPowerLevel)
+				stats.MembersPromoted++
+			}
 			continue
 		}
 
-		// Get Matrix IDs
-		userID, userExists := userMapping[membership.UserID]
-		spaceID, spaceExists := spaceMapping[membership.TeamID]
+		logger.Info("Membership %d/%d: inviting %s to %s %s", pair.Index+1, total, pair.UserID, kind, pair.RoomID)
 
-		if !userExists || !spaceExists {
-			if !userExists {
-				logger.Warn("Team membership %d/%d skipped: user %s not in mapping", idx+1, total, membership.UserID)
+		if err := i.client.InviteUser(ctx, pair.RoomID, pair.UserID); err != nil {
+			if errors.Is(err, ErrAlreadyInRoom) {
+				logger.Info("Membership %d/%d skipped: %s is already in %s %s", pair.Index+1, total, pair.UserID, kind, pair.RoomID)
+				stats.MembersSkipped++
+				// Admins invited by an earlier run may not have been promoted yet
+				i.promoteAdmin(ctx, pair, total, stats)
+				continue
 			}
-			if !spaceExists {
-				logger.Warn("Team membership %d/%d skipped: team %s not in mapping", idx+1, total, membership.TeamID)
+			if apiErr, ok := AsAPIError(err); ok && apiErr.Errcode == "M_FORBIDDEN" && i.options.InviteForbiddenPolicy == InviteForbiddenSkip {
+				logger.Warn("Membership %d/%d skipped: %s -> %s: %v", pair.Index+1, total, pair.UserID, pair.RoomID, err)
+				stats.MembersSkipped++
+				continue
 			}
-			stats.MembersSkipped++
-			continue
-		}
-
-		logger.Info("Team membership %d/%d: inviting %s to space %s", idx+1, total, userID, spaceID)
-
-		// Invite user to space
-		if err := i.client.InviteUser(spaceID, userID); err != nil {
-			logger.Error("Team membership %d/%d failed: %s -> %s: %v", idx+1, total, userID, spaceID, err)
+			logger.Error("Membership %d/%d failed: %s -> %s: %v", pair.Index+1, total, pair.UserID, pair.RoomID, err)
 			stats.MembersFailed++
 			continue
 		}
 
-		logger.Success("Team membership %d/%d: %s added to space", idx+1, total, userID)
+		logger.Success("Membership %d/%d: %s added to %s", pair.Index+1, total, pair.UserID, kind)
 		stats.MembersAdded++
+		i.promoteAdmin(ctx, pair, total, stats)
 	}
 
-	logger.Info("Team membership import completed: added=%d, skipped=%d, failed=%d", 
-		stats.MembersAdded, stats.MembersSkipped, stats.MembersFailed)
-
 	return stats, nil
 }
 
-// ApplyChannelMemberships invites users to rooms based on channel memberships
-func (i *Importer) ApplyChannelMemberships(
+// RemoveTeamMemberships removes users from the spaces of teams they left
+func (i *Importer) RemoveTeamMemberships(
+	ctx context.Context,
+	memberships []mattermost.TeamMember,
+	userMapping map[string]string,
+	spaceMapping map[string]string,
+	progress ImportProgressCallback,
+) (*ImportStats, error) {
+	logger.Info("Starting team membership removal: %d memberships to process", len(memberships))
+
+	resolved, skips := resolveMemberships(teamMembershipRefs(memberships), userMapping, spaceMapping, SkipReasonTeamNotMapped)
+	return i.removeMemberships(ctx, "team_removals", "space", len(memberships), resolved, skips, progress)
+}
+
+// RemoveChannelMemberships removes users from the rooms of channels they left
+func (i *Importer) RemoveChannelMemberships(
+	ctx context.Context,
 	memberships []mattermost.ChannelMember,
 	userMapping map[string]string,
 	roomMapping map[string]string,
 	progress ImportProgressCallback,
+) (*ImportStats, error) {
+	logger.Info("Starting channel membership removal: %d memberships to process", len(memberships))
+
+	resolved, skips := resolveMemberships(channelMembershipRefs(memberships), userMapping, roomMapping, SkipReasonChannelNotMapped)
+	return i.removeMemberships(ctx, "channel_removals", "room", len(memberships), resolved, skips, progress)
+}
+
+// removeMemberships kicks the users of the resolved memberships from their
+// rooms. Users who already left are counted as skipped. It stops with the
+// context's error once ctx is cancelled.
+func (i *Importer) removeMemberships(
+	ctx context.Context,
+	stage, kind string,
+	total int,
+	resolved []MembershipPair,
+	skips []MembershipSkip,
+	progress ImportProgressCallback,
 ) (*ImportStats, error) {
 	stats := &ImportStats{}
-	total := len(memberships)
 
-	logger.Info("Starting channel membership import: %d memberships to process", total)
+	for _, skip := range skips {
+		logger.Warn("Removal %d/%d skipped: %s (user %s, target %s)", skip.Index+1, total, skip.Reason, skip.UserID, skip.TargetID)
+		stats.MembersSkipped++
+	}
 
-	for idx, membership := range memberships {
+	for idx, pair := range resolved {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
 		if progress != nil {
-			progress("channel_memberships", idx+1, total, "")
+			progress(stage, len(skips)+idx+1, total, "")
 		}
 
-		// Get Matrix IDs
-		userID, userExists := userMapping[membership.UserID]
-		roomID, roomExists := roomMapping[membership.ChannelID]
-
-		if !userExists || !roomExists {
-			if !userExists {
-				logger.Warn("Channel membership %d/%d skipped: user %s not in mapping", idx+1, total, membership.UserID)
-			}
-			if !roomExists {
-				logger.Warn("Channel membership %d/%d skipped: channel %s not in mapping", idx+1, total, membership.ChannelID)
-			}
-			stats.MembersSkipped++
+		if i.options.DryRun {
+			logger.Info("[dry run] Removal %d/%d: would remove %s from %s %s", pair.Index+1, total, pair.UserID, kind, pair.RoomID)
+			stats.MembersRemoved++
 			continue
 		}
 
-		logger.Info("Channel membership %d/%d: inviting %s to room %s", idx+1, total, userID, roomID)
-
-		// Invite user to room
-		if err := i.client.InviteUser(roomID, userID); err != nil {
-			logger.Error("Channel membership %d/%d failed: %s -> %s: %v", idx+1, total, userID, roomID, err)
+		err := i.client.KickUser(ctx, pair.RoomID, pair.UserID, "Left in Mattermost")
+		if apiErr, ok := AsAPIError(err); ok && apiErr.StatusCode == http.StatusForbidden && i.client.HasASToken() {
+			// The admin user can't kick here (no power or not a member);
+			// have the user leave on their own instead
+			err = i.client.ForceLeave(ctx, pair.RoomID, pair.UserID, "Left in Mattermost")
+		}
+		if err != nil {
+			if errors.Is(err, ErrNotInRoom) {
+				logger.Info("Removal %d/%d skipped: %s is not in %s %s", pair.Index+1, total, pair.UserID, kind, pair.RoomID)
+				stats.MembersSkipped++
+				continue
+			}
+			logger.Error("Removal %d/%d failed: %s -> %s: %v", pair.Index+1, total, pair.UserID, pair.RoomID, err)
 			stats.MembersFailed++
 			continue
 		}
 
-		logger.Success("Channel membership %d/%d: %s added to room", idx+1, total, userID)
-		stats.MembersAdded++
+		logger.Success("Removal %d/%d: %s removed from %s", pair.Index+1, total, pair.UserID, kind)
+		stats.MembersRemoved++
 	}
 
-	logger.Info("Channel membership import completed: added=%d, skipped=%d, failed=%d", 
-		stats.MembersAdded, stats.MembersSkipped, stats.MembersFailed)
-
 	return stats, nil
 }
 
-// LinkRoomsToSpaces links rooms to their parent spaces based on channel-team relationships
+// promotes returns true if the membership's user should get the admin power level
+func (i *Importer) promotes(pair MembershipPair) bool {
+	return pair.Admin && i.options.AdminPowerLevel > 0
+}
+
+// promoteAdmin gives an admin the admin power level in the room. The power
+// levels apply to invited users too, so it doesn't wait for them to join.
+// A failure is logged and leaves the membership in place.
+func (i *Importer) promoteAdmin(ctx context.Context, pair MembershipPair, total int, stats *ImportStats) {
+	if !i.promotes(pair) {
+		return
+	}
+	changed, err := i.client.SetPowerLevel(ctx, pair.RoomID, pair.UserID, i.options.AdminPowerLevel)
+	if err != nil {
+		logger.Warn("Membership %d/%d: could not give %s power level %d in %s: %v", pair.Index+1, total, pair.UserID, i.options.AdminPowerLevel, pair.RoomID, err)
+		return
+	}
+	if !changed {
+		logger.Info("Membership %d/%d: %s already has power level %d or higher", pair.Index+1, total, pair.UserID, i.options.AdminPowerLevel)
+		return
+	}
+	logger.Info("Membership %d/%d: %s has power level %d", pair.Index+1, total, pair.UserID, i.options.AdminPowerLevel)
+	stats.MembersPromoted++
+}
+
+// LinkRoomsToSpaces links rooms to their parent spaces based on channel-team relationships.
+// The children of each space are read once, and rooms already linked are left alone.
 func (i *Importer) LinkRoomsToSpaces(
+	ctx context.Context,
 	channels []mattermost.Channel,
 	spaceMapping map[string]string,
 	roomMapping map[string]string,
@@ -341,8 +1687,30 @@ func (i *Importer) LinkRoomsToSpaces(
 ) (*ImportStats, error) {
 	stats := &ImportStats{}
 	total := len(channels)
+	orders := childOrders(channels, i.options.ChildOrder)
+
+	// Space ID -> rooms already linked to it; nil if they couldn't be read
+	spaceChildren := make(map[string]map[string]bool)
+	childrenOf := func(spaceID string) map[string]bool {
+		if children, ok := spaceChildren[spaceID]; ok {
+			return children
+		}
+		var children map[string]bool
+		if !strings.HasPrefix(spaceID, dryRunRoomPrefix) {
+			var err error
+			children, err = i.client.GetSpaceChildren(ctx, spaceID)
+			if err != nil {
+				logger.Warn("Could not read the children of space %s, linking all its rooms: %v", spaceID, err)
+			}
+		}
+		spaceChildren[spaceID] = children
+		return children
+	}
 
 	for idx, channel := range channels {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
 		if progress != nil {
 			progress("linking", idx+1, total, channel.DisplayName)
 		}
@@ -356,19 +1724,43 @@ func (i *Importer) LinkRoomsToSpaces(
 		spaceID, spaceExists := spaceMapping[channel.TeamID]
 		roomID, roomExists := roomMapping[channel.ID]
 
-		if !spaceExists || !roomExists {
+		if !roomExists {
+			continue
+		}
+		if !spaceExists {
+			if i.options.OrphanChannelPolicy != OrphanPolicyUncategorized {
+				continue
+			}
+			var err error
+			spaceID, err = i.getUncategorizedSpace(ctx)
+			if err != nil {
+				logger.Error("Failed to link room '%s' to the Uncategorized space: %v", channel.DisplayName, err)
+				stats.RoomsLinkFailed++
+				continue
+			}
+		}
+
+		if childrenOf(spaceID)[roomID] {
+			logger.Info("Room '%s' is already linked to its space, skipped", channel.DisplayName)
+			stats.RoomsAlreadyLinked++
+			continue
+		}
+
+		if i.options.DryRun {
+			logger.Info("[dry run] Would link room '%s' to space %s", channel.DisplayName, spaceID)
+			stats.RoomsLinked++
 			continue
 		}
 
 		// Add room as child of space
-		if err := i.client.AddRoomToSpace(spaceID, roomID, true); err != nil {
+		if err := i.client.AddRoomToSpace(ctx, spaceID, roomID, true, orders[channel.ID]); err != nil {
 			logger.Error("Failed to link room '%s' to space: %v", channel.DisplayName, err)
 			stats.RoomsLinkFailed++
 			continue
 		}
 
 		// Set space as parent of room
-		if err := i.client.SetRoomParent(roomID, spaceID, true); err != nil {
+		if err := i.client.SetRoomParent(ctx, roomID, spaceID, true); err != nil {
 			// Non-critical error, room is still linked as child
 			logger.Warn("Failed to set parent for room '%s': %v", channel.DisplayName, err)
 		}
@@ -380,6 +1772,31 @@ func (i *Importer) LinkRoomsToSpaces(
 	return stats, nil
 }
 
+// getUncategorizedSpace returns the space orphan rooms are linked to, creating it if needed.
+// The space has a fixed alias, so re-runs reuse the space created earlier.
+func (i *Importer) getUncategorizedSpace(ctx context.Context) (string, error) {
+	if i.uncategorizedSpaceID != "" {
+		return i.uncategorizedSpaceID, nil
+	}
+
+	resp, err := i.createRoom(ctx, RoomOptions{
+		Name:      "Uncategorized",
+		Topic:     "Channels whose Mattermost team was not imported",
+		AliasName: uncategorizedSpaceAlias,
+	}, true)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Existing && i.options.DryRun {
+		logger.Info("[dry run] Would create space 'Uncategorized'")
+	} else if !resp.Existing {
+		logger.Success("Created space 'Uncategorized' -> %s", resp.RoomID)
+	}
+
+	i.uncategorizedSpaceID = resp.RoomID
+	return resp.RoomID, nil
+}
+
 // ImportAssetsResult holds the result of importing assets
 type ImportAssetsResult struct {
 	UserMapping  map[string]string
@@ -393,11 +1810,37 @@ type ExistingMappings struct {
 	Users    map[string]string
 	Spaces   map[string]string
 	Rooms    map[string]string
+	RoomAliases map[string]string // Channel ID -> name alias of its room
+}
+
+// ImportAssetsOptions selects which asset types are imported
+type ImportAssetsOptions struct {
+	Users  bool
+	Spaces bool
+	Rooms  bool
+
+	// LinkExistingUsers maps users that already exist on the homeserver
+	// instead of creating them when Users is false, e.g. when accounts
+	// are provisioned by another process
+	LinkExistingUsers bool
+}
+
+// DefaultImportAssetsOptions returns options that import every asset type
+func DefaultImportAssetsOptions() ImportAssetsOptions {
+	return ImportAssetsOptions{Users: true, Spaces: true, Rooms: true}
 }
 
 // ImportAssets imports all assets (users, teams as spaces, channels as rooms)
 // If existingMappings is provided, already imported items will be skipped
-func (i *Importer) ImportAssets(assets *mattermost.Assets, existingMappings *ExistingMappings, progress ImportProgressCallback) (*ImportAssetsResult, error) {
+func (i *Importer) ImportAssets(ctx context.Context, assets *mattermost.Assets, existingMappings *ExistingMappings, progress ImportProgressCallback) (*ImportAssetsResult, error) {
+	return i.ImportAssetsWithOptions(ctx, assets, existingMappings, DefaultImportAssetsOptions(), progress)
+}
+
+// ImportAssetsWithOptions imports the asset types selected in opts.
+// Asset types that are not selected keep their existing mapping entries,
+// so the resulting mapping stays complete across phased imports. On error
+// the result holds the mappings of what was imported before the failure.
+func (i *Importer) ImportAssetsWithOptions(ctx context.Context, assets *mattermost.Assets, existingMappings *ExistingMappings, opts ImportAssetsOptions, progress ImportProgressCallback) (*ImportAssetsResult, error) {
 	result := &ImportAssetsResult{
 		Stats: &ImportStats{},
 	}
@@ -405,6 +1848,7 @@ func (i *Importer) ImportAssets(assets *mattermost.Assets, existingMappings *Exi
 	logger.Info("=== ImportAssets Started ===")
 	logger.Info("Assets to import: %d users, %d teams, %d channels", 
 		len(assets.Users), len(assets.Teams), len(assets.Channels))
+	logger.Info("Selected asset types: users=%t, spaces=%t, rooms=%t", opts.Users, opts.Spaces, opts.Rooms)
 
 	// Initialize empty mappings if not provided
 	if existingMappings == nil {
@@ -419,43 +1863,108 @@ func (i *Importer) ImportAssets(assets *mattermost.Assets, existingMappings *Exi
 			len(existingMappings.Users), len(existingMappings.Spaces), len(existingMappings.Rooms))
 	}
 
+	// Keep the aliases of earlier runs, also when rooms aren't imported now
+	i.roomAliases = make(map[string]string, len(existingMappings.RoomAliases))
+	for k, v := range existingMappings.RoomAliases {
+		i.roomAliases[k] = v
+	}
+
 	// Import users
-	logger.Info("=== Starting User Import ===")
-	userMapping, userStats, err := i.ImportUsers(assets.Users, existingMappings.Users, progress)
-	if err != nil {
-		logger.Error("User import failed: %v", err)
-		return nil, fmt.Errorf("failed to import users: %w", err)
+	if err := i.checkConnection(); err != nil {
+		return result, err
+	}
+	if opts.Users {
+		logger.Info("=== Starting User Import ===")
+		userMapping, userStats, err := i.ImportUsers(ctx, assets.Users, existingMappings.Users, progress)
+		result.UserMapping = userMapping
+		if err != nil {
+			logger.Error("User import failed: %v", err)
+			return result, fmt.Errorf("failed to import users: %w", err)
+		}
+		result.Stats.UsersCreated = userStats.UsersCreated
+		result.Stats.UsersSkipped = userStats.UsersSkipped
+		result.Stats.UsersFailed = userStats.UsersFailed
+		result.Stats.UsersInvalid = userStats.UsersInvalid
+		result.Stats.UsersDuplicateEmail = userStats.UsersDuplicateEmail
+		result.Stats.UsersDeactivated = userStats.UsersDeactivated
+		logger.Info("User import completed: created=%d, skipped=%d, failed=%d, invalid=%d",
+			userStats.UsersCreated, userStats.UsersSkipped, userStats.UsersFailed, userStats.UsersInvalid)
+	} else if opts.LinkExistingUsers {
+		logger.Info("=== Linking Existing Users ===")
+		userMapping, linked, err := i.LinkExistingUsers(ctx, assets.Users, existingMappings.Users)
+		if err != nil {
+			return nil, fmt.Errorf("failed to link users: %w", err)
+		}
+		result.UserMapping = userMapping
+		result.Stats.UsersSkipped = linked
+		logger.Info("User linking completed: %d existing users mapped", linked)
+	} else {
+		logger.Info("Skipping user import")
+		result.UserMapping = copyMapping(existingMappings.Users)
 	}
-	result.UserMapping = userMapping
-	result.Stats.UsersCreated = userStats.UsersCreated
-	result.Stats.UsersSkipped = userStats.UsersSkipped
-	result.Stats.UsersFailed = userStats.UsersFailed
-	logger.Info("User import completed: created=%d, skipped=%d, failed=%d",
-		userStats.UsersCreated, userStats.UsersSkipped, userStats.UsersFailed)
 
 	// Import teams as spaces
-	spaceMapping, spaceStats, err := i.ImportTeamsAsSpaces(assets.Teams, existingMappings.Spaces, progress)
-	if err != nil {
-		return nil, fmt.Errorf("failed to import teams: %w", err)
+	if err := i.checkConnection(); err != nil {
+		return result, err
+	}
+	if opts.Spaces {
+		spaceMapping, spaceStats, err := i.ImportTeamsAsSpaces(ctx, assets.Teams, existingMappings.Spaces, progress)
+		result.SpaceMapping = spaceMapping
+		if err != nil {
+			return result, fmt.Errorf("failed to import teams: %w", err)
+		}
+		result.Stats.SpacesCreated = spaceStats.SpacesCreated
+		result.Stats.SpacesSkipped = spaceStats.SpacesSkipped
+		result.Stats.SpacesFailed = spaceStats.SpacesFailed
+	} else {
+		logger.Info("Skipping space import")
+		result.SpaceMapping = copyMapping(existingMappings.Spaces)
 	}
-	result.SpaceMapping = spaceMapping
-	result.Stats.SpacesCreated = spaceStats.SpacesCreated
-	result.Stats.SpacesSkipped = spaceStats.SpacesSkipped
-	result.Stats.SpacesFailed = spaceStats.SpacesFailed
 
 	// Import channels as rooms
-	roomMapping, roomStats, err := i.ImportChannelsAsRooms(assets.Channels, existingMappings.Rooms, progress)
-	if err != nil {
-		return nil, fmt.Errorf("failed to import channels: %w", err)
+	if err := i.checkConnection(); err != nil {
+		return result, err
+	}
+	if opts.Rooms {
+		roomMapping, roomStats, err := i.ImportChannelsAsRooms(ctx, assets.Channels, existingMappings.Rooms, NewRoomImportContext(assets, result.SpaceMapping, result.UserMapping), progress)
+		result.RoomMapping = roomMapping
+		if err != nil {
+			return result, fmt.Errorf("failed to import channels: %w", err)
+		}
+		result.Stats.RoomsCreated = roomStats.RoomsCreated
+		result.Stats.RoomsSkipped = roomStats.RoomsSkipped
+		result.Stats.RoomsFailed = roomStats.RoomsFailed
+		result.Stats.RoomsSkippedEmpty = roomStats.RoomsSkippedEmpty
+		result.Stats.RoomsUpdated = roomStats.RoomsUpdated
+		result.Stats.DirectRoomsCreated = roomStats.DirectRoomsCreated
+	} else {
+		logger.Info("Skipping room import")
+		result.RoomMapping = copyMapping(existingMappings.Rooms)
 	}
-	result.RoomMapping = roomMapping
-	result.Stats.RoomsCreated = roomStats.RoomsCreated
-	result.Stats.RoomsSkipped = roomStats.RoomsSkipped
-	result.Stats.RoomsFailed = roomStats.RoomsFailed
 
 	return result, nil
 }
 
+// connectionCheckInterval is how many messages are imported between connection checks
+const connectionCheckInterval = 500
+
+// checkConnection runs the CheckConnection option, if set
+func (i *Importer) checkConnection() error {
+	if i.options.CheckConnection == nil {
+		return nil
+	}
+	return i.options.CheckConnection()
+}
+
+// copyMapping returns a copy of m that is safe to modify (never nil)
+func copyMapping(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
 // MessageImportStats holds statistics about message import
 type MessageImportStats struct {
 	MessagesImported int `json:"messages_imported"`
@@ -466,6 +1975,12 @@ type MessageImportStats struct {
 	FilesLinked      int `json:"files_linked"`    // Files added as links
 	FilesUploaded    int `json:"files_uploaded"`  // Files uploaded to Matrix
 	FilesSkipped     int `json:"files_skipped"`   // Files skipped
+	FilesFailed      int `json:"files_failed"`    // Files whose upload or send failed
+	MessagesOversize int `json:"messages_oversize"` // Split, truncated or skipped for exceeding the size limit
+	ChannelsCompleted int `json:"channels_completed"` // Channels skipped because an earlier run imported all their posts
+	MessagesEncrypted int `json:"messages_encrypted"` // Skipped because their room is end-to-end encrypted
+	RoomsEncrypted    int `json:"rooms_encrypted"`    // Encrypted rooms whose history was skipped
+	PinnedEvents      int `json:"pinned_events"`      // Pinned posts whose event was pinned in the room
 }
 
 // FileConfig holds file migration settings
@@ -473,8 +1988,14 @@ type FileConfig struct {
 	Mode         string // "link", "upload", or "skip"
 	S3PublicURL  string // Base URL for S3 files
 	MaxUploadSize int64 // Max file size for upload
+	Fetch        FileFetcher // Reads file contents in "upload" mode
 }
 
+// FileFetcher returns the contents of a Mattermost file attachment. An
+// error wrapping mattermost.ErrFileNotFound skips the file; other errors
+// fail its post, to be retried by the next run.
+type FileFetcher func(file *mattermost.FileInfo) ([]byte, error)
+
 // MessageImportCallback is called for each message imported
 type MessageImportCallback func(current, total int, channelName string, status string)
 
@@ -488,6 +2009,7 @@ type ImportMessagesResult struct {
 // ImportMessages imports messages from Mattermost posts to Matrix rooms
 // This requires Application Service token for timestamp support
 func (i *Importer) ImportMessages(
+	ctx context.Context,
 	posts []mattermost.Post,
 	channelToRoom map[string]string,      // Mattermost channel ID -> Matrix room ID
 	userMapping map[string]string,         // Mattermost user ID -> Matrix user ID
@@ -517,6 +2039,10 @@ func (i *Importer) ImportMessages(
 	
 	// Process messages in order
 	for idx, post := range posts {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
 		// Check if already imported
 		if _, exists := existingMapping[post.ID]; exists {
 			result.Stats.MessagesSkipped++
@@ -557,7 +2083,7 @@ func (i *Importer) ImportMessages(
 				result.Errors = append(result.Errors, fmt.Sprintf("Parent post %s not found for reply %s", post.RootID, post.ID))
 				
 				// Import as regular message instead of failing
-				resp, sendErr := i.client.SendMessageWithTimestamp(roomID, post.Message, post.CreateAt, senderID)
+				resp, sendErr := i.client.SendMessageWithTimestamp(ctx, roomID, post.Message, post.CreateAt, senderID)
 				if sendErr != nil {
 					result.Stats.MessagesFailed++
 					result.Errors = append(result.Errors, fmt.Sprintf("Failed to send message %s: %v", post.ID, sendErr))
@@ -569,7 +2095,7 @@ func (i *Importer) ImportMessages(
 				eventID = resp.EventID
 			} else {
 				// Send as reply
-				resp, sendErr := i.client.SendReplyWithTimestamp(roomID, post.Message, parentEventID, post.CreateAt, senderID)
+				resp, sendErr := i.client.SendReplyWithTimestamp(ctx, roomID, post.Message, parentEventID, post.CreateAt, senderID)
 				if sendErr != nil {
 					result.Stats.RepliesFailed++
 					result.Errors = append(result.Errors, fmt.Sprintf("Failed to send reply %s: %v", post.ID, sendErr))
@@ -583,7 +2109,7 @@ func (i *Importer) ImportMessages(
 			}
 		} else {
 			// Regular message
-			resp, sendErr := i.client.SendMessageWithTimestamp(roomID, post.Message, post.CreateAt, senderID)
+			resp, sendErr := i.client.SendMessageWithTimestamp(ctx, roomID, post.Message, post.CreateAt, senderID)
 			if sendErr != nil {
 				result.Stats.MessagesFailed++
 				result.Errors = append(result.Errors, fmt.Sprintf("Failed to send message %s: %v", post.ID, sendErr))
@@ -616,9 +2142,47 @@ func (i *Importer) ImportMessages(
 	return result, nil
 }
 
+// MessageStore keeps track of imported messages so that re-runs skip them
+// and replies can find their parent events. Implementations may hold the
+// mapping in memory or on disk.
+type MessageStore interface {
+	// LookupEvent returns the Matrix event ID of an already imported post
+	LookupEvent(mattermostID string) (string, bool)
+	// RecordMessage stores the Matrix event ID of a newly imported post
+	RecordMessage(post *mattermost.Post, roomID, matrixUserID, eventID string) error
+}
+
+// ChannelCompletionStore is a MessageStore that also remembers which
+// channels were fully imported. Posts of a completed channel are skipped
+// without looking them up, which makes re-runs over large, finished
+// channels cheap.
+type ChannelCompletionStore interface {
+	MessageStore
+	// ChannelCompletedThrough returns the creation time of the newest post
+	// of a channel whose posts were all imported
+	ChannelCompletedThrough(channelID string) (int64, bool)
+	// MarkChannelCompleted records that every post of a channel created up
+	// to through was imported
+	MarkChannelCompleted(channelID string, through int64) error
+}
+
+// mapMessageStore is a MessageStore backed by a plain map
+type mapMessageStore map[string]string
+
+func (m mapMessageStore) LookupEvent(mattermostID string) (string, bool) {
+	eventID, ok := m[mattermostID]
+	return eventID, ok
+}
+
+func (m mapMessageStore) RecordMessage(post *mattermost.Post, roomID, matrixUserID, eventID string) error {
+	m[post.ID] = eventID
+	return nil
+}
+
 // ImportMessagesWithFiles imports messages with file attachments
 // filesByPost maps post ID to list of file infos
 func (i *Importer) ImportMessagesWithFiles(
+	ctx context.Context,
 	posts []mattermost.Post,
 	channelToRoom map[string]string,
 	userMapping map[string]string,
@@ -626,11 +2190,90 @@ func (i *Importer) ImportMessagesWithFiles(
 	filesByPost map[string][]mattermost.FileInfo,
 	fileConfig *FileConfig,
 	progress MessageImportCallback,
+) (*ImportMessagesResult, error) {
+	store := make(mapMessageStore, len(existingMapping))
+	for k, v := range existingMapping {
+		store[k] = v
+	}
+
+	result, err := i.ImportMessagesToStore(ctx, posts, channelToRoom, userMapping, store, filesByPost, fileConfig, progress)
+	if result != nil {
+		result.Mapping = store
+	}
+	return result, err
+}
+
+// uploadAttachment uploads the contents of a Mattermost file to the media
+// repository and returns the content of the file event showing it
+func (i *Importer) uploadAttachment(ctx context.Context, file *mattermost.FileInfo, data []byte) (*FileMessageContent, error) {
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	mxcURI, err := i.client.UploadMedia(ctx, bytes.NewReader(data), file.Name, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file %s: %w", file.Name, err)
+	}
+
+	content := &FileMessageContent{
+		MsgType:  file.GetMatrixMsgType(),
+		Body:     file.Name,
+		URL:      mxcURI,
+		Filename: file.Name,
+		Info: &FileInfo{
+			MimeType: mimeType,
+			Size:     int64(len(data)),
+		},
+	}
+	if file.Width > 0 && file.Height > 0 {
+		content.Info.Width = file.Width
+		content.Info.Height = file.Height
+	}
+	return content, nil
+}
+
+// sendAttachment sends an uploaded file to the room as a file event,
+// returning its ID. relation places the file in a thread or reply (optional).
+func (i *Importer) sendAttachment(ctx context.Context, roomID, senderID string, timestamp int64, content *FileMessageContent, relation *RelatesTo) (string, error) {
+	content.RelatesTo = relation
+	resp, err := i.client.SendFileMessage(ctx, roomID, content, timestamp, senderID)
+	if err != nil {
+		return "", fmt.Errorf("failed to send file %s: %w", content.Filename, err)
+	}
+	return resp.EventID, nil
+}
+
+// sendText sends a message body into the thread of threadRoot when set,
+// otherwise as a reply to replyTo when set, otherwise as a plain message.
+// In a thread, replyTo is the reply fallback for clients without threads.
+func (i *Importer) sendText(ctx context.Context, roomID, body, threadRoot, replyTo string, timestamp int64, senderID string) (*SendMessageResponse, error) {
+	switch {
+	case threadRoot != "":
+		return i.client.SendThreadMessageWithTimestamp(ctx, roomID, body, threadRoot, replyTo, timestamp, senderID)
+	case replyTo != "":
+		return i.client.SendReplyWithTimestamp(ctx, roomID, body, replyTo, timestamp, senderID)
+	default:
+		return i.client.SendMessageWithTimestamp(ctx, roomID, body, timestamp, senderID)
+	}
+}
+
+// ImportMessagesToStore imports messages with file attachments, using store
+// both to skip already imported posts and to record new ones.
+// The returned result has no Mapping; the store holds it instead.
+func (i *Importer) ImportMessagesToStore(
+	ctx context.Context,
+	posts []mattermost.Post,
+	channelToRoom map[string]string,
+	userMapping map[string]string,
+	store MessageStore,
+	filesByPost map[string][]mattermost.FileInfo,
+	fileConfig *FileConfig,
+	progress MessageImportCallback,
 ) (*ImportMessagesResult, error) {
 	result := &ImportMessagesResult{
-		Stats:   &MessageImportStats{},
-		Mapping: make(map[string]string),
-		Errors:  []string{},
+		Stats:  &MessageImportStats{},
+		Errors: []string{},
 	}
 	
 	if !i.client.HasASToken() {
@@ -645,15 +2288,73 @@ func (i *Importer) ImportMessagesWithFiles(
 		fileConfig = &FileConfig{Mode: "skip"}
 	}
 	
-	// Collect all existing mappings
-	for k, v := range existingMapping {
-		result.Mapping[k] = v
+	// With a completion-aware store, channels finished by an earlier run are
+	// skipped outright and channels finished by this one are marked
+	completion, trackChannels := store.(ChannelCompletionStore)
+	lastPost := make(map[string]int)         // channel ID -> index of its last post
+	newestPost := make(map[string]int64)     // channel ID -> creation time of its newest post
+	failedChannels := make(map[string]bool)  // channels with posts that were not imported
+	skippedChannels := make(map[string]bool) // channels completed by an earlier run
+	encryptedRooms := make(map[string]bool)  // room ID -> end-to-end encrypted
+	threadLatest := make(map[string]string)  // root post ID -> latest event imported into its thread
+	if trackChannels {
+		for idx, post := range posts {
+			lastPost[post.ChannelID] = idx
+			if post.CreateAt > newestPost[post.ChannelID] {
+				newestPost[post.ChannelID] = post.CreateAt
+			}
+		}
+	}
+	markCompleted := func(channelID string) error {
+		if failedChannels[channelID] {
+			return nil
+		}
+		if through, done := completion.ChannelCompletedThrough(channelID); done && through >= newestPost[channelID] {
+			return nil
+		}
+		if err := completion.MarkChannelCompleted(channelID, newestPost[channelID]); err != nil {
+			return fmt.Errorf("failed to mark channel %s as completed: %w", channelID, err)
+		}
+		return nil
 	}
 	
 	// Process messages in order
 	for idx, post := range posts {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if idx%connectionCheckInterval == 0 {
+			if err := i.checkConnection(); err != nil {
+				return result, err
+			}
+		}
+
+		// A channel is finished once the post after its last one is reached
+		if trackChannels && idx > 0 {
+			if prev := posts[idx-1].ChannelID; lastPost[prev] == idx-1 {
+				if err := markCompleted(prev); err != nil {
+					return result, err
+				}
+			}
+		}
+		
+		// Skip channels completed by an earlier run without looking up their posts
+		if trackChannels {
+			if through, done := completion.ChannelCompletedThrough(post.ChannelID); done && post.CreateAt <= through {
+				if !skippedChannels[post.ChannelID] {
+					skippedChannels[post.ChannelID] = true
+					result.Stats.ChannelsCompleted++
+				}
+				result.Stats.MessagesSkipped++
+				if progress != nil {
+					progress(idx+1, total, post.ChannelID, "skipped")
+				}
+				continue
+			}
+		}
+		
 		// Check if already imported
-		if _, exists := existingMapping[post.ID]; exists {
+		if _, exists := store.LookupEvent(post.ID); exists {
 			result.Stats.MessagesSkipped++
 			if progress != nil {
 				progress(idx+1, total, post.ChannelID, "skipped")
@@ -665,12 +2366,37 @@ func (i *Importer) ImportMessagesWithFiles(
 		roomID, roomExists := channelToRoom[post.ChannelID]
 		if !roomExists {
 			result.Stats.MessagesFailed++
+			failedChannels[post.ChannelID] = true
 			result.Errors = append(result.Errors, fmt.Sprintf("No room mapping for channel %s (post %s)", post.ChannelID, post.ID))
 			if progress != nil {
 				progress(idx+1, total, post.ChannelID, "failed:no_room")
 			}
 			continue
 		}
+
+		// Messages sent by the importer into an encrypted room would be
+		// unreadable to clients, so the room's history is skipped
+		encrypted, checked := encryptedRooms[roomID]
+		if !checked {
+			var err error
+			encrypted, err = i.client.RoomEncrypted(ctx, roomID)
+			if err != nil {
+				logger.Warn("Could not check whether room %s is encrypted, importing its messages: %v", roomID, err)
+			} else if encrypted {
+				logger.Warn("Room %s (channel %s) is end-to-end encrypted, skipping its messages", roomID, post.ChannelID)
+				result.Stats.RoomsEncrypted++
+			}
+			encryptedRooms[roomID] = encrypted
+		}
+		if encrypted {
+			result.Stats.MessagesEncrypted++
+			// Not completed: the history is still missing
+			failedChannels[post.ChannelID] = true
+			if progress != nil {
+				progress(idx+1, total, post.ChannelID, "skipped:encrypted")
+			}
+			continue
+		}
 		
 		// Get sender
 		senderID, userExists := userMapping[post.UserID]
@@ -683,6 +2409,16 @@ func (i *Importer) ImportMessagesWithFiles(
 		messageContent := post.Message
 		files := filesByPost[post.ID]
 		
+		// Integration posts often carry their content only in props attachments
+		if props, err := post.ParseProps(); err != nil {
+			logger.Warn("Post %s: %v", post.ID, err)
+		} else if text := props.AttachmentText(); text != "" {
+			if messageContent != "" {
+				messageContent += "\n\n"
+			}
+			messageContent += text
+		}
+		
 		// Append file links if mode is "link"
 		if fileConfig.Mode == "link" && len(files) > 0 && fileConfig.S3PublicURL != "" {
 			for _, file := range files {
@@ -692,53 +2428,218 @@ func (i *Importer) ImportMessagesWithFiles(
 			}
 		}
 		
-		// Handle reply
+		// In "upload" mode files within the size limit are uploaded after the
+		// text; larger ones are linked if a public URL is configured
+		var uploads []mattermost.FileInfo
+		if fileConfig.Mode == "upload" && fileConfig.Fetch != nil {
+			for _, file := range files {
+				switch {
+				case fileConfig.MaxUploadSize <= 0 || file.Size <= fileConfig.MaxUploadSize:
+					uploads = append(uploads, file)
+				case fileConfig.S3PublicURL != "":
+					fileURL := strings.TrimSuffix(fileConfig.S3PublicURL, "/") + "/" + file.Path
+					messageContent += fmt.Sprintf("\n\n📎 [%s](%s)", file.Name, fileURL)
+					result.Stats.FilesLinked++
+				default:
+					logger.Warn("File %s of post %s is %d bytes (limit %d), skipping", file.Name, post.ID, file.Size, fileConfig.MaxUploadSize)
+					result.Stats.FilesSkipped++
+				}
+			}
+		}
+		// A post with only attachments is represented by its first file event
+		filesOnly := messageContent == "" && len(uploads) > 0
+		
+		// Apply the oversize policy to bodies too large for one event
+		parts := []string{messageContent}
+		if size := jsonLen(messageContent); size > i.options.MaxMessageBytes {
+			result.Stats.MessagesOversize++
+			switch i.options.OversizePolicy {
+			case OversizePolicySkip:
+				result.Stats.MessagesSkipped++
+				result.Errors = append(result.Errors, fmt.Sprintf("Message %s is %d bytes (limit %d), skipped", post.ID, size, i.options.MaxMessageBytes))
+				if progress != nil {
+					progress(idx+1, total, post.ChannelID, "skipped:oversize")
+				}
+				continue
+			case OversizePolicyTruncate:
+				logger.Warn("Message %s is %d bytes (limit %d), truncating", post.ID, size, i.options.MaxMessageBytes)
+				parts = []string{truncateMessageBody(messageContent, i.options.MaxMessageBytes)}
+			default:
+				parts = splitMessageBody(messageContent, i.options.MaxMessageBytes)
+				logger.Warn("Message %s is %d bytes (limit %d), splitting into %d events", post.ID, size, i.options.MaxMessageBytes, len(parts))
+			}
+			messageContent = parts[0]
+		}
+		
+		// Upload the attachments before anything is sent: a post whose files
+		// can't be read or uploaded now fails whole and is retried by the next
+		// run. Files missing from the store are skipped for good.
+		var attachments []*FileMessageContent
+		var uploadErr error
+		for n := range uploads {
+			file := &uploads[n]
+			data, err := fileConfig.Fetch(file)
+			if errors.Is(err, mattermost.ErrFileNotFound) {
+				logger.Warn("Post %s: skipping file %s: %v", post.ID, file.Name, err)
+				result.Stats.FilesSkipped++
+				continue
+			}
+			if err != nil {
+				uploadErr = err
+				break
+			}
+			content, err := i.uploadAttachment(ctx, file, data)
+			if err != nil {
+				uploadErr = err
+				break
+			}
+			attachments = append(attachments, content)
+		}
+		if uploadErr != nil {
+			result.Stats.MessagesFailed++
+			result.Stats.FilesFailed++
+			failedChannels[post.ChannelID] = true
+			result.Errors = append(result.Errors, fmt.Sprintf("Post %s: %v", post.ID, uploadErr))
+			if progress != nil {
+				progress(idx+1, total, post.ChannelID, "failed:file_error")
+			}
+			continue
+		}
+		if filesOnly && len(attachments) == 0 {
+			// All files of an attachment-only post are missing; there is nothing to send
+			result.Stats.MessagesSkipped++
+			if progress != nil {
+				progress(idx+1, total, post.ChannelID, "skipped:files_missing")
+			}
+			continue
+		}
+		
+		// Replies go into the thread of their root post. Without a mapped
+		// root they reply to the thread's latest imported event instead.
 		var eventID string
+		var threadRoot string // Thread root event when sent into a thread
+		var replyTo string    // Event replied to; in a thread, the fallback for clients without threads
 		
 		if post.IsReply() {
-			parentEventID, parentExists := result.Mapping[post.RootID]
-			if !parentExists {
+			rootEventID, rootExists := store.LookupEvent(post.RootID)
+			latestEventID, hasLatest := threadLatest[post.RootID]
+			switch {
+			case rootExists:
+				threadRoot = rootEventID
+				replyTo = rootEventID
+				if hasLatest {
+					replyTo = latestEventID
+				}
+			case hasLatest:
+				replyTo = latestEventID
+			default:
 				result.Stats.RepliesFailed++
 				result.Errors = append(result.Errors, fmt.Sprintf("Parent post %s not found for reply %s", post.RootID, post.ID))
-				
-				resp, sendErr := i.client.SendMessageWithTimestamp(roomID, messageContent, post.CreateAt, senderID)
-				if sendErr != nil {
-					result.Stats.MessagesFailed++
-					result.Errors = append(result.Errors, fmt.Sprintf("Failed to send message %s: %v", post.ID, sendErr))
-					if progress != nil {
-						progress(idx+1, total, post.ChannelID, "failed:send_error")
-					}
-					continue
-				}
-				eventID = resp.EventID
-			} else {
-				resp, sendErr := i.client.SendReplyWithTimestamp(roomID, messageContent, parentEventID, post.CreateAt, senderID)
-				if sendErr != nil {
+			}
+		}
+		
+		if !filesOnly {
+			resp, sendErr := i.sendText(ctx, roomID, messageContent, threadRoot, replyTo, post.CreateAt, senderID)
+			if sendErr != nil {
+				failedChannels[post.ChannelID] = true
+				if replyTo != "" {
 					result.Stats.RepliesFailed++
 					result.Errors = append(result.Errors, fmt.Sprintf("Failed to send reply %s: %v", post.ID, sendErr))
 					if progress != nil {
 						progress(idx+1, total, post.ChannelID, "failed:reply_error")
 					}
-					continue
-				}
-				eventID = resp.EventID
-				result.Stats.RepliesImported++
-			}
-		} else {
-			resp, sendErr := i.client.SendMessageWithTimestamp(roomID, messageContent, post.CreateAt, senderID)
-			if sendErr != nil {
-				result.Stats.MessagesFailed++
-				result.Errors = append(result.Errors, fmt.Sprintf("Failed to send message %s: %v", post.ID, sendErr))
-				if progress != nil {
-					progress(idx+1, total, post.ChannelID, "failed:send_error")
+				} else {
+					result.Stats.MessagesFailed++
+					result.Errors = append(result.Errors, fmt.Sprintf("Failed to send message %s: %v", post.ID, sendErr))
+					if progress != nil {
+						progress(idx+1, total, post.ChannelID, "failed:send_error")
+					}
 				}
 				continue
 			}
 			eventID = resp.EventID
 		}
 		
+		// Send the rest of a split message right after the first part, in
+		// the same thread position; the mapping points at the first part
+		var partErr error
+		for _, part := range parts[1:] {
+			partReplyTo := replyTo
+			if threadRoot != "" {
+				partReplyTo = eventID
+			}
+			if _, partErr = i.sendText(ctx, roomID, part, threadRoot, partReplyTo, post.CreateAt, senderID); partErr != nil {
+				break
+			}
+		}
+		if partErr != nil {
+			// The post isn't recorded, so the next run sends it whole again
+			// rather than losing the rest of the body; the parts sent now
+			// stay in the room
+			result.Stats.MessagesFailed++
+			failedChannels[post.ChannelID] = true
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to send part of split message %s: %v", post.ID, partErr))
+			if progress != nil {
+				progress(idx+1, total, post.ChannelID, "failed:send_error")
+			}
+			continue
+		}
+		
+		// Send the uploaded attachments as file events, in the same thread or
+		// reply position as the text
+		var relation *RelatesTo
+		switch {
+		case threadRoot != "" && eventID != "":
+			relation = ThreadRelation(threadRoot, eventID)
+		case threadRoot != "":
+			relation = ThreadRelation(threadRoot, replyTo)
+		case replyTo != "":
+			relation = &RelatesTo{InReplyTo: &InReplyTo{EventID: replyTo}}
+		}
+		for _, content := range attachments {
+			fileEventID, err := i.sendAttachment(ctx, roomID, senderID, post.CreateAt, content, relation)
+			if err != nil {
+				// A post with text is recorded with the file missing; keep
+				// its channel from being marked completed over the gap
+				result.Stats.FilesFailed++
+				failedChannels[post.ChannelID] = true
+				result.Errors = append(result.Errors, fmt.Sprintf("Post %s: %v", post.ID, err))
+				continue
+			}
+			result.Stats.FilesUploaded++
+			if eventID == "" {
+				eventID = fileEventID
+			}
+		}
+		if eventID == "" {
+			// None of the files of an attachment-only post could be sent; retry it next run
+			result.Stats.MessagesFailed++
+			failedChannels[post.ChannelID] = true
+			if progress != nil {
+				progress(idx+1, total, post.ChannelID, "failed:file_error")
+			}
+			continue
+		}
+		
 		// Store mapping
-		result.Mapping[post.ID] = eventID
+		if err := store.RecordMessage(&posts[idx], roomID, senderID, eventID); err != nil {
+			return result, fmt.Errorf("failed to record message %s: %w", post.ID, err)
+		}
+		if post.IsPinned {
+			// A failed pin doesn't fail the message; it was sent and recorded
+			if err := i.client.PinEvent(ctx, roomID, eventID); err != nil {
+				logger.Warn("Post %s: failed to pin event %s in %s: %v", post.ID, eventID, roomID, err)
+				result.Errors = append(result.Errors, fmt.Sprintf("Failed to pin post %s: %v", post.ID, err))
+			} else {
+				result.Stats.PinnedEvents++
+			}
+		}
+		if post.IsReply() {
+			threadLatest[post.RootID] = eventID
+			if replyTo != "" {
+				result.Stats.RepliesImported++
+			}
+		}
 		result.Stats.MessagesImported++
 		
 		if progress != nil {
@@ -752,6 +2653,12 @@ func (i *Importer) ImportMessagesWithFiles(
 		}
 	}
 	
+	if trackChannels && total > 0 {
+		if err := markCompleted(posts[total-1].ChannelID); err != nil {
+			return result, err
+		}
+	}
+	
 	logger.Info("Message import completed: imported=%d, skipped=%d, failed=%d, replies=%d, files_linked=%d",
 		result.Stats.MessagesImported, result.Stats.MessagesSkipped, 
 		result.Stats.MessagesFailed, result.Stats.RepliesImported, result.Stats.FilesLinked)
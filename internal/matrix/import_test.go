@@ -0,0 +1,82 @@
+package matrix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveMemberships(t *testing.T) {
+	userMapping := map[string]string{
+		"u1": "@alice:example.org",
+		"u2": "@bob:example.org",
+	}
+	roomMapping := map[string]string{
+		"c1": "!room1:example.org",
+	}
+
+	tests := []struct {
+		name        string
+		memberships []membershipRef
+		wantPairs   []MembershipPair
+		wantSkips   []MembershipSkip
+	}{
+		{
+			name:        "mapped member",
+			memberships: []membershipRef{{UserID: "u1", TargetID: "c1"}},
+			wantPairs:   []MembershipPair{{Index: 0, UserID: "@alice:example.org", RoomID: "!room1:example.org"}},
+		},
+		{
+			name:        "admin flag is kept",
+			memberships: []membershipRef{{UserID: "u2", TargetID: "c1", Admin: true}},
+			wantPairs:   []MembershipPair{{Index: 0, UserID: "@bob:example.org", RoomID: "!room1:example.org", Admin: true}},
+		},
+		{
+			name:        "unmapped user",
+			memberships: []membershipRef{{UserID: "u9", TargetID: "c1"}},
+			wantSkips:   []MembershipSkip{{Index: 0, UserID: "u9", TargetID: "c1", Reason: SkipReasonUserNotMapped}},
+		},
+		{
+			name:        "unmapped room",
+			memberships: []membershipRef{{UserID: "u1", TargetID: "c9"}},
+			wantSkips:   []MembershipSkip{{Index: 0, UserID: "u1", TargetID: "c9", Reason: SkipReasonChannelNotMapped}},
+		},
+		{
+			name:        "deleted membership wins over missing mappings",
+			memberships: []membershipRef{{UserID: "u9", TargetID: "c9", Deleted: true}},
+			wantSkips:   []MembershipSkip{{Index: 0, UserID: "u9", TargetID: "c9", Reason: SkipReasonDeleted}},
+		},
+		{
+			name:        "unmapped user is reported before unmapped room",
+			memberships: []membershipRef{{UserID: "u9", TargetID: "c9"}},
+			wantSkips:   []MembershipSkip{{Index: 0, UserID: "u9", TargetID: "c9", Reason: SkipReasonUserNotMapped}},
+		},
+		{
+			name: "indexes follow the input order",
+			memberships: []membershipRef{
+				{UserID: "u1", TargetID: "c1", Deleted: true},
+				{UserID: "u2", TargetID: "c1"},
+				{UserID: "u1", TargetID: "c9"},
+			},
+			wantPairs: []MembershipPair{{Index: 1, UserID: "@bob:example.org", RoomID: "!room1:example.org"}},
+			wantSkips: []MembershipSkip{
+				{Index: 0, UserID: "u1", TargetID: "c1", Reason: SkipReasonDeleted},
+				{Index: 2, UserID: "u1", TargetID: "c9", Reason: SkipReasonChannelNotMapped},
+			},
+		},
+		{
+			name: "no memberships",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs, skips := resolveMemberships(tt.memberships, userMapping, roomMapping, SkipReasonChannelNotMapped)
+			if !reflect.DeepEqual(pairs, tt.wantPairs) {
+				t.Errorf("resolved = %+v, want %+v", pairs, tt.wantPairs)
+			}
+			if !reflect.DeepEqual(skips, tt.wantSkips) {
+				t.Errorf("skips = %+v, want %+v", skips, tt.wantSkips)
+			}
+		})
+	}
+}
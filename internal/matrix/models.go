@@ -1,5 +1,10 @@
 ﻿package matrix
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // User represents a Matrix user
 type User struct {
 	UserID      string `json:"user_id"`
@@ -14,6 +19,17 @@ type CreateUserRequest struct {
 	DisplayName string `json:"displayname,omitempty"`
 	Admin       bool   `json:"admin"`
 	Deactivated bool   `json:"deactivated"`
+
+	// Optional Synapse fields; nil/empty values are not sent
+	UserType      *string      `json:"user_type,omitempty"`      // e.g. "bot" or "support"; unset is a regular user
+	LogoutDevices *bool        `json:"logout_devices,omitempty"` // Log out devices when the password changes (Synapse default: true)
+	ExternalIDs   []ExternalID `json:"external_ids,omitempty"`   // SSO identities linked to the account
+}
+
+// ExternalID links a Matrix account to an identity of an SSO provider
+type ExternalID struct {
+	AuthProvider string `json:"auth_provider"` // Provider ID from the homeserver config, e.g. "oidc-keycloak"
+	ExternalID   string `json:"external_id"`   // Subject of the user at that provider
 }
 
 // UserResponse is the response from the Admin API for user operations
@@ -27,6 +43,81 @@ type UserResponse struct {
 	Error       string `json:"error,omitempty"`
 }
 
+// DeleteRoomRequest is the request body of the Admin API v2 room delete
+type DeleteRoomRequest struct {
+	Purge bool `json:"purge"` // Remove the room's history from the database
+	Block bool `json:"block"` // Prevent the room from being joined again
+}
+
+// DeleteRoomResponse is the response of the Admin API v2 room delete
+type DeleteRoomResponse struct {
+	DeleteID string `json:"delete_id"`
+	Errcode  string `json:"errcode,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RoomMembersResponse is the Admin API list of a room's joined members
+type RoomMembersResponse struct {
+	Members []string `json:"members"`
+	Total   int      `json:"total"`
+	Errcode string   `json:"errcode,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Statuses of an asynchronous room delete
+const (
+	RoomDeleteScheduled    = "scheduled"
+	RoomDeleteShuttingDown = "shutting_down"
+	RoomDeletePurging      = "purging"
+	RoomDeleteComplete     = "complete"
+	RoomDeleteFailed       = "failed"
+)
+
+// RoomDeleteStatus is the status of an asynchronous room delete
+type RoomDeleteStatus struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Errcode string `json:"errcode,omitempty"`
+}
+
+// Done returns true once the delete completed or failed
+func (s *RoomDeleteStatus) Done() bool {
+	return s.Status == RoomDeleteComplete || s.Status == RoomDeleteFailed
+}
+
+// ListUsersResponse is a page of the Admin API user list
+type ListUsersResponse struct {
+	Users     []ListedUser `json:"users"`
+	NextToken json.Number  `json:"next_token,omitempty"` // Offset of the next page, empty on the last page
+	Total     int          `json:"total"`
+	Errcode   string       `json:"errcode,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// ListedUser is a user entry in the Admin API user list
+type ListedUser struct {
+	Name        string   `json:"name"` // Full user ID
+	DisplayName string   `json:"displayname,omitempty"`
+	Admin       flexBool `json:"admin"`
+	Deactivated flexBool `json:"deactivated"`
+}
+
+// flexBool accepts both booleans and the 0/1 integers older Synapse versions return
+type flexBool bool
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean value: %s", data)
+	}
+	return nil
+}
+
 // Room represents a Matrix room
 type Room struct {
 	RoomID       string   `json:"room_id"`
@@ -49,6 +140,7 @@ type CreateRoomRequest struct {
 	CreationContent map[string]interface{} `json:"creation_content,omitempty"`
 	InitialState    []StateEvent           `json:"initial_state,omitempty"`
 	Invite          []string               `json:"invite,omitempty"`
+	RoomVersion     string                 `json:"room_version,omitempty"`
 }
 
 // CreateRoomResponse is the response from creating a room
@@ -56,6 +148,38 @@ type CreateRoomResponse struct {
 	RoomID  string `json:"room_id,omitempty"`
 	Errcode string `json:"errcode,omitempty"`
 	Error   string `json:"error,omitempty"`
+
+	// Existing is set when the room was not created because its alias
+	// already pointed to a room (e.g. from a previous partial run)
+	Existing bool `json:"-"`
+}
+
+// RoomOptions holds the settings used by CreateSpace and CreateRegularRoom
+type RoomOptions struct {
+	Name      string
+	Topic     string
+	AliasName string // Alias localpart; makes creation idempotent across re-runs
+	Public    bool // Anyone can join (public chat preset)
+	Listed    bool // Published in the room directory when created
+	InitialState []StateEvent // Extra state events set when the room is created
+	RoomVersion  string       // Room version (empty: server default)
+	JoinRule     string       // Overrides the join rule of the preset, e.g. JoinRuleKnock
+	Encrypted    bool         // Enable end-to-end encryption (Megolm)
+	Direct       bool         // Create a direct message room (is_direct, trusted private chat)
+	Invite       []string     // Users invited when the room is created
+}
+
+// RoomVisibilityRequest sets whether a room is listed in the room directory
+type RoomVisibilityRequest struct {
+	Visibility string `json:"visibility"` // "public" or "private"
+}
+
+// ResolveAliasResponse is the response from resolving a room alias
+type ResolveAliasResponse struct {
+	RoomID  string   `json:"room_id,omitempty"`
+	Servers []string `json:"servers,omitempty"`
+	Errcode string   `json:"errcode,omitempty"`
+	Error   string   `json:"error,omitempty"`
 }
 
 // StateEvent represents a Matrix state event
@@ -65,6 +189,14 @@ type StateEvent struct {
 	Content  interface{} `json:"content"`
 }
 
+// RoomStateEvent is a state event as returned by the room state API
+type RoomStateEvent struct {
+	Type     string          `json:"type"`
+	StateKey string          `json:"state_key"`
+	Sender   string          `json:"sender,omitempty"`
+	Content  json.RawMessage `json:"content"`
+}
+
 // SpaceChildContent is the content for m.space.child events
 type SpaceChildContent struct {
 	Via       []string `json:"via,omitempty"`
@@ -77,6 +209,27 @@ type InviteRequest struct {
 	UserID string `json:"user_id"`
 }
 
+// KickRequest is the request body for removing a user from a room
+type KickRequest struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// DeactivateRequest is the request body of the Admin API deactivate endpoint
+type DeactivateRequest struct {
+	Erase bool `json:"erase"` // Also remove the user's profile and mark their messages erased
+}
+
+// MemberContent is the content of an m.room.member state event
+type MemberContent struct {
+	Membership string `json:"membership"` // invite, join, leave, ban or knock
+}
+
+// LeaveRequest is the request body for leaving a room
+type LeaveRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
 // JoinRequest is the request body for joining a room
 type JoinRequest struct {
 	Reason string `json:"reason,omitempty"`
@@ -112,6 +265,28 @@ type RoomTopicContent struct {
 	Topic string `json:"topic"`
 }
 
+// PinnedEventsContent is the content for m.room.pinned_events events
+type PinnedEventsContent struct {
+	Pinned []string `json:"pinned"`
+}
+
+// CanonicalAliasContent is the content for m.room.canonical_alias events
+type CanonicalAliasContent struct {
+	Alias      string   `json:"alias,omitempty"`
+	AltAliases []string `json:"alt_aliases,omitempty"`
+}
+
+// HistoryVisibilityContent is the content for m.room.history_visibility events
+type HistoryVisibilityContent struct {
+	HistoryVisibility string `json:"history_visibility"`
+}
+
+// History visibilities of rooms
+const (
+	HistoryVisibilityShared        = "shared"         // Members see all history, including from before they joined
+	HistoryVisibilityWorldReadable = "world_readable" // Anyone can read the history, even without joining
+)
+
 // ImportResult represents the result of an import operation
 type ImportResult struct {
 	UserID       string `json:"user_id,omitempty"`
@@ -127,17 +302,38 @@ type ImportStats struct {
 	UsersCreated    int `json:"users_created"`
 	UsersSkipped    int `json:"users_skipped"`
 	UsersFailed     int `json:"users_failed"`
+	UsersInvalid    int `json:"users_invalid"`
+	UsersDuplicateEmail int `json:"users_duplicate_email"`
 	SpacesCreated   int `json:"spaces_created"`
 	SpacesSkipped   int `json:"spaces_skipped"`
 	SpacesFailed    int `json:"spaces_failed"`
 	RoomsCreated    int `json:"rooms_created"`
 	RoomsSkipped    int `json:"rooms_skipped"`
 	RoomsFailed     int `json:"rooms_failed"`
+	RoomsSkippedEmpty int `json:"rooms_skipped_empty"`
+	RoomsSkippedOrphan int `json:"rooms_skipped_orphan"`
 	MembersAdded    int `json:"members_added"`
 	MembersSkipped  int `json:"members_skipped"`
 	MembersFailed   int `json:"members_failed"`
 	RoomsLinked     int `json:"rooms_linked"`
 	RoomsLinkFailed int `json:"rooms_link_failed"`
+	RoomsAlreadyLinked int `json:"rooms_already_linked"`
+	UsersDeactivated int `json:"users_deactivated"`
+	RoomsUpdated     int `json:"rooms_updated"`
+	MembersPromoted  int `json:"members_promoted"`
+	MembersRemoved   int `json:"members_removed"`
+	DirectRoomsCreated int `json:"direct_rooms_created"`
+}
+
+// DeactivationRecord is the audit entry for an account created deactivated
+// because the source Mattermost user was deleted
+type DeactivationRecord struct {
+	MattermostUserID string `json:"mattermost_user_id"`
+	Username         string `json:"username"`
+	MatrixUserID     string `json:"matrix_user_id"`
+	DeletedAt        int64  `json:"deleted_at"`  // Mattermost DeleteAt (ms)
+	ImportedAt       int64  `json:"imported_at"` // When the deactivated account was created (ms)
+	Reason           string `json:"reason"`
 }
 
 // RoomPreset defines room creation presets
@@ -166,8 +362,50 @@ const (
 	EventTypeSpaceParent = "m.space.parent"
 	EventTypeRoomName    = "m.room.name"
 	EventTypeRoomTopic   = "m.room.topic"
+	EventTypePowerLevels = "m.room.power_levels"
+	EventTypeRoomMessage = "m.room.message"
+	EventTypeJoinRules   = "m.room.join_rules"
+	EventTypeEncryption  = "m.room.encryption"
+	EventTypeHistoryVisibility = "m.room.history_visibility"
+	EventTypeCanonicalAlias    = "m.room.canonical_alias"
+	EventTypeRoomMember        = "m.room.member"
+	EventTypePinnedEvents      = "m.room.pinned_events"
+
+	// EventTypeMattermostCreator records who created the source channel
+	EventTypeMattermostCreator = "im.mattermost.creator"
 )
 
+// AccountDataTypeDirect lists a user's direct message rooms, by the other user
+const AccountDataTypeDirect = "m.direct"
+
+// MattermostCreatorContent is the content of an im.mattermost.creator state event
+type MattermostCreatorContent struct {
+	MattermostUserID string `json:"mattermost_user_id"`
+	Username         string `json:"username,omitempty"`
+	UserID           string `json:"user_id,omitempty"` // Matrix user, if the creator was imported
+	Substitute       bool   `json:"substitute,omitempty"` // UserID stands in for a creator that wasn't imported
+}
+
+// EncryptionAlgorithmMegolm is the end-to-end encryption algorithm of encrypted rooms
+const EncryptionAlgorithmMegolm = "m.megolm.v1.aes-sha2"
+
+// EncryptionContent is the content of an m.room.encryption state event
+type EncryptionContent struct {
+	Algorithm string `json:"algorithm"`
+}
+
+// JoinRuleKnock lets users ask to join a room; members with invite power
+// can accept them (room version 7 and later)
+const JoinRuleKnock = "knock"
+
+// JoinRulesContent is the content of an m.room.join_rules state event
+type JoinRulesContent struct {
+	JoinRule string `json:"join_rule"`
+}
+
+// ReadOnlyPowerLevel is the power level required to post in a read-only room
+const ReadOnlyPowerLevel = 100
+
 
 
 
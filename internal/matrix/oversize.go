@@ -0,0 +1,95 @@
+package matrix
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Oversize policies for message bodies larger than the configured maximum
+const (
+	OversizePolicySplit    = "split"    // Send the body as several sequential events
+	OversizePolicyTruncate = "truncate" // Send the start of the body with a marker
+	OversizePolicySkip     = "skip"     // Don't import the message
+)
+
+// DefaultMaxMessageBytes keeps message events well below Synapse's 64 KiB
+// event size limit, leaving room for the rest of the event
+const DefaultMaxMessageBytes = 32000
+
+// truncatedMarker is appended to truncated message bodies
+const truncatedMarker = "\n\n[message truncated]"
+
+// jsonLen returns the length of s once encoded as a JSON string (without
+// quotes). Event size limits apply to the encoded event, and escaping can
+// make a body considerably larger than its raw length.
+func jsonLen(s string) int {
+	n := 0
+	for _, r := range s {
+		n += jsonRuneLen(r)
+	}
+	return n
+}
+
+// jsonRuneLen returns the encoded length of r as produced by encoding/json
+func jsonRuneLen(r rune) int {
+	switch {
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+		return 6
+	case r == utf8.RuneError:
+		return 6
+	default:
+		return utf8.RuneLen(r)
+	}
+}
+
+// splitMessageBody splits body into parts whose encoded size is at most
+// maxBytes, preferring to break at line ends, then at spaces
+func splitMessageBody(body string, maxBytes int) []string {
+	var parts []string
+	for jsonLen(body) > maxBytes {
+		cut := cutIndex(body, maxBytes)
+		if i := strings.LastIndex(body[:cut], "\n"); i > cut/2 {
+			cut = i + 1
+		} else if i := strings.LastIndex(body[:cut], " "); i > cut/2 {
+			cut = i + 1
+		}
+		if part := strings.TrimRight(body[:cut], "\n"); part != "" {
+			parts = append(parts, part)
+		}
+		body = body[cut:]
+	}
+	if body != "" {
+		parts = append(parts, body)
+	}
+	return parts
+}
+
+// truncateMessageBody shortens body to at most maxBytes encoded bytes,
+// marker included
+func truncateMessageBody(body string, maxBytes int) string {
+	limit := maxBytes - jsonLen(truncatedMarker)
+	if limit < 1 {
+		limit = 1
+	}
+	return body[:cutIndex(body, limit)] + truncatedMarker
+}
+
+// cutIndex returns the largest byte index at a rune boundary such that
+// body[:index] encodes to at most maxBytes. At least one rune is kept so
+// splitting always makes progress.
+func cutIndex(body string, maxBytes int) int {
+	n := 0
+	for i, r := range body {
+		n += jsonRuneLen(r)
+		if n > maxBytes {
+			if i == 0 {
+				_, size := utf8.DecodeRuneInString(body)
+				return size
+			}
+			return i
+		}
+	}
+	return len(body)
+}
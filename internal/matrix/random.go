@@ -0,0 +1,61 @@
+package matrix
+
+import (
+	"crypto/rand"
+	"math/big"
+	mathrand "math/rand/v2"
+	"sync"
+)
+
+// passwordAlphabet is the set of characters generated passwords are drawn from
+const passwordAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// generatedPasswordLength is the length of generated passwords
+const generatedPasswordLength = 24
+
+var (
+	randMu sync.Mutex
+	// seededRand replaces crypto/rand in deterministic mode (nil: crypto/rand)
+	seededRand *mathrand.Rand
+)
+
+// SetDeterministicSeed makes generated values reproducible from seed, for
+// test migrations and golden-file comparisons. Never use it for a real
+// migration: generated passwords become predictable.
+func SetDeterministicSeed(seed uint64) {
+	randMu.Lock()
+	defer randMu.Unlock()
+	seededRand = mathrand.New(mathrand.NewPCG(seed, seed))
+}
+
+// IsDeterministic reports whether SetDeterministicSeed was called
+func IsDeterministic() bool {
+	randMu.Lock()
+	defer randMu.Unlock()
+	return seededRand != nil
+}
+
+// randomIndex returns a random number in [0, n)
+func randomIndex(n int) int {
+	randMu.Lock()
+	defer randMu.Unlock()
+
+	if seededRand != nil {
+		return seededRand.IntN(n)
+	}
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return int(v.Int64())
+}
+
+// GenerateRandomPassword generates a random password for new users. It uses
+// crypto/rand unless deterministic mode is enabled.
+func GenerateRandomPassword() string {
+	b := make([]byte, generatedPasswordLength)
+	for i := range b {
+		b[i] = passwordAlphabet[randomIndex(len(passwordAlphabet))]
+	}
+	return string(b)
+}
@@ -0,0 +1,61 @@
+package matrix
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces out requests to the homeserver. A limiter can be shared
+// by several clients (e.g. an admin client and a media client), so that
+// together they stay within the configured requests per second.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // Minimum time between two requests (0 = no limit)
+	next     time.Time     // Earliest time the next request may start
+}
+
+// NewRateLimiter creates a limiter allowing requestsPerSecond requests per
+// second; zero or less means no limit
+func NewRateLimiter(requestsPerSecond float64) *RateLimiter {
+	var interval time.Duration
+	if requestsPerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	return &RateLimiter{interval: interval}
+}
+
+// Interval returns the minimum time between two requests
+func (l *RateLimiter) Interval() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.interval
+}
+
+// Wait blocks until the caller may send its request. Each caller reserves
+// its own slot, so concurrent callers are spaced out without waiting on
+// each other's sleep. It returns ctx.Err() if ctx is cancelled first.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil || l.interval <= 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
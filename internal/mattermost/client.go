@@ -3,18 +3,34 @@
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 )
 
 // Client represents a Mattermost database client
 type Client struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+
+	maxOpenConns int // Set by SetMaxOpenConns
 }
 
-// NewClient creates a new Mattermost database client
+// NewClient creates a new Mattermost database client for PostgreSQL
 func NewClient(dsn string) (*Client, error) {
-	db, err := sql.Open("postgres", dsn)
+	return NewClientWithDriver(DriverPostgres, dsn)
+}
+
+// NewClientWithDriver creates a new Mattermost database client for the
+// given driver (DriverPostgres or DriverMySQL)
+func NewClientWithDriver(driver, dsn string) (*Client, error) {
+	d, err := newDialect(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -25,7 +41,30 @@ func NewClient(dsn string) (*Client, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &Client{db: db}, nil
+	return &Client{db: db, dialect: d}, nil
+}
+
+// SetMaxOpenConns limits the database connections open at once; the
+// exporter runs as many workers in parallel (values below 1 mean 1)
+func (c *Client) SetMaxOpenConns(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.db.SetMaxOpenConns(n)
+	c.maxOpenConns = n
+}
+
+// MaxOpenConns returns the limit set with SetMaxOpenConns, or 1
+func (c *Client) MaxOpenConns() int {
+	if c.maxOpenConns < 1 {
+		return 1
+	}
+	return c.maxOpenConns
+}
+
+// Driver returns the database driver of the client
+func (c *Client) Driver() string {
+	return c.dialect.driver
 }
 
 // Close closes the database connection
@@ -38,8 +77,8 @@ func (c *Client) Ping() error {
 	return c.db.Ping()
 }
 
-// GetUsers retrieves all users from the database
-func (c *Client) GetUsers() ([]User, error) {
+// GetUsers retrieves the users selected by filter's Since from the database
+func (c *Client) GetUsers(filter AssetFilter) ([]User, error) {
 	query := `
 		SELECT 
 			id, username, email, 
@@ -48,14 +87,20 @@ func (c *Client) GetUsers() ([]User, error) {
 			COALESCE(nickname, '') as nickname,
 			COALESCE(position, '') as position,
 			COALESCE(locale, 'en') as locale,
-			COALESCE(timezone::text, '{}') as timezone,
+			COALESCE(` + c.dialect.jsonText("timezone") + `, '{}') as timezone,
 			createat, updateat, deleteat,
-			COALESCE(roles, '') as roles
-		FROM users
-		ORDER BY createat ASC
+			COALESCE(roles, '') as roles,
+			COALESCE(authservice, '') as authservice,
+			COALESCE(authdata, '') as authdata
+		FROM Users
 	`
+	var args []interface{}
+	if filter.Since > 0 {
+		query += " WHERE " + c.sinceCondition(filter.Since, &args)
+	}
+	query += " ORDER BY createat ASC"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
@@ -69,7 +114,7 @@ func (c *Client) GetUsers() ([]User, error) {
 			&u.FirstName, &u.LastName, &u.Nickname,
 			&u.Position, &u.Locale, &u.Timezone,
 			&u.CreateAt, &u.UpdateAt, &u.DeleteAt,
-			&u.Roles,
+			&u.Roles, &u.AuthService, &u.AuthData,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
@@ -84,8 +129,35 @@ func (c *Client) GetUsers() ([]User, error) {
 	return users, nil
 }
 
-// GetTeams retrieves all teams from the database
-func (c *Client) GetTeams() ([]Team, error) {
+// sinceCondition returns a condition selecting the rows created or updated
+// at or after since, appending its arguments to args
+func (c *Client) sinceCondition(since int64, args *[]interface{}) string {
+	*args = append(*args, since, since)
+	return "(createat >= " + c.dialect.placeholder(len(*args)-1) + " OR updateat >= " + c.dialect.placeholder(len(*args)) + ")"
+}
+
+// teamCondition returns a condition selecting the rows whose idColumn is a
+// team selected by filter, appending its arguments to args
+func (c *Client) teamCondition(filter AssetFilter, idColumn string, args *[]interface{}) string {
+	return idColumn + " IN (SELECT id FROM Teams WHERE " +
+		c.dialect.inList("name", filter.Teams, args) + " OR " + c.dialect.inList("id", filter.Teams, args) + ")"
+}
+
+// channelCondition returns a condition selecting the rows whose idColumn is
+// a channel selected by filter, appending its arguments to args
+func (c *Client) channelCondition(filter AssetFilter, idColumn string, args *[]interface{}) string {
+	var conditions []string
+	if len(filter.Teams) > 0 {
+		conditions = append(conditions, c.teamCondition(filter, "teamid", args))
+	}
+	if len(filter.Channels) > 0 {
+		conditions = append(conditions, "("+c.dialect.inList("name", filter.Channels, args)+" OR "+c.dialect.inList("id", filter.Channels, args)+")")
+	}
+	return idColumn + " IN (SELECT id FROM Channels WHERE " + strings.Join(conditions, " AND ") + ")"
+}
+
+// GetTeams retrieves the teams selected by filter from the database
+func (c *Client) GetTeams(filter AssetFilter) ([]Team, error) {
 	query := `
 		SELECT 
 			id, name, displayname, 
@@ -97,11 +169,15 @@ func (c *Client) GetTeams() ([]Team, error) {
 			COALESCE(inviteid, '') as inviteid,
 			allowopeninvite,
 			createat, updateat, deleteat
-		FROM teams
-		ORDER BY createat ASC
+		FROM Teams
 	`
+	var args []interface{}
+	if len(filter.Teams) > 0 {
+		query += " WHERE " + c.teamCondition(filter, "id", &args)
+	}
+	query += " ORDER BY createat ASC"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query teams: %w", err)
 	}
@@ -130,8 +206,8 @@ func (c *Client) GetTeams() ([]Team, error) {
 	return teams, nil
 }
 
-// GetChannels retrieves all channels from the database
-func (c *Client) GetChannels() ([]Channel, error) {
+// GetChannels retrieves the channels selected by filter from the database
+func (c *Client) GetChannels(filter AssetFilter) ([]Channel, error) {
 	query := `
 		SELECT 
 			id, 
@@ -143,12 +219,19 @@ func (c *Client) GetChannels() ([]Channel, error) {
 			createat, updateat, deleteat,
 			COALESCE(creatorid, '') as creatorid,
 			COALESCE(totalmsgcount, 0) as totalmsgcount
-		FROM channels
-		WHERE type IN ('O', 'P', 'G')
-		ORDER BY createat ASC
+		FROM Channels
+		WHERE type IN ` + filter.channelTypes() + `
 	`
+	var args []interface{}
+	if filter.selectsChannels() {
+		query += " AND " + c.channelCondition(filter, "id", &args)
+	}
+	if filter.Since > 0 {
+		query += " AND " + c.sinceCondition(filter.Since, &args)
+	}
+	query += " ORDER BY createat ASC"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query channels: %w", err)
 	}
@@ -176,18 +259,23 @@ func (c *Client) GetChannels() ([]Channel, error) {
 	return channels, nil
 }
 
-// GetTeamMembers retrieves all team memberships from the database
-func (c *Client) GetTeamMembers() ([]TeamMember, error) {
+// GetTeamMembers retrieves the memberships of the teams selected by filter
+// from the database
+func (c *Client) GetTeamMembers(filter AssetFilter) ([]TeamMember, error) {
 	query := `
 		SELECT 
 			teamid, userid, 
 			COALESCE(roles, '') as roles,
 			deleteat
-		FROM teammembers
-		ORDER BY teamid, userid
+		FROM TeamMembers
 	`
+	var args []interface{}
+	if len(filter.Teams) > 0 {
+		query += " WHERE " + c.teamCondition(filter, "teamid", &args)
+	}
+	query += " ORDER BY teamid, userid"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query team members: %w", err)
 	}
@@ -210,20 +298,25 @@ func (c *Client) GetTeamMembers() ([]TeamMember, error) {
 	return members, nil
 }
 
-// GetChannelMembers retrieves all channel memberships from the database
-func (c *Client) GetChannelMembers() ([]ChannelMember, error) {
+// GetChannelMembers retrieves the memberships of the channels selected by
+// filter from the database
+func (c *Client) GetChannelMembers(filter AssetFilter) ([]ChannelMember, error) {
 	query := `
 		SELECT 
 			channelid, userid, 
 			COALESCE(roles, '') as roles,
-			COALESCE(notifyprops::text, '{}') as notifyprops,
+			COALESCE(` + c.dialect.jsonText("notifyprops") + `, '{}') as notifyprops,
 			COALESCE(lastviewedat, 0) as lastviewedat,
 			COALESCE(msgcount, 0) as msgcount
-		FROM channelmembers
-		ORDER BY channelid, userid
+		FROM ChannelMembers
 	`
+	var args []interface{}
+	if filter.selectsChannels() {
+		query += " WHERE " + c.channelCondition(filter, "channelid", &args)
+	}
+	query += " ORDER BY channelid, userid"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query channel members: %w", err)
 	}
@@ -252,26 +345,87 @@ func (c *Client) GetChannelMembers() ([]ChannelMember, error) {
 // GetUserCount returns the total number of users
 func (c *Client) GetUserCount() (int, error) {
 	var count int
-	err := c.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	err := c.db.QueryRow("SELECT COUNT(*) FROM Users").Scan(&count)
 	return count, err
 }
 
-// GetTeamCount returns the total number of teams
-func (c *Client) GetTeamCount() (int, error) {
+// GetTeamCount returns the number of teams selected by filter
+func (c *Client) GetTeamCount(filter AssetFilter) (int, error) {
+	query := "SELECT COUNT(*) FROM Teams"
+	var args []interface{}
+	if len(filter.Teams) > 0 {
+		query += " WHERE " + c.teamCondition(filter, "id", &args)
+	}
 	var count int
-	err := c.db.QueryRow("SELECT COUNT(*) FROM teams").Scan(&count)
+	err := c.db.QueryRow(query, args...).Scan(&count)
 	return count, err
 }
 
-// GetChannelCount returns the total number of channels (public, private, and group)
-func (c *Client) GetChannelCount() (int, error) {
+// GetChannelCount returns the number of channels (public, private, and group,
+// and direct with filter.DirectMessages) selected by filter
+func (c *Client) GetChannelCount(filter AssetFilter) (int, error) {
+	query := "SELECT COUNT(*) FROM Channels WHERE type IN " + filter.channelTypes()
+	var args []interface{}
+	if filter.selectsChannels() {
+		query += " AND " + c.channelCondition(filter, "id", &args)
+	}
 	var count int
-	err := c.db.QueryRow("SELECT COUNT(*) FROM channels WHERE type IN ('O', 'P', 'G')").Scan(&count)
+	err := c.db.QueryRow(query, args...).Scan(&count)
 	return count, err
 }
 
 // GetPosts retrieves all posts from the database (excluding deleted and system messages)
 func (c *Client) GetPosts() ([]Post, error) {
+	return c.GetPostsFiltered(PostFilter{})
+}
+
+// GetPostsFiltered retrieves the posts created within the filter's range
+// (excluding deleted and system messages)
+func (c *Client) GetPostsFiltered(filter PostFilter) ([]Post, error) {
+	var posts []Post
+	err := c.ForEachPost(filter, func(p *Post) error {
+		posts = append(posts, *p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// postCondition returns the condition selecting the posts of an export:
+// those created within the filter's range, excluding deleted and system
+// messages. Its arguments are appended to args.
+func (c *Client) postCondition(filter PostFilter, args *[]interface{}) string {
+	condition := "deleteat = 0 AND (type = '' OR type IS NULL)"
+	if filter.Since > 0 {
+		*args = append(*args, filter.Since)
+		condition += " AND createat >= " + c.dialect.placeholder(len(*args))
+	}
+	if filter.Until > 0 {
+		*args = append(*args, filter.Until)
+		condition += " AND createat < " + c.dialect.placeholder(len(*args))
+	}
+	if filter.selectsChannels() {
+		condition += " AND " + c.channelCondition(AssetFilter{Teams: filter.Teams, Channels: filter.Channels}, "channelid", args)
+	}
+	return condition
+}
+
+// ForEachPost passes the posts created within the filter's range (excluding
+// deleted and system messages) to fn as they are read, oldest first, without
+// collecting them. An error from fn stops the iteration and is returned.
+func (c *Client) ForEachPost(filter PostFilter, fn func(*Post) error) error {
+	return c.forEachPost(filter, "", fn)
+}
+
+// ForEachPostInChannel is ForEachPost limited to one channel
+func (c *Client) ForEachPostInChannel(channelID string, filter PostFilter, fn func(*Post) error) error {
+	return c.forEachPost(filter, channelID, fn)
+}
+
+// forEachPost implements ForEachPost, for all channels if channelID is empty
+func (c *Client) forEachPost(filter PostFilter, channelID string, fn func(*Post) error) error {
 	query := `
 		SELECT 
 			id, createat, updateat, deleteat, userid, channelid,
@@ -280,38 +434,44 @@ func (c *Client) GetPosts() ([]Post, error) {
 			COALESCE(message, '') as message,
 			COALESCE(type, '') as type,
 			COALESCE(props, '{}') as props,
-			COALESCE(fileids, '[]') as fileids
-		FROM posts
-		WHERE deleteat = 0
-		AND (type = '' OR type IS NULL)
-		ORDER BY createat ASC
+			COALESCE(fileids, '[]') as fileids,
+			COALESCE(ispinned, false) as ispinned
+		FROM Posts
 	`
+	var args []interface{}
+	query += " WHERE " + c.postCondition(filter, &args)
+	if channelID != "" {
+		args = append(args, channelID)
+		query += " AND channelid = " + c.dialect.placeholder(len(args))
+	}
+	query += " ORDER BY createat ASC"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query posts: %w", err)
+		return fmt.Errorf("failed to query posts: %w", err)
 	}
 	defer rows.Close()
 
-	var posts []Post
 	for rows.Next() {
 		var p Post
 		err := rows.Scan(
 			&p.ID, &p.CreateAt, &p.UpdateAt, &p.DeleteAt,
 			&p.UserID, &p.ChannelID, &p.RootID, &p.OriginalID,
-			&p.Message, &p.Type, &p.Props, &p.FileIDs,
+			&p.Message, &p.Type, &p.Props, &p.FileIDs, &p.IsPinned,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan post: %w", err)
+			return fmt.Errorf("failed to scan post: %w", err)
+		}
+		if err := fn(&p); err != nil {
+			return err
 		}
-		posts = append(posts, p)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating posts: %w", err)
+		return fmt.Errorf("error iterating posts: %w", err)
 	}
 
-	return posts, nil
+	return nil
 }
 
 // GetPostsByChannel retrieves posts for a specific channel
@@ -324,9 +484,10 @@ func (c *Client) GetPostsByChannel(channelID string) ([]Post, error) {
 			COALESCE(message, '') as message,
 			COALESCE(type, '') as type,
 			COALESCE(props, '{}') as props,
-			COALESCE(fileids, '[]') as fileids
-		FROM posts
-		WHERE channelid = $1
+			COALESCE(fileids, '[]') as fileids,
+			COALESCE(ispinned, false) as ispinned
+		FROM Posts
+		WHERE channelid = ` + c.dialect.placeholder(1) + `
 		AND deleteat = 0
 		AND (type = '' OR type IS NULL)
 		ORDER BY createat ASC
@@ -344,7 +505,7 @@ func (c *Client) GetPostsByChannel(channelID string) ([]Post, error) {
 		err := rows.Scan(
 			&p.ID, &p.CreateAt, &p.UpdateAt, &p.DeleteAt,
 			&p.UserID, &p.ChannelID, &p.RootID, &p.OriginalID,
-			&p.Message, &p.Type, &p.Props, &p.FileIDs,
+			&p.Message, &p.Type, &p.Props, &p.FileIDs, &p.IsPinned,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan post: %w", err)
@@ -359,28 +520,21 @@ func (c *Client) GetPostsByChannel(channelID string) ([]Post, error) {
 	return posts, nil
 }
 
-// GetPostCount returns the total number of active posts
-func (c *Client) GetPostCount() (int, error) {
+// GetPostCount returns the number of posts ForEachPost returns for filter
+func (c *Client) GetPostCount(filter PostFilter) (int, error) {
+	var args []interface{}
 	var count int
-	err := c.db.QueryRow(`
-		SELECT COUNT(*) FROM posts 
-		WHERE deleteat = 0 
-		AND (type = '' OR type IS NULL)
-	`).Scan(&count)
+	err := c.db.QueryRow("SELECT COUNT(*) FROM Posts WHERE "+c.postCondition(filter, &args), args...).Scan(&count)
 	return count, err
 }
 
-// GetPostCountByChannel returns post counts per channel
-func (c *Client) GetPostCountByChannel() (map[string]int, error) {
-	query := `
-		SELECT channelid, COUNT(*) as cnt
-		FROM posts
-		WHERE deleteat = 0
-		AND (type = '' OR type IS NULL)
-		GROUP BY channelid
-	`
+// GetPostCountByChannel returns the number of posts ForEachPost returns for
+// filter, per channel
+func (c *Client) GetPostCountByChannel(filter PostFilter) (map[string]int, error) {
+	var args []interface{}
+	query := "SELECT channelid, COUNT(*) as cnt FROM Posts WHERE " + c.postCondition(filter, &args) + " GROUP BY channelid"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query post counts: %w", err)
 	}
@@ -401,6 +555,21 @@ func (c *Client) GetPostCountByChannel() (map[string]int, error) {
 
 // GetFileInfos retrieves all file infos from the database
 func (c *Client) GetFileInfos() ([]FileInfo, error) {
+	var files []FileInfo
+	err := c.ForEachFileInfo(PostFilter{}, func(f *FileInfo) error {
+		files = append(files, *f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ForEachFileInfo passes the file infos to fn as they are read, without
+// collecting them. A non-zero filter limits them to the files of the posts
+// ForEachPost returns for it. An error from fn stops the iteration.
+func (c *Client) ForEachFileInfo(filter PostFilter, fn func(*FileInfo) error) error {
 	query := `
 		SELECT 
 			id, 
@@ -417,18 +586,21 @@ func (c *Client) GetFileInfos() ([]FileInfo, error) {
 			COALESCE(width, 0) as width,
 			COALESCE(height, 0) as height,
 			COALESCE(haspreviewimage, false) as haspreviewimage
-		FROM fileinfo
+		FROM FileInfo
 		WHERE deleteat = 0
-		ORDER BY createat ASC
 	`
+	var args []interface{}
+	if !filter.IsZero() {
+		query += " AND postid IN (SELECT id FROM Posts WHERE " + c.postCondition(filter, &args) + ")"
+	}
+	query += " ORDER BY createat ASC"
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query file infos: %w", err)
+		return fmt.Errorf("failed to query file infos: %w", err)
 	}
 	defer rows.Close()
 
-	var files []FileInfo
 	for rows.Next() {
 		var f FileInfo
 		err := rows.Scan(
@@ -439,16 +611,52 @@ func (c *Client) GetFileInfos() ([]FileInfo, error) {
 			&f.Width, &f.Height, &f.HasPreviewImage,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan file info: %w", err)
+			return fmt.Errorf("failed to scan file info: %w", err)
+		}
+		if err := fn(&f); err != nil {
+			return err
 		}
-		files = append(files, f)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating file infos: %w", err)
+		return fmt.Errorf("error iterating file infos: %w", err)
 	}
 
-	return files, nil
+	return nil
+}
+
+// GetEmojis retrieves the custom emojis that haven't been deleted
+func (c *Client) GetEmojis() ([]Emoji, error) {
+	query := `
+		SELECT
+			id, name,
+			COALESCE(creatorid, '') as creatorid,
+			createat, updateat, deleteat
+		FROM Emoji
+		WHERE deleteat = 0
+		ORDER BY name ASC
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emojis: %w", err)
+	}
+	defer rows.Close()
+
+	var emojis []Emoji
+	for rows.Next() {
+		var e Emoji
+		if err := rows.Scan(&e.ID, &e.Name, &e.CreatorID, &e.CreateAt, &e.UpdateAt, &e.DeleteAt); err != nil {
+			return nil, fmt.Errorf("failed to scan emoji: %w", err)
+		}
+		emojis = append(emojis, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating emojis: %w", err)
+	}
+
+	return emojis, nil
 }
 
 // GetFileInfosByPost retrieves file infos for a specific post
@@ -469,8 +677,8 @@ func (c *Client) GetFileInfosByPost(postID string) ([]FileInfo, error) {
 			COALESCE(width, 0) as width,
 			COALESCE(height, 0) as height,
 			COALESCE(haspreviewimage, false) as haspreviewimage
-		FROM fileinfo
-		WHERE postid = $1 AND deleteat = 0
+		FROM FileInfo
+		WHERE postid = ` + c.dialect.placeholder(1) + ` AND deleteat = 0
 		ORDER BY createat ASC
 	`
 
@@ -502,14 +710,14 @@ func (c *Client) GetFileInfosByPost(postID string) ([]FileInfo, error) {
 // GetFileInfoCount returns the total number of files
 func (c *Client) GetFileInfoCount() (int, error) {
 	var count int
-	err := c.db.QueryRow("SELECT COUNT(*) FROM fileinfo WHERE deleteat = 0").Scan(&count)
+	err := c.db.QueryRow("SELECT COUNT(*) FROM FileInfo WHERE deleteat = 0").Scan(&count)
 	return count, err
 }
 
 // GetFileInfoTotalSize returns the total size of all files in bytes
 func (c *Client) GetFileInfoTotalSize() (int64, error) {
 	var size int64
-	err := c.db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM fileinfo WHERE deleteat = 0").Scan(&size)
+	err := c.db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM FileInfo WHERE deleteat = 0").Scan(&size)
 	return size, err
 }
 
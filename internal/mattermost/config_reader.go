@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/aligundogdu/matrixmigrate/internal/config"
@@ -23,6 +25,7 @@ type SqlSettings struct {
 
 // DatabaseCredentials holds parsed database credentials
 type DatabaseCredentials struct {
+	Driver   string // DriverPostgres or DriverMySQL
 	Host     string
 	Port     int
 	Database string
@@ -39,6 +42,18 @@ var DefaultConfigPaths = []string{
 	"./config/config.json",
 }
 
+// DefaultEnvCommand prints the environment of the running Mattermost server
+var DefaultEnvCommand = `pid=$(pgrep -o -x mattermost) && cat /proc/$pid/environ`
+
+// Environment variables Mattermost reads its SQL settings from
+const (
+	envDataSource = "MM_SQLSETTINGS_DATASOURCE"
+	envDriverName = "MM_SQLSETTINGS_DRIVERNAME"
+)
+
+// envPlaceholder matches ${env:VAR} placeholders in config.json values
+var envPlaceholder = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
 // ReadConfigFromRemote reads Mattermost config.json from remote server via SSH
 func ReadConfigFromRemote(sshCfg config.SSHConfig, passphrase, password string, configPath string) (*MattermostConfig, error) {
 	// Create SSH executor
@@ -48,6 +63,12 @@ func ReadConfigFromRemote(sshCfg config.SSHConfig, passphrase, password string,
 	}
 	defer executor.Close()
 
+	return readConfig(executor, configPath)
+}
+
+// readConfig reads config.json at configPath, or at the first default
+// location that exists if configPath is empty
+func readConfig(executor *ssh.RemoteExecutor, configPath string) (*MattermostConfig, error) {
 	// If config path not specified, try default locations
 	paths := []string{configPath}
 	if configPath == "" {
@@ -66,7 +87,7 @@ func ReadConfigFromRemote(sshCfg config.SSHConfig, passphrase, password string,
 			continue
 		}
 		if exists {
-			data, err := executor.ReadFile(path)
+			data, err := executor.ReadFileSFTP(path)
 			if err != nil {
 				continue
 			}
@@ -89,9 +110,29 @@ func ReadConfigFromRemote(sshCfg config.SSHConfig, passphrase, password string,
 	return &mmConfig, nil
 }
 
+// ParseDataSourceForDriver parses the connection string of the given
+// driver from Mattermost config
+func ParseDataSourceForDriver(driver, dataSource string) (*DatabaseCredentials, error) {
+	switch driver {
+	case DriverPostgres:
+		return ParseDataSource(dataSource)
+	case DriverMySQL:
+		creds, err := parseMySQLDataSource(dataSource)
+		if err != nil {
+			return nil, err
+		}
+		if err := creds.validate(); err != nil {
+			return nil, err
+		}
+		return creds, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver: %s (supported: postgres, mysql)", driver)
+}
+
 // ParseDataSource parses the PostgreSQL connection string from Mattermost config
 func ParseDataSource(dataSource string) (*DatabaseCredentials, error) {
 	creds := &DatabaseCredentials{
+		Driver:  DriverPostgres,
 		Port:    5432,
 		SSLMode: "disable",
 	}
@@ -144,38 +185,116 @@ func ParseDataSource(dataSource string) (*DatabaseCredentials, error) {
 		}
 	}
 
-	// Validate
-	if creds.Host == "" {
-		return nil, fmt.Errorf("could not parse host from data source")
+	if err := creds.validate(); err != nil {
+		return nil, err
 	}
-	if creds.Database == "" {
-		return nil, fmt.Errorf("could not parse database name from data source")
+	return creds, nil
+}
+
+// validate checks that the credentials name a host, database and user
+func (c *DatabaseCredentials) validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("could not parse host from data source")
 	}
-	if creds.User == "" {
-		return nil, fmt.Errorf("could not parse user from data source")
+	if c.Database == "" {
+		return fmt.Errorf("could not parse database name from data source")
 	}
-
-	return creds, nil
+	if c.User == "" {
+		return fmt.Errorf("could not parse user from data source")
+	}
+	return nil
 }
 
-// GetDatabaseCredentials reads Mattermost config and returns database credentials
-func GetDatabaseCredentials(sshCfg config.SSHConfig, passphrase, password string, configPath string) (*DatabaseCredentials, error) {
+// GetDatabaseCredentials reads Mattermost config and returns database
+// credentials. SQL settings kept in the environment of the Mattermost server
+// (an empty DataSource or ${env:VAR} placeholders) are read with envCommand,
+// or DefaultEnvCommand if it is empty.
+func GetDatabaseCredentials(sshCfg config.SSHConfig, passphrase, password string, configPath, envCommand string) (*DatabaseCredentials, error) {
+	executor, err := ssh.NewRemoteExecutorWithPassword(sshCfg, passphrase, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+	defer executor.Close()
+
 	// Read config from remote
-	mmConfig, err := ReadConfigFromRemote(sshCfg, passphrase, password, configPath)
+	mmConfig, err := readConfig(executor, configPath)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check driver
-	if mmConfig.SqlSettings.DriverName != "postgres" {
-		return nil, fmt.Errorf("unsupported database driver: %s (only postgres is supported)", mmConfig.SqlSettings.DriverName)
+	settings := mmConfig.SqlSettings
+	if settings.DataSource == "" || envPlaceholder.MatchString(settings.DataSource) {
+		if envCommand == "" {
+			envCommand = DefaultEnvCommand
+		}
+		output, err := executor.ExecuteCommand(envCommand)
+		if err != nil {
+			return nil, fmt.Errorf("SqlSettings.DataSource is set through the environment of the Mattermost server, "+
+				"which could not be read (set mattermost.env_command or mattermost.database): %w", err)
+		}
+		settings, err = resolveEnvSettings(settings, parseEnviron(output))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Parse data source
-	creds, err := ParseDataSource(mmConfig.SqlSettings.DataSource)
+	// Parse data source of the configured driver
+	creds, err := ParseDataSourceForDriver(settings.DriverName, settings.DataSource)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse data source: %w", err)
 	}
 
 	return creds, nil
 }
+
+// resolveEnvSettings fills SQL settings from the environment of the
+// Mattermost server: MM_SQLSETTINGS_* variables override config.json as in
+// Mattermost itself, and ${env:VAR} placeholders are replaced by their values
+func resolveEnvSettings(settings SqlSettings, env map[string]string) (SqlSettings, error) {
+	if driver := env[envDriverName]; driver != "" {
+		settings.DriverName = driver
+	}
+	if dataSource := env[envDataSource]; dataSource != "" {
+		settings.DataSource = dataSource
+	}
+	if settings.DataSource == "" {
+		return settings, fmt.Errorf("SqlSettings.DataSource is empty in config.json and %s is not set on the Mattermost server", envDataSource)
+	}
+
+	missing := make(map[string]bool)
+	settings.DataSource = envPlaceholder.ReplaceAllStringFunc(settings.DataSource, func(placeholder string) string {
+		name := envPlaceholder.FindStringSubmatch(placeholder)[1]
+		value, ok := env[name]
+		if !ok {
+			missing[name] = true
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return settings, fmt.Errorf("SqlSettings.DataSource refers to environment variables not set on the Mattermost server: %s", strings.Join(names, ", "))
+	}
+	return settings, nil
+}
+
+// parseEnviron parses the output of an env command: NUL-separated as in
+// /proc/<pid>/environ, or one KEY=VALUE per line as printed by env
+func parseEnviron(output string) map[string]string {
+	separator := "\n"
+	if strings.Contains(output, "\x00") {
+		separator = "\x00"
+	}
+
+	env := make(map[string]string)
+	for _, entry := range strings.Split(output, separator) {
+		key, value, ok := strings.Cut(entry, "=")
+		if ok && key != "" {
+			env[key] = value
+		}
+	}
+	return env
+}
@@ -0,0 +1,166 @@
+package mattermost
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Database drivers, as in SqlSettings.DriverName of the Mattermost config
+const (
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// dialect holds the SQL differences between the supported databases.
+// Table names are written as in the Mattermost schema (e.g. ChannelMembers):
+// MySQL table names are case sensitive, PostgreSQL folds them to lower case.
+type dialect struct {
+	driver string
+}
+
+// newDialect returns the dialect of a driver
+func newDialect(driver string) (dialect, error) {
+	switch driver {
+	case DriverPostgres, DriverMySQL:
+		return dialect{driver: driver}, nil
+	}
+	return dialect{}, fmt.Errorf("unsupported database driver: %s (supported: postgres, mysql)", driver)
+}
+
+// jsonText returns an expression reading a JSON column as text
+func (d dialect) jsonText(column string) string {
+	if d.driver == DriverPostgres {
+		return column + "::text"
+	}
+	return column
+}
+
+// placeholder returns the placeholder of the n-th query argument (1-based)
+func (d dialect) placeholder(n int) string {
+	if d.driver == DriverPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// inList returns "column IN (...)" with a placeholder for each value and
+// appends the values to args
+func (d dialect) inList(column string, values []string, args *[]interface{}) string {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		*args = append(*args, value)
+		placeholders[i] = d.placeholder(len(*args))
+	}
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// TLSOptions holds the TLS settings of a database connection
+type TLSOptions struct {
+	// Mode is a PostgreSQL sslmode (disable, require, verify-ca, ...) or a
+	// MySQL tls value (true, skip-verify, preferred); empty disables TLS
+	Mode string
+	// RootCert is a local CA certificate file to verify the server with
+	RootCert string
+	// ServerName is the name the server certificate is issued for; the
+	// connection itself goes to the local end of a tunnel
+	ServerName string
+}
+
+// mysqlTLSConfigName is the name the verifying MySQL TLS config is registered under
+const mysqlTLSConfigName = "matrixmigrate"
+
+// BuildDSN returns the connection string for a database reached at host:port
+func BuildDSN(driver, host string, port int, user, password, database string, tlsOpts TLSOptions) (string, error) {
+	switch driver {
+	case DriverPostgres:
+		sslMode := tlsOpts.Mode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		dsn := fmt.Sprintf(
+			"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			host, port, user, password, database, sslMode,
+		)
+		if tlsOpts.RootCert != "" {
+			dsn += " sslrootcert=" + tlsOpts.RootCert
+		}
+		return dsn, nil
+	case DriverMySQL:
+		cfg := mysql.NewConfig()
+		cfg.User = user
+		cfg.Passwd = password
+		cfg.Net = "tcp"
+		cfg.Addr = fmt.Sprintf("%s:%d", host, port)
+		cfg.DBName = database
+		tlsConfig, err := mysqlTLSConfig(tlsOpts)
+		if err != nil {
+			return "", err
+		}
+		cfg.TLSConfig = tlsConfig
+		return cfg.FormatDSN(), nil
+	}
+	return "", fmt.Errorf("unsupported database driver: %s (supported: postgres, mysql)", driver)
+}
+
+// mysqlTLSConfig returns the tls parameter of a MySQL DSN for tlsOpts.
+// PostgreSQL modes are accepted too. Verifying modes register a TLS config
+// that checks the certificate against ServerName instead of the tunnel address.
+func mysqlTLSConfig(tlsOpts TLSOptions) (string, error) {
+	switch tlsOpts.Mode {
+	case "", "disable", "false":
+		return "", nil
+	case "allow", "prefer", "preferred":
+		return "preferred", nil
+	case "require", "skip-verify":
+		return "skip-verify", nil
+	}
+
+	// true, verify-ca, verify-full or a custom config name of the Mattermost server
+	config := &tls.Config{ServerName: tlsOpts.ServerName}
+	if tlsOpts.RootCert != "" {
+		pem, err := os.ReadFile(tlsOpts.RootCert)
+		if err != nil {
+			return "", fmt.Errorf("failed to read database CA certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificates found in %s", tlsOpts.RootCert)
+		}
+	}
+	if err := mysql.RegisterTLSConfig(mysqlTLSConfigName, config); err != nil {
+		return "", fmt.Errorf("failed to register MySQL TLS config: %w", err)
+	}
+	return mysqlTLSConfigName, nil
+}
+
+// parseMySQLDataSource parses a MySQL DataSource of the Mattermost config,
+// e.g. mmuser:password@tcp(localhost:3306)/mattermost?charset=utf8mb4
+func parseMySQLDataSource(dataSource string) (*DatabaseCredentials, error) {
+	cfg, err := mysql.ParseDSN(strings.TrimPrefix(dataSource, "mysql://"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data source: %w", err)
+	}
+
+	creds := &DatabaseCredentials{
+		Driver:   DriverMySQL,
+		Port:     3306,
+		User:     cfg.User,
+		Password: cfg.Passwd,
+		Database: cfg.DBName,
+	}
+	if cfg.Net != "tcp" {
+		return nil, fmt.Errorf("unsupported MySQL connection type %q (only tcp can be tunneled)", cfg.Net)
+	}
+	host, port, found := strings.Cut(cfg.Addr, ":")
+	creds.Host = host
+	if found {
+		fmt.Sscanf(port, "%d", &creds.Port)
+	}
+	creds.SSLMode = cfg.TLSConfig
+	return creds, nil
+}
@@ -1,13 +1,18 @@
 ﻿package mattermost
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Exporter handles exporting data from Mattermost
 type Exporter struct {
 	client *Client
+	filter AssetFilter // Teams and channels to export (zero: all)
 }
 
 // NewExporter creates a new exporter
@@ -15,6 +20,12 @@ func NewExporter(client *Client) *Exporter {
 	return &Exporter{client: client}
 }
 
+// NewExporterWithFilter creates an exporter that exports only the teams and
+// channels selected by filter, and their memberships
+func NewExporterWithFilter(client *Client, filter AssetFilter) *Exporter {
+	return &Exporter{client: client, filter: filter}
+}
+
 // ExportProgressCallback is called to report export progress
 type ExportProgressCallback func(stage string, current, total int)
 
@@ -25,46 +36,100 @@ func (e *Exporter) ExportAssets(progress ExportProgressCallback) (*Assets, error
 		Version:    "1.0",
 	}
 
-	// Export users
+	users, err := e.ExportUsers(progress)
+	if err != nil {
+		return nil, err
+	}
+	assets.Users = users
+
+	teams, err := e.ExportTeams(progress)
+	if err != nil {
+		return nil, err
+	}
+	assets.Teams = teams
+
+	channels, err := e.ExportChannels(progress)
+	if err != nil {
+		return nil, err
+	}
+	assets.Channels = channels
+
+	return assets, nil
+}
+
+// ExportUsers exports all users, or those changed since the filter's Since
+func (e *Exporter) ExportUsers(progress ExportProgressCallback) ([]User, error) {
 	if progress != nil {
 		progress("users", 0, 0)
 	}
-	users, err := e.client.GetUsers()
+	users, err := e.client.GetUsers(e.filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export users: %w", err)
 	}
-	assets.Users = users
 	if progress != nil {
 		progress("users", len(users), len(users))
 	}
+	return users, nil
+}
 
-	// Export teams
+// ExportTeams exports all teams selected by the exporter's filter
+func (e *Exporter) ExportTeams(progress ExportProgressCallback) ([]Team, error) {
 	if progress != nil {
 		progress("teams", 0, 0)
 	}
-	teams, err := e.client.GetTeams()
+	teams, err := e.client.GetTeams(e.filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export teams: %w", err)
 	}
-	assets.Teams = teams
 	if progress != nil {
 		progress("teams", len(teams), len(teams))
 	}
+	return teams, nil
+}
 
-	// Export channels
+// ExportChannels exports all channels selected by the exporter's filter
+func (e *Exporter) ExportChannels(progress ExportProgressCallback) ([]Channel, error) {
 	if progress != nil {
 		progress("channels", 0, 0)
 	}
-	channels, err := e.client.GetChannels()
+	channels, err := e.client.GetChannels(e.filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export channels: %w", err)
 	}
-	assets.Channels = channels
+	if e.filter.DirectMessages {
+		if err := e.addDirectMembers(channels); err != nil {
+			return nil, err
+		}
+	}
 	if progress != nil {
 		progress("channels", len(channels), len(channels))
 	}
+	return channels, nil
+}
+
+// addDirectMembers sets the members of the direct and group messages among
+// channels, so they can be imported without the membership export
+func (e *Exporter) addDirectMembers(channels []Channel) error {
+	direct := make(map[string]*Channel)
+	for idx := range channels {
+		if channels[idx].IsDirect() || channels[idx].IsGroup() {
+			direct[channels[idx].ID] = &channels[idx]
+		}
+	}
+	if len(direct) == 0 {
+		return nil
+	}
 
-	return assets, nil
+	members, err := e.client.GetChannelMembers(e.filter)
+	if err != nil {
+		return fmt.Errorf("failed to export direct message members: %w", err)
+	}
+	for _, member := range members {
+		if channel, ok := direct[member.ChannelID]; ok {
+			channel.Members = append(channel.Members, member.UserID)
+		}
+	}
+	return nil
 }
 
 // ExportMemberships exports all memberships (team and channel members)
@@ -74,12 +139,26 @@ func (e *Exporter) ExportMemberships(progress ExportProgressCallback) (*Membersh
 		Version:    "1.0",
 	}
 
+	// With a second connection, channel members are read while team
+	// members are; progress is still reported from this goroutine
+	var channelMembers []ChannelMember
+	var channelErr error
+	var wg sync.WaitGroup
+	if e.client.MaxOpenConns() > 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			channelMembers, channelErr = e.client.GetChannelMembers(e.filter)
+		}()
+	}
+
 	// Export team members
 	if progress != nil {
 		progress("team_members", 0, 0)
 	}
-	teamMembers, err := e.client.GetTeamMembers()
+	teamMembers, err := e.client.GetTeamMembers(e.filter)
 	if err != nil {
+		wg.Wait()
 		return nil, fmt.Errorf("failed to export team members: %w", err)
 	}
 	memberships.TeamMembers = teamMembers
@@ -91,9 +170,13 @@ func (e *Exporter) ExportMemberships(progress ExportProgressCallback) (*Membersh
 	if progress != nil {
 		progress("channel_members", 0, 0)
 	}
-	channelMembers, err := e.client.GetChannelMembers()
-	if err != nil {
-		return nil, fmt.Errorf("failed to export channel members: %w", err)
+	if e.client.MaxOpenConns() > 1 {
+		wg.Wait()
+	} else {
+		channelMembers, channelErr = e.client.GetChannelMembers(e.filter)
+	}
+	if channelErr != nil {
+		return nil, fmt.Errorf("failed to export channel members: %w", channelErr)
 	}
 	memberships.ChannelMembers = channelMembers
 	if progress != nil {
@@ -103,19 +186,20 @@ func (e *Exporter) ExportMemberships(progress ExportProgressCallback) (*Membersh
 	return memberships, nil
 }
 
-// GetCounts returns the counts of all entities
+// GetCounts returns the counts of all users and of the teams and channels
+// selected by the exporter's filter
 func (e *Exporter) GetCounts() (users, teams, channels int, err error) {
 	users, err = e.client.GetUserCount()
 	if err != nil {
 		return 0, 0, 0, fmt.Errorf("failed to get user count: %w", err)
 	}
 
-	teams, err = e.client.GetTeamCount()
+	teams, err = e.client.GetTeamCount(e.filter)
 	if err != nil {
 		return 0, 0, 0, fmt.Errorf("failed to get team count: %w", err)
 	}
 
-	channels, err = e.client.GetChannelCount()
+	channels, err = e.client.GetChannelCount(e.filter)
 	if err != nil {
 		return 0, 0, 0, fmt.Errorf("failed to get channel count: %w", err)
 	}
@@ -125,25 +209,33 @@ func (e *Exporter) GetCounts() (users, teams, channels int, err error) {
 
 // FilterActiveAssets filters out deleted items from assets
 func FilterActiveAssets(assets *Assets) *Assets {
+	return FilterAssets(assets, false, false)
+}
+
+// FilterAssets filters out deleted items from assets.
+// If includeDeleted is true, deleted users are kept so they can be
+// imported as deactivated accounts. If includeArchived is true, deleted
+// teams and channels are kept so they can be imported as read-only rooms.
+func FilterAssets(assets *Assets, includeDeleted, includeArchived bool) *Assets {
 	filtered := &Assets{
 		ExportedAt: assets.ExportedAt,
 		Version:    assets.Version,
 	}
 
 	for _, u := range assets.Users {
-		if !u.IsDeleted() {
+		if includeDeleted || !u.IsDeleted() {
 			filtered.Users = append(filtered.Users, u)
 		}
 	}
 
 	for _, t := range assets.Teams {
-		if !t.IsDeleted() {
+		if includeArchived || !t.IsDeleted() {
 			filtered.Teams = append(filtered.Teams, t)
 		}
 	}
 
 	for _, c := range assets.Channels {
-		if !c.IsDeleted() {
+		if includeArchived || !c.IsDeleted() {
 			filtered.Channels = append(filtered.Channels, c)
 		}
 	}
@@ -170,15 +262,33 @@ func FilterActiveMemberships(memberships *Memberships) *Memberships {
 	return filtered
 }
 
-// ExportMessages exports all messages (posts) and file attachments
+// ExportMessages exports all messages (posts) and file attachments of the
+// channels selected by the exporter's filter
 func (e *Exporter) ExportMessages(progress ExportProgressCallback) (*Messages, error) {
+	return e.ExportMessagesFiltered(PostFilter{}, progress)
+}
+
+// postFilter limits filter to the channels selected by the exporter's filter
+func (e *Exporter) postFilter(filter PostFilter) PostFilter {
+	filter.Teams = e.filter.Teams
+	filter.Channels = e.filter.Channels
+	return filter
+}
+
+// ExportMessagesFiltered exports the messages created within the filter's
+// range in the channels selected by the exporter's filter, with the files
+// attached to them
+func (e *Exporter) ExportMessagesFiltered(filter PostFilter, progress ExportProgressCallback) (*Messages, error) {
+	filter = e.postFilter(filter)
 	messages := &Messages{
 		ExportedAt: time.Now().UnixMilli(),
 		Version:    "1.0",
+		Since:      filter.Since,
+		Until:      filter.Until,
 	}
 
 	// Get total count first
-	totalCount, err := e.client.GetPostCount()
+	totalCount, err := e.client.GetPostCount(filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get post count: %w", err)
 	}
@@ -188,7 +298,7 @@ func (e *Exporter) ExportMessages(progress ExportProgressCallback) (*Messages, e
 	}
 
 	// Export posts
-	posts, err := e.client.GetPosts()
+	posts, err := e.client.GetPostsFiltered(filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export posts: %w", err)
 	}
@@ -208,18 +318,250 @@ func (e *Exporter) ExportMessages(progress ExportProgressCallback) (*Messages, e
 		// Non-fatal: continue without files
 		// Some Mattermost installations might not have files
 	} else {
+		// Keep only the files of exported posts when limited to a range
+		if !filter.IsZero() {
+			exported := make(map[string]bool, len(posts))
+			for _, p := range posts {
+				exported[p.ID] = true
+			}
+			kept := files[:0]
+			for _, f := range files {
+				if exported[f.PostID] {
+					kept = append(kept, f)
+				}
+			}
+			files = kept
+		}
 		messages.Files = files
 		if progress != nil {
 			progress("files", len(files), len(files))
 		}
 	}
 
+	// Export custom emojis; like files, they are optional
+	emojis, err := e.client.GetEmojis()
+	if err == nil {
+		messages.Emojis = emojis
+	}
+
 	return messages, nil
 }
 
-// GetMessageCount returns the total number of messages
+// MessageWriter receives the messages of a streaming export one at a time:
+// all posts first, then the files attached to them, then the custom emojis
+type MessageWriter interface {
+	WritePost(post *Post) error
+	WriteFile(file *FileInfo) error
+	WriteEmoji(emoji *Emoji) error
+}
+
+// MessageExportCounts counts what a streaming message export wrote
+type MessageExportCounts struct {
+	Posts         int
+	PostsExcluded int // Posts of excluded users, left out with their files
+	Files         int
+	Emojis        int
+}
+
+// streamProgressInterval is how many posts are streamed between progress reports
+const streamProgressInterval = 1000
+
+// StreamMessagesFiltered exports the same messages as ExportMessagesFiltered
+// but hands them to w as they are read instead of collecting them, so memory
+// use doesn't grow with the number of posts. Posts of the users in
+// excludedUserIDs are left out, with their files.
+func (e *Exporter) StreamMessagesFiltered(filter PostFilter, excludedUserIDs map[string]bool, w MessageWriter, progress ExportProgressCallback) (*MessageExportCounts, error) {
+	filter = e.postFilter(filter)
+	counts := &MessageExportCounts{}
+
+	// Get total count first
+	totalCount, err := e.client.GetPostCount(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post count: %w", err)
+	}
+
+	if progress != nil {
+		progress("messages", 0, totalCount)
+	}
+
+	// Export posts, from several channels at once if the client allows
+	// more than one connection
+	excludedPosts := make(map[string]bool)
+	err = e.forEachPost(filter, func(p *Post) error {
+		if excludedUserIDs[p.UserID] {
+			excludedPosts[p.ID] = true
+			counts.PostsExcluded++
+			return nil
+		}
+		if err := w.WritePost(p); err != nil {
+			return err
+		}
+		counts.Posts++
+		if progress != nil && counts.Posts%streamProgressInterval == 0 {
+			progress("messages", counts.Posts, totalCount)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export posts: %w", err)
+	}
+
+	if progress != nil {
+		progress("messages", counts.Posts, totalCount)
+	}
+
+	// Export file infos; reading them is optional, like in ExportMessagesFiltered,
+	// but failing to write them is not
+	if progress != nil {
+		progress("files", 0, 0)
+	}
+
+	var writeErr error
+	err = e.client.ForEachFileInfo(filter, func(f *FileInfo) error {
+		if excludedPosts[f.PostID] {
+			return nil
+		}
+		if writeErr = w.WriteFile(f); writeErr != nil {
+			return writeErr
+		}
+		counts.Files++
+		return nil
+	})
+	if writeErr != nil {
+		return nil, fmt.Errorf("failed to export files: %w", writeErr)
+	}
+	if err == nil && progress != nil {
+		progress("files", counts.Files, counts.Files)
+	}
+
+	// Export custom emojis; like files, they are optional
+	emojis, err := e.client.GetEmojis()
+	if err == nil {
+		for idx := range emojis {
+			if err := w.WriteEmoji(&emojis[idx]); err != nil {
+				return nil, fmt.Errorf("failed to export emojis: %w", err)
+			}
+			counts.Emojis++
+		}
+	}
+
+	return counts, nil
+}
+
+// errExportStopped stops the export workers after the consumer failed
+var errExportStopped = errors.New("export stopped")
+
+// forEachPost passes the posts selected by filter to fn. With one database
+// connection they are read in a single query, oldest first; otherwise a
+// bounded pool of workers reads channels concurrently. Each channel's posts
+// still arrive oldest first, but channels are interleaved. fn is always
+// called from the calling goroutine.
+func (e *Exporter) forEachPost(filter PostFilter, fn func(*Post) error) error {
+	workers := e.client.MaxOpenConns()
+	if workers <= 1 {
+		return e.client.ForEachPost(filter, fn)
+	}
+
+	counts, err := e.client.GetPostCountByChannel(filter)
+	if err != nil {
+		return err
+	}
+	channelIDs := make([]string, 0, len(counts))
+	for channelID := range counts {
+		channelIDs = append(channelIDs, channelID)
+	}
+	// Start with the largest channels so no worker is left with one at the end
+	sort.Slice(channelIDs, func(a, b int) bool {
+		if counts[channelIDs[a]] != counts[channelIDs[b]] {
+			return counts[channelIDs[a]] > counts[channelIDs[b]]
+		}
+		return channelIDs[a] < channelIDs[b]
+	})
+	if workers > len(channelIDs) {
+		workers = len(channelIDs)
+	}
+
+	jobs := make(chan string)
+	posts := make(chan Post, workers*streamProgressInterval)
+	stop := make(chan struct{})
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for channelID := range jobs {
+				err := e.client.ForEachPostInChannel(channelID, filter, func(p *Post) error {
+					select {
+					case posts <- *p:
+						return nil
+					case <-stop:
+						return errExportStopped
+					}
+				})
+				if err != nil {
+					if !errors.Is(err, errExportStopped) {
+						errs <- fmt.Errorf("channel %s: %w", channelID, err)
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, channelID := range channelIDs {
+			select {
+			case jobs <- channelID:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(posts)
+	}()
+
+	var stopOnce sync.Once
+	halt := func() { stopOnce.Do(func() { close(stop) }) }
+	defer halt()
+
+	// drain stops the workers and waits for them to finish
+	drain := func() {
+		halt()
+		for range posts {
+		}
+	}
+
+	for {
+		select {
+		case err := <-errs:
+			drain()
+			return err
+		case p, ok := <-posts:
+			if !ok {
+				select {
+				case err := <-errs:
+					return err
+				default:
+					return nil
+				}
+			}
+			if err := fn(&p); err != nil {
+				drain()
+				return err
+			}
+		}
+	}
+}
+
+// GetMessageCount returns the number of messages in the channels selected
+// by the exporter's filter
 func (e *Exporter) GetMessageCount() (int, error) {
-	return e.client.GetPostCount()
+	return e.client.GetPostCount(e.postFilter(PostFilter{}))
 }
 
 // GetFileCount returns the total number of files
@@ -227,6 +569,169 @@ func (e *Exporter) GetFileCount() (int, error) {
 	return e.client.GetFileInfoCount()
 }
 
+// UserFilter matches users excluded from the migration by username or email
+type UserFilter struct {
+	entries map[string]bool
+}
+
+// NewUserFilter creates a filter from a list of usernames and emails.
+// Matching is case-insensitive and a leading '@' on usernames is ignored.
+// Returns nil if the list is empty.
+func NewUserFilter(entries []string) *UserFilter {
+	f := &UserFilter{entries: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry), "@"))
+		if entry != "" {
+			f.entries[entry] = true
+		}
+	}
+	if len(f.entries) == 0 {
+		return nil
+	}
+	return f
+}
+
+// Excludes returns true if the user matches the filter
+func (f *UserFilter) Excludes(u User) bool {
+	if f == nil {
+		return false
+	}
+	return f.entries[strings.ToLower(u.Username)] ||
+		(u.Email != "" && f.entries[strings.ToLower(u.Email)])
+}
+
+// ExcludedIDs returns the IDs of the users matching the filter
+func (f *UserFilter) ExcludedIDs(users []User) map[string]bool {
+	ids := make(map[string]bool)
+	for _, u := range users {
+		if f.Excludes(u) {
+			ids[u.ID] = true
+		}
+	}
+	return ids
+}
+
+// ExcludeUsers removes the users matching the filter from assets.
+// Returns the number of users removed.
+func ExcludeUsers(assets *Assets, filter *UserFilter) int {
+	kept := assets.Users[:0]
+	for _, u := range assets.Users {
+		if !filter.Excludes(u) {
+			kept = append(kept, u)
+		}
+	}
+	excluded := len(assets.Users) - len(kept)
+	assets.Users = kept
+	return excluded
+}
+
+// ExcludeUserMemberships removes the team and channel memberships of the
+// given users. Returns the number of memberships removed.
+func ExcludeUserMemberships(memberships *Memberships, userIDs map[string]bool) int {
+	if len(userIDs) == 0 {
+		return 0
+	}
+	excluded := 0
+
+	teamMembers := memberships.TeamMembers[:0]
+	for _, tm := range memberships.TeamMembers {
+		if userIDs[tm.UserID] {
+			excluded++
+			continue
+		}
+		teamMembers = append(teamMembers, tm)
+	}
+	memberships.TeamMembers = teamMembers
+
+	channelMembers := memberships.ChannelMembers[:0]
+	for _, cm := range memberships.ChannelMembers {
+		if userIDs[cm.UserID] {
+			excluded++
+			continue
+		}
+		channelMembers = append(channelMembers, cm)
+	}
+	memberships.ChannelMembers = channelMembers
 
+	return excluded
+}
 
+// DiffMemberships compares a membership export with an earlier one. added
+// holds the memberships that are new, or whose member became an admin;
+// removed holds the earlier memberships that are gone or deleted now.
+func DiffMemberships(previous, current *Memberships) (added, removed *Memberships) {
+	added = &Memberships{ExportedAt: current.ExportedAt, Version: current.Version}
+	removed = &Memberships{ExportedAt: current.ExportedAt, Version: current.Version}
 
+	previousTeams := make(map[[2]string]TeamMember, len(previous.TeamMembers))
+	for _, tm := range previous.TeamMembers {
+		if !tm.IsDeleted() {
+			previousTeams[[2]string{tm.TeamID, tm.UserID}] = tm
+		}
+	}
+	currentTeams := make(map[[2]string]bool, len(current.TeamMembers))
+	for _, tm := range current.TeamMembers {
+		if tm.IsDeleted() {
+			continue
+		}
+		key := [2]string{tm.TeamID, tm.UserID}
+		currentTeams[key] = true
+		if before, ok := previousTeams[key]; !ok || (tm.IsAdmin() && !before.IsAdmin()) {
+			added.TeamMembers = append(added.TeamMembers, tm)
+		}
+	}
+	for _, tm := range previous.TeamMembers {
+		if !tm.IsDeleted() && !currentTeams[[2]string{tm.TeamID, tm.UserID}] {
+			removed.TeamMembers = append(removed.TeamMembers, tm)
+		}
+	}
+
+	previousChannels := make(map[[2]string]ChannelMember, len(previous.ChannelMembers))
+	for _, cm := range previous.ChannelMembers {
+		previousChannels[[2]string{cm.ChannelID, cm.UserID}] = cm
+	}
+	currentChannels := make(map[[2]string]bool, len(current.ChannelMembers))
+	for _, cm := range current.ChannelMembers {
+		key := [2]string{cm.ChannelID, cm.UserID}
+		currentChannels[key] = true
+		if before, ok := previousChannels[key]; !ok || (cm.IsAdmin() && !before.IsAdmin()) {
+			added.ChannelMembers = append(added.ChannelMembers, cm)
+		}
+	}
+	for _, cm := range previous.ChannelMembers {
+		if !currentChannels[[2]string{cm.ChannelID, cm.UserID}] {
+			removed.ChannelMembers = append(removed.ChannelMembers, cm)
+		}
+	}
+
+	return added, removed
+}
+
+// ExcludeUserPosts removes the posts of the given users, and the files
+// attached to them. Returns the number of posts removed.
+func ExcludeUserPosts(messages *Messages, userIDs map[string]bool) int {
+	if len(userIDs) == 0 {
+		return 0
+	}
+
+	removedPosts := make(map[string]bool)
+	posts := messages.Posts[:0]
+	for _, p := range messages.Posts {
+		if userIDs[p.UserID] {
+			removedPosts[p.ID] = true
+			continue
+		}
+		posts = append(posts, p)
+	}
+	messages.Posts = posts
+
+	files := messages.Files[:0]
+	for _, f := range messages.Files {
+		if !removedPosts[f.PostID] {
+			files = append(files, f)
+		}
+	}
+	messages.Files = files
+
+	return len(removedPosts)
+}
@@ -0,0 +1,73 @@
+package mattermost
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+	"github.com/aligundogdu/matrixmigrate/internal/ssh"
+)
+
+// ErrFileNotFound is returned by FileStore for an attachment that isn't in
+// the file storage or has an invalid path; reading it again won't help
+var ErrFileNotFound = errors.New("file not found")
+
+// FileStore reads attachment contents from the local file storage of a
+// Mattermost server (FileSettings.Directory) over SSH
+type FileStore struct {
+	executor *ssh.RemoteExecutor
+	dataPath string
+}
+
+// OpenFileStore connects to the Mattermost server to read files below
+// dataPath. Files larger than maxSize bytes are refused.
+func OpenFileStore(sshCfg config.SSHConfig, passphrase, password, dataPath string, maxSize int64) (*FileStore, error) {
+	if dataPath == "" {
+		return nil, fmt.Errorf("mattermost.files.local_data_path is not set")
+	}
+
+	executor, err := ssh.NewRemoteExecutorWithPassword(sshCfg, passphrase, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+	executor.SetLimits(0, maxSize)
+
+	return &FileStore{executor: executor, dataPath: dataPath}, nil
+}
+
+// ReadFile returns the contents of an attachment
+func (s *FileStore) ReadFile(file *FileInfo) ([]byte, error) {
+	// FileInfo paths are relative to the data directory; never leave it
+	if file.Path == "" || strings.Contains(file.Path, "..") {
+		return nil, fmt.Errorf("%w: file %s has an invalid path %q", ErrFileNotFound, file.ID, file.Path)
+	}
+
+	data, err := s.executor.ReadFile(path.Join(s.dataPath, file.Path))
+	if errors.Is(err, ssh.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: file %s (%s)", ErrFileNotFound, file.ID, file.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s (%s): %w", file.ID, file.Path, err)
+	}
+	return data, nil
+}
+
+// ReadEmoji returns the image of a custom emoji
+func (s *FileStore) ReadEmoji(emoji *Emoji) ([]byte, error) {
+	if emoji.ID == "" || strings.ContainsAny(emoji.ID, "/.") {
+		return nil, fmt.Errorf("emoji %q has an invalid ID %q", emoji.Name, emoji.ID)
+	}
+
+	data, err := s.executor.ReadFile(path.Join(s.dataPath, emoji.ImagePath()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read emoji %s: %w", emoji.Name, err)
+	}
+	return data, nil
+}
+
+// Close closes the SSH connection
+func (s *FileStore) Close() error {
+	return s.executor.Close()
+}
@@ -0,0 +1,151 @@
+package mattermost
+
+import (
+	"fmt"
+	"time"
+)
+
+// AssetSource is one asset export taking part in a merge
+type AssetSource struct {
+	Name   string // Shown in collisions, usually the file name
+	Prefix string // Prepended to every ID of this source (optional)
+	Assets *Assets
+}
+
+// MergeCollision is a record ID used by different records in two sources
+type MergeCollision struct {
+	Kind   string // "user", "team" or "channel"
+	ID     string
+	First  string // Source the ID was first seen in
+	Second string
+}
+
+// String describes the collision
+func (c MergeCollision) String() string {
+	return fmt.Sprintf("%s %s exists in %s and %s", c.Kind, c.ID, c.First, c.Second)
+}
+
+// MergeReport describes the outcome of MergeAssets
+type MergeReport struct {
+	Duplicates    int              // Records present in several sources, kept once
+	Collisions    []MergeCollision // Different records sharing an ID
+	NameConflicts []string         // Usernames or team names used by several records
+}
+
+// MergeAssets merges asset exports of several Mattermost instances into one.
+//
+// Records are matched by ID. The same record exported more than once (same
+// ID and creation time) is kept once, in its most recently updated version.
+// Different records sharing an ID are reported as collisions and left out;
+// giving the sources prefixes avoids them. A prefix is applied to all IDs of
+// a source, including channel team IDs, so membership and message exports
+// of that source no longer refer to the merged records.
+func MergeAssets(sources []AssetSource) (*Assets, *MergeReport) {
+	merged := &Assets{
+		ExportedAt: time.Now().UnixMilli(),
+		Version:    "1.0",
+	}
+	report := &MergeReport{}
+
+	userIdx := make(map[string]int)
+	userSource := make(map[string]string)
+	teamIdx := make(map[string]int)
+	teamSource := make(map[string]string)
+	channelIdx := make(map[string]int)
+	channelSource := make(map[string]string)
+
+	for _, src := range sources {
+		for _, user := range src.Assets.Users {
+			user.ID = src.Prefix + user.ID
+			if i, ok := userIdx[user.ID]; ok {
+				existing := &merged.Users[i]
+				if existing.CreateAt != user.CreateAt {
+					report.Collisions = append(report.Collisions, MergeCollision{"user", user.ID, userSource[user.ID], src.Name})
+					continue
+				}
+				report.Duplicates++
+				if user.UpdateAt > existing.UpdateAt {
+					*existing = user
+				}
+				continue
+			}
+			userIdx[user.ID] = len(merged.Users)
+			userSource[user.ID] = src.Name
+			merged.Users = append(merged.Users, user)
+		}
+
+		for _, team := range src.Assets.Teams {
+			team.ID = src.Prefix + team.ID
+			if i, ok := teamIdx[team.ID]; ok {
+				existing := &merged.Teams[i]
+				if existing.CreateAt != team.CreateAt {
+					report.Collisions = append(report.Collisions, MergeCollision{"team", team.ID, teamSource[team.ID], src.Name})
+					continue
+				}
+				report.Duplicates++
+				if team.UpdateAt > existing.UpdateAt {
+					*existing = team
+				}
+				continue
+			}
+			teamIdx[team.ID] = len(merged.Teams)
+			teamSource[team.ID] = src.Name
+			merged.Teams = append(merged.Teams, team)
+		}
+
+		for _, channel := range src.Assets.Channels {
+			channel.ID = src.Prefix + channel.ID
+			if channel.TeamID != "" {
+				channel.TeamID = src.Prefix + channel.TeamID
+			}
+			if channel.CreatorID != "" {
+				channel.CreatorID = src.Prefix + channel.CreatorID
+			}
+			if src.Prefix != "" && len(channel.Members) > 0 {
+				// A new slice, so the source's channel keeps its members
+				members := make([]string, len(channel.Members))
+				for i, userID := range channel.Members {
+					members[i] = src.Prefix + userID
+				}
+				channel.Members = members
+			}
+			if i, ok := channelIdx[channel.ID]; ok {
+				existing := &merged.Channels[i]
+				if existing.CreateAt != channel.CreateAt {
+					report.Collisions = append(report.Collisions, MergeCollision{"channel", channel.ID, channelSource[channel.ID], src.Name})
+					continue
+				}
+				report.Duplicates++
+				if channel.UpdateAt > existing.UpdateAt {
+					*existing = channel
+				}
+				continue
+			}
+			channelIdx[channel.ID] = len(merged.Channels)
+			channelSource[channel.ID] = src.Name
+			merged.Channels = append(merged.Channels, channel)
+		}
+	}
+
+	// Distinct records with the same name would map to the same Matrix user or alias
+	usernames := make(map[string]string)
+	for _, user := range merged.Users {
+		if other, ok := usernames[user.Username]; ok {
+			report.NameConflicts = append(report.NameConflicts,
+				fmt.Sprintf("username %q is used by users %s and %s", user.Username, other, user.ID))
+			continue
+		}
+		usernames[user.Username] = user.ID
+	}
+	teamNames := make(map[string]string)
+	for _, team := range merged.Teams {
+		if other, ok := teamNames[team.Name]; ok {
+			report.NameConflicts = append(report.NameConflicts,
+				fmt.Sprintf("team name %q is used by teams %s and %s", team.Name, other, team.ID))
+			continue
+		}
+		teamNames[team.Name] = team.ID
+	}
+
+	return merged, report
+}
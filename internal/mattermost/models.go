@@ -1,6 +1,11 @@
 ﻿package mattermost
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // User represents a Mattermost user
 type User struct {
@@ -17,6 +22,8 @@ type User struct {
 	UpdateAt  int64     `json:"update_at" db:"updateat"`
 	DeleteAt  int64     `json:"delete_at" db:"deleteat"`
 	Roles     string    `json:"roles" db:"roles"`
+	AuthService string  `json:"auth_service,omitempty" db:"authservice"` // SSO service, e.g. "gitlab" or "openid"; empty for email login
+	AuthData    string  `json:"auth_data,omitempty" db:"authdata"`       // User ID at the SSO service
 }
 
 // IsDeleted returns true if the user is deleted
@@ -56,6 +63,12 @@ func (t *Team) IsOpen() bool {
 	return t.Type == "O"
 }
 
+// IsOpenInvite returns true if any user of the server may join the team
+// without an invite, possibly only those with an email in AllowedDomains
+func (t *Team) IsOpenInvite() bool {
+	return t.AllowOpenInvite || t.IsOpen()
+}
+
 // Channel represents a Mattermost channel
 type Channel struct {
 	ID          string `json:"id" db:"id"`
@@ -70,6 +83,7 @@ type Channel struct {
 	DeleteAt    int64  `json:"delete_at" db:"deleteat"`
 	CreatorID   string `json:"creator_id" db:"creatorid"`
 	TotalMsgCount int64 `json:"total_msg_count" db:"totalmsgcount"`
+	Members     []string `json:"members,omitempty"` // User IDs of a direct or group message's participants, if exported
 }
 
 // IsDeleted returns true if the channel is deleted
@@ -138,6 +152,10 @@ type Assets struct {
 	Users      []User    `json:"users"`
 	Teams      []Team    `json:"teams"`
 	Channels   []Channel `json:"channels"`
+
+	// Since is set for a delta export: only users and channels created or
+	// updated at or after it (Unix milliseconds) are included
+	Since int64 `json:"since,omitempty"`
 }
 
 // Memberships represents all membership data from Mattermost
@@ -208,6 +226,7 @@ type Post struct {
 	Type      string `json:"type" db:"type"`           // "" for normal, "system_*" for system messages
 	Props     string `json:"props" db:"props"`         // JSON string with additional properties
 	FileIDs   string `json:"file_ids" db:"fileids"`    // JSON array of file IDs
+	IsPinned  bool   `json:"is_pinned,omitempty" db:"ispinned"`
 }
 
 // FileInfo represents a Mattermost file attachment
@@ -282,6 +301,21 @@ func (f *FileInfo) CreatedTime() time.Time {
 	return time.UnixMilli(f.CreateAt)
 }
 
+// Emoji is a custom emoji uploaded to Mattermost
+type Emoji struct {
+	ID        string `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"` // Used as :name: in messages
+	CreatorID string `json:"creator_id" db:"creatorid"`
+	CreateAt  int64  `json:"create_at" db:"createat"`
+	UpdateAt  int64  `json:"update_at" db:"updateat"`
+	DeleteAt  int64  `json:"delete_at" db:"deleteat"`
+}
+
+// ImagePath returns the path of the emoji image, relative to the file storage root
+func (e *Emoji) ImagePath() string {
+	return "emoji/" + e.ID + "/image"
+}
+
 // Files represents exported file data from Mattermost
 type Files struct {
 	ExportedAt int64      `json:"exported_at"`
@@ -345,12 +379,150 @@ func (p *Post) CreatedTime() time.Time {
 	return time.UnixMilli(p.CreateAt)
 }
 
+// PostProps holds the parts of Post.Props the migration uses
+type PostProps struct {
+	Attachments []MessageAttachment `json:"attachments,omitempty"`
+}
+
+// MessageAttachment is a Slack-style attachment added by integrations,
+// webhooks and slash command responses
+type MessageAttachment struct {
+	Fallback  string                   `json:"fallback"`
+	Pretext   string                   `json:"pretext"`
+	Title     string                   `json:"title"`
+	TitleLink string                   `json:"title_link"`
+	Text      string                   `json:"text"`
+	Fields    []MessageAttachmentField `json:"fields"`
+}
+
+// MessageAttachmentField is a title/value pair in an attachment
+type MessageAttachmentField struct {
+	Title string      `json:"title"`
+	Value interface{} `json:"value"` // String or number
+}
+
+// ParseProps parses the post's JSON props
+func (p *Post) ParseProps() (*PostProps, error) {
+	props := &PostProps{}
+	if p.Props == "" || p.Props == "{}" {
+		return props, nil
+	}
+	if err := json.Unmarshal([]byte(p.Props), props); err != nil {
+		return nil, fmt.Errorf("failed to parse props: %w", err)
+	}
+	return props, nil
+}
+
+// AttachmentText renders the attachments as plain text, one block per
+// attachment. Returns "" if there are none.
+func (pp *PostProps) AttachmentText() string {
+	var blocks []string
+	for _, a := range pp.Attachments {
+		var lines []string
+		if a.Pretext != "" {
+			lines = append(lines, a.Pretext)
+		}
+		switch {
+		case a.Title != "" && a.TitleLink != "":
+			lines = append(lines, fmt.Sprintf("[%s](%s)", a.Title, a.TitleLink))
+		case a.Title != "":
+			lines = append(lines, a.Title)
+		}
+		if a.Text != "" {
+			lines = append(lines, a.Text)
+		}
+		for _, f := range a.Fields {
+			value := ""
+			if f.Value != nil {
+				value = fmt.Sprint(f.Value)
+			}
+			if f.Title == "" && value == "" {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", f.Title, value))
+		}
+		// The fallback is a plain-text summary; use it only when nothing else is set
+		if len(lines) == 0 && a.Fallback != "" {
+			lines = append(lines, a.Fallback)
+		}
+		if len(lines) > 0 {
+			blocks = append(blocks, strings.Join(lines, "\n"))
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// PostFilter limits exported posts to a creation time range.
+// Times are Unix milliseconds; zero means unbounded.
+type PostFilter struct {
+	Since int64 // Inclusive lower bound
+	Until int64 // Exclusive upper bound
+
+	// Teams and Channels limit posts to the channels an AssetFilter with
+	// the same lists selects
+	Teams    []string
+	Channels []string
+}
+
+// IsZero returns true if the filter doesn't limit anything
+func (f PostFilter) IsZero() bool {
+	return f.Since == 0 && f.Until == 0 && !f.selectsChannels()
+}
+
+// selectsChannels returns true if the filter limits posts to some channels
+func (f PostFilter) selectsChannels() bool {
+	return len(f.Teams) > 0 || len(f.Channels) > 0
+}
+
+// Contains returns true if a post created at createAt passes the filter
+func (f PostFilter) Contains(createAt int64) bool {
+	return (f.Since == 0 || createAt >= f.Since) && (f.Until == 0 || createAt < f.Until)
+}
+
+// AssetFilter limits an export to some teams and channels. Entries match a
+// team or channel by name or ID; an empty list selects all of them.
+// Channels of other teams are left out, and so are group messages (which
+// belong to no team) when teams are selected.
+type AssetFilter struct {
+	Teams    []string
+	Channels []string
+
+	// Since limits users and channels to those created or updated at or
+	// after this time (Unix milliseconds; 0: all). Memberships aren't limited.
+	Since int64
+
+	// DirectMessages also exports direct message channels, and the members
+	// of direct and group messages with their channels
+	DirectMessages bool
+}
+
+// channelTypes returns the SQL list of the channel types to export
+func (f AssetFilter) channelTypes() string {
+	if f.DirectMessages {
+		return "('O', 'P', 'G', 'D')"
+	}
+	return "('O', 'P', 'G')"
+}
+
+// IsZero returns true if the filter doesn't limit anything
+func (f AssetFilter) IsZero() bool {
+	return !f.selectsChannels() && f.Since == 0
+}
+
+// selectsChannels returns true if the filter selects teams or channels
+func (f AssetFilter) selectsChannels() bool {
+	return len(f.Teams) > 0 || len(f.Channels) > 0
+}
+
 // Messages represents all message data from Mattermost
 type Messages struct {
 	ExportedAt int64      `json:"exported_at"`
 	Version    string     `json:"version"`
 	Posts      []Post     `json:"posts"`
 	Files      []FileInfo `json:"files,omitempty"` // File attachments
+	Emojis     []Emoji    `json:"emojis,omitempty"` // Custom emojis
+	Since      int64      `json:"since,omitempty"`  // Lower bound of the exported posts' creation time, if any (Unix ms)
+	Until      int64      `json:"until,omitempty"`  // Upper bound (exclusive) of the exported posts' creation time, if any
 }
 
 // MessageStats holds statistics about messages
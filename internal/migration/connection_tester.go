@@ -1,7 +1,9 @@
 package migration
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"os"
 
 	"github.com/aligundogdu/matrixmigrate/internal/config"
@@ -75,6 +77,113 @@ func RunConnectionTests(cfg *config.Config, callback TestCallback) *ConnectionTe
 	return result
 }
 
+// RunOfflineTests checks the configuration without connecting anywhere:
+// the config tests plus static checks such as SSH key presence. No SSH
+// dial, database or API request is made and secrets from the environment
+// aren't required, so it can run in CI.
+func RunOfflineTests(cfg *config.Config, callback TestCallback) *ConnectionTestResult {
+	result := &ConnectionTestResult{
+		AllPassed: true,
+	}
+
+	result.ConfigSteps = runConfigTests(cfg, callback)
+	if cfg != nil {
+		result.MattermostSteps = []TestStep{offlineSSHStep("mm_ssh_config", "mattermost", cfg.Mattermost.SSH, callback)}
+		result.MatrixSteps = []TestStep{
+			offlineSSHStep("mx_ssh_config", "matrix", cfg.Matrix.SSH, callback),
+			offlineAPIStep(cfg, callback),
+		}
+	}
+
+	for _, steps := range [][]TestStep{result.ConfigSteps, result.MattermostSteps, result.MatrixSteps} {
+		for _, step := range steps {
+			if step.Status == TestFailed {
+				result.AllPassed = false
+			}
+		}
+	}
+	return result
+}
+
+// offlineSSHStep checks that an SSH auth method is configured and that the
+// key file exists; password env vars are not read
+func offlineSSHStep(name, server string, sshCfg config.SSHConfig, callback TestCallback) TestStep {
+	step := TestStep{
+		Name:        name,
+		Description: "SSH configuration",
+		Status:      TestPassed,
+	}
+
+	switch {
+	case sshCfg.Host == "":
+		step.Status = TestSkipped
+		step.Details = "SSH host not configured"
+	case sshCfg.KeyPath != "":
+		if info, err := os.Stat(sshCfg.KeyPath); err != nil {
+			step.Status = TestFailed
+			step.Error = fmt.Sprintf("SSH key not found: %s", sshCfg.KeyPath)
+		} else if info.IsDir() {
+			step.Status = TestFailed
+			step.Error = fmt.Sprintf("SSH key is a directory: %s", sshCfg.KeyPath)
+		} else {
+			step.Details = fmt.Sprintf("%s@%s, key: %s", sshCfg.User, sshCfg.Host, sshCfg.KeyPath)
+		}
+	case sshCfg.PasswordEnv != "":
+		step.Details = fmt.Sprintf("%s@%s, password auth via $%s (not checked offline)", sshCfg.User, sshCfg.Host, sshCfg.PasswordEnv)
+	default:
+		step.Status = TestFailed
+		step.Error = "No SSH authentication method configured"
+	}
+
+	// The host key is checked against known_hosts when connecting
+	if step.Status == TestPassed && !sshCfg.InsecureHostKey {
+		knownHosts := sshCfg.KnownHostsPath
+		if knownHosts == "" {
+			knownHosts = ssh.DefaultKnownHostsPath()
+		}
+		if _, err := os.Stat(knownHosts); err != nil {
+			step.Status = TestFailed
+			step.Error = fmt.Sprintf("known_hosts not found: %s (add the server's host key or set insecure_host_key)", knownHosts)
+		}
+	}
+
+	if callback != nil {
+		callback(server, &step)
+	}
+	return step
+}
+
+// offlineAPIStep checks that the Matrix API base URL is usable
+func offlineAPIStep(cfg *config.Config, callback TestCallback) TestStep {
+	step := TestStep{
+		Name:        "mx_api_config",
+		Description: "Matrix API configuration",
+		Status:      TestPassed,
+		Details:     fmt.Sprintf("%s, homeserver: %s", cfg.Matrix.API.BaseURL, cfg.Matrix.Homeserver),
+	}
+
+	u, err := url.Parse(cfg.Matrix.API.BaseURL)
+	switch {
+	case err != nil:
+		step.Status = TestFailed
+		step.Error = fmt.Sprintf("Invalid base_url: %v", err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		step.Status = TestFailed
+		step.Error = fmt.Sprintf("base_url must start with http:// or https://, got %q", cfg.Matrix.API.BaseURL)
+	case u.Host == "":
+		step.Status = TestFailed
+		step.Error = fmt.Sprintf("base_url has no host: %q", cfg.Matrix.API.BaseURL)
+	case cfg.Matrix.Homeserver == "":
+		step.Status = TestWarning
+		step.Details = "homeserver not set; it will be detected after login"
+	}
+
+	if callback != nil {
+		callback("matrix", &step)
+	}
+	return step
+}
+
 // runConfigTests runs configuration validation tests
 func runConfigTests(cfg *config.Config, callback TestCallback) []TestStep {
 	steps := []TestStep{}
@@ -221,13 +330,13 @@ func runMattermostTests(cfg *config.Config, callback TestCallback) []TestStep {
 			callback("mattermost", &step)
 		}
 
-		creds, err := mattermost.GetDatabaseCredentials(cfg.Mattermost.SSH, passphrase, sshPassword, cfg.Mattermost.ConfigPath)
+		creds, err := mattermost.GetDatabaseCredentials(cfg.Mattermost.SSH, passphrase, sshPassword, cfg.Mattermost.ConfigPath, cfg.Mattermost.EnvCommand)
 		if err != nil {
 			step.Status = TestFailed
 			step.Error = err.Error()
 		} else {
 			step.Status = TestPassed
-			step.Details = fmt.Sprintf("DB: %s@%s:%d/%s", creds.User, creds.Host, creds.Port, creds.Database)
+			step.Details = fmt.Sprintf("DB (%s): %s@%s:%d/%s", creds.Driver, creds.User, creds.Host, creds.Port, creds.Database)
 		}
 		if callback != nil {
 			callback("mattermost", &step)
@@ -257,7 +366,7 @@ func runMattermostTests(cfg *config.Config, callback TestCallback) []TestStep {
 	} else {
 		defer orch.Close()
 		
-		if err := orch.ConnectMattermost(); err != nil {
+		if err := orch.ConnectMattermost(nil); err != nil {
 			step.Status = TestFailed
 			step.Error = err.Error()
 		} else {
@@ -267,9 +376,12 @@ func runMattermostTests(cfg *config.Config, callback TestCallback) []TestStep {
 				step.Error = fmt.Sprintf("Database ping failed: %s", err.Error())
 			} else {
 				// Get some stats
-				users, teams, channels, _ := mattermost.NewExporter(orch.mmClient).GetCounts()
+				users, teams, channels, _ := orch.newExporter().GetCounts()
 				step.Status = TestPassed
 				step.Details = fmt.Sprintf("%d users, %d teams, %d channels", users, teams, channels)
+				if len(cfg.Mattermost.IncludeTeams) > 0 || len(cfg.Mattermost.IncludeChannels) > 0 {
+					step.Details += " (limited by include_teams/include_channels)"
+				}
 			}
 		}
 	}
@@ -420,18 +532,6 @@ func runMatrixTests(cfg *config.Config, callback TestCallback) []TestStep {
 		callback("matrix", &step)
 	}
 
-	// Get local port for tunnel
-	localPort, err := ssh.GetLocalPort()
-	if err != nil {
-		step.Status = TestFailed
-		step.Error = err.Error()
-		if callback != nil {
-			callback("matrix", &step)
-		}
-		steps = append(steps, step)
-		return steps
-	}
-
 	// Get remote API port from config (default: 8008)
 	remotePort := cfg.Matrix.API.Port
 	if remotePort == 0 {
@@ -441,7 +541,7 @@ func runMatrixTests(cfg *config.Config, callback TestCallback) []TestStep {
 	// Create tunnel
 	tunnelCfg := ssh.TunnelConfig{
 		SSHConfig:  cfg.Matrix.SSH,
-		LocalPort:  localPort,
+		LocalPort:  cfg.Matrix.SSH.LocalPort,
 		RemoteHost: "127.0.0.1",
 		RemotePort: remotePort,
 		Passphrase: passphrase,
@@ -460,14 +560,14 @@ func runMatrixTests(cfg *config.Config, callback TestCallback) []TestStep {
 	}
 	defer tunnel.Close()
 
-	baseURL := fmt.Sprintf("http://127.0.0.1:%d", localPort)
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", tunnel.LocalPort())
 
 	// Get access token
 	var accessToken string
 	if cfg.UseTokenAuth() {
 		accessToken = cfg.GetMatrixAdminToken()
 	} else {
-		loginResp, err := matrix.Login(baseURL, cfg.Matrix.Auth.Username, cfg.GetMatrixPassword())
+		loginResp, err := matrix.LoginWithOptions(baseURL, cfg.Matrix.Auth.Username, cfg.GetMatrixPassword(), matrixLoginOptions(cfg))
 		if err != nil {
 			step.Status = TestFailed
 			step.Error = fmt.Sprintf("Login failed: %s", err.Error())
@@ -483,7 +583,10 @@ func runMatrixTests(cfg *config.Config, callback TestCallback) []TestStep {
 
 	// Test API
 	client := matrix.NewClient(baseURL, accessToken, cfg.Matrix.Homeserver)
-	if err := client.TestConnection(); err != nil {
+	if err := client.SetHTTPOptions(matrixHTTPOptions(cfg)); err != nil {
+		step.Status = TestFailed
+		step.Error = err.Error()
+	} else if err := client.TestConnection(context.Background()); err != nil {
 		step.Status = TestFailed
 		step.Error = err.Error()
 	} else {
@@ -493,6 +596,7 @@ func runMatrixTests(cfg *config.Config, callback TestCallback) []TestStep {
 		callback("matrix", &step)
 	}
 	steps = append(steps, step)
+	apiConnected := step.Status == TestPassed
 
 	// Step 5: Application Service configuration (for message timestamps)
 	step = TestStep{
@@ -522,9 +626,81 @@ func runMatrixTests(cfg *config.Config, callback TestCallback) []TestStep {
 	}
 	steps = append(steps, step)
 
+	// Step 5b: Synapse admin v2 API, needed to create users
+	if apiConnected {
+		steps = append(steps, testAdminAPI(client, callback))
+	}
+
+	// Step 6: Application Service token accepted by the homeserver
+	if cfg.UseAppService() && apiConnected {
+		steps = append(steps, testAppServiceToken(client, cfg, callback))
+	}
+
 	return steps
 }
 
+// testAdminAPI checks that the homeserver serves the Synapse admin v2 API
+// that user creation depends on
+func testAdminAPI(client *matrix.Client, callback TestCallback) TestStep {
+	step := TestStep{
+		Name:        "mx_admin_api",
+		Description: "Synapse admin API",
+		Status:      TestRunning,
+	}
+	if callback != nil {
+		callback("matrix", &step)
+	}
+
+	available, err := client.AdminV2Available(context.Background())
+	switch {
+	case err != nil:
+		step.Status = TestFailed
+		step.Error = err.Error()
+	case !available:
+		step.Status = TestFailed
+		step.Error = matrix.ErrAdminV2Unavailable.Error()
+	default:
+		step.Status = TestPassed
+		step.Details = "Admin API v2 available"
+	}
+
+	if callback != nil {
+		callback("matrix", &step)
+	}
+	return step
+}
+
+// testAppServiceToken checks that the homeserver accepts the AS token and
+// that its sender_localpart user exists, so message import won't fail later
+func testAppServiceToken(client *matrix.Client, cfg *config.Config, callback TestCallback) TestStep {
+	step := TestStep{
+		Name:        "mx_appservice_token",
+		Description: "Application Service token",
+		Status:      TestRunning,
+	}
+	if callback != nil {
+		callback("matrix", &step)
+	}
+
+	client.SetASToken(cfg.GetASToken())
+	resp, err := client.AppServiceWhoAmI(context.Background())
+	if err != nil {
+		step.Status = TestFailed
+		step.Error = err.Error()
+		if apiErr, ok := matrix.AsAPIError(err); ok && apiErr.Errcode == "M_UNKNOWN_TOKEN" {
+			step.Error += " (is the registration file listed in app_service_config_files in homeserver.yaml?)"
+		}
+	} else {
+		step.Status = TestPassed
+		step.Details = fmt.Sprintf("Sends as %s", resp.UserID)
+	}
+
+	if callback != nil {
+		callback("matrix", &step)
+	}
+	return step
+}
+
 // GetTestStatusIcon returns an icon for the test status
 func GetTestStatusIcon(status TestStatus) string {
 	switch status {
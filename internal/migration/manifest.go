@@ -0,0 +1,139 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+// ManifestFileName is the manifest's file name; it is kept next to the state file
+const ManifestFileName = "migration-manifest.json"
+
+// Manifest is an index of every file a migration produced, together with
+// the source and target hosts. It is rewritten as steps complete and is
+// meant for handing a migration over or cleaning up after it.
+type Manifest struct {
+	Version        string `json:"version"`
+	CreatedAt      int64  `json:"created_at"`
+	UpdatedAt      int64  `json:"updated_at"`
+	MattermostHost string `json:"mattermost_host,omitempty"`
+	MatrixHost     string `json:"matrix_host,omitempty"`
+	Homeserver     string `json:"homeserver,omitempty"`
+	StateFile      string `json:"state_file"`
+
+	AssetsFile         string   `json:"assets_file,omitempty"`          // export assets
+	MappingFile        string   `json:"mapping_file,omitempty"`         // import assets
+	MembershipsFile    string   `json:"memberships_file,omitempty"`     // export memberships
+	MessagesFile       string   `json:"messages_file,omitempty"`        // export messages
+	MessageMappingFile string   `json:"message_mapping_file,omitempty"` // import messages
+	Reports            []string `json:"reports,omitempty"`              // e.g. deactivation reports
+
+	CompletedSteps []StepName `json:"completed_steps,omitempty"`
+}
+
+// ManifestPath returns the manifest path for a state file
+func ManifestPath(stateFile string) string {
+	return filepath.Join(filepath.Dir(stateFile), ManifestFileName)
+}
+
+// LoadManifest loads a manifest, returning a new one if the file doesn't exist
+func LoadManifest(filePath string) (*Manifest, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return &Manifest{Version: "1.0", CreatedAt: time.Now().UnixMilli()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// SaveManifest saves a manifest to a JSON file
+func SaveManifest(manifest *Manifest, filePath string) error {
+	manifest.UpdatedAt = time.Now().UnixMilli()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := archive.WriteFile(filePath, data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// UpdateFromState copies the hosts and step output files from the state.
+// Files of steps that haven't produced one yet keep their previous value.
+func (m *Manifest) UpdateFromState(state *MigrationState) {
+	if state.MattermostHost != "" {
+		m.MattermostHost = state.MattermostHost
+	}
+	if state.MatrixHost != "" {
+		m.MatrixHost = state.MatrixHost
+	}
+
+	outputs := map[StepName]*string{
+		StepExportAssets:      &m.AssetsFile,
+		StepImportAssets:      &m.MappingFile,
+		StepExportMemberships: &m.MembershipsFile,
+		StepExportMessages:    &m.MessagesFile,
+		StepImportMessages:    &m.MessageMappingFile,
+	}
+	m.CompletedSteps = nil
+	for name, step := range state.Steps {
+		if field, ok := outputs[name]; ok && step.OutputFile != "" {
+			*field = step.OutputFile
+		}
+		if step.Status == StatusCompleted {
+			m.CompletedSteps = append(m.CompletedSteps, name)
+		}
+	}
+	sort.Slice(m.CompletedSteps, func(a, b int) bool { return m.CompletedSteps[a] < m.CompletedSteps[b] })
+}
+
+// AddReport records a report file, ignoring duplicates
+func (m *Manifest) AddReport(filePath string) {
+	for _, existing := range m.Reports {
+		if existing == filePath {
+			return
+		}
+	}
+	m.Reports = append(m.Reports, filePath)
+}
+
+// updateManifest rewrites the manifest from the current state and records
+// the given report files. Failures are only logged: the manifest is an
+// index and must not fail a step that already succeeded.
+func (o *Orchestrator) updateManifest(reports ...string) {
+	if o.dryRunning {
+		return
+	}
+	path := ManifestPath(o.config.Data.StateFile)
+	manifest, err := LoadManifest(path)
+	if err != nil {
+		logger.Warn("Could not update %s: %v", ManifestFileName, err)
+		return
+	}
+
+	manifest.StateFile = o.config.Data.StateFile
+	manifest.Homeserver = o.config.Matrix.Homeserver
+	manifest.UpdateFromState(o.state)
+	for _, report := range reports {
+		manifest.AddReport(report)
+	}
+
+	if err := SaveManifest(manifest, path); err != nil {
+		logger.Warn("Could not update %s: %v", ManifestFileName, err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
 )
 
 // Mapping represents the ID mappings between Mattermost and Matrix
@@ -17,6 +19,10 @@ type Mapping struct {
 	Users       map[string]string `json:"users"`       // mm_user_id -> matrix_user_id
 	Teams       map[string]string `json:"teams"`       // mm_team_id -> matrix_space_id
 	Channels    map[string]string `json:"channels"`    // mm_channel_id -> matrix_room_id
+	RoomAliases map[string]string `json:"room_aliases,omitempty"` // mm_channel_id -> name alias of the room
+
+	// Archived (deleted) channels imported as rooms, made read-only after message import
+	ArchivedChannels []string `json:"archived_channels,omitempty"`
 }
 
 // NewMapping creates a new empty mapping
@@ -93,18 +99,12 @@ type MappingStats struct {
 
 // SaveMapping saves a mapping to a JSON file
 func SaveMapping(mapping *Mapping, filePath string) error {
-	// Ensure directory exists
-	dir := filepath.Dir(filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
 	data, err := json.MarshalIndent(mapping, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal mapping: %w", err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := archive.WriteFile(filePath, data); err != nil {
 		return fmt.Errorf("failed to write mapping file: %w", err)
 	}
 
@@ -134,7 +134,8 @@ func MappingExists(filePath string) bool {
 
 // GetLatestMappingFile finds the most recent mapping file in a directory
 func GetLatestMappingFile(dir string) (string, error) {
-	pattern := filepath.Join(dir, "asset-mapping-*.json")
+	// Matches timestamped and fixed names
+	pattern := filepath.Join(dir, "asset-mapping*.json")
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		return "", fmt.Errorf("failed to glob mapping files: %w", err)
@@ -166,10 +167,31 @@ func GetLatestMappingFile(dir string) (string, error) {
 	return latest, nil
 }
 
-// GenerateMappingFilename generates a filename for a new mapping file
-func GenerateMappingFilename(dir string) string {
-	timestamp := time.Now().Format("20060102-150405")
-	return filepath.Join(dir, fmt.Sprintf("asset-mapping-%s.json", timestamp))
+// GenerateMappingFilename generates a filename for a new mapping file,
+// without a timestamp if fixed is true
+func GenerateMappingFilename(dir string, fixed bool) string {
+	return filepath.Join(dir, dataFileName(".json", "asset-mapping", fileTimestamp(fixed)))
+}
+
+// fileTimestamp returns the timestamp put in data file names, or "" for
+// fixed names
+func fileTimestamp(fixed bool) string {
+	if fixed {
+		return ""
+	}
+	return time.Now().Format("20060102-150405")
+}
+
+// dataFileName returns prefix and the non-empty parts joined by "-",
+// followed by ext
+func dataFileName(ext, prefix string, parts ...string) string {
+	name := prefix
+	for _, part := range parts {
+		if part != "" {
+			name += "-" + part
+		}
+	}
+	return name + ext
 }
 
 
@@ -1,12 +1,16 @@
 package migration
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
 )
 
 // MessageMapping represents the mapping between Mattermost posts and Matrix events
@@ -16,6 +20,9 @@ type MessageMapping struct {
 	UpdatedAt  int64                      `json:"updated_at"`
 	Homeserver string                     `json:"homeserver"`
 	Messages   map[string]*MessageMapEntry `json:"messages"` // key: Mattermost post ID
+	// CompletedChannels holds the channels whose posts were all imported,
+	// with the creation time of the newest one (key: Mattermost channel ID)
+	CompletedChannels map[string]int64 `json:"completed_channels,omitempty"`
 	mu         sync.RWMutex               `json:"-"`
 }
 
@@ -84,6 +91,62 @@ func (m *MessageMapping) GetMatrixEventID(mattermostID string) string {
 	return ""
 }
 
+// LookupEvent returns the Matrix event ID for a Mattermost post, if it was imported
+func (m *MessageMapping) LookupEvent(mattermostID string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, exists := m.Messages[mattermostID]
+	if !exists {
+		return "", false
+	}
+	return entry.MatrixEventID, true
+}
+
+// RecordMessage adds the mapping for a newly imported post
+func (m *MessageMapping) RecordMessage(post *mattermost.Post, roomID, matrixUserID, eventID string) error {
+	m.AddMessage(newMessageMapEntry(post, roomID, matrixUserID, eventID))
+	return nil
+}
+
+// ChannelCompletedThrough returns the creation time of the newest post of a
+// channel whose posts were all imported
+func (m *MessageMapping) ChannelCompletedThrough(channelID string) (int64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	through, ok := m.CompletedChannels[channelID]
+	return through, ok
+}
+
+// MarkChannelCompleted records that every post of a channel created up to through was imported
+func (m *MessageMapping) MarkChannelCompleted(channelID string, through int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.CompletedChannels == nil {
+		m.CompletedChannels = make(map[string]int64)
+	}
+	m.CompletedChannels[channelID] = through
+	m.UpdatedAt = time.Now().UnixMilli()
+	return nil
+}
+
+// newMessageMapEntry builds a mapping entry for an imported post
+func newMessageMapEntry(post *mattermost.Post, roomID, matrixUserID, eventID string) *MessageMapEntry {
+	return &MessageMapEntry{
+		MattermostID:  post.ID,
+		MatrixEventID: eventID,
+		ChannelID:     post.ChannelID,
+		RoomID:        roomID,
+		UserID:        post.UserID,
+		MatrixUserID:  matrixUserID,
+		Timestamp:     post.CreateAt,
+		IsReply:       post.IsReply(),
+		RootID:        post.RootID,
+	}
+}
+
 // Count returns the number of mapped messages
 func (m *MessageMapping) Count() int {
 	m.mu.RLock()
@@ -121,25 +184,183 @@ type MessageMappingStats struct {
 	ByRoom    map[string]int `json:"by_room"`
 }
 
-// SaveMessageMapping saves the message mapping to a file
+// SaveMessageMapping saves the message mapping to a file.
+// The mapping is snapshotted under the lock and written after releasing it,
+// so concurrent AddMessage calls are not blocked by the disk write.
 func SaveMessageMapping(mapping *MessageMapping, filepath string) error {
-	mapping.mu.RLock()
-	defer mapping.mu.RUnlock()
-	
-	mapping.UpdatedAt = time.Now().UnixMilli()
+	snapshot := mapping.snapshot()
 	
-	data, err := json.MarshalIndent(mapping, "", "  ")
+	data, err := json.MarshalIndent(snapshot, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal message mapping: %w", err)
 	}
 	
-	if err := os.WriteFile(filepath, data, 0644); err != nil {
+	if err := archive.WriteFile(filepath, data); err != nil {
 		return fmt.Errorf("failed to write message mapping file: %w", err)
 	}
 	
 	return nil
 }
 
+// snapshot returns a copy of the mapping that can be serialized without holding the lock.
+// Entries are never modified after being added, so they are shared rather than copied.
+func (m *MessageMapping) snapshot() *MessageMapping {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.UpdatedAt = time.Now().UnixMilli()
+	messages := make(map[string]*MessageMapEntry, len(m.Messages))
+	for k, v := range m.Messages {
+		messages[k] = v
+	}
+	var completed map[string]int64
+	if len(m.CompletedChannels) > 0 {
+		completed = make(map[string]int64, len(m.CompletedChannels))
+		for k, v := range m.CompletedChannels {
+			completed[k] = v
+		}
+	}
+
+	return &MessageMapping{
+		Version:           m.Version,
+		CreatedAt:         m.CreatedAt,
+		UpdatedAt:         m.UpdatedAt,
+		Homeserver:        m.Homeserver,
+		Messages:          messages,
+		CompletedChannels: completed,
+	}
+}
+
+// messageMappingSaveInterval is how many new message mappings trigger a
+// background save, unless configured otherwise
+const messageMappingSaveInterval = 1000
+
+// MessageMappingSaver saves a message mapping in the background.
+// Save requests made while a save is running are coalesced into one,
+// so callers never block on disk writes.
+type MessageMappingSaver struct {
+	mapping  *MessageMapping
+	filePath string
+	interval int
+	trigger  chan struct{}
+	done     chan struct{}
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex
+	lastErr  error
+	recorded int
+}
+
+// NewMessageMappingSaver starts a background saver for mapping that saves
+// every interval new mappings (0: messageMappingSaveInterval).
+// The saver stops when ctx is cancelled or Close is called.
+func NewMessageMappingSaver(ctx context.Context, mapping *MessageMapping, filePath string, interval int) *MessageMappingSaver {
+	if interval <= 0 {
+		interval = messageMappingSaveInterval
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &MessageMappingSaver{
+		mapping:  mapping,
+		filePath: filePath,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		cancel:   cancel,
+	}
+	go s.run(ctx)
+	return s
+}
+
+// Trigger requests a save without waiting for it
+func (s *MessageMappingSaver) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// A save is already pending
+	}
+}
+
+// LookupEvent returns the Matrix event ID for a Mattermost post, if it was imported
+func (s *MessageMappingSaver) LookupEvent(mattermostID string) (string, bool) {
+	return s.mapping.LookupEvent(mattermostID)
+}
+
+// RecordMessage adds a mapping and triggers a save every interval additions
+func (s *MessageMappingSaver) RecordMessage(post *mattermost.Post, roomID, matrixUserID, eventID string) error {
+	if err := s.mapping.RecordMessage(post, roomID, matrixUserID, eventID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.recorded++
+	due := s.recorded%s.interval == 0
+	s.mu.Unlock()
+
+	if due {
+		s.Trigger()
+	}
+	return nil
+}
+
+// ChannelCompletedThrough returns the creation time of the newest post of a
+// channel whose posts were all imported
+func (s *MessageMappingSaver) ChannelCompletedThrough(channelID string) (int64, bool) {
+	return s.mapping.ChannelCompletedThrough(channelID)
+}
+
+// MarkChannelCompleted records a completed channel and triggers a save
+func (s *MessageMappingSaver) MarkChannelCompleted(channelID string, through int64) error {
+	if err := s.mapping.MarkChannelCompleted(channelID, through); err != nil {
+		return err
+	}
+	s.Trigger()
+	return nil
+}
+
+// Count returns the number of mapped messages
+func (s *MessageMappingSaver) Count() int {
+	return s.mapping.Count()
+}
+
+// Path returns the file the mapping is saved to
+func (s *MessageMappingSaver) Path() string {
+	return s.filePath
+}
+
+// Close stops the saver and performs a final, synchronous save
+func (s *MessageMappingSaver) Close() error {
+	s.cancel()
+	<-s.done
+
+	if err := SaveMessageMapping(s.mapping, s.filePath); err != nil {
+		return err
+	}
+	return s.Err()
+}
+
+// Err returns the error of the last failed background save, if any
+func (s *MessageMappingSaver) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// run performs saves until the context is cancelled
+func (s *MessageMappingSaver) run(ctx context.Context) {
+	defer close(s.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.trigger:
+			err := SaveMessageMapping(s.mapping, s.filePath)
+			s.mu.Lock()
+			s.lastErr = err
+			s.mu.Unlock()
+		}
+	}
+}
+
 // LoadMessageMapping loads a message mapping from a file
 func LoadMessageMapping(filepath string) (*MessageMapping, error) {
 	data, err := os.ReadFile(filepath)
@@ -159,15 +380,16 @@ func LoadMessageMapping(filepath string) (*MessageMapping, error) {
 	return &mapping, nil
 }
 
-// GenerateMessageMappingFilename generates a filename for message mapping
-func GenerateMessageMappingFilename(dir string) string {
-	timestamp := time.Now().Format("20060102-150405")
-	return filepath.Join(dir, fmt.Sprintf("message-mapping-%s.json", timestamp))
+// GenerateMessageMappingFilename generates a filename for message mapping,
+// without a timestamp if fixed is true
+func GenerateMessageMappingFilename(dir string, fixed bool) string {
+	return filepath.Join(dir, dataFileName(".json", "message-mapping", fileTimestamp(fixed)))
 }
 
 // GetLatestMessageMappingFile finds the latest message mapping file in a directory
 func GetLatestMessageMappingFile(dir string) (string, error) {
-	pattern := filepath.Join(dir, "message-mapping-*.json")
+	// Matches timestamped and fixed names
+	pattern := filepath.Join(dir, "message-mapping*.json")
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		return "", err
@@ -177,11 +399,18 @@ func GetLatestMessageMappingFile(dir string) (string, error) {
 		return "", nil
 	}
 	
-	// Return the latest (last alphabetically due to timestamp format)
-	latest := matches[0]
-	for _, match := range matches[1:] {
-		if match > latest {
+	// Return the most recently written; names don't order timestamped
+	// and fixed files
+	var latest string
+	var latestTime time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
 			latest = match
+			latestTime = info.ModTime()
 		}
 	}
 	
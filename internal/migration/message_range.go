@@ -0,0 +1,117 @@
+package migration
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+)
+
+// messageDateLayout is the date format accepted and shown for message ranges
+const messageDateLayout = "2006-01-02"
+
+// MessageRange limits exported and imported messages to a creation date range
+type MessageRange struct {
+	Since time.Time // Inclusive; zero means no lower bound
+	Until time.Time // Exclusive; zero means no upper bound
+}
+
+// IsZero returns true if the range doesn't limit anything
+func (r MessageRange) IsZero() bool {
+	return r.Since.IsZero() && r.Until.IsZero()
+}
+
+// PostFilter returns the range as a Mattermost post filter
+func (r MessageRange) PostFilter() mattermost.PostFilter {
+	var filter mattermost.PostFilter
+	if !r.Since.IsZero() {
+		filter.Since = r.Since.UnixMilli()
+	}
+	if !r.Until.IsZero() {
+		filter.Until = r.Until.UnixMilli()
+	}
+	return filter
+}
+
+// String describes the range for logs and the UI
+func (r MessageRange) String() string {
+	switch {
+	case r.IsZero():
+		return "all messages"
+	case r.Until.IsZero():
+		return "since " + r.Since.Format(time.RFC3339)
+	case r.Since.IsZero():
+		return "before " + r.Until.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("%s to %s", r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+	}
+}
+
+// ParseMessageRange parses the bounds of a message range. Each bound may be
+// empty (unbounded), a date (YYYY-MM-DD, local time), an RFC 3339 time, a
+// Unix epoch in seconds or milliseconds, or a number of days before now such
+// as "90d". A date as the upper bound includes that whole day.
+func ParseMessageRange(since, until string, now time.Time) (MessageRange, error) {
+	var r MessageRange
+	var err error
+
+	if r.Since, _, err = parseMessageDate(since, now); err != nil {
+		return MessageRange{}, fmt.Errorf("invalid start date: %w", err)
+	}
+
+	var dateOnly bool
+	if r.Until, dateOnly, err = parseMessageDate(until, now); err != nil {
+		return MessageRange{}, fmt.Errorf("invalid end date: %w", err)
+	}
+	if dateOnly {
+		r.Until = r.Until.AddDate(0, 0, 1)
+	}
+
+	if !r.Since.IsZero() && !r.Until.IsZero() && !r.Since.Before(r.Until) {
+		return MessageRange{}, fmt.Errorf("end date must be after start date")
+	}
+	return r, nil
+}
+
+// parseMessageDate parses a single range bound; dateOnly reports whether
+// it was given as a calendar date
+func parseMessageDate(s string, now time.Time) (t time.Time, dateOnly bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, false, fmt.Errorf("%q: expected a number of days such as 90d", s)
+		}
+		return now.AddDate(0, 0, -n), false, nil
+	}
+
+	if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return parseEpoch(epoch), false, nil
+	}
+	if t, err := time.ParseInLocation(messageDateLayout, s, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("%q: expected YYYY-MM-DD, an RFC 3339 time, a Unix epoch or a number of days such as 90d", s)
+}
+
+// epochMillisThreshold separates epoch seconds from milliseconds; it is
+// 1e12 seconds (year 33658) or 1e12 milliseconds (September 2001)
+const epochMillisThreshold = 1_000_000_000_000
+
+// parseEpoch converts a Unix epoch in seconds or milliseconds to a time.
+// Mattermost stores createat in milliseconds, so both forms are common.
+func parseEpoch(epoch int64) time.Time {
+	if epoch >= epochMillisThreshold || epoch <= -epochMillisThreshold {
+		return time.UnixMilli(epoch)
+	}
+	return time.Unix(epoch, 0)
+}
@@ -0,0 +1,221 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+// BoltMessageMappingFile is the name of the on-disk message mapping database
+const BoltMessageMappingFile = "message-mapping.db"
+
+var (
+	boltMessagesBucket = []byte("messages")
+	boltMetaBucket     = []byte("meta")
+	boltChannelsBucket = []byte("completed_channels")
+)
+
+// BoltMessageStore keeps the message mapping in an embedded bbolt database,
+// keyed by Mattermost post ID. Lookups go to disk, so memory use stays flat
+// no matter how many messages have been imported.
+type BoltMessageStore struct {
+	db   *bolt.DB
+	path string
+}
+
+// GetBoltMessageMappingPath returns the path of the message mapping database in dir
+func GetBoltMessageMappingPath(dir string) string {
+	return filepath.Join(dir, BoltMessageMappingFile)
+}
+
+// OpenBoltMessageStore opens (or creates) the message mapping database at path
+func OpenBoltMessageStore(path, homeserver string) (*BoltMessageStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), archive.DirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create mapping directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, archive.FileMode(), &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message mapping database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltMessagesBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltChannelsBucket); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		if meta.Get([]byte("homeserver")) == nil {
+			if err := meta.Put([]byte("homeserver"), []byte(homeserver)); err != nil {
+				return err
+			}
+			return meta.Put([]byte("created_at"), []byte(fmt.Sprintf("%d", time.Now().UnixMilli())))
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize message mapping database: %w", err)
+	}
+
+	return &BoltMessageStore{db: db, path: path}, nil
+}
+
+// Path returns the database file path
+func (s *BoltMessageStore) Path() string {
+	return s.path
+}
+
+// Close closes the database
+func (s *BoltMessageStore) Close() error {
+	return s.db.Close()
+}
+
+// Homeserver returns the homeserver the database was created for
+func (s *BoltMessageStore) Homeserver() string {
+	var homeserver string
+	s.db.View(func(tx *bolt.Tx) error {
+		homeserver = string(tx.Bucket(boltMetaBucket).Get([]byte("homeserver")))
+		return nil
+	})
+	return homeserver
+}
+
+// GetMessage returns a message mapping by Mattermost ID
+func (s *BoltMessageStore) GetMessage(mattermostID string) (*MessageMapEntry, bool) {
+	var entry *MessageMapEntry
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltMessagesBucket).Get([]byte(mattermostID))
+		if data == nil {
+			return nil
+		}
+		var e MessageMapEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		entry = &e
+		return nil
+	})
+	return entry, entry != nil
+}
+
+// HasMessage checks if a message has already been imported
+func (s *BoltMessageStore) HasMessage(mattermostID string) bool {
+	var exists bool
+	s.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(boltMessagesBucket).Get([]byte(mattermostID)) != nil
+		return nil
+	})
+	return exists
+}
+
+// LookupEvent returns the Matrix event ID for a Mattermost post, if it was imported
+func (s *BoltMessageStore) LookupEvent(mattermostID string) (string, bool) {
+	entry, exists := s.GetMessage(mattermostID)
+	if !exists {
+		return "", false
+	}
+	return entry.MatrixEventID, true
+}
+
+// RecordMessage stores the mapping for a newly imported post
+func (s *BoltMessageStore) RecordMessage(post *mattermost.Post, roomID, matrixUserID, eventID string) error {
+	return s.AddMessages([]*MessageMapEntry{newMessageMapEntry(post, roomID, matrixUserID, eventID)})
+}
+
+// AddMessages stores several mappings in a single transaction
+func (s *BoltMessageStore) AddMessages(entries []*MessageMapEntry) error {
+	now := time.Now().UnixMilli()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMessagesBucket)
+		for _, entry := range entries {
+			if entry.ImportedAt == 0 {
+				entry.ImportedAt = now
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("failed to marshal message mapping entry: %w", err)
+			}
+			if err := bucket.Put([]byte(entry.MattermostID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ChannelCompletedThrough returns the creation time of the newest post of a
+// channel whose posts were all imported
+func (s *BoltMessageStore) ChannelCompletedThrough(channelID string) (int64, bool) {
+	var data []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltChannelsBucket).Get([]byte(channelID)); v != nil {
+			data = append(data, v...)
+		}
+		return nil
+	})
+	if data == nil {
+		return 0, false
+	}
+	through, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return through, true
+}
+
+// MarkChannelCompleted records that every post of a channel created up to through was imported
+func (s *BoltMessageStore) MarkChannelCompleted(channelID string, through int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltChannelsBucket).Put([]byte(channelID), []byte(strconv.FormatInt(through, 10)))
+	})
+}
+
+// ImportMapping copies all entries of a JSON message mapping into the database
+func (s *BoltMessageStore) ImportMapping(mapping *MessageMapping) error {
+	snapshot := mapping.snapshot()
+
+	for channelID, through := range snapshot.CompletedChannels {
+		if err := s.MarkChannelCompleted(channelID, through); err != nil {
+			return err
+		}
+	}
+
+	batch := make([]*MessageMapEntry, 0, messageMappingSaveInterval)
+	for _, entry := range snapshot.Messages {
+		batch = append(batch, entry)
+		if len(batch) == messageMappingSaveInterval {
+			if err := s.AddMessages(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return s.AddMessages(batch)
+	}
+	return nil
+}
+
+// Count returns the number of mapped messages
+func (s *BoltMessageStore) Count() int {
+	var count int
+	s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltMessagesBucket).Stats().KeyN
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,175 @@
+package migration
+
+import (
+	"context"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+// messageFileWriter writes a streamed message export in the layout of
+// mattermost.Messages, so it loads like an export saved in one piece.
+// Writing stops with the context's error once ctx is cancelled, or with
+// the error of check, which runs at each section and every
+// messageCheckInterval posts.
+type messageFileWriter struct {
+	ctx     context.Context
+	w       *archive.GzipJSONStreamWriter
+	check   func() error
+	section string // Array field being written: posts, files or emojis
+	posts   int
+}
+
+// messageCheckInterval is how many posts are written between two checks
+const messageCheckInterval = 1000
+
+// newMessageFileWriter creates a message export file for posts selected by filter
+func newMessageFileWriter(ctx context.Context, filePath string, filter mattermost.PostFilter, check func() error) (*messageFileWriter, error) {
+	w, err := archive.NewGzipJSONStreamWriter(filePath)
+	if err != nil {
+		return nil, err
+	}
+	m := &messageFileWriter{ctx: ctx, w: w, check: check}
+
+	if err := m.writeHeader(filter); err != nil {
+		w.Discard()
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeHeader writes the fields preceding the posts
+func (m *messageFileWriter) writeHeader(filter mattermost.PostFilter) error {
+	if err := m.w.WriteField("exported_at", time.Now().UnixMilli()); err != nil {
+		return err
+	}
+	if err := m.w.WriteField("version", "1.0"); err != nil {
+		return err
+	}
+	if filter.Since > 0 {
+		if err := m.w.WriteField("since", filter.Since); err != nil {
+			return err
+		}
+	}
+	if filter.Until > 0 {
+		if err := m.w.WriteField("until", filter.Until); err != nil {
+			return err
+		}
+	}
+	// Always write the posts array, even if there are no posts
+	return m.startSection("posts")
+}
+
+// startSection switches to the array field name, ending the previous one
+func (m *messageFileWriter) startSection(name string) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if m.section == name {
+		return nil
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	if m.section != "" {
+		if err := m.w.EndArray(); err != nil {
+			return err
+		}
+	}
+	m.section = name
+	return m.w.BeginArray(name)
+}
+
+// WritePost implements mattermost.MessageWriter
+func (m *messageFileWriter) WritePost(post *mattermost.Post) error {
+	if err := m.startSection("posts"); err != nil {
+		return err
+	}
+	m.posts++
+	if m.posts%messageCheckInterval == 0 {
+		if err := m.check(); err != nil {
+			return err
+		}
+	}
+	return m.w.WriteItem(post)
+}
+
+// WriteFile implements mattermost.MessageWriter
+func (m *messageFileWriter) WriteFile(file *mattermost.FileInfo) error {
+	if err := m.startSection("files"); err != nil {
+		return err
+	}
+	return m.w.WriteItem(file)
+}
+
+// WriteEmoji implements mattermost.MessageWriter
+func (m *messageFileWriter) WriteEmoji(emoji *mattermost.Emoji) error {
+	if err := m.startSection("emojis"); err != nil {
+		return err
+	}
+	return m.w.WriteItem(emoji)
+}
+
+// Close finishes the export file
+func (m *messageFileWriter) Close() error {
+	return m.w.Close()
+}
+
+// Discard removes an unfinished export file
+func (m *messageFileWriter) Discard() {
+	m.w.Discard()
+}
+
+// loadMessages loads a message export, decoding posts and files one at a
+// time. Unlike archive.LoadGzipJSON it never holds the whole JSON text in
+// memory next to the decoded messages.
+func loadMessages(filePath string) (*mattermost.Messages, error) {
+	r, err := archive.NewGzipJSONStreamReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	messages := &mattermost.Messages{}
+	for {
+		name, ok, err := r.NextField()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return messages, nil
+		}
+
+		switch name {
+		case "exported_at":
+			err = r.Decode(&messages.ExportedAt)
+		case "version":
+			err = r.Decode(&messages.Version)
+		case "since":
+			err = r.Decode(&messages.Since)
+		case "until":
+			err = r.Decode(&messages.Until)
+		case "posts":
+			err = archive.ReadArray(r, func(post mattermost.Post) error {
+				messages.Posts = append(messages.Posts, post)
+				return nil
+			})
+		case "files":
+			err = archive.ReadArray(r, func(file mattermost.FileInfo) error {
+				messages.Files = append(messages.Files, file)
+				return nil
+			})
+		case "emojis":
+			err = archive.ReadArray(r, func(emoji mattermost.Emoji) error {
+				messages.Emojis = append(messages.Emojis, emoji)
+				return nil
+			})
+		default:
+			err = r.Skip()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
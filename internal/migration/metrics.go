@@ -0,0 +1,242 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+)
+
+// metricsPrefix is prepended to every metric name
+const metricsPrefix = "matrixmigrate_"
+
+// Metrics collects the progress and results of the running command and
+// renders them in the Prometheus text format. It is fed from the progress
+// callbacks while an operation runs and from its result when it finishes.
+type Metrics struct {
+	mu       sync.Mutex
+	started  time.Time
+	stages   map[string]*stageMetrics
+	counters map[string]map[string]int // metric name -> label set -> value
+}
+
+// stageMetrics is the progress of one stage, e.g. "users" or "messages"
+type stageMetrics struct {
+	current   int
+	total     int
+	startedAt time.Time
+	updatedAt time.Time
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		started:  time.Now(),
+		stages:   make(map[string]*stageMetrics),
+		counters: make(map[string]map[string]int),
+	}
+}
+
+// SetProgress records the progress of a stage
+func (m *Metrics) SetProgress(stage string, current, total int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	s, ok := m.stages[stage]
+	if !ok || current < s.current {
+		s = &stageMetrics{startedAt: now}
+		m.stages[stage] = s
+	}
+	s.current = current
+	s.total = total
+	s.updatedAt = now
+}
+
+// Inc adds one to a counter
+func (m *Metrics) Inc(name, labels string) {
+	m.add(name, labels, 1)
+}
+
+// add adds n to a counter; labels is the rendered label set, e.g. `result="failed"`
+func (m *Metrics) add(name, labels string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]int)
+	}
+	m.counters[name][labels] += n
+}
+
+// set sets a counter to an absolute value taken from an operation result
+func (m *Metrics) set(name, labels string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]int)
+	}
+	m.counters[name][labels] = n
+}
+
+// ObserveMessage counts a message import outcome as reported to a
+// matrix.MessageImportCallback ("imported", "skipped:oversize", "failed:send_error", ...)
+func (m *Metrics) ObserveMessage(status string) {
+	result, reason, _ := strings.Cut(status, ":")
+	if reason == "" {
+		m.Inc("messages_total", fmt.Sprintf("result=%q", result))
+		return
+	}
+	m.Inc("messages_total", fmt.Sprintf("result=%q,reason=%q", result, reason))
+}
+
+// RecordResult records the final counts of a finished operation. Results of
+// other types are ignored.
+func (m *Metrics) RecordResult(result interface{}) {
+	switch r := result.(type) {
+	case *OperationResult:
+		for kind, n := range map[string]int{
+			"users":               r.UsersExported,
+			"teams":               r.TeamsExported,
+			"channels":            r.ChannelsExported,
+			"team_memberships":    r.TeamMembershipsExported,
+			"channel_memberships": r.ChannelMembershipsExported,
+		} {
+			if n > 0 {
+				m.set("exported_total", fmt.Sprintf("kind=%q", kind), n)
+			}
+		}
+		m.setResults("users_total", map[string]int{
+			"created": r.UsersCreated, "skipped": r.UsersSkipped, "failed": r.UsersFailed, "invalid": r.UsersInvalid,
+		})
+		m.setResults("spaces_total", map[string]int{
+			"created": r.SpacesCreated, "skipped": r.SpacesSkipped, "failed": r.SpacesFailed,
+		})
+		m.setResults("rooms_total", map[string]int{
+			"created": r.RoomsCreated, "skipped": r.RoomsSkipped, "failed": r.RoomsFailed, "updated": r.RoomsUpdated,
+			"skipped_empty": r.RoomsSkippedEmpty, "created_direct": r.DirectRoomsCreated,
+		})
+		m.setResults("memberships_total", map[string]int{
+			"added": r.MembersAdded, "skipped": r.MembersSkipped, "failed": r.MembersFailed,
+			"promoted": r.MembersPromoted, "removed": r.MembersRemoved,
+		})
+	case *ExportMessagesResult:
+		m.set("exported_total", `kind="messages"`, r.MessagesExported)
+		m.set("exported_total", `kind="files"`, r.FilesExported)
+	case *ImportMessagesResult:
+		m.setResults("files_total", map[string]int{
+			"linked": r.FilesLinked, "uploaded": r.FilesUploaded, "skipped": r.FilesSkipped, "failed": r.FilesFailed,
+		})
+	}
+}
+
+// setResults sets a counter per result label, leaving out zero values
+func (m *Metrics) setResults(name string, results map[string]int) {
+	for result, n := range results {
+		if n > 0 {
+			m.set(name, fmt.Sprintf("result=%q", result), n)
+		}
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+
+	fmt.Fprintf(&b, "# HELP %suptime_seconds Seconds since the command started.\n", metricsPrefix)
+	fmt.Fprintf(&b, "# TYPE %suptime_seconds gauge\n", metricsPrefix)
+	fmt.Fprintf(&b, "%suptime_seconds %.0f\n", metricsPrefix, now.Sub(m.started).Seconds())
+
+	stages := make([]string, 0, len(m.stages))
+	for stage := range m.stages {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	gauges := []struct {
+		name, help string
+		value      func(s *stageMetrics) float64
+	}{
+		{"stage_processed", "Items processed in the stage.", func(s *stageMetrics) float64 { return float64(s.current) }},
+		{"stage_items", "Items the stage has to process.", func(s *stageMetrics) float64 { return float64(s.total) }},
+		{"stage_rate", "Items processed per second since the stage started.", func(s *stageMetrics) float64 {
+			if elapsed := s.updatedAt.Sub(s.startedAt).Seconds(); elapsed > 0 {
+				return float64(s.current) / elapsed
+			}
+			return 0
+		}},
+	}
+	for _, g := range gauges {
+		if len(stages) == 0 {
+			break
+		}
+		fmt.Fprintf(&b, "# HELP %s%s %s\n", metricsPrefix, g.name, g.help)
+		fmt.Fprintf(&b, "# TYPE %s%s gauge\n", metricsPrefix, g.name)
+		for _, stage := range stages {
+			fmt.Fprintf(&b, "%s%s{stage=%q} %g\n", metricsPrefix, g.name, stage, g.value(m.stages[stage]))
+		}
+	}
+
+	names := make([]string, 0, len(m.counters))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s%s counter\n", metricsPrefix, name)
+		labelSets := make([]string, 0, len(m.counters[name]))
+		for labels := range m.counters[name] {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+		for _, labels := range labelSets {
+			fmt.Fprintf(&b, "%s%s{%s} %d\n", metricsPrefix, name, labels, m.counters[name][labels])
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeMetrics serves m on addr under /metrics until the returned stop
+// function is called. The listener is opened before returning, so a busy
+// address fails right away.
+func ServeMetrics(addr string, m *Metrics) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warn("Metrics server stopped: %v", err)
+		}
+	}()
+	logger.Info("Serving metrics on http://%s/metrics", listener.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
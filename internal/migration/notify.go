@@ -0,0 +1,91 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+)
+
+// Notification statuses
+const (
+	NotifyStatusCompleted = "completed"
+	NotifyStatusFailed    = "failed"
+)
+
+// Notification is the JSON body posted to notify.webhook_url when a batch
+// command finishes
+type Notification struct {
+	Text        string                  `json:"text"` // One-line summary, shown by Slack and Matrix webhook bridges
+	Command     string                  `json:"command"`
+	Status      string                  `json:"status"` // completed or failed
+	Error       string                  `json:"error,omitempty"`
+	StartedAt   int64                   `json:"started_at"`
+	FinishedAt  int64                   `json:"finished_at"`
+	DurationSec float64                 `json:"duration_sec"`
+	Result      interface{}             `json:"result,omitempty"` // The command's result stats
+	Summary     StateSummary            `json:"summary"`
+	Steps       map[StepName]*StepState `json:"steps,omitempty"`
+}
+
+// NewNotification builds the notification for a command that started at
+// startedAt and ended with err (nil on success)
+func NewNotification(command string, startedAt time.Time, result interface{}, state *MigrationState, err error) *Notification {
+	finished := time.Now()
+	duration := finished.Sub(startedAt).Round(time.Second)
+
+	n := &Notification{
+		Command:     command,
+		Status:      NotifyStatusCompleted,
+		StartedAt:   startedAt.UnixMilli(),
+		FinishedAt:  finished.UnixMilli(),
+		DurationSec: duration.Seconds(),
+		Result:      result,
+	}
+	if state != nil {
+		n.Summary = state.Summary()
+		n.Steps = state.Steps
+	}
+
+	if err != nil {
+		n.Status = NotifyStatusFailed
+		n.Error = err.Error()
+		n.Text = fmt.Sprintf("%s failed after %s: %v", command, duration, err)
+	} else {
+		n.Text = fmt.Sprintf("%s completed in %s", command, duration)
+	}
+	return n
+}
+
+// SendNotification posts n as JSON to the configured webhook.
+// It does nothing if no webhook is configured.
+func SendNotification(cfg config.NotifyConfig, n *Notification) error {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Post(cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -1,10 +1,22 @@
 ﻿package migration
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+
 	"github.com/aligundogdu/matrixmigrate/internal/config"
 	"github.com/aligundogdu/matrixmigrate/internal/logger"
 	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
@@ -22,10 +34,23 @@ type Orchestrator struct {
 	mmClient      *mattermost.Client
 	mxClient      *matrix.Client
 	mxToken       string // Matrix access token (from login or config)
+	auditLog      *matrix.AuditLog
+
+	runOptions RunOptions
+
+	// dryRunning is set while a dry run works on a copy of the state, which
+	// must not be saved
+	dryRunning bool
 }
 
 // NewOrchestrator creates a new migration orchestrator
 func NewOrchestrator(cfg *config.Config) (*Orchestrator, error) {
+	// Apply data file permissions before anything is written
+	archive.SetPermissions(cfg.GetDataFileMode(), cfg.GetDataDirMode())
+	if err := archive.SetGzipLevel(cfg.GetGzipLevel()); err != nil {
+		return nil, err
+	}
+
 	// Initialize logger
 	if err := logger.Init(cfg.Data.AssetsDir); err != nil {
 		// Non-fatal, continue without logging
@@ -44,15 +69,140 @@ func NewOrchestrator(cfg *config.Config) (*Orchestrator, error) {
 	}, nil
 }
 
+// RunOptions holds per-invocation settings, typically set from command line flags
+type RunOptions struct {
+	// UpdateExisting updates names and topics of already imported rooms
+	UpdateExisting bool
+
+	// MessageRange limits the messages exported and imported
+	MessageRange MessageRange
+
+	// AssetsSince makes export assets a delta export of the users and
+	// channels created or updated since then (zero: export everything)
+	AssetsSince time.Time
+
+	// Force runs a step even if its prerequisite steps aren't marked
+	// completed, e.g. to retry after the state was reset or edited
+	Force bool
+
+	// DryRun runs the asset and membership imports without changing
+	// anything on the homeserver; no mapping or state is saved
+	DryRun bool
+
+	// Resume continues an interrupted message import. Unlike a plain run,
+	// it fails instead of starting over when the message mapping is
+	// missing or unreadable, since that would send every message again.
+	Resume bool
+
+	// ValidateMapping checks that every space and room in the mapping still
+	// exists before the membership import, instead of failing per invite
+	ValidateMapping bool
+}
+
+// SetRunOptions sets the per-invocation options for subsequent operations
+func (o *Orchestrator) SetRunOptions(opts RunOptions) {
+	o.runOptions = opts
+}
+
+// CheckCanRunStep returns an error if the prerequisites of a step aren't
+// completed. With RunOptions.Force the check only logs a warning.
+// Completed and failed steps can always run again.
+func (o *Orchestrator) CheckCanRunStep(name StepName) error {
+	canRun, reason := o.state.CanRunStep(name)
+	if canRun {
+		return nil
+	}
+	if o.runOptions.Force {
+		logger.Warn("Running %s despite unmet prerequisite (forced): %s", name, reason)
+		return nil
+	}
+	logger.Error("Cannot run step: %s", reason)
+	return fmt.Errorf("cannot run step: %s", reason)
+}
+
+// newImporter creates a Matrix importer configured from the migration config
+func (o *Orchestrator) newImporter() *matrix.Importer {
+	aliases, err := matrix.NewAliasGenerator(o.config.Matrix.AliasTemplate, o.config.Matrix.SpaceAliasTemplate)
+	if err != nil {
+		logger.Warn("Using default alias scheme: %v", err)
+		aliases = nil
+	}
+
+	return matrix.NewImporterWithOptions(o.mxClient, matrix.ImporterOptions{
+		ImportDeletedUsers:  o.config.Mattermost.IncludeDeleted,
+		UpdateExisting:      o.runOptions.UpdateExisting,
+		Aliases:             aliases,
+		OrphanChannelPolicy: o.config.GetOrphanChannelPolicy(),
+		ChildOrder:          o.config.Matrix.ChildOrder,
+		NameAliases:         o.config.Matrix.NameAliases,
+		ImportArchived:      o.config.ImportArchivedChannels(),
+		MaxMessageBytes:     o.config.Messages.MaxBodyBytes,
+		OversizePolicy:      o.config.GetOversizePolicy(),
+		CreatorInTopic:      o.config.Matrix.CreatorInTopic,
+		DefaultRoomCreator:  o.config.Matrix.DefaultRoomCreator,
+		PublishPublicRooms:  o.config.Matrix.PublishPublicRooms,
+		UserType:            o.config.Matrix.Users.UserType,
+		LogoutDevices:       &o.config.Matrix.Users.LogoutDevices,
+		SSOAuthProvider:     o.config.Matrix.Users.SSO.AuthProvider,
+		SSOExternalIDField:  o.config.Matrix.Users.SSO.ExternalIDField,
+		SSOWithoutPassword:  o.config.Matrix.Users.SSO.WithoutPassword,
+		DuplicateEmailPolicy: o.config.Matrix.Users.DuplicateEmailPolicy,
+		MaxDisplayNameLength: o.config.Matrix.Users.MaxDisplayNameLength,
+		DirectMessages:      o.config.Import.DirectMessages,
+		InviteForbiddenPolicy: o.config.Matrix.InviteForbiddenPolicy,
+		SkipEmptyChannels:   o.config.Import.SkipEmptyChannels,
+		RoomVersion:         o.config.Matrix.RoomVersion,
+		AdminPowerLevel:     o.config.Matrix.AdminPowerLevel,
+		CheckConnection:     o.CheckTunnels,
+		EncryptRooms:        o.config.Matrix.EncryptRooms,
+		HistoryVisibility:   o.config.Matrix.HistoryVisibility,
+		RoomCreationPacing:  time.Duration(o.config.Matrix.RoomCreationPacingMs) * time.Millisecond,
+		RoomCreationJitter:  time.Duration(o.config.Matrix.RoomCreationJitterMs) * time.Millisecond,
+		DryRun:              o.runOptions.DryRun,
+	})
+}
+
+// matrixHTTPOptions returns the extra headers and proxy settings for Matrix requests
+func matrixHTTPOptions(cfg *config.Config) matrix.HTTPOptions {
+	return matrix.HTTPOptions{
+		ExtraHeaders: cfg.Matrix.ExtraHeaders,
+		Proxy:        cfg.Matrix.HTTPProxy,
+	}
+}
+
+// matrixLoginOptions builds the login options from the Matrix config
+func matrixLoginOptions(cfg *config.Config) matrix.LoginOptions {
+	return matrix.LoginOptions{
+		Attempts:   cfg.Matrix.Auth.LoginAttempts,
+		RetryDelay: time.Duration(cfg.Matrix.Auth.LoginRetryDelaySec) * time.Second,
+		HTTP:       matrixHTTPOptions(cfg),
+	}
+}
+
 // Close closes all connections
 func (o *Orchestrator) Close() error {
 	logger.Close()
 	if o.mmClient != nil {
 		o.mmClient.Close()
 	}
+	if o.auditLog != nil {
+		o.auditLog.Close()
+	}
 	return o.tunnelManager.CloseAll()
 }
 
+// CheckTunnels returns an error if one of the SSH tunnels stopped working.
+// It is called between export phases and message batches and before each
+// import stage, so a dead tunnel fails the step with a clear cause instead
+// of the next query or request timing out. Running the step again replaces
+// the dead tunnel.
+func (o *Orchestrator) CheckTunnels() error {
+	if err := o.tunnelManager.Health(); err != nil {
+		return fmt.Errorf("%w; run the step again to reconnect", err)
+	}
+	return nil
+}
+
 // waitForTunnel waits for the SSH tunnel to be ready by making HTTP requests
 func (o *Orchestrator) waitForTunnel(baseURL string, timeout time.Duration) error {
 	client := &http.Client{
@@ -63,30 +213,159 @@ func (o *Orchestrator) waitForTunnel(baseURL string, timeout time.Duration) erro
 	var lastErr error
 	
 	for time.Now().Before(deadline) {
-		// Try to connect to the Matrix server's version endpoint
+		// Try to connect to the Matrix server's version endpoint. The local
+		// listener accepts before the SSH channel is forwarding, so only a
+		// real HTTP response from Synapse counts as ready.
 		resp, err := client.Get(baseURL + "/_matrix/client/versions")
 		if err == nil {
 			resp.Body.Close()
-			logger.Info("SSH tunnel to Matrix API is ready")
-			return nil
+			if resp.StatusCode != http.StatusBadGateway &&
+				resp.StatusCode != http.StatusServiceUnavailable &&
+				resp.StatusCode != http.StatusGatewayTimeout {
+				logger.Info("SSH tunnel to Matrix API is ready")
+				return nil
+			}
+			err = fmt.Errorf("homeserver returned %s", resp.Status)
 		}
 		lastErr = err
-		time.Sleep(500 * time.Millisecond)
+		time.Sleep(250 * time.Millisecond)
 	}
 	
 	return fmt.Errorf("timeout waiting for tunnel: %w", lastErr)
 }
 
+// connectDatabase opens the Mattermost database through the tunnel. The first
+// forwarded connection can race the SSH channel setup and be dropped, so
+// connection-level failures are retried until timeout; errors reported by
+// the database itself (e.g. authentication) are returned immediately.
+func connectDatabase(dbDriver, dsn string, timeout time.Duration) (*mattermost.Client, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		client, err := mattermost.NewClientWithDriver(dbDriver, dsn)
+		if err == nil || !isTransientConnError(err) || time.Now().After(deadline) {
+			return client, err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// isTransientConnError reports whether err is a dropped, refused or timed out
+// connection, or a temporary DNS failure. Errors that won't go away by
+// reconnecting, such as unknown hosts or TLS failures, are not transient.
+func isTransientConnError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// newExporter creates an exporter limited to mattermost.include_teams and
+// mattermost.include_channels
+func (o *Orchestrator) newExporter() *mattermost.Exporter {
+	filter := mattermost.AssetFilter{
+		Teams:          o.config.Mattermost.IncludeTeams,
+		Channels:       o.config.Mattermost.IncludeChannels,
+		DirectMessages: o.config.Import.DirectMessages,
+	}
+	if !o.runOptions.AssetsSince.IsZero() {
+		filter.Since = o.runOptions.AssetsSince.UnixMilli()
+	}
+	return mattermost.NewExporterWithFilter(o.mmClient, filter)
+}
+
+// excludeSkippedUsers removes the users listed in mattermost.skip_users from
+// assets and returns how many were removed
+func (o *Orchestrator) excludeSkippedUsers(assets *mattermost.Assets) int {
+	excluded := mattermost.ExcludeUsers(assets, mattermost.NewUserFilter(o.config.Mattermost.SkipUsers))
+	if excluded > 0 {
+		logger.Info("Excluded %d users listed in skip_users", excluded)
+	}
+	return excluded
+}
+
+// skippedUserIDs returns the Mattermost IDs of the users listed in
+// mattermost.skip_users, or nil if none are configured
+func (o *Orchestrator) skippedUserIDs() (map[string]bool, error) {
+	filter := mattermost.NewUserFilter(o.config.Mattermost.SkipUsers)
+	if filter == nil {
+		return nil, nil
+	}
+	users, err := o.mmClient.GetUsers(mattermost.AssetFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users for skip_users: %w", err)
+	}
+	return filter.ExcludedIDs(users), nil
+}
+
 // GetState returns the current migration state
 func (o *Orchestrator) GetState() *MigrationState {
 	return o.state
 }
 
-// SaveState saves the current state
+// failStep records a step as failed, or as cancelled if err comes from a
+// cancelled context
+func (o *Orchestrator) failStep(name StepName, err error) {
+	if errors.Is(err, context.Canceled) {
+		logger.Warn("%s cancelled", name)
+		o.state.CancelStep(name)
+		return
+	}
+	o.state.FailStep(name, err)
+}
+
+// ResetStep sets a step back to pending and saves the state. It returns the
+// completed steps that now lack a prerequisite and should be reset too
+// before they are relied on.
+func (o *Orchestrator) ResetStep(name StepName) ([]StepName, error) {
+	o.state.ResetStep(name)
+	if err := o.SaveState(); err != nil {
+		return nil, err
+	}
+	logger.Info("Step %s reset to pending", name)
+	return o.state.StaleSteps(), nil
+}
+
+// ResetAllSteps sets every step back to pending and saves the state
+func (o *Orchestrator) ResetAllSteps() error {
+	for _, name := range AllSteps {
+		o.state.ResetStep(name)
+	}
+	if err := o.SaveState(); err != nil {
+		return err
+	}
+	logger.Info("All steps reset to pending")
+	return nil
+}
+
+// SaveState saves the current state. Nothing is saved during a dry run.
 func (o *Orchestrator) SaveState() error {
+	if o.dryRunning {
+		return nil
+	}
 	return SaveState(o.state, o.config.Data.StateFile)
 }
 
+// beginDryRun lets a dry run update a copy of the state as a real run
+// would, without saving it. The returned function restores the real state.
+func (o *Orchestrator) beginDryRun() func() {
+	state := o.state
+	o.state = state.Clone()
+	o.dryRunning = true
+	logger.Info("Dry run: nothing will be changed on the homeserver")
+	return func() {
+		o.state = state
+		o.dryRunning = false
+	}
+}
+
 // ProgressCallback is called to report progress during operations
 type ProgressCallback func(stage string, current, total int, item string)
 
@@ -96,147 +375,192 @@ type OperationResult struct {
 	UsersExported    int
 	TeamsExported    int
 	ChannelsExported int
+	UsersExcluded    int // Users dropped by mattermost.skip_users
 
 	// Import stats
 	UsersCreated   int
 	UsersSkipped   int
 	UsersFailed    int
+	UsersInvalid   int // Users skipped because their username can't form a Matrix ID
+	UsersDuplicateEmail int // Users sharing an email address with an earlier user
+	UsersDeactivated int
 	SpacesCreated  int
 	SpacesSkipped  int
 	SpacesFailed   int
 	RoomsCreated   int
 	RoomsSkipped   int
 	RoomsFailed    int
+	RoomsSkippedEmpty int // Channels without messages, with import.skip_empty_channels
+	DirectRoomsCreated int // Direct and group messages, with import.direct_messages
 	RoomsLinked    int
+	RoomsUpdated   int
 
 	// Membership stats
 	TeamMembershipsExported    int
 	ChannelMembershipsExported int
+	MembershipsExcluded        int // Memberships of users in mattermost.skip_users
 	MembersAdded               int
 	MembersSkipped             int
 	MembersFailed              int
+	MembersPromoted            int // Admins given matrix.admin_power_level
+	MembersRemoved             int // Members who left in Mattermost, by a membership sync
+
+	// Reconciliation compares the exported assets with what is in Matrix
+	// after an asset import
+	Reconciliation []ReconcileRow
 
 	// Output file
 	OutputFile string
+
+	// Partial is true when only some phases of the step ran
+	Partial bool
+
+	// DryRun is true when the stats are what an import would have done
+	DryRun bool
+}
+
+// reportConnect reports a step of connecting to a server, which may take a
+// while on slow links
+func reportConnect(progress ProgressCallback, format string, args ...interface{}) {
+	if progress != nil {
+		progress(fmt.Sprintf(format, args...), 0, 0, "")
+	}
 }
 
-// ConnectMattermost establishes connection to Mattermost
-func (o *Orchestrator) ConnectMattermost() error {
+// ConnectMattermost establishes connection to Mattermost, reporting each
+// step to progress (may be nil)
+func (o *Orchestrator) ConnectMattermost(progress ProgressCallback) error {
 	cfg := o.config.Mattermost
 	passphrase := o.config.GetSSHKeyPassphrase("mattermost")
 	sshPassword := o.config.GetSSHPassword("mattermost")
 
 	// Get database credentials
+	var dbDriver string
 	var dbHost string
 	var dbPort int
 	var dbUser string
 	var dbPassword string
 	var dbName string
+	var dbTLS mattermost.TLSOptions
 
 	if o.config.HasManualDatabaseConfig() {
 		// Use manual config
+		dbDriver = cfg.Database.Driver
+		if dbDriver == "" {
+			dbDriver = mattermost.DriverPostgres
+		}
 		dbHost = cfg.Database.Host
 		dbPort = cfg.Database.Port
 		dbUser = cfg.Database.User
 		dbPassword = o.config.GetMattermostDBPassword()
 		dbName = cfg.Database.Name
+		dbTLS.Mode = cfg.Database.SSLMode
 	} else {
 		// Read from Mattermost config.json via SSH
-		creds, err := mattermost.GetDatabaseCredentials(cfg.SSH, passphrase, sshPassword, cfg.ConfigPath)
+		reportConnect(progress, "Reading database settings from %s via SSH...", cfg.SSH.Host)
+		creds, err := mattermost.GetDatabaseCredentials(cfg.SSH, passphrase, sshPassword, cfg.ConfigPath, cfg.EnvCommand)
 		if err != nil {
 			return fmt.Errorf("failed to read database credentials from Mattermost config: %w", err)
 		}
+		dbDriver = creds.Driver
 		dbHost = creds.Host
 		dbPort = creds.Port
 		dbUser = creds.User
 		dbPassword = creds.Password
 		dbName = creds.Database
+		dbTLS.Mode = creds.SSLMode
+		if cfg.Database.SSLMode != "" {
+			dbTLS.Mode = cfg.Database.SSLMode
+		}
 	}
+	dbTLS.RootCert = cfg.Database.SSLRootCert
+	dbTLS.ServerName = dbHost
 
-	// Get an available local port for the tunnel
-	localPort, err := ssh.GetLocalPort()
-	if err != nil {
-		return fmt.Errorf("failed to get local port: %w", err)
+	// The tunnel ends at 127.0.0.1, which the server certificate doesn't
+	// name; the PostgreSQL driver can only check the CA then
+	if dbDriver == mattermost.DriverPostgres && dbTLS.Mode == "verify-full" {
+		logger.Warn("Database sslmode verify-full can't check the host name through the SSH tunnel, using verify-ca")
+		dbTLS.Mode = "verify-ca"
 	}
 
-	// Create SSH tunnel to database
+	// Create SSH tunnel to database, on ssh.local_port or any free port
 	tunnelCfg := ssh.TunnelConfig{
 		SSHConfig:  cfg.SSH,
-		LocalPort:  localPort,
+		LocalPort:  cfg.SSH.LocalPort,
 		RemoteHost: dbHost,
 		RemotePort: dbPort,
 		Passphrase: passphrase,
 		Password:   sshPassword,
 	}
 
-	_, err = o.tunnelManager.CreateTunnel("mattermost", tunnelCfg)
+	reportConnect(progress, "Establishing SSH tunnel to %s:%d...", cfg.SSH.Host, cfg.SSH.Port)
+	tunnel, err := o.tunnelManager.CreateTunnel("mattermost", tunnelCfg)
 	if err != nil {
 		return fmt.Errorf("failed to create SSH tunnel: %w", err)
 	}
 
 	// Build DSN using local tunnel port
-	dsn := fmt.Sprintf(
-		"host=127.0.0.1 port=%d user=%s password=%s dbname=%s sslmode=disable",
-		localPort,
-		dbUser,
-		dbPassword,
-		dbName,
-	)
+	dsn, err := mattermost.BuildDSN(dbDriver, "127.0.0.1", tunnel.LocalPort(), dbUser, dbPassword, dbName, dbTLS)
+	if err != nil {
+		o.tunnelManager.CloseTunnel("mattermost")
+		return err
+	}
 
-	// Connect to database
-	client, err := mattermost.NewClient(dsn)
+	// Connect to database, waiting for the tunnel to forward
+	reportConnect(progress, "Connecting to the %s database...", dbDriver)
+	client, err := connectDatabase(dbDriver, dsn, 5*time.Second)
 	if err != nil {
 		o.tunnelManager.CloseTunnel("mattermost")
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	client.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	// A reconnect replaces the client of the previous, possibly dead, tunnel
+	if o.mmClient != nil {
+		o.mmClient.Close()
+	}
 	o.mmClient = client
 	o.state.MattermostHost = cfg.SSH.Host
 	return nil
 }
 
-// ConnectMatrix establishes connection to Matrix
-func (o *Orchestrator) ConnectMatrix() error {
+// ConnectMatrix establishes connection to Matrix, reporting each step to
+// progress (may be nil)
+func (o *Orchestrator) ConnectMatrix(ctx context.Context, progress ProgressCallback) error {
 	cfg := o.config.Matrix
 	passphrase := o.config.GetSSHKeyPassphrase("matrix")
 	sshPassword := o.config.GetSSHPassword("matrix")
 
-	// Get an available local port for the tunnel
-	localPort, err := ssh.GetLocalPort()
-	if err != nil {
-		return fmt.Errorf("failed to get local port: %w", err)
-	}
-
 	// Get remote API port from config (default: 8008)
 	remotePort := cfg.API.Port
 	if remotePort == 0 {
 		remotePort = 8008
 	}
 
-	// Create SSH tunnel to Matrix API
+	// Create SSH tunnel to Matrix API, on ssh.local_port or any free port
 	tunnelCfg := ssh.TunnelConfig{
 		SSHConfig:  cfg.SSH,
-		LocalPort:  localPort,
+		LocalPort:  cfg.SSH.LocalPort,
 		RemoteHost: "127.0.0.1",
 		RemotePort: remotePort,
 		Passphrase: passphrase,
 		Password:   sshPassword,
 	}
 
-	logger.Info("Creating SSH tunnel to Matrix API (local:%d -> remote:127.0.0.1:%d)", localPort, remotePort)
+	reportConnect(progress, "Establishing SSH tunnel to %s:%d...", cfg.SSH.Host, cfg.SSH.Port)
 
-	_, err = o.tunnelManager.CreateTunnel("matrix", tunnelCfg)
+	tunnel, err := o.tunnelManager.CreateTunnel("matrix", tunnelCfg)
 	if err != nil {
 		return fmt.Errorf("failed to create SSH tunnel: %w", err)
 	}
+	localPort := tunnel.LocalPort()
+	logger.Info("Created SSH tunnel to Matrix API (local:%d -> remote:127.0.0.1:%d)", localPort, remotePort)
 
 	// Use local tunnel URL
 	baseURL := fmt.Sprintf("http://127.0.0.1:%d", localPort)
 
-	// Wait a moment for the tunnel to be ready
-	time.Sleep(500 * time.Millisecond)
-
-	// Verify tunnel is working by attempting a simple HTTP request
+	// Verify tunnel is forwarding before the first API call
+	reportConnect(progress, "Waiting for the Matrix API...")
 	if err := o.waitForTunnel(baseURL, 5*time.Second); err != nil {
 		o.tunnelManager.CloseTunnel("matrix")
 		return fmt.Errorf("SSH tunnel to Matrix API is not responding on port %d: %w (is Synapse running and listening on port %d?)", remotePort, err, remotePort)
@@ -256,7 +580,7 @@ func (o *Orchestrator) ConnectMatrix() error {
 			return fmt.Errorf("Matrix password not found in environment variable %s", cfg.Auth.PasswordEnv)
 		}
 
-		loginResp, err := matrix.Login(baseURL, cfg.Auth.Username, password)
+		loginResp, err := matrix.LoginWithOptions(baseURL, cfg.Auth.Username, password, matrixLoginOptions(o.config))
 		if err != nil {
 			o.tunnelManager.CloseTunnel("matrix")
 			return fmt.Errorf("failed to login to Matrix: %w", err)
@@ -272,30 +596,55 @@ func (o *Orchestrator) ConnectMatrix() error {
 		RetryBaseDelay:    time.Duration(cfg.RateLimit.RetryBaseDelay) * time.Millisecond,
 	}
 	client := matrix.NewClientWithRateLimit(baseURL, accessToken, cfg.Homeserver, rlConfig)
+	if err := client.SetHTTPOptions(matrixHTTPOptions(o.config)); err != nil {
+		o.tunnelManager.CloseTunnel("matrix")
+		return fmt.Errorf("invalid matrix HTTP settings: %w", err)
+	}
 
 	// Test connection
-	if err := client.TestConnection(); err != nil {
+	if err := client.TestConnection(ctx); err != nil {
 		o.tunnelManager.CloseTunnel("matrix")
 		return fmt.Errorf("failed to connect to Matrix API: %w", err)
 	}
 
-	// Auto-detect homeserver from authenticated user
-	detectedHomeserver, err := client.DetectHomeserver()
-	if err != nil {
+	// Auto-detect homeserver from authenticated user, unless the configured
+	// value is to be trusted as is
+	if cfg.ForceHomeserver {
+		logger.Info("Homeserver auto-detection disabled, using configured value: %s", cfg.Homeserver)
+	} else if detectedHomeserver, err := client.DetectHomeserver(ctx); err != nil {
 		logger.Warn("Could not auto-detect homeserver: %v, using configured value: %s", err, cfg.Homeserver)
+	} else if detectedHomeserver != cfg.Homeserver && cfg.HomeserverStrict {
+		o.tunnelManager.CloseTunnel("matrix")
+		return fmt.Errorf("detected homeserver '%s' differs from configured '%s' (matrix.homeserver_strict is enabled)",
+			detectedHomeserver, cfg.Homeserver)
 	} else if detectedHomeserver != cfg.Homeserver {
 		logger.Info("Auto-detected homeserver '%s' differs from configured '%s', using detected value", 
 			detectedHomeserver, cfg.Homeserver)
 		client.SetHomeserver(detectedHomeserver)
 	}
 
+	// Record every change made on the homeserver when an audit log is configured
+	if cfg.AuditLog != "" {
+		if o.auditLog == nil {
+			auditLog, err := matrix.OpenAuditLog(cfg.AuditLog)
+			if err != nil {
+				o.tunnelManager.CloseTunnel("matrix")
+				return err
+			}
+			o.auditLog = auditLog
+			logger.Info("Recording API changes in audit log %s", cfg.AuditLog)
+		}
+		client.SetAuditLog(o.auditLog)
+	}
+
 	o.mxClient = client
 	o.state.MatrixHost = cfg.SSH.Host
 	return nil
 }
 
-// ExportAssets exports assets from Mattermost
-func (o *Orchestrator) ExportAssets(progress ProgressCallback) (*OperationResult, error) {
+// ExportAssets exports assets from Mattermost. Cancelling ctx stops the
+// export before its next phase.
+func (o *Orchestrator) ExportAssets(ctx context.Context, progress ProgressCallback) (*OperationResult, error) {
 	result := &OperationResult{}
 
 	if o.mmClient == nil {
@@ -303,9 +652,12 @@ func (o *Orchestrator) ExportAssets(progress ProgressCallback) (*OperationResult
 	}
 
 	// Check if we can run this step
-	canRun, reason := o.state.CanRunStep(StepExportAssets)
-	if !canRun {
-		return nil, fmt.Errorf("cannot run step: %s", reason)
+	if err := o.CheckCanRunStep(StepExportAssets); err != nil {
+		return nil, err
+	}
+	if o.state.IsStepCompleted(StepExportAssets) {
+		logger.Warn("export_assets was already completed (%s), the new export replaces it for import",
+			o.state.GetStepOutputFile(StepExportAssets))
 	}
 
 	// Start step
@@ -315,7 +667,7 @@ func (o *Orchestrator) ExportAssets(progress ProgressCallback) (*OperationResult
 	}
 
 	// Create exporter
-	exporter := mattermost.NewExporter(o.mmClient)
+	exporter := o.newExporter()
 
 	// Export callback
 	var exportProgress mattermost.ExportProgressCallback
@@ -326,16 +678,45 @@ func (o *Orchestrator) ExportAssets(progress ProgressCallback) (*OperationResult
 		}
 	}
 
-	// Export assets
-	assets, err := exporter.ExportAssets(exportProgress)
+	// Export assets phase by phase; phases finished by an interrupted run are reused
+	timestamp := fileTimestamp(o.config.UseFixedFileNames())
+	assets := &mattermost.Assets{
+		ExportedAt: time.Now().UnixMilli(),
+		Version:    "1.0",
+	}
+	if since := o.runOptions.AssetsSince; !since.IsZero() {
+		assets.Since = since.UnixMilli()
+		logger.Info("Delta export: users and channels created or updated since %s", since.Format(time.RFC3339))
+	}
+
+	users, err := exportAssetPhase(ctx, o, "users", timestamp, func() ([]mattermost.User, error) {
+		return exporter.ExportUsers(exportProgress)
+	})
 	if err != nil {
-		o.state.FailStep(StepExportAssets, err)
-		o.SaveState()
-		return nil, fmt.Errorf("export failed: %w", err)
+		return nil, err
+	}
+	assets.Users = users
+
+	teams, err := exportAssetPhase(ctx, o, "teams", timestamp, func() ([]mattermost.Team, error) {
+		return exporter.ExportTeams(exportProgress)
+	})
+	if err != nil {
+		return nil, err
 	}
+	assets.Teams = teams
 
-	// Filter to active assets only
-	assets = mattermost.FilterActiveAssets(assets)
+	channels, err := exportAssetPhase(ctx, o, "channels", timestamp, func() ([]mattermost.Channel, error) {
+		return exporter.ExportChannels(exportProgress)
+	})
+	if err != nil {
+		return nil, err
+	}
+	assets.Channels = channels
+
+	// Filter to active assets only (deleted users are kept when include_deleted
+	// is set, archived teams and channels when they will be imported read-only)
+	assets = mattermost.FilterAssets(assets, o.config.Mattermost.IncludeDeleted, o.config.ImportArchivedChannels())
+	result.UsersExcluded = o.excludeSkippedUsers(assets)
 
 	// Count exported items
 	result.UsersExported = len(assets.Users)
@@ -343,25 +724,86 @@ func (o *Orchestrator) ExportAssets(progress ProgressCallback) (*OperationResult
 	result.ChannelsExported = len(assets.Channels)
 
 	// Generate filename
-	timestamp := time.Now().Format("20060102-150405")
-	filename := fmt.Sprintf("mattermost-assets-%s.json.gz", timestamp)
+	filename := dataFileName(".json.gz", "mattermost-assets", timestamp)
 	filepath := o.config.Data.AssetsDir + "/" + filename
 
 	// Save to gzipped JSON
 	if err := archive.SaveGzipJSON(filepath, assets); err != nil {
-		o.state.FailStep(StepExportAssets, err)
+		o.failStep(StepExportAssets, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to save assets: %w", err)
 	}
 
-	// Complete step
+	// Complete step; the per-phase checkpoint files are no longer needed
+	phaseFiles := o.state.GetStep(StepExportAssets).Checkpoints
 	o.state.CompleteStep(StepExportAssets, filepath)
 	result.OutputFile = filepath
-	return result, o.SaveState()
+	if err := o.SaveState(); err != nil {
+		return result, err
+	}
+	o.updateManifest()
+	for _, phaseFile := range phaseFiles {
+		if err := os.Remove(phaseFile); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove export checkpoint %s: %v", phaseFile, err)
+		}
+	}
+	return result, nil
+}
+
+// exportAssetPhase runs one phase of the asset export and checkpoints its result,
+// or loads the result of an earlier, interrupted run if the phase already finished
+func exportAssetPhase[T any](ctx context.Context, o *Orchestrator, phase, timestamp string, export func() ([]T, error)) ([]T, error) {
+	if checkpoint := o.state.GetCheckpoint(StepExportAssets, phase); checkpoint != "" {
+		var items []T
+		err := archive.LoadGzipJSON(checkpoint, &items)
+		if err == nil {
+			logger.Info("Resuming asset export: loaded %d %s from %s", len(items), phase, checkpoint)
+			return items, nil
+		}
+		logger.Warn("Failed to load export checkpoint %s, exporting %s again: %v", checkpoint, phase, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		o.failStep(StepExportAssets, err)
+		o.SaveState()
+		return nil, err
+	}
+	if err := o.CheckTunnels(); err != nil {
+		o.failStep(StepExportAssets, err)
+		o.SaveState()
+		return nil, err
+	}
+
+	items, err := export()
+	if err != nil {
+		o.failStep(StepExportAssets, err)
+		o.SaveState()
+		return nil, fmt.Errorf("export failed: %w", err)
+	}
+
+	phaseFile := filepath.Join(o.config.Data.AssetsDir, dataFileName(".json.gz", "mattermost-assets", timestamp, phase))
+	if err := archive.SaveGzipJSON(phaseFile, items); err != nil {
+		o.failStep(StepExportAssets, err)
+		o.SaveState()
+		return nil, fmt.Errorf("failed to save %s checkpoint: %w", phase, err)
+	}
+
+	o.state.SetCheckpoint(StepExportAssets, phase, phaseFile)
+	if err := o.SaveState(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 // ImportAssets imports assets to Matrix
-func (o *Orchestrator) ImportAssets(progress ProgressCallback) (*OperationResult, error) {
+func (o *Orchestrator) ImportAssets(ctx context.Context, progress ProgressCallback) (*OperationResult, error) {
+	return o.ImportAssetsWithOptions(ctx, matrix.DefaultImportAssetsOptions(), progress)
+}
+
+// ImportAssetsWithOptions imports only the asset types selected in opts.
+// The step is completed once every asset type has been imported, possibly
+// across several runs; until then the mapping is saved and the step stays pending.
+func (o *Orchestrator) ImportAssetsWithOptions(ctx context.Context, opts matrix.ImportAssetsOptions, progress ProgressCallback) (*OperationResult, error) {
 	result := &OperationResult{}
 
 	if o.mxClient == nil {
@@ -369,9 +811,12 @@ func (o *Orchestrator) ImportAssets(progress ProgressCallback) (*OperationResult
 	}
 
 	// Check if we can run this step
-	canRun, reason := o.state.CanRunStep(StepImportAssets)
-	if !canRun {
-		return nil, fmt.Errorf("cannot run step: %s", reason)
+	if err := o.CheckCanRunStep(StepImportAssets); err != nil {
+		return nil, err
+	}
+	if o.runOptions.DryRun {
+		defer o.beginDryRun()()
+		result.DryRun = true
 	}
 
 	// Get the asset file from previous step
@@ -380,6 +825,9 @@ func (o *Orchestrator) ImportAssets(progress ProgressCallback) (*OperationResult
 		return nil, fmt.Errorf("no asset file found from export step")
 	}
 
+	// A partial run after a completed import doesn't make it incomplete
+	wasCompleted := o.state.GetStep(StepImportAssets).Status == StatusCompleted
+
 	// Start step
 	o.state.StartStep(StepImportAssets)
 	if err := o.SaveState(); err != nil {
@@ -389,42 +837,24 @@ func (o *Orchestrator) ImportAssets(progress ProgressCallback) (*OperationResult
 	// Load assets
 	var assets mattermost.Assets
 	if err := archive.LoadGzipJSON(assetFile, &assets); err != nil {
-		o.state.FailStep(StepImportAssets, err)
+		o.failStep(StepImportAssets, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to load assets: %w", err)
 	}
 
+	// Drop skipped users in case the export predates the skip_users setting
+	result.UsersExcluded = o.excludeSkippedUsers(&assets)
+
 	// Try to load existing mapping to skip already imported items
-	var existingMappings *matrix.ExistingMappings
-	existingMappingFile := o.state.GetStepOutputFile(StepImportAssets)
-	if existingMappingFile != "" {
-		existingMapping, err := LoadMapping(existingMappingFile)
-		if err == nil {
-			existingMappings = &matrix.ExistingMappings{
-				Users:  existingMapping.Users,
-				Spaces: existingMapping.Teams,
-				Rooms:  existingMapping.Channels,
-			}
-		}
-	}
+	existingMappings := o.loadExistingMappings()
 
-	// Also check for latest mapping file in mappings directory
-	if existingMappings == nil {
-		latestMapping, _ := GetLatestMappingFile(o.config.Data.MappingsDir)
-		if latestMapping != "" {
-			existingMapping, err := LoadMapping(latestMapping)
-			if err == nil {
-				existingMappings = &matrix.ExistingMappings{
-					Users:  existingMapping.Users,
-					Spaces: existingMapping.Teams,
-					Rooms:  existingMapping.Channels,
-				}
-			}
-		}
+	// m.direct account data is set on behalf of the participants
+	if o.config.Import.DirectMessages && o.config.UseAppService() {
+		o.mxClient.SetASToken(o.config.GetASToken())
 	}
 
 	// Create importer
-	importer := matrix.NewImporter(o.mxClient)
+	importer := o.newImporter()
 
 	// Import callback
 	var importProgress matrix.ImportProgressCallback
@@ -436,9 +866,21 @@ func (o *Orchestrator) ImportAssets(progress ProgressCallback) (*OperationResult
 	}
 
 	// Import assets (passing existing mappings to skip duplicates)
-	importResult, err := importer.ImportAssets(&assets, existingMappings, importProgress)
+	importResult, err := importer.ImportAssetsWithOptions(ctx, &assets, existingMappings, opts, importProgress)
 	if err != nil {
-		o.state.FailStep(StepImportAssets, err)
+		// Keep what was created before the failure, so a re-run reuses it
+		// and undo can delete it. Phases that didn't finish may have no
+		// mapping, so the existing one is kept underneath.
+		if importResult != nil && !o.runOptions.DryRun {
+			mappingFile := GenerateMappingFilename(o.config.Data.MappingsDir, o.config.UseFixedFileNames())
+			if saveErr := SaveMapping(o.importMapping(importer, &assets, existingMappings, importResult), mappingFile); saveErr != nil {
+				logger.Warn("Failed to save the mapping of the partial import: %v", saveErr)
+			} else {
+				logger.Info("Mapping of the partial import saved: %s", mappingFile)
+				o.state.RecordStepOutput(StepImportAssets, mappingFile)
+			}
+		}
+		o.failStep(StepImportAssets, err)
 		o.SaveState()
 		return nil, fmt.Errorf("import failed: %w", err)
 	}
@@ -447,44 +889,122 @@ func (o *Orchestrator) ImportAssets(progress ProgressCallback) (*OperationResult
 	result.UsersCreated = importResult.Stats.UsersCreated
 	result.UsersSkipped = importResult.Stats.UsersSkipped
 	result.UsersFailed = importResult.Stats.UsersFailed
+	result.UsersInvalid = importResult.Stats.UsersInvalid
+	result.UsersDuplicateEmail = importResult.Stats.UsersDuplicateEmail
+	result.UsersDeactivated = importResult.Stats.UsersDeactivated
 	result.SpacesCreated = importResult.Stats.SpacesCreated
 	result.SpacesSkipped = importResult.Stats.SpacesSkipped
 	result.SpacesFailed = importResult.Stats.SpacesFailed
 	result.RoomsCreated = importResult.Stats.RoomsCreated
 	result.RoomsSkipped = importResult.Stats.RoomsSkipped
 	result.RoomsFailed = importResult.Stats.RoomsFailed
+	result.RoomsSkippedEmpty = importResult.Stats.RoomsSkippedEmpty
+	result.RoomsUpdated = importResult.Stats.RoomsUpdated
+	result.DirectRoomsCreated = importResult.Stats.DirectRoomsCreated
+
+	// Save mapping; a dry run's mapping holds placeholder room IDs
+	var mappingFile string
+	if !o.runOptions.DryRun {
+		mappingFile = GenerateMappingFilename(o.config.Data.MappingsDir, o.config.UseFixedFileNames())
+		if err := SaveMapping(o.importMapping(importer, &assets, nil, importResult), mappingFile); err != nil {
+			o.failStep(StepImportAssets, err)
+			o.SaveState()
+			return nil, fmt.Errorf("failed to save mapping: %w", err)
+		}
+	}
 
-	// Create mapping
-	mapping := NewMapping(o.config.Matrix.Homeserver)
-	mapping.MergeUsers(importResult.UserMapping)
-	mapping.MergeTeams(importResult.SpaceMapping)
-	mapping.MergeChannels(importResult.RoomMapping)
+	// Keep an audit trail of accounts created deactivated
+	var reports []string
+	if records := importer.Deactivations(); len(records) > 0 {
+		reportFile, err := SaveDeactivationReport(o.config.Data.MappingsDir, records)
+		if err != nil {
+			logger.Warn("Failed to save deactivation report: %v", err)
+		} else {
+			logger.Info("Deactivation report saved: %s", reportFile)
+			reports = append(reports, reportFile)
+		}
+	}
 
-	// Save mapping
-	mappingFile := GenerateMappingFilename(o.config.Data.MappingsDir)
-	if err := SaveMapping(mapping, mappingFile); err != nil {
-		o.state.FailStep(StepImportAssets, err)
-		o.SaveState()
-		return nil, fmt.Errorf("failed to save mapping: %w", err)
+	// Link rooms to spaces (only needed when spaces or rooms changed)
+	if opts.Spaces || opts.Rooms {
+		if progress != nil {
+			progress("linking", 0, len(assets.Channels), "")
+		}
+		linkResult, err := importer.LinkRoomsToSpaces(ctx, assets.Channels, importResult.SpaceMapping, importResult.RoomMapping, importProgress)
+		if errors.Is(err, context.Canceled) {
+			o.failStep(StepImportAssets, err)
+			o.SaveState()
+			return nil, err
+		}
+		if err == nil && linkResult != nil {
+			result.RoomsLinked = linkResult.RoomsLinked
+			logger.Info("Rooms linked to spaces: %d (already linked: %d, failed: %d)",
+				linkResult.RoomsLinked, linkResult.RoomsAlreadyLinked, linkResult.RoomsLinkFailed)
+		}
 	}
 
-	// Link rooms to spaces
-	if progress != nil {
-		progress("linking", 0, len(assets.Channels), "")
+	result.Reconciliation = o.Reconcile(&assets, importResult, opts)
+	for _, row := range result.Reconciliation {
+		if missing := row.Missing(); missing > 0 {
+			logger.Warn("Reconciliation: %d of %d %s are not in Matrix", missing, row.Source-row.Excluded, row.Kind)
+		} else {
+			logger.Info("Reconciliation: all %d %s are in Matrix", row.Migrated, row.Kind)
+		}
 	}
-	linkResult, err := importer.LinkRoomsToSpaces(assets.Channels, importResult.SpaceMapping, importResult.RoomMapping, importProgress)
-	if err == nil && linkResult != nil {
-		result.RoomsLinked = linkResult.RoomsLinked
+	if o.runOptions.DryRun {
+		return result, nil
 	}
 
-	// Complete step
-	o.state.CompleteStep(StepImportAssets, mappingFile)
+	// Complete step once every asset type has been imported; partial
+	// imports keep the mapping but leave the step pending
+	if opts.Users || opts.LinkExistingUsers {
+		o.state.SetCheckpoint(StepImportAssets, "users", mappingFile)
+	}
+	if opts.Spaces {
+		o.state.SetCheckpoint(StepImportAssets, "spaces", mappingFile)
+	}
+	if opts.Rooms {
+		o.state.SetCheckpoint(StepImportAssets, "rooms", mappingFile)
+	}
+	if wasCompleted || o.state.GetCheckpoint(StepImportAssets, "users") != "" &&
+		o.state.GetCheckpoint(StepImportAssets, "spaces") != "" &&
+		o.state.GetCheckpoint(StepImportAssets, "rooms") != "" {
+		o.state.CompleteStep(StepImportAssets, mappingFile)
+	} else {
+		result.Partial = true
+		o.state.RecordStepOutput(StepImportAssets, mappingFile)
+	}
 	result.OutputFile = mappingFile
-	return result, o.SaveState()
+	if err := o.SaveState(); err != nil {
+		return result, err
+	}
+	o.updateManifest(reports...)
+	return result, nil
+}
+
+// importMapping builds the asset mapping of an import result, on top of
+// existing if it is not nil
+func (o *Orchestrator) importMapping(importer *matrix.Importer, assets *mattermost.Assets, existing *matrix.ExistingMappings, importResult *matrix.ImportAssetsResult) *Mapping {
+	mapping := NewMapping(o.config.Matrix.Homeserver)
+	if existing != nil {
+		mapping.MergeUsers(existing.Users)
+		mapping.MergeTeams(existing.Spaces)
+		mapping.MergeChannels(existing.Rooms)
+	}
+	mapping.MergeUsers(importResult.UserMapping)
+	mapping.MergeTeams(importResult.SpaceMapping)
+	mapping.MergeChannels(importResult.RoomMapping)
+	mapping.RoomAliases = importer.RoomAliases()
+	for _, channel := range assets.Channels {
+		if _, ok := mapping.Channels[channel.ID]; ok && channel.IsDeleted() {
+			mapping.ArchivedChannels = append(mapping.ArchivedChannels, channel.ID)
+		}
+	}
+	return mapping
 }
 
 // ExportMemberships exports memberships from Mattermost
-func (o *Orchestrator) ExportMemberships(progress ProgressCallback) (*OperationResult, error) {
+func (o *Orchestrator) ExportMemberships(ctx context.Context, progress ProgressCallback) (*OperationResult, error) {
 	result := &OperationResult{}
 
 	if o.mmClient == nil {
@@ -492,9 +1012,8 @@ func (o *Orchestrator) ExportMemberships(progress ProgressCallback) (*OperationR
 	}
 
 	// Check if we can run this step
-	canRun, reason := o.state.CanRunStep(StepExportMemberships)
-	if !canRun {
-		return nil, fmt.Errorf("cannot run step: %s", reason)
+	if err := o.CheckCanRunStep(StepExportMemberships); err != nil {
+		return nil, err
 	}
 
 	// Start step
@@ -504,7 +1023,7 @@ func (o *Orchestrator) ExportMemberships(progress ProgressCallback) (*OperationR
 	}
 
 	// Create exporter
-	exporter := mattermost.NewExporter(o.mmClient)
+	exporter := o.newExporter()
 
 	// Export callback
 	var exportProgress mattermost.ExportProgressCallback
@@ -516,40 +1035,213 @@ func (o *Orchestrator) ExportMemberships(progress ProgressCallback) (*OperationR
 	}
 
 	// Export memberships
-	memberships, err := exporter.ExportMemberships(exportProgress)
+	memberships, excluded, err := o.exportActiveMemberships(ctx, exporter, exportProgress)
 	if err != nil {
-		o.state.FailStep(StepExportMemberships, err)
+		o.failStep(StepExportMemberships, err)
 		o.SaveState()
-		return nil, fmt.Errorf("export failed: %w", err)
+		return nil, err
 	}
-
-	// Filter to active memberships
-	memberships = mattermost.FilterActiveMemberships(memberships)
+	result.MembershipsExcluded = excluded
 
 	// Count exported memberships
 	result.TeamMembershipsExported = len(memberships.TeamMembers)
 	result.ChannelMembershipsExported = len(memberships.ChannelMembers)
 
-	// Generate filename
-	timestamp := time.Now().Format("20060102-150405")
-	filename := fmt.Sprintf("mattermost-memberships-%s.json.gz", timestamp)
-	filepath := o.config.Data.AssetsDir + "/" + filename
-
 	// Save to gzipped JSON
-	if err := archive.SaveGzipJSON(filepath, memberships); err != nil {
-		o.state.FailStep(StepExportMemberships, err)
+	filepath, err := o.saveMemberships(memberships)
+	if err != nil {
+		o.failStep(StepExportMemberships, err)
 		o.SaveState()
-		return nil, fmt.Errorf("failed to save memberships: %w", err)
+		return nil, err
 	}
 
 	// Complete step
 	o.state.CompleteStep(StepExportMemberships, filepath)
 	result.OutputFile = filepath
-	return result, o.SaveState()
+	if err := o.SaveState(); err != nil {
+		return result, err
+	}
+	o.updateManifest()
+	return result, nil
+}
+
+// exportActiveMemberships exports the active memberships, without those of
+// users in skip_users. Returns the memberships and how many were excluded.
+func (o *Orchestrator) exportActiveMemberships(ctx context.Context, exporter *mattermost.Exporter, progress mattermost.ExportProgressCallback) (*mattermost.Memberships, int, error) {
+	memberships, err := exporter.ExportMemberships(progress)
+	if err != nil {
+		return nil, 0, fmt.Errorf("export failed: %w", err)
+	}
+
+	// Don't use an export that was interrupted
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Filter to active memberships
+	memberships = mattermost.FilterActiveMemberships(memberships)
+
+	// Drop memberships of skipped users
+	skipped, err := o.skippedUserIDs()
+	if err != nil {
+		return nil, 0, err
+	}
+	excluded := mattermost.ExcludeUserMemberships(memberships, skipped)
+	if excluded > 0 {
+		logger.Info("Excluded %d memberships of users listed in skip_users", excluded)
+	}
+	return memberships, excluded, nil
+}
+
+// saveMemberships writes a membership export to the assets directory and
+// returns its path
+func (o *Orchestrator) saveMemberships(memberships *mattermost.Memberships) (string, error) {
+	filename := dataFileName(".json.gz", "mattermost-memberships", fileTimestamp(o.config.UseFixedFileNames()))
+	filepath := o.config.Data.AssetsDir + "/" + filename
+
+	if err := archive.SaveGzipJSON(filepath, memberships); err != nil {
+		return "", fmt.Errorf("failed to save memberships: %w", err)
+	}
+	return filepath, nil
+}
+
+// SyncMemberships brings the memberships in Matrix up to date after the
+// initial import: it exports the current memberships, compares them with the
+// last export and only applies the changes. New members are invited (and
+// promoted, if they became admins); members who left are removed. The
+// current export then becomes the base of the next sync, once all changes
+// were applied.
+func (o *Orchestrator) SyncMemberships(ctx context.Context, progress ProgressCallback) (*OperationResult, error) {
+	result := &OperationResult{}
+
+	logger.Info("=== SyncMemberships Started ===")
+
+	if o.mmClient == nil {
+		return nil, fmt.Errorf("not connected to Mattermost")
+	}
+	if o.mxClient == nil {
+		return nil, fmt.Errorf("not connected to Matrix")
+	}
+
+	// A sync updates imported memberships; without them it would import all
+	if !o.state.IsStepCompleted(StepImportMemberships) {
+		if !o.runOptions.Force {
+			return nil, fmt.Errorf("cannot sync memberships: import_memberships must be completed first")
+		}
+		logger.Warn("Syncing memberships although import_memberships is not completed (forced)")
+	}
+	if o.runOptions.DryRun {
+		defer o.beginDryRun()()
+		result.DryRun = true
+	}
+
+	membershipFile := o.state.GetStepOutputFile(StepExportMemberships)
+	if membershipFile == "" {
+		return nil, fmt.Errorf("no membership file found from export step")
+	}
+	mappingFile := o.state.GetStepOutputFile(StepImportAssets)
+	if mappingFile == "" {
+		return nil, fmt.Errorf("no mapping file found from import assets step")
+	}
+	logger.Info("Comparing with membership file: %s", membershipFile)
+
+	var previous mattermost.Memberships
+	if err := archive.LoadGzipJSON(membershipFile, &previous); err != nil {
+		return nil, fmt.Errorf("failed to load memberships: %w", err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mapping: %w", err)
+	}
+
+	// Export the current memberships
+	var exportProgress mattermost.ExportProgressCallback
+	if progress != nil {
+		exportProgress = func(stage string, current, total int) {
+			progress(stage, current, total, "")
+		}
+	}
+	current, excluded, err := o.exportActiveMemberships(ctx, o.newExporter(), exportProgress)
+	if err != nil {
+		return nil, err
+	}
+	result.MembershipsExcluded = excluded
+	result.TeamMembershipsExported = len(current.TeamMembers)
+	result.ChannelMembershipsExported = len(current.ChannelMembers)
+
+	added, removed := mattermost.DiffMemberships(&previous, current)
+	logger.Info("Membership changes: %d team and %d channel memberships added, %d team and %d channel memberships removed",
+		len(added.TeamMembers), len(added.ChannelMembers), len(removed.TeamMembers), len(removed.ChannelMembers))
+
+	importer := o.newImporter()
+	var importProgress matrix.ImportProgressCallback
+	if progress != nil {
+		importProgress = matrix.ImportProgressCallback(progress)
+	}
+
+	// Apply additions first, so users moving between channels are never
+	// left outside of all of them
+	var stats []*matrix.ImportStats
+	teamStats, err := importer.ApplyTeamMemberships(ctx, added.TeamMembers, mapping.Users, mapping.Teams, importProgress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply team memberships: %w", err)
+	}
+	stats = append(stats, teamStats)
+	channelStats, err := importer.ApplyChannelMemberships(ctx, added.ChannelMembers, mapping.Users, mapping.Channels, importProgress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply channel memberships: %w", err)
+	}
+	stats = append(stats, channelStats)
+
+	channelStats, err = importer.RemoveChannelMemberships(ctx, removed.ChannelMembers, mapping.Users, mapping.Channels, importProgress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove channel memberships: %w", err)
+	}
+	stats = append(stats, channelStats)
+	teamStats, err = importer.RemoveTeamMemberships(ctx, removed.TeamMembers, mapping.Users, mapping.Teams, importProgress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove team memberships: %w", err)
+	}
+	stats = append(stats, teamStats)
+
+	for _, s := range stats {
+		result.MembersAdded += s.MembersAdded
+		result.MembersSkipped += s.MembersSkipped
+		result.MembersFailed += s.MembersFailed
+		result.MembersPromoted += s.MembersPromoted
+		result.MembersRemoved += s.MembersRemoved
+	}
+
+	logger.Info("=== SyncMemberships Completed ===")
+	logger.Info("Total: added=%d, removed=%d, skipped=%d, failed=%d, promoted=%d",
+		result.MembersAdded, result.MembersRemoved, result.MembersSkipped, result.MembersFailed, result.MembersPromoted)
+	if o.runOptions.DryRun {
+		return result, nil
+	}
+
+	// Keep comparing with the old export until every change is applied; the
+	// changes that were applied are skipped by the next sync
+	if result.MembersFailed > 0 {
+		logger.Warn("%d membership changes failed; run the sync again to retry them", result.MembersFailed)
+		return result, nil
+	}
+
+	// The next sync compares with this export
+	file, err := o.saveMemberships(current)
+	if err != nil {
+		return result, err
+	}
+	o.state.CompleteStep(StepExportMemberships, file)
+	result.OutputFile = file
+	if err := o.SaveState(); err != nil {
+		return result, err
+	}
+	o.updateManifest()
+	return result, nil
 }
 
 // ImportMemberships imports memberships to Matrix
-func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationResult, error) {
+func (o *Orchestrator) ImportMemberships(ctx context.Context, progress ProgressCallback) (*OperationResult, error) {
 	result := &OperationResult{}
 
 	logger.Info("=== ImportMemberships Started ===")
@@ -560,10 +1252,12 @@ func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationR
 	}
 
 	// Check if we can run this step
-	canRun, reason := o.state.CanRunStep(StepImportMemberships)
-	if !canRun {
-		logger.Error("Cannot run step: %s", reason)
-		return nil, fmt.Errorf("cannot run step: %s", reason)
+	if err := o.CheckCanRunStep(StepImportMemberships); err != nil {
+		return nil, err
+	}
+	if o.runOptions.DryRun {
+		defer o.beginDryRun()()
+		result.DryRun = true
 	}
 
 	// Get the membership file and mapping file from previous steps
@@ -592,7 +1286,7 @@ func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationR
 	var memberships mattermost.Memberships
 	if err := archive.LoadGzipJSON(membershipFile, &memberships); err != nil {
 		logger.Error("Failed to load memberships: %v", err)
-		o.state.FailStep(StepImportMemberships, err)
+		o.failStep(StepImportMemberships, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to load memberships: %w", err)
 	}
@@ -604,15 +1298,24 @@ func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationR
 	mapping, err := LoadMapping(mappingFile)
 	if err != nil {
 		logger.Error("Failed to load mapping: %v", err)
-		o.state.FailStep(StepImportMemberships, err)
+		o.failStep(StepImportMemberships, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to load mapping: %w", err)
 	}
 	logger.Info("Loaded mapping: %d users, %d teams, %d channels", 
 		len(mapping.Users), len(mapping.Teams), len(mapping.Channels))
 
+	// Stop before inviting anyone if mapped rooms were deleted
+	if o.runOptions.ValidateMapping {
+		if err := o.validateMappedRooms(ctx, mapping, progress); err != nil {
+			o.failStep(StepImportMemberships, err)
+			o.SaveState()
+			return nil, err
+		}
+	}
+
 	// Create importer
-	importer := matrix.NewImporter(o.mxClient)
+	importer := o.newImporter()
 
 	// Import callback
 	var importProgress matrix.ImportProgressCallback
@@ -627,9 +1330,9 @@ func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationR
 	if progress != nil {
 		progress("team_memberships", 0, len(memberships.TeamMembers), "")
 	}
-	teamStats, err := importer.ApplyTeamMemberships(memberships.TeamMembers, mapping.Users, mapping.Teams, importProgress)
+	teamStats, err := importer.ApplyTeamMemberships(ctx, memberships.TeamMembers, mapping.Users, mapping.Teams, importProgress)
 	if err != nil {
-		o.state.FailStep(StepImportMemberships, err)
+		o.failStep(StepImportMemberships, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to apply team memberships: %w", err)
 	}
@@ -638,9 +1341,9 @@ func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationR
 	if progress != nil {
 		progress("channel_memberships", 0, len(memberships.ChannelMembers), "")
 	}
-	channelStats, err := importer.ApplyChannelMemberships(memberships.ChannelMembers, mapping.Users, mapping.Channels, importProgress)
+	channelStats, err := importer.ApplyChannelMemberships(ctx, memberships.ChannelMembers, mapping.Users, mapping.Channels, importProgress)
 	if err != nil {
-		o.state.FailStep(StepImportMemberships, err)
+		o.failStep(StepImportMemberships, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to apply channel memberships: %w", err)
 	}
@@ -649,15 +1352,186 @@ func (o *Orchestrator) ImportMemberships(progress ProgressCallback) (*OperationR
 	result.MembersAdded = teamStats.MembersAdded + channelStats.MembersAdded
 	result.MembersSkipped = teamStats.MembersSkipped + channelStats.MembersSkipped
 	result.MembersFailed = teamStats.MembersFailed + channelStats.MembersFailed
+	result.MembersPromoted = teamStats.MembersPromoted + channelStats.MembersPromoted
 
 	logger.Info("=== ImportMemberships Completed ===")
-	logger.Info("Total: added=%d, skipped=%d, failed=%d", 
-		result.MembersAdded, result.MembersSkipped, result.MembersFailed)
+	logger.Info("Total: added=%d, skipped=%d, failed=%d, promoted=%d", 
+		result.MembersAdded, result.MembersSkipped, result.MembersFailed, result.MembersPromoted)
 	logger.Success("Membership import completed successfully")
 
 	// Complete step
 	o.state.CompleteStep(StepImportMemberships, "")
-	return result, o.SaveState()
+	if err := o.SaveState(); err != nil {
+		return result, err
+	}
+	o.updateManifest()
+	return result, nil
+}
+
+// deadRoom is a space or room of the mapping that doesn't exist any more
+type deadRoom struct {
+	kind         string // "team" or "channel"
+	mattermostID string
+	roomID       string
+}
+
+// validateMappedRooms checks that the spaces and rooms of mapping still
+// exist on the homeserver and returns an error listing those that don't
+func (o *Orchestrator) validateMappedRooms(ctx context.Context, mapping *Mapping, progress ProgressCallback) error {
+	var refs []deadRoom
+	for teamID, spaceID := range mapping.Teams {
+		refs = append(refs, deadRoom{kind: "team", mattermostID: teamID, roomID: spaceID})
+	}
+	for channelID, roomID := range mapping.Channels {
+		refs = append(refs, deadRoom{kind: "channel", mattermostID: channelID, roomID: roomID})
+	}
+	sort.Slice(refs, func(a, b int) bool { return refs[a].roomID < refs[b].roomID })
+
+	logger.Info("Validating %d mapped spaces and rooms...", len(refs))
+	var dead []deadRoom
+	for idx, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress("validate_mapping", idx+1, len(refs), ref.roomID)
+		}
+		exists, err := o.mxClient.RoomExists(ctx, ref.roomID)
+		if err != nil {
+			return fmt.Errorf("failed to validate mapping: %s %s -> %s: %w", ref.kind, ref.mattermostID, ref.roomID, err)
+		}
+		if !exists {
+			logger.Error("Mapped room no longer exists: %s %s -> %s", ref.kind, ref.mattermostID, ref.roomID)
+			dead = append(dead, ref)
+		}
+	}
+	if len(dead) == 0 {
+		logger.Success("All %d mapped spaces and rooms exist", len(refs))
+		return nil
+	}
+
+	const maxListed = 10
+	var listed []string
+	for _, ref := range dead[:min(len(dead), maxListed)] {
+		listed = append(listed, fmt.Sprintf("%s %s -> %s", ref.kind, ref.mattermostID, ref.roomID))
+	}
+	if len(dead) > maxListed {
+		listed = append(listed, fmt.Sprintf("and %d more (see the log)", len(dead)-maxListed))
+	}
+	return fmt.Errorf("%d mapped spaces or rooms no longer exist: %s; restore them or remove them from the mapping and run import assets again",
+		len(dead), strings.Join(listed, ", "))
+}
+
+// loadExistingMappings returns the mappings of an earlier asset import, from
+// the import step or else the latest mapping file, or nil if there is none
+func (o *Orchestrator) loadExistingMappings() *matrix.ExistingMappings {
+	mappingFile := o.state.GetStepOutputFile(StepImportAssets)
+	if mappingFile == "" {
+		mappingFile, _ = GetLatestMappingFile(o.config.Data.MappingsDir)
+	}
+	if mappingFile == "" {
+		return nil
+	}
+
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		return nil
+	}
+	return &matrix.ExistingMappings{
+		Users:  mapping.Users,
+		Spaces: mapping.Teams,
+		Rooms:  mapping.Channels,
+		RoomAliases: mapping.RoomAliases,
+	}
+}
+
+// AssetPlan counts the users, spaces and rooms an asset import would create
+type AssetPlan struct {
+	Users  int
+	Spaces int
+	Rooms  int
+
+	// Already imported according to the existing mapping; skipped
+	UsersMapped  int
+	SpacesMapped int
+	RoomsMapped  int
+}
+
+// PlanAssets reports how many users, spaces and rooms an asset import with
+// opts would create, using only the export and mapping files (no
+// connections). Channels the import leaves out for other reasons, such as
+// empty ones, are still counted, so the numbers are an upper bound.
+func (o *Orchestrator) PlanAssets(opts matrix.ImportAssetsOptions) (*AssetPlan, error) {
+	assetFile := o.state.GetStepOutputFile(StepExportAssets)
+	if assetFile == "" {
+		return nil, fmt.Errorf("no asset file found from export step")
+	}
+
+	var assets mattermost.Assets
+	if err := archive.LoadGzipJSON(assetFile, &assets); err != nil {
+		return nil, fmt.Errorf("failed to load assets: %w", err)
+	}
+	mattermost.ExcludeUsers(&assets, mattermost.NewUserFilter(o.config.Mattermost.SkipUsers))
+
+	existing := o.loadExistingMappings()
+	if existing == nil {
+		existing = &matrix.ExistingMappings{}
+	}
+
+	plan := &AssetPlan{}
+	if opts.Users {
+		for _, user := range assets.Users {
+			if _, ok := existing.Users[user.ID]; ok {
+				plan.UsersMapped++
+			} else {
+				plan.Users++
+			}
+		}
+	}
+	if opts.Spaces {
+		for _, team := range assets.Teams {
+			if _, ok := existing.Spaces[team.ID]; ok {
+				plan.SpacesMapped++
+			} else {
+				plan.Spaces++
+			}
+		}
+	}
+	if opts.Rooms {
+		for _, channel := range assets.Channels {
+			if _, ok := existing.Rooms[channel.ID]; ok {
+				plan.RoomsMapped++
+			} else {
+				plan.Rooms++
+			}
+		}
+	}
+	return plan, nil
+}
+
+// PlanMemberships reports how the exported memberships would map onto the
+// imported assets, using only the export and mapping files (no connections)
+func (o *Orchestrator) PlanMemberships() (*matrix.MembershipPlan, error) {
+	membershipFile := o.state.GetStepOutputFile(StepExportMemberships)
+	if membershipFile == "" {
+		return nil, fmt.Errorf("no membership file found from export step")
+	}
+	mappingFile := o.state.GetStepOutputFile(StepImportAssets)
+	if mappingFile == "" {
+		return nil, fmt.Errorf("no mapping file found from import assets step")
+	}
+
+	var memberships mattermost.Memberships
+	if err := archive.LoadGzipJSON(membershipFile, &memberships); err != nil {
+		return nil, fmt.Errorf("failed to load memberships: %w", err)
+	}
+
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mapping: %w", err)
+	}
+
+	return matrix.PlanMemberships(&memberships, mapping.Users, mapping.Teams, mapping.Channels), nil
 }
 
 // TestMattermostConnection tests the Mattermost connection
@@ -673,14 +1547,14 @@ func (o *Orchestrator) TestMattermostConnection() error {
 
 	// If not using manual config, test reading config.json
 	if !o.config.HasManualDatabaseConfig() {
-		_, err := mattermost.GetDatabaseCredentials(cfg.SSH, passphrase, sshPassword, cfg.ConfigPath)
+		_, err := mattermost.GetDatabaseCredentials(cfg.SSH, passphrase, sshPassword, cfg.ConfigPath, cfg.EnvCommand)
 		if err != nil {
 			return fmt.Errorf("failed to read Mattermost config: %w", err)
 		}
 	}
 
 	// Connect and test database
-	if err := o.ConnectMattermost(); err != nil {
+	if err := o.ConnectMattermost(nil); err != nil {
 		return err
 	}
 
@@ -704,22 +1578,32 @@ func (o *Orchestrator) TestMatrixConnection() error {
 	}
 
 	// Connect and test API
-	if err := o.ConnectMatrix(); err != nil {
+	if err := o.ConnectMatrix(context.Background(), nil); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ListMatrixUsers returns one page of users on the Matrix homeserver and the
+// token for the next page ("" after the last page)
+func (o *Orchestrator) ListMatrixUsers(ctx context.Context, from string, limit int) ([]matrix.User, string, error) {
+	if o.mxClient == nil {
+		return nil, "", fmt.Errorf("not connected to Matrix")
+	}
+	return o.mxClient.ListUsers(ctx, from, limit)
+}
+
 // ExportMessagesResult contains the result of message export
 type ExportMessagesResult struct {
 	OutputFile       string
 	MessagesExported int
+	MessagesExcluded int // Messages of users in mattermost.skip_users
 	FilesExported    int
 }
 
 // ExportMessages exports all messages from Mattermost
-func (o *Orchestrator) ExportMessages(progress matrix.ImportProgressCallback) (*ExportMessagesResult, error) {
+func (o *Orchestrator) ExportMessages(ctx context.Context, progress matrix.ImportProgressCallback) (*ExportMessagesResult, error) {
 	// Start step
 	o.state.StartStep(StepExportMessages)
 	if err := o.SaveState(); err != nil {
@@ -728,8 +1612,8 @@ func (o *Orchestrator) ExportMessages(progress matrix.ImportProgressCallback) (*
 
 	logger.Info("=== ExportMessages Started ===")
 
-	// Create exporter
-	exporter := mattermost.NewExporter(o.mmClient)
+	// Create exporter; the team and channel selection limits the messages too
+	exporter := o.newExporter()
 
 	// Export messages
 	exportProgress := func(stage string, current, total int) {
@@ -739,25 +1623,55 @@ func (o *Orchestrator) ExportMessages(progress matrix.ImportProgressCallback) (*
 		o.state.UpdateStepProgress(StepExportMessages, current, total)
 	}
 
-	messages, err := exporter.ExportMessages(exportProgress)
-	if err != nil {
-		o.state.FailStep(StepExportMessages, err)
-		o.SaveState()
-		return nil, fmt.Errorf("failed to export messages: %w", err)
+	// Messages of skipped users are dropped when configured
+	var skipped map[string]bool
+	if o.config.Mattermost.SkipUserMessages {
+		var err error
+		if skipped, err = o.skippedUserIDs(); err != nil {
+			o.failStep(StepExportMessages, err)
+			o.SaveState()
+			return nil, err
+		}
 	}
 
-	logger.Info("Exported %d messages", len(messages.Posts))
+	msgRange := o.runOptions.MessageRange
+	if !msgRange.IsZero() {
+		logger.Info("Exporting messages in range: %s", msgRange)
+	}
 
-	// Save to compressed file
-	timestamp := time.Now().Format("20060102-150405")
-	filename := fmt.Sprintf("%s/mattermost-messages-%s.json.gz", o.config.Data.AssetsDir, timestamp)
+	// Stream the messages to the compressed file as they are read, so
+	// memory use doesn't grow with the number of posts
+	filename := o.config.Data.AssetsDir + "/" + dataFileName(".json.gz", "mattermost-messages", fileTimestamp(o.config.UseFixedFileNames()))
+	writer, err := newMessageFileWriter(ctx, filename, msgRange.PostFilter(), o.CheckTunnels)
+	if err != nil {
+		o.failStep(StepExportMessages, err)
+		o.SaveState()
+		return nil, fmt.Errorf("failed to save messages: %w", err)
+	}
 
-	if err := archive.SaveGzipJSON(filename, messages); err != nil {
-		o.state.FailStep(StepExportMessages, err)
+	counts, err := exporter.StreamMessagesFiltered(msgRange.PostFilter(), skipped, writer, exportProgress)
+	if err != nil {
+		// Don't keep an export that failed or was interrupted
+		writer.Discard()
+		o.failStep(StepExportMessages, err)
+		o.SaveState()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("failed to export messages: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		o.failStep(StepExportMessages, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to save messages: %w", err)
 	}
 
+	logger.Info("Exported %d messages", counts.Posts)
+	messagesExcluded := counts.PostsExcluded
+	if messagesExcluded > 0 {
+		logger.Info("Excluded %d messages of users listed in skip_users", messagesExcluded)
+	}
+
 	logger.Success("Messages saved to %s", filename)
 
 	// Complete step
@@ -765,11 +1679,13 @@ func (o *Orchestrator) ExportMessages(progress matrix.ImportProgressCallback) (*
 	if err := o.SaveState(); err != nil {
 		return nil, err
 	}
+	o.updateManifest()
 
 	return &ExportMessagesResult{
 		OutputFile:       filename,
-		MessagesExported: len(messages.Posts),
-		FilesExported:    len(messages.Files),
+		MessagesExported: counts.Posts,
+		MessagesExcluded: messagesExcluded,
+		FilesExported:    counts.Files,
 	}, nil
 }
 
@@ -783,11 +1699,22 @@ type ImportMessagesResult struct {
 	FilesLinked      int
 	FilesUploaded    int
 	FilesSkipped     int
+	FilesFailed      int
+	MessagesOversize int // Split, truncated or skipped per messages.oversize_policy
+	MessagesEncrypted int // Skipped because their room is end-to-end encrypted
+	RoomsEncrypted   int
+	PinnedEvents     int // Pinned posts pinned again in their room
 	MappingFile      string
 }
 
 // ImportMessages imports messages to Matrix
-func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*ImportMessagesResult, error) {
+func (o *Orchestrator) ImportMessages(ctx context.Context, progress matrix.MessageImportCallback) (*ImportMessagesResult, error) {
+	// Replies, threads and files refer to the events sent before them, so
+	// messages can't be imported without sending them
+	if o.runOptions.DryRun {
+		return nil, fmt.Errorf("message import does not support dry runs")
+	}
+
 	// Start step
 	o.state.StartStep(StepImportMessages)
 	if err := o.SaveState(); err != nil {
@@ -800,20 +1727,34 @@ func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*I
 	messagesFile := o.state.GetStepOutputFile(StepExportMessages)
 	if messagesFile == "" {
 		err := fmt.Errorf("no messages export file found")
-		o.state.FailStep(StepImportMessages, err)
+		o.failStep(StepImportMessages, err)
 		o.SaveState()
 		return nil, err
 	}
 
-	var messages mattermost.Messages
-	if err := archive.LoadGzipJSON(messagesFile, &messages); err != nil {
-		o.state.FailStep(StepImportMessages, err)
+	messages, err := loadMessages(messagesFile)
+	if err != nil {
+		o.failStep(StepImportMessages, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to load messages: %w", err)
 	}
 
 	logger.Info("Loaded %d messages and %d files from %s", len(messages.Posts), len(messages.Files), messagesFile)
 
+	// Limit to the selected date range; replies whose thread root is outside
+	// it are imported as plain messages
+	if msgRange := o.runOptions.MessageRange; !msgRange.IsZero() {
+		filter := msgRange.PostFilter()
+		kept := messages.Posts[:0]
+		for _, post := range messages.Posts {
+			if filter.Contains(post.CreateAt) {
+				kept = append(kept, post)
+			}
+		}
+		logger.Info("Importing %d of %d messages in range: %s", len(kept), len(messages.Posts), msgRange)
+		messages.Posts = kept
+	}
+
 	// Build files by post map
 	filesByPost := make(map[string][]mattermost.FileInfo)
 	for _, file := range messages.Files {
@@ -827,34 +1768,35 @@ func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*I
 	assetMappingFile := o.state.GetStepOutputFile(StepImportAssets)
 	if assetMappingFile == "" {
 		err := fmt.Errorf("no asset mapping file found")
-		o.state.FailStep(StepImportMessages, err)
+		o.failStep(StepImportMessages, err)
 		o.SaveState()
 		return nil, err
 	}
 
 	assetMapping, err := LoadMapping(assetMappingFile)
 	if err != nil {
-		o.state.FailStep(StepImportMessages, err)
+		o.failStep(StepImportMessages, err)
 		o.SaveState()
 		return nil, fmt.Errorf("failed to load asset mapping: %w", err)
 	}
 
 	logger.Info("Loaded asset mapping: %d rooms, %d users", len(assetMapping.Channels), len(assetMapping.Users))
 
-	// Load or create message mapping for resume support
-	msgMappingFile, _ := GetLatestMessageMappingFile(o.config.Data.MappingsDir)
-	var msgMapping *MessageMapping
-	
-	if msgMappingFile != "" {
-		msgMapping, err = LoadMessageMapping(msgMappingFile)
-		if err != nil {
-			logger.Warn("Failed to load existing message mapping, starting fresh: %v", err)
-			msgMapping = NewMessageMapping(o.config.Matrix.Homeserver)
-		} else {
-			logger.Info("Resuming from existing mapping with %d messages", msgMapping.Count())
-		}
-	} else {
-		msgMapping = NewMessageMapping(o.config.Matrix.Homeserver)
+	// Resuming needs the mapping of the interrupted run; without it every
+	// message would be sent again
+	if o.runOptions.Resume && !o.hasMessageMapping() {
+		err := fmt.Errorf("cannot resume: no message mapping found in %s", o.config.Data.MappingsDir)
+		o.failStep(StepImportMessages, err)
+		o.SaveState()
+		return nil, err
+	}
+
+	// Open the message mapping for resume support
+	store, err := o.openMessageStore(ctx, len(messages.Posts))
+	if err != nil {
+		o.failStep(StepImportMessages, err)
+		o.SaveState()
+		return nil, err
 	}
 
 	// Set up AS token if configured
@@ -866,13 +1808,7 @@ func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*I
 	}
 
 	// Create importer
-	importer := matrix.NewImporter(o.mxClient)
-
-	// Convert existing mapping to simple map
-	existingMapping := make(map[string]string)
-	for mmID, entry := range msgMapping.Messages {
-		existingMapping[mmID] = entry.MatrixEventID
-	}
+	importer := o.newImporter()
 
 	// Build file config
 	fileConfig := &matrix.FileConfig{
@@ -882,51 +1818,102 @@ func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*I
 	}
 	logger.Info("File mode: %s, S3 URL: %s", fileConfig.Mode, fileConfig.S3PublicURL)
 
+	// Uploads and custom emojis read the files from the Mattermost server's
+	// local storage; emojis alone don't justify failing the import
+	uploadFiles := fileConfig.Mode == "upload" && len(filesByPost) > 0
+	importEmojis := len(messages.Emojis) > 0 && o.config.Mattermost.Files.LocalDataPath != ""
+	var fileStore *mattermost.FileStore
+	if uploadFiles || importEmojis {
+		fileStore, err = mattermost.OpenFileStore(
+			o.config.Mattermost.SSH,
+			o.config.GetSSHKeyPassphrase("mattermost"),
+			o.config.GetSSHPassword("mattermost"),
+			o.config.Mattermost.Files.LocalDataPath,
+			fileConfig.MaxUploadSize,
+		)
+		if err != nil && uploadFiles {
+			store.Close()
+			err = fmt.Errorf("failed to open Mattermost file storage: %w", err)
+			o.failStep(StepImportMessages, err)
+			o.SaveState()
+			return nil, err
+		}
+		if err != nil {
+			logger.Warn("Skipping custom emojis - failed to open Mattermost file storage: %v", err)
+			fileStore = nil
+		} else {
+			defer fileStore.Close()
+		}
+	}
+	if uploadFiles {
+		fileConfig.Fetch = fileStore.ReadFile
+		logger.Info("Uploading files from %s:%s", o.config.Mattermost.SSH.Host, o.config.Mattermost.Files.LocalDataPath)
+	}
+
+	// Custom emojis go first so that rooms offer them once history is in
+	if fileStore != nil && importEmojis {
+		emojiStats := importer.ImportCustomEmojis(ctx, messages.Emojis, messages.Posts, assetMapping.Channels, fileStore.ReadEmoji)
+		logger.Info("Custom emojis: uploaded=%d, failed=%d, rooms updated=%d",
+			emojiStats.EmojisUploaded, emojiStats.EmojisFailed, emojiStats.RoomsUpdated)
+	} else if len(messages.Emojis) > 0 && !importEmojis {
+		logger.Warn("Skipping %d custom emojis - mattermost.files.local_data_path is not set", len(messages.Emojis))
+	}
+
+	// Archived rooms are locked when an import completes; a later import into
+	// them, such as a retry of failed posts, lifts the lock until it completes
+	// again, as the puppeted senders lack the raised power level
+	archivedRooms := o.archivedRoomIDs(assetMapping)
+	if len(archivedRooms) > 0 {
+		withPosts := make(map[string]bool)
+		for _, post := range messages.Posts {
+			if roomID, ok := assetMapping.GetMatrixRoomID(post.ChannelID); ok {
+				withPosts[roomID] = true
+			}
+		}
+		var unlock []string
+		for _, roomID := range archivedRooms {
+			if withPosts[roomID] {
+				unlock = append(unlock, roomID)
+			}
+		}
+		if len(unlock) > 0 {
+			unlocked, failed := importer.UnlockRooms(ctx, unlock)
+			logger.Info("Archived rooms opened for the import: %d (failed: %d)", unlocked, failed)
+		}
+	}
+
+	// A date range, given now or when the messages were exported, covers
+	// only part of each channel, so channels must not be marked completed;
+	// hiding the completion methods still dedups per post
+	var importStore matrix.MessageStore = store
+	if !o.runOptions.MessageRange.IsZero() || messages.Since != 0 || messages.Until != 0 {
+		importStore = struct{ matrix.MessageStore }{store}
+	}
+
 	// Import messages with files
-	result, err := importer.ImportMessagesWithFiles(
+	result, err := importer.ImportMessagesToStore(
+		ctx,
 		messages.Posts,
 		assetMapping.Channels,  // channelID -> roomID
 		assetMapping.Users,     // userID -> matrixUserID
-		existingMapping,        // existing message mapping
+		importStore,            // message mapping, updated as messages are sent
 		filesByPost,            // post ID -> files
 		fileConfig,             // file migration settings
 		progress,
 	)
-	if err != nil {
-		o.state.FailStep(StepImportMessages, err)
-		o.SaveState()
-		return nil, fmt.Errorf("failed to import messages: %w", err)
-	}
 
-	// Update message mapping with new imports
-	for mmID, mxEventID := range result.Mapping {
-		if _, exists := msgMapping.Messages[mmID]; !exists {
-			// Find the post to get additional info
-			for _, post := range messages.Posts {
-				if post.ID == mmID {
-					msgMapping.AddMessage(&MessageMapEntry{
-						MattermostID:  mmID,
-						MatrixEventID: mxEventID,
-						ChannelID:     post.ChannelID,
-						RoomID:        assetMapping.Channels[post.ChannelID],
-						UserID:        post.UserID,
-						MatrixUserID:  assetMapping.Users[post.UserID],
-						Timestamp:     post.CreateAt,
-						IsReply:       post.IsReply(),
-						RootID:        post.RootID,
-					})
-					break
-				}
-			}
-		}
+	// Save message mapping, including whatever was imported before a failure
+	mappingFile := store.Path()
+	if closeErr := store.Close(); closeErr != nil {
+		logger.Warn("Failed to save message mapping: %v", closeErr)
+	} else {
+		logger.Info("Message mapping saved to %s", mappingFile)
 	}
 
-	// Save message mapping
-	newMappingFile := GenerateMessageMappingFilename(o.config.Data.MappingsDir)
-	if err := SaveMessageMapping(msgMapping, newMappingFile); err != nil {
-		logger.Warn("Failed to save message mapping: %v", err)
-	} else {
-		logger.Info("Message mapping saved to %s", newMappingFile)
+	if err != nil {
+		o.failStep(StepImportMessages, err)
+		o.SaveState()
+		return nil, fmt.Errorf("failed to import messages: %w", err)
 	}
 
 	logger.Info("=== ImportMessages Completed ===")
@@ -934,15 +1921,34 @@ func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*I
 		result.Stats.MessagesImported, result.Stats.MessagesSkipped, result.Stats.MessagesFailed)
 	logger.Info("Replies: imported=%d, failed=%d",
 		result.Stats.RepliesImported, result.Stats.RepliesFailed)
-	logger.Info("Files: linked=%d, uploaded=%d, skipped=%d",
-		result.Stats.FilesLinked, result.Stats.FilesUploaded, result.Stats.FilesSkipped)
+	logger.Info("Files: linked=%d, uploaded=%d, skipped=%d, failed=%d",
+		result.Stats.FilesLinked, result.Stats.FilesUploaded, result.Stats.FilesSkipped, result.Stats.FilesFailed)
+	if result.Stats.ChannelsCompleted > 0 {
+		logger.Info("Channels already completed: %d", result.Stats.ChannelsCompleted)
+	}
+	if result.Stats.MessagesOversize > 0 {
+		logger.Info("Oversized messages (%s): %d", o.config.GetOversizePolicy(), result.Stats.MessagesOversize)
+	}
+	if result.Stats.MessagesEncrypted > 0 {
+		logger.Warn("Messages skipped in %d encrypted rooms: %d", result.Stats.RoomsEncrypted, result.Stats.MessagesEncrypted)
+	}
+	if result.Stats.PinnedEvents > 0 {
+		logger.Info("Pinned events restored: %d", result.Stats.PinnedEvents)
+	}
 	logger.Success("Message import completed successfully")
 
+	// Lock archived rooms now that their history is in place
+	if len(archivedRooms) > 0 {
+		locked, failed := o.newImporter().LockRooms(ctx, archivedRooms)
+		logger.Info("Archived rooms made read-only: %d (failed: %d)", locked, failed)
+	}
+
 	// Complete step
-	o.state.CompleteStep(StepImportMessages, newMappingFile)
+	o.state.CompleteStep(StepImportMessages, mappingFile)
 	if err := o.SaveState(); err != nil {
 		return nil, err
 	}
+	o.updateManifest()
 
 	return &ImportMessagesResult{
 		MessagesImported: result.Stats.MessagesImported,
@@ -953,6 +1959,105 @@ func (o *Orchestrator) ImportMessages(progress matrix.MessageImportCallback) (*I
 		FilesLinked:      result.Stats.FilesLinked,
 		FilesUploaded:    result.Stats.FilesUploaded,
 		FilesSkipped:     result.Stats.FilesSkipped,
-		MappingFile:      newMappingFile,
+		FilesFailed:      result.Stats.FilesFailed,
+		MessagesOversize: result.Stats.MessagesOversize,
+		MessagesEncrypted: result.Stats.MessagesEncrypted,
+		RoomsEncrypted:   result.Stats.RoomsEncrypted,
+		PinnedEvents:     result.Stats.PinnedEvents,
+		MappingFile:      mappingFile,
 	}, nil
 }
+
+// archivedRoomIDs returns the rooms of the archived channels in mapping, or
+// nil when archived channels aren't imported
+func (o *Orchestrator) archivedRoomIDs(mapping *Mapping) []string {
+	if !o.config.ImportArchivedChannels() {
+		return nil
+	}
+	roomIDs := make([]string, 0, len(mapping.ArchivedChannels))
+	for _, channelID := range mapping.ArchivedChannels {
+		if roomID, ok := mapping.GetMatrixRoomID(channelID); ok {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs
+}
+
+// messageStore is a message mapping the importer writes to while sending messages
+type messageStore interface {
+	matrix.ChannelCompletionStore
+	Count() int
+	Path() string
+	Close() error
+}
+
+// hasMessageMapping returns true if an earlier message import left a
+// message mapping, in either backend
+func (o *Orchestrator) hasMessageMapping() bool {
+	if _, err := os.Stat(GetBoltMessageMappingPath(o.config.Data.MappingsDir)); err == nil {
+		return true
+	}
+	file, _ := GetLatestMessageMappingFile(o.config.Data.MappingsDir)
+	return file != ""
+}
+
+// openMessageStore opens the message mapping for an import of postCount posts.
+// Small imports keep the mapping in memory and save it as JSON; large ones use
+// an on-disk bbolt database so lookups don't need the whole mapping in memory.
+func (o *Orchestrator) openMessageStore(ctx context.Context, postCount int) (messageStore, error) {
+	mappingsDir := o.config.Data.MappingsDir
+	boltPath := GetBoltMessageMappingPath(mappingsDir)
+	msgMappingFile, _ := GetLatestMessageMappingFile(mappingsDir)
+
+	_, statErr := os.Stat(boltPath)
+	boltExists := statErr == nil
+
+	// Once a database exists, keep using it unless memory is explicitly requested
+	if o.config.UseBoltMessageMapping(postCount) || (boltExists && o.config.Data.MessageMappingBackend != "memory") {
+		store, err := OpenBoltMessageStore(boltPath, o.config.Matrix.Homeserver)
+		if err != nil {
+			return nil, err
+		}
+
+		// Carry over progress from an earlier in-memory run
+		if store.Count() == 0 && msgMappingFile != "" {
+			msgMapping, err := LoadMessageMapping(msgMappingFile)
+			if err != nil && o.runOptions.Resume {
+				store.Close()
+				return nil, fmt.Errorf("cannot resume: failed to load message mapping %s: %w", msgMappingFile, err)
+			} else if err != nil {
+				logger.Warn("Failed to load existing message mapping, starting fresh: %v", err)
+			} else if err := store.ImportMapping(msgMapping); err != nil {
+				store.Close()
+				return nil, fmt.Errorf("failed to copy message mapping into %s: %w", boltPath, err)
+			}
+		}
+
+		logger.Info("Using on-disk message mapping %s with %d messages", boltPath, store.Count())
+		return store, nil
+	}
+
+	if boltExists {
+		logger.Warn("Found on-disk message mapping %s, but the memory backend is selected; set data.message_mapping_backend to bolt to resume from it", boltPath)
+	}
+
+	var msgMapping *MessageMapping
+	if msgMappingFile != "" {
+		var err error
+		msgMapping, err = LoadMessageMapping(msgMappingFile)
+		if err != nil && o.runOptions.Resume {
+			return nil, fmt.Errorf("cannot resume: failed to load message mapping %s: %w", msgMappingFile, err)
+		} else if err != nil {
+			logger.Warn("Failed to load existing message mapping, starting fresh: %v", err)
+			msgMapping = NewMessageMapping(o.config.Matrix.Homeserver)
+		} else {
+			logger.Info("Resuming from existing mapping with %d messages", msgMapping.Count())
+		}
+	} else {
+		msgMapping = NewMessageMapping(o.config.Matrix.Homeserver)
+	}
+
+	// Save in the background as the mapping grows
+	newMappingFile := GenerateMessageMappingFilename(mappingsDir, o.config.UseFixedFileNames())
+	return NewMessageMappingSaver(ctx, msgMapping, newMappingFile, o.config.Data.MessageMappingSaveInterval), nil
+}
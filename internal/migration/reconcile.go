@@ -0,0 +1,87 @@
+package migration
+
+import (
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+)
+
+// ReconcileRow compares the Mattermost items of one kind with the Matrix
+// items they were migrated to
+type ReconcileRow struct {
+	Kind     string `json:"kind"`     // users, spaces or rooms
+	Source   int    `json:"source"`   // Mattermost items that should be migrated
+	Excluded int    `json:"excluded"` // Of those, left out on purpose by the configuration
+	Migrated int    `json:"migrated"` // Of those, items with a Matrix counterpart (created now or earlier)
+	Created  int    `json:"created"`  // Matrix items created by this run
+}
+
+// Missing returns how many items should have been migrated but have no
+// Matrix counterpart
+func (r ReconcileRow) Missing() int {
+	if missing := r.Source - r.Excluded - r.Migrated; missing > 0 {
+		return missing
+	}
+	return 0
+}
+
+// Reconcile compares the exported assets with the mappings of an asset
+// import, for the asset types selected in opts. Deleted teams and channels
+// count only when archived channels are imported, deleted users only with
+// mattermost.include_deleted, and direct messages only with
+// import.direct_messages.
+func (o *Orchestrator) Reconcile(assets *mattermost.Assets, imported *matrix.ImportAssetsResult, opts matrix.ImportAssetsOptions) []ReconcileRow {
+	exportStats := assets.CalculateStats()
+	importStats := imported.Stats
+	var rows []ReconcileRow
+
+	if opts.Users || opts.LinkExistingUsers {
+		row := ReconcileRow{Kind: "users", Source: exportStats.UsersActive, Created: importStats.UsersCreated}
+		if o.config.Mattermost.IncludeDeleted {
+			row.Source = exportStats.UsersTotal
+		}
+		for _, user := range assets.Users {
+			if _, ok := imported.UserMapping[user.ID]; ok && (!user.IsDeleted() || o.config.Mattermost.IncludeDeleted) {
+				row.Migrated++
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	archived := o.config.ImportArchivedChannels()
+
+	if opts.Spaces {
+		row := ReconcileRow{Kind: "spaces", Source: exportStats.TeamsActive, Created: importStats.SpacesCreated}
+		if archived {
+			row.Source = exportStats.TeamsTotal
+		}
+		for _, team := range assets.Teams {
+			if _, ok := imported.SpaceMapping[team.ID]; ok && (!team.IsDeleted() || archived) {
+				row.Migrated++
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if opts.Rooms {
+		row := ReconcileRow{Kind: "rooms", Source: exportStats.ChannelsActive, Created: importStats.RoomsCreated}
+		if archived {
+			row.Source = exportStats.ChannelsTotal
+		}
+		row.Excluded = importStats.RoomsSkippedEmpty + importStats.RoomsSkippedOrphan
+		for _, channel := range assets.Channels {
+			if channel.IsDeleted() && !archived {
+				continue
+			}
+			if channel.IsDirect() && !o.config.Import.DirectMessages {
+				row.Source--
+				continue
+			}
+			if _, ok := imported.RoomMapping[channel.ID]; ok {
+				row.Migrated++
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
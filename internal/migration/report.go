@@ -0,0 +1,43 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
+)
+
+// DeactivationReport lists accounts that were created deactivated because
+// the source Mattermost user was deleted. It distinguishes migration
+// deactivations from accounts later disabled by an admin.
+type DeactivationReport struct {
+	Version   string                      `json:"version"`
+	CreatedAt int64                       `json:"created_at"`
+	Users     []matrix.DeactivationRecord `json:"users"`
+}
+
+// SaveDeactivationReport writes a deactivation report to dir and returns its
+// path. The name is always timestamped, even with fixed file naming, so
+// earlier runs' reports are kept as an audit trail.
+func SaveDeactivationReport(dir string, records []matrix.DeactivationRecord) (string, error) {
+	report := &DeactivationReport{
+		Version:   "1.0",
+		CreatedAt: time.Now().UnixMilli(),
+		Users:     records,
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal deactivation report: %w", err)
+	}
+
+	filePath := filepath.Join(dir, dataFileName(".json", "deactivated-users", fileTimestamp(false)))
+	if err := archive.WriteFile(filePath, data); err != nil {
+		return "", fmt.Errorf("failed to write deactivation report: %w", err)
+	}
+
+	return filePath, nil
+}
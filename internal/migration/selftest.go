@@ -0,0 +1,244 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+	"github.com/aligundogdu/matrixmigrate/internal/mattermost"
+)
+
+// selfTestCleanupTimeout bounds the cleanup, which runs on its own context so
+// that it still happens after the test was interrupted
+const selfTestCleanupTimeout = 2 * time.Minute
+
+// SelfTestOptions controls SelfTest
+type SelfTestOptions struct {
+	// MatrixOnly skips the read-only export from Mattermost
+	MatrixOnly bool
+}
+
+// SelfTestResult holds the steps run by SelfTest
+type SelfTestResult struct {
+	Steps     []TestStep
+	AllPassed bool
+}
+
+// selfTestData is the synthetic migration SelfTest imports
+type selfTestData struct {
+	assets  *mattermost.Assets
+	members []mattermost.ChannelMember
+}
+
+// newSelfTestData generates two users, a team and a private channel with
+// both users in it. All names carry stamp, so runs never collide.
+func newSelfTestData(stamp string) selfTestData {
+	now := time.Now().UnixMilli()
+	prefix := "selftest" + stamp
+	assets := &mattermost.Assets{
+		ExportedAt: now,
+		Version:    "1.0",
+		Users: []mattermost.User{
+			{ID: prefix + "user1", Username: "selftest-" + stamp + "-1", FirstName: "Selftest", LastName: "One", CreateAt: now},
+			{ID: prefix + "user2", Username: "selftest-" + stamp + "-2", FirstName: "Selftest", LastName: "Two", CreateAt: now},
+		},
+		Teams: []mattermost.Team{
+			{ID: prefix + "team", Name: "selftest-" + stamp, DisplayName: "MatrixMigrate self-test " + stamp, Type: "I", CreateAt: now},
+		},
+		Channels: []mattermost.Channel{
+			{ID: prefix + "channel", TeamID: prefix + "team", Name: "selftest-" + stamp, DisplayName: "Self-test " + stamp,
+				Purpose: "Created by matrixmigrate selftest; deleted when the test ends", Type: "P", CreatorID: prefix + "user1", CreateAt: now},
+		},
+	}
+
+	var members []mattermost.ChannelMember
+	for _, user := range assets.Users {
+		members = append(members, mattermost.ChannelMember{ChannelID: prefix + "channel", UserID: user.ID, Roles: "channel_user"})
+	}
+	return selfTestData{assets: assets, members: members}
+}
+
+// SelfTest runs a small synthetic migration against the homeserver: it
+// imports two users, a space and a room, invites the users, checks the
+// result and then deletes the room and space and deactivates the users.
+// Unless opts.MatrixOnly is set, it first exports the assets from
+// Mattermost without saving them. The state, mappings and data files are
+// never touched. Cleanup runs even if a step failed.
+func (o *Orchestrator) SelfTest(ctx context.Context, opts SelfTestOptions, callback TestCallback) (*SelfTestResult, error) {
+	if o.mxClient == nil {
+		return nil, fmt.Errorf("not connected to Matrix")
+	}
+	if !opts.MatrixOnly && o.mmClient == nil {
+		return nil, fmt.Errorf("not connected to Mattermost")
+	}
+
+	result := &SelfTestResult{AllPassed: true}
+	run := func(name, description string, test func() (string, error)) bool {
+		step := TestStep{Name: name, Description: description, Status: TestRunning}
+		details, err := test()
+		if err != nil {
+			step.Status = TestFailed
+			step.Error = err.Error()
+			result.AllPassed = false
+		} else {
+			step.Status = TestPassed
+			step.Details = details
+		}
+		if callback != nil {
+			callback("selftest", &step)
+		}
+		result.Steps = append(result.Steps, step)
+		return err == nil
+	}
+
+	if !opts.MatrixOnly {
+		run("selftest_export", "Export from Mattermost (not saved)", func() (string, error) {
+			assets, err := o.newExporter().ExportAssets(nil)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d users, %d teams, %d channels", len(assets.Users), len(assets.Teams), len(assets.Channels)), nil
+		})
+	}
+
+	data := newSelfTestData(fmt.Sprintf("%x", time.Now().Unix()))
+	logger.Info("=== Self-test Started: %d users, %d teams, %d channels ===",
+		len(data.assets.Users), len(data.assets.Teams), len(data.assets.Channels))
+
+	// On error imported still holds what was created, for the cleanup
+	importer := o.newImporter()
+	var imported *matrix.ImportAssetsResult
+	run("selftest_import", "Import users, space and room", func() (string, error) {
+		var err error
+		imported, err = importer.ImportAssets(ctx, data.assets, &matrix.ExistingMappings{}, nil)
+		if err != nil {
+			return "", err
+		}
+		if _, err := importer.LinkRoomsToSpaces(ctx, data.assets.Channels, imported.SpaceMapping, imported.RoomMapping, nil); err != nil {
+			return "", err
+		}
+		stats := imported.Stats
+		if stats.UsersFailed+stats.SpacesFailed+stats.RoomsFailed > 0 {
+			return "", fmt.Errorf("failed: %d users, %d spaces, %d rooms (see the log)", stats.UsersFailed, stats.SpacesFailed, stats.RoomsFailed)
+		}
+		if len(imported.UserMapping) != len(data.assets.Users) || len(imported.SpaceMapping) != 1 || len(imported.RoomMapping) != 1 {
+			return "", fmt.Errorf("imported %d users, %d spaces, %d rooms, expected %d, 1, 1",
+				len(imported.UserMapping), len(imported.SpaceMapping), len(imported.RoomMapping), len(data.assets.Users))
+		}
+		return fmt.Sprintf("%d users, 1 space, 1 room", len(imported.UserMapping)), nil
+	})
+
+	if imported != nil && len(imported.RoomMapping) > 0 && ctx.Err() == nil {
+		run("selftest_memberships", "Invite users to the room", func() (string, error) {
+			stats, err := importer.ApplyChannelMemberships(ctx, data.members, imported.UserMapping, imported.RoomMapping, nil)
+			if err != nil {
+				return "", err
+			}
+			if stats.MembersFailed > 0 {
+				return "", fmt.Errorf("%d invites failed (see the log)", stats.MembersFailed)
+			}
+			return fmt.Sprintf("%d invited", stats.MembersAdded), nil
+		})
+
+		run("selftest_verify", "Verify users and room members", func() (string, error) {
+			return o.verifySelfTest(ctx, data, imported)
+		})
+	}
+
+	if imported != nil {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), selfTestCleanupTimeout)
+		defer cancel()
+		run("selftest_cleanup", "Delete room and space, deactivate users", func() (string, error) {
+			return o.cleanUpSelfTest(cleanupCtx, imported)
+		})
+	}
+
+	logger.Info("=== Self-test Completed: passed=%v ===", result.AllPassed)
+	return result, nil
+}
+
+// verifySelfTest checks that the imported users exist and are invited to
+// or joined in the imported room
+func (o *Orchestrator) verifySelfTest(ctx context.Context, data selfTestData, imported *matrix.ImportAssetsResult) (string, error) {
+	for _, user := range data.assets.Users {
+		userID := imported.UserMapping[user.ID]
+		info, err := o.mxClient.GetUser(ctx, userID)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up %s: %w", userID, err)
+		}
+		if info == nil {
+			return "", fmt.Errorf("user %s does not exist", userID)
+		}
+	}
+
+	roomID := imported.RoomMapping[data.assets.Channels[0].ID]
+	joined, err := o.mxClient.GetRoomMembers(ctx, roomID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the members of %s: %w", roomID, err)
+	}
+	if len(joined) == 0 {
+		return "", fmt.Errorf("room %s has no joined members", roomID)
+	}
+
+	// Invited users aren't among the joined members yet
+	state, err := o.mxClient.GetRoomState(ctx, roomID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the state of %s: %w", roomID, err)
+	}
+	memberships := make(map[string]string)
+	for _, event := range state {
+		if event.Type != matrix.EventTypeRoomMember {
+			continue
+		}
+		var content matrix.MemberContent
+		if err := json.Unmarshal(event.Content, &content); err == nil {
+			memberships[event.StateKey] = content.Membership
+		}
+	}
+	for _, member := range data.members {
+		userID := imported.UserMapping[member.UserID]
+		if !slices.Contains(joined, userID) && memberships[userID] != "invite" {
+			return "", fmt.Errorf("%s is not in room %s (membership: %q)", userID, roomID, memberships[userID])
+		}
+	}
+
+	return fmt.Sprintf("%d users found, %d members in %s", len(data.assets.Users), len(data.members), roomID), nil
+}
+
+// cleanUpSelfTest deletes the imported room and space through the undo
+// path and deactivates the imported users
+func (o *Orchestrator) cleanUpSelfTest(ctx context.Context, imported *matrix.ImportAssetsResult) (string, error) {
+	opts := UndoOptions{
+		Concurrency:  1,
+		PollInterval: defaultUndoPollInterval,
+		Timeout:      defaultUndoTimeout,
+		Purge:        true,
+	}
+
+	var failures []string
+	for _, targets := range [][]undoTarget{undoTargets(imported.RoomMapping), undoTargets(imported.SpaceMapping)} {
+		_, failed := o.deleteRooms(ctx, targets, opts, "cleanup", nil)
+		for roomID, msg := range failed {
+			failures = append(failures, fmt.Sprintf("%s: %s", roomID, msg))
+		}
+	}
+
+	deactivated := 0
+	for _, userID := range imported.UserMapping {
+		if err := o.mxClient.DeactivateUser(ctx, userID, true); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", userID, err))
+			continue
+		}
+		deactivated++
+	}
+
+	if len(failures) > 0 {
+		slices.Sort(failures)
+		return "", fmt.Errorf("cleanup incomplete, remove these by hand: %v", failures)
+	}
+	return fmt.Sprintf("%d rooms deleted, %d users deactivated", len(imported.RoomMapping)+len(imported.SpaceMapping), deactivated), nil
+}
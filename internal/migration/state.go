@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
+
+	"github.com/aligundogdu/matrixmigrate/pkg/archive"
 )
 
 // StepStatus represents the status of a migration step
@@ -16,6 +17,7 @@ const (
 	StatusInProgress StepStatus = "in_progress"
 	StatusCompleted  StepStatus = "completed"
 	StatusFailed     StepStatus = "failed"
+	StatusCancelled  StepStatus = "cancelled"
 	StatusSkipped    StepStatus = "skipped"
 )
 
@@ -31,6 +33,26 @@ const (
 	StepImportMessages     StepName = "import_messages"
 )
 
+// AllSteps lists the migration steps in the order they are run
+var AllSteps = []StepName{
+	StepExportAssets,
+	StepImportAssets,
+	StepExportMemberships,
+	StepImportMemberships,
+	StepExportMessages,
+	StepImportMessages,
+}
+
+// ParseStepName returns the step called name, e.g. import_assets
+func ParseStepName(name string) (StepName, error) {
+	for _, step := range AllSteps {
+		if string(step) == name {
+			return step, nil
+		}
+	}
+	return "", fmt.Errorf("unknown step %q", name)
+}
+
 // StepState represents the state of a single migration step
 type StepState struct {
 	Name           StepName   `json:"name"`
@@ -41,6 +63,7 @@ type StepState struct {
 	ItemsTotal     int        `json:"items_total,omitempty"`
 	ErrorMessage   string     `json:"error_message,omitempty"`
 	OutputFile     string     `json:"output_file,omitempty"`
+	Checkpoints    map[string]string `json:"checkpoints,omitempty"` // Finished phases of a partially run step
 }
 
 // MigrationState represents the overall migration state
@@ -64,6 +87,19 @@ func NewMigrationState() *MigrationState {
 	}
 }
 
+// Clone returns a deep copy of the state
+func (s *MigrationState) Clone() *MigrationState {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return NewMigrationState()
+	}
+	var clone MigrationState
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return NewMigrationState()
+	}
+	return &clone
+}
+
 // GetStep gets or creates a step state
 func (s *MigrationState) GetStep(name StepName) *StepState {
 	if step, exists := s.Steps[name]; exists {
@@ -101,9 +137,38 @@ func (s *MigrationState) CompleteStep(name StepName, outputFile string) {
 	step.Status = StatusCompleted
 	step.CompletedAt = time.Now().UnixMilli()
 	step.OutputFile = outputFile
+	step.Checkpoints = nil
 	s.UpdatedAt = time.Now().UnixMilli()
 }
 
+// RecordStepOutput stores a step's output file without marking it completed.
+// Used for partial runs whose output later runs should build on. A step
+// that isn't completed goes back to pending; a completed one stays so.
+func (s *MigrationState) RecordStepOutput(name StepName, outputFile string) {
+	step := s.GetStep(name)
+	if step.Status != StatusCompleted {
+		step.Status = StatusPending
+	}
+	step.OutputFile = outputFile
+	s.UpdatedAt = time.Now().UnixMilli()
+}
+
+// SetCheckpoint records that a phase of a step has finished, with its output file
+func (s *MigrationState) SetCheckpoint(name StepName, phase, outputFile string) {
+	step := s.GetStep(name)
+	if step.Checkpoints == nil {
+		step.Checkpoints = make(map[string]string)
+	}
+	step.Checkpoints[phase] = outputFile
+	s.UpdatedAt = time.Now().UnixMilli()
+}
+
+// GetCheckpoint returns the output file of a finished phase, or "" if the phase has not finished
+func (s *MigrationState) GetCheckpoint(name StepName, phase string) string {
+	step := s.GetStep(name)
+	return step.Checkpoints[phase]
+}
+
 // FailStep marks a step as failed
 func (s *MigrationState) FailStep(name StepName, err error) {
 	step := s.GetStep(name)
@@ -113,6 +178,41 @@ func (s *MigrationState) FailStep(name StepName, err error) {
 	s.UpdatedAt = time.Now().UnixMilli()
 }
 
+// CancelStep marks a step as cancelled by the user; like a failed step it
+// can be run again
+func (s *MigrationState) CancelStep(name StepName) {
+	step := s.GetStep(name)
+	step.Status = StatusCancelled
+	step.CompletedAt = time.Now().UnixMilli()
+	step.ErrorMessage = "cancelled"
+	s.UpdatedAt = time.Now().UnixMilli()
+}
+
+// ResetStep sets a step back to pending, forgetting its output file, error
+// and checkpoints, so it can be run again from scratch
+func (s *MigrationState) ResetStep(name StepName) {
+	s.Steps[name] = &StepState{
+		Name:   name,
+		Status: StatusPending,
+	}
+	s.UpdatedAt = time.Now().UnixMilli()
+}
+
+// StaleSteps returns the completed steps whose prerequisites are no longer
+// completed, e.g. after one of them was reset
+func (s *MigrationState) StaleSteps() []StepName {
+	var stale []StepName
+	for _, name := range AllSteps {
+		if s.GetStep(name).Status != StatusCompleted {
+			continue
+		}
+		if ok, _ := s.CanRunStep(name); !ok {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
 // SkipStep marks a step as skipped
 func (s *MigrationState) SkipStep(name StepName, reason string) {
 	step := s.GetStep(name)
@@ -171,6 +271,11 @@ func (s *MigrationState) CanRunStep(name StepName) (bool, string) {
 	return false, "unknown step"
 }
 
+// IsStepCompleted returns true if the step has completed
+func (s *MigrationState) IsStepCompleted(name StepName) bool {
+	return s.GetStep(name).Status == StatusCompleted
+}
+
 // GetStepOutputFile returns the output file path for a step
 func (s *MigrationState) GetStepOutputFile(name StepName) string {
 	step := s.GetStep(name)
@@ -179,16 +284,7 @@ func (s *MigrationState) GetStepOutputFile(name StepName) string {
 
 // IsComplete checks if all steps are completed
 func (s *MigrationState) IsComplete() bool {
-	requiredSteps := []StepName{
-		StepExportAssets,
-		StepImportAssets,
-		StepExportMemberships,
-		StepImportMemberships,
-		StepExportMessages,
-		StepImportMessages,
-	}
-
-	for _, name := range requiredSteps {
+	for _, name := range AllSteps {
 		step := s.GetStep(name)
 		if step.Status != StatusCompleted && step.Status != StatusSkipped {
 			return false
@@ -203,6 +299,7 @@ type StateSummary struct {
 	InProgress int `json:"in_progress"`
 	Completed  int `json:"completed"`
 	Failed     int `json:"failed"`
+	Cancelled  int `json:"cancelled"`
 	Skipped    int `json:"skipped"`
 }
 
@@ -218,6 +315,8 @@ func (s *MigrationState) Summary() StateSummary {
 			summary.Completed++
 		case StatusFailed:
 			summary.Failed++
+		case StatusCancelled:
+			summary.Cancelled++
 		case StatusSkipped:
 			summary.Skipped++
 		}
@@ -227,18 +326,12 @@ func (s *MigrationState) Summary() StateSummary {
 
 // SaveState saves the migration state to a JSON file
 func SaveState(state *MigrationState, filePath string) error {
-	// Ensure directory exists
-	dir := filepath.Dir(filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := archive.WriteFile(filePath, data); err != nil {
 		return fmt.Errorf("failed to write state file: %w", err)
 	}
 
@@ -0,0 +1,206 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+)
+
+// Defaults for UndoOptions
+const (
+	defaultUndoConcurrency  = 4
+	defaultUndoPollInterval = 2 * time.Second
+	defaultUndoTimeout      = 10 * time.Minute
+)
+
+// UndoOptions controls how UndoRooms deletes migrated rooms
+type UndoOptions struct {
+	Concurrency   int           // Rooms deleted in parallel (default: 4)
+	PollInterval  time.Duration // Delay between delete status checks (default: 2s)
+	Timeout       time.Duration // Max wait for a single room (default: 10m)
+	Purge         bool          // Also remove the rooms' history from the database
+	IncludeSpaces bool          // Also delete the spaces created for teams
+}
+
+// UndoResult contains the result of UndoRooms
+type UndoResult struct {
+	MappingFile   string
+	RoomsDeleted  int
+	SpacesDeleted int
+	Failed        int
+	Failures      map[string]string // Matrix room ID -> error
+}
+
+// undoTarget is a room or space to delete along with its mapping entry
+type undoTarget struct {
+	mattermostID string
+	roomID       string
+}
+
+// UndoRooms deletes the rooms (and optionally spaces) recorded in the asset
+// mapping through the asynchronous Admin API, waiting for each delete to
+// finish. At most opts.Concurrency deletes run at once; all requests go
+// through the client's rate limiter. Deleted rooms are removed from the
+// mapping, so a later import assets creates them again. When ctx is
+// cancelled no further deletes are started, the mapping is saved with the
+// rooms deleted so far and ctx.Err() is returned.
+func (o *Orchestrator) UndoRooms(ctx context.Context, opts UndoOptions, progress ProgressCallback) (*UndoResult, error) {
+	if o.mxClient == nil {
+		return nil, fmt.Errorf("not connected to Matrix")
+	}
+
+	mappingFile := o.state.GetStepOutputFile(StepImportAssets)
+	if mappingFile == "" {
+		return nil, fmt.Errorf("no mapping file found from import assets step")
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultUndoConcurrency
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultUndoPollInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultUndoTimeout
+	}
+
+	result := &UndoResult{MappingFile: mappingFile, Failures: make(map[string]string)}
+	logger.Info("=== Undo Started: %d rooms, %d spaces in %s ===", len(mapping.Channels), len(mapping.Teams), mappingFile)
+
+	// Rooms go first so spaces are only deleted once their children are gone
+	deleted, failures := o.deleteRooms(ctx, undoTargets(mapping.Channels), opts, "rooms", progress)
+	for _, target := range deleted {
+		delete(mapping.Channels, target.mattermostID)
+	}
+	result.RoomsDeleted = len(deleted)
+	for roomID, msg := range failures {
+		result.Failures[roomID] = msg
+	}
+
+	if opts.IncludeSpaces && ctx.Err() == nil {
+		deleted, failures := o.deleteRooms(ctx, undoTargets(mapping.Teams), opts, "spaces", progress)
+		for _, target := range deleted {
+			delete(mapping.Teams, target.mattermostID)
+		}
+		result.SpacesDeleted = len(deleted)
+		for roomID, msg := range failures {
+			result.Failures[roomID] = msg
+		}
+	}
+	result.Failed = len(result.Failures)
+
+	mapping.UpdatedAt = time.Now().UnixMilli()
+	if err := SaveMapping(mapping, mappingFile); err != nil {
+		return result, err
+	}
+	if err := ctx.Err(); err != nil {
+		logger.Warn("Undo interrupted: %d rooms, %d spaces deleted", result.RoomsDeleted, result.SpacesDeleted)
+		return result, err
+	}
+
+	logger.Info("=== Undo Completed: %d rooms, %d spaces deleted, %d failed ===",
+		result.RoomsDeleted, result.SpacesDeleted, result.Failed)
+	return result, nil
+}
+
+// undoTargets lists the entries of a mapping, ordered by room ID so runs are repeatable
+func undoTargets(entries map[string]string) []undoTarget {
+	targets := make([]undoTarget, 0, len(entries))
+	for mmID, roomID := range entries {
+		targets = append(targets, undoTarget{mattermostID: mmID, roomID: roomID})
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a].roomID < targets[b].roomID })
+	return targets
+}
+
+// deleteRooms deletes targets with a pool of opts.Concurrency workers and
+// returns the deleted targets and the errors of the failed ones by room ID.
+// No targets are handed out once ctx is cancelled.
+func (o *Orchestrator) deleteRooms(ctx context.Context, targets []undoTarget, opts UndoOptions, stage string, progress ProgressCallback) ([]undoTarget, map[string]string) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		done     int
+		deleted  []undoTarget
+		failures = make(map[string]string)
+	)
+
+	queue := make(chan undoTarget)
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range queue {
+				err := o.deleteRoomAndWait(ctx, target.roomID, opts)
+
+				mu.Lock()
+				done++
+				if err != nil {
+					logger.Error("Failed to delete %s: %v", target.roomID, err)
+					failures[target.roomID] = err.Error()
+				} else {
+					logger.Success("Deleted %s", target.roomID)
+					deleted = append(deleted, target)
+				}
+				if progress != nil {
+					progress(stage, done, len(targets), target.roomID)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, target := range targets {
+		select {
+		case queue <- target:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return deleted, failures
+}
+
+// deleteRoomAndWait starts the delete of a room and polls its status until
+// it completes, fails, opts.Timeout passes or ctx is cancelled
+func (o *Orchestrator) deleteRoomAndWait(ctx context.Context, roomID string, opts UndoOptions) error {
+	deleteID, err := o.mxClient.DeleteRoom(ctx, roomID, opts.Purge)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		status, err := o.mxClient.GetRoomDeleteStatus(ctx, deleteID)
+		if err != nil {
+			return fmt.Errorf("failed to check delete status: %w", err)
+		}
+		switch status.Status {
+		case matrix.RoomDeleteComplete:
+			return nil
+		case matrix.RoomDeleteFailed:
+			return fmt.Errorf("delete failed: %s", status.Error)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("delete still %s after %v (delete_id %s)", status.Status, opts.Timeout, deleteID)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+)
+
+// DefaultKnownHostsPath returns the known_hosts file used when none is configured
+func DefaultKnownHostsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// newClientConfig builds the SSH client config for cfg, verifying the
+// server's host key against known_hosts unless insecure_host_key is set
+func newClientConfig(cfg config.SSHConfig, authMethods []ssh.AuthMethod, timeout time.Duration) (*ssh.ClientConfig, error) {
+	clientConfig := &ssh.ClientConfig{
+		User:    cfg.User,
+		Auth:    authMethods,
+		Timeout: timeout,
+	}
+
+	if cfg.InsecureHostKey {
+		logger.Warn("Host key of %s is not verified (insecure_host_key is set)", cfg.Host)
+		clientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return clientConfig, nil
+	}
+
+	path := cfg.KnownHostsPath
+	if path == "" {
+		path = DefaultKnownHostsPath()
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts %s: %w (set ssh.known_hosts_path, or ssh.insecure_host_key: true to skip host key verification)", path, err)
+	}
+
+	address := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	clientConfig.HostKeyCallback = verifyHostKey(callback, path, address)
+	clientConfig.HostKeyAlgorithms = knownHostKeyAlgorithms(callback, address)
+	return clientConfig, nil
+}
+
+// verifyHostKey wraps a known_hosts callback with errors that name the
+// server's key fingerprint and how to resolve them
+func verifyHostKey(callback ssh.HostKeyCallback, path, address string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) == 0 {
+			host, port, _ := net.SplitHostPort(address)
+			return fmt.Errorf("host %s is not in %s (%s key %s); verify the fingerprint with the server admin, then add it, e.g. with: ssh-keyscan -p %s %s >> %s",
+				address, path, key.Type(), fingerprint, port, host, path)
+		}
+		return fmt.Errorf("host key of %s does not match %s (got %s key %s, expected %s): the server key changed or the connection is being intercepted",
+			address, path, key.Type(), fingerprint, keyErr.Want[0].String())
+	}
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms of the keys known
+// for address, so the server presents a key that can be checked. Returns
+// nil (any algorithm) when the host is unknown.
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, address string) []string {
+	// Checking a key that can't match lists the known keys in the error
+	probe, err := ssh.NewPublicKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public())
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(address, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, known := range keyErr.Want {
+		keyType := known.Key.Type()
+		candidates := []string{keyType}
+		if keyType == ssh.KeyAlgoRSA {
+			// RSA keys are used with SHA-2 signatures by current servers
+			candidates = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algorithm := range candidates {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+)
+
+// dial connects to the SSH server of cfg, through its jump host if one is
+// configured. The jump host client is returned so it can be closed after
+// the target client; it is nil for direct connections.
+func dial(cfg config.SSHConfig, passphrase, password string, timeout time.Duration) (client, jump *ssh.Client, err error) {
+	authMethods, err := buildAuthMethods(cfg, passphrase, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build auth methods: %w", err)
+	}
+	sshConfig, err := newClientConfig(cfg, authMethods, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	sshAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+
+	if cfg.JumpHost.Host == "" {
+		client, err := ssh.Dial("tcp", sshAddr, sshConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+		}
+		return client, nil, nil
+	}
+
+	// Connect to the jump host with its own credentials
+	jumpCfg := cfg.JumpHost.SSHConfig()
+	jumpAuth, err := buildAuthMethods(jumpCfg, cfg.JumpHost.Passphrase(), cfg.JumpHost.Password())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build jump host auth methods: %w", err)
+	}
+	jumpConfig, err := newClientConfig(jumpCfg, jumpAuth, timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jump host: %w", err)
+	}
+	jumpAddr := fmt.Sprintf("%s:%d", jumpCfg.Host, jumpCfg.Port)
+	jump, err = ssh.Dial("tcp", jumpAddr, jumpConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to jump host %s: %w", jumpAddr, err)
+	}
+
+	// Open the target SSH connection over a channel of the jump host
+	conn, err := jump.Dial("tcp", sshAddr)
+	if err != nil {
+		jump.Close()
+		return nil, nil, fmt.Errorf("jump host %s failed to reach SSH server %s: %w", jumpAddr, sshAddr, err)
+	}
+	// sshConfig.Timeout only covers ssh.Dial, and a channel of the jump host
+	// doesn't support deadlines, so a stalled handshake is ended by closing it
+	timer := time.AfterFunc(timeout, func() { conn.Close() })
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, sshAddr, sshConfig)
+	if !timer.Stop() {
+		if err == nil {
+			clientConn.Close()
+		}
+		err = fmt.Errorf("handshake timed out after %s", timeout)
+	}
+	if err != nil {
+		conn.Close()
+		jump.Close()
+		return nil, nil, fmt.Errorf("failed to connect to SSH server via jump host: %w", err)
+	}
+	return ssh.NewClient(clientConn, chans, reqs), jump, nil
+}
@@ -2,17 +2,39 @@
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/aligundogdu/matrixmigrate/internal/config"
 )
 
+const (
+	// DefaultCommandTimeout is used when no command timeout is configured
+	DefaultCommandTimeout = 30 * time.Second
+
+	// DefaultMaxReadSize is used when no read size cap is configured (10 MB)
+	DefaultMaxReadSize int64 = 10 * 1024 * 1024
+)
+
+// errReadLimitExceeded is returned by limitedBuffer once the cap is reached
+var errReadLimitExceeded = errors.New("read limit exceeded")
+
+// ErrFileNotFound is returned by ReadFile when the remote file doesn't exist
+var ErrFileNotFound = errors.New("remote file not found")
+
 // RemoteExecutor executes commands on remote servers via SSH
 type RemoteExecutor struct {
-	client *ssh.Client
+	client         *ssh.Client
+	jump           *ssh.Client // Jump host the client is connected through (optional)
+	commandTimeout time.Duration
+	maxReadSize    int64
 }
 
 // NewRemoteExecutor creates a new remote executor with key auth
@@ -22,69 +44,243 @@ func NewRemoteExecutor(cfg config.SSHConfig, passphrase string) (*RemoteExecutor
 
 // NewRemoteExecutorWithPassword creates a new remote executor with optional password auth
 func NewRemoteExecutorWithPassword(cfg config.SSHConfig, passphrase, password string) (*RemoteExecutor, error) {
-	// Build auth methods
-	authMethods, err := buildAuthMethods(cfg, passphrase, password)
+	// Connect to SSH server, through the jump host if configured
+	client, jump, err := dial(cfg, passphrase, password, 30*time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build auth methods: %w", err)
+		return nil, err
 	}
 
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            cfg.User,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
+	executor := &RemoteExecutor{
+		client:         client,
+		jump:           jump,
+		commandTimeout: DefaultCommandTimeout,
+		maxReadSize:    DefaultMaxReadSize,
 	}
-
-	// Connect to SSH server
-	sshAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	client, err := ssh.Dial("tcp", sshAddr, sshConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+	if cfg.CommandTimeoutSec > 0 {
+		executor.commandTimeout = time.Duration(cfg.CommandTimeoutSec) * time.Second
 	}
+	if cfg.MaxReadSizeKB > 0 {
+		executor.maxReadSize = int64(cfg.MaxReadSizeKB) * 1024
+	}
+
+	return executor, nil
+}
 
-	return &RemoteExecutor{client: client}, nil
+// SetLimits overrides the command timeout and the maximum read size
+func (r *RemoteExecutor) SetLimits(timeout time.Duration, maxReadSize int64) {
+	if timeout > 0 {
+		r.commandTimeout = timeout
+	}
+	if maxReadSize > 0 {
+		r.maxReadSize = maxReadSize
+	}
 }
 
 // Close closes the SSH connection
 func (r *RemoteExecutor) Close() error {
+	var err error
 	if r.client != nil {
-		return r.client.Close()
+		err = r.client.Close()
 	}
-	return nil
+	if r.jump != nil {
+		r.jump.Close()
+	}
+	return err
 }
 
-// ReadFile reads a file from the remote server
+// ReadFile reads a file from the remote server.
+// The read is aborted if it exceeds the configured size cap or command timeout.
 func (r *RemoteExecutor) ReadFile(path string) ([]byte, error) {
+	if err := validateRemotePath(path); err != nil {
+		return nil, err
+	}
+
 	session, err := r.client.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	var stdout bytes.Buffer
+	// Closing the session on overflow stops the remote cat instead of waiting for the timeout
+	stdout := &limitedBuffer{limit: r.maxReadSize, onExceed: func() { session.Close() }}
 	var stderr bytes.Buffer
-	session.Stdout = &stdout
+	session.Stdout = stdout
 	session.Stderr = &stderr
 
 	// Use cat to read the file, with sudo if needed
-	cmd := fmt.Sprintf("cat %s 2>/dev/null || sudo cat %s", path, path)
-	if err := session.Run(cmd); err != nil {
+	quoted := shellQuote(path)
+	cmd := fmt.Sprintf("cat -- %s 2>/dev/null || sudo cat -- %s", quoted, quoted)
+	if err := r.runWithTimeout(session, cmd); err != nil {
+		if stdout.exceeded.Load() {
+			return nil, fmt.Errorf("remote file %s exceeds maximum size of %d bytes", path, r.maxReadSize)
+		}
+		if errors.Is(err, errCommandTimeout) {
+			return nil, fmt.Errorf("reading %s timed out after %s", path, r.commandTimeout)
+		}
+		if strings.Contains(stderr.String(), "No such file or directory") {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+		}
 		return nil, fmt.Errorf("failed to read file: %s", stderr.String())
 	}
 
 	return stdout.Bytes(), nil
 }
 
+// ReadFileSFTP reads a file from the remote server over SFTP.
+// If the SFTP subsystem is unavailable or the file cannot be opened
+// (e.g. it requires sudo), it falls back to the shell based ReadFile.
+func (r *RemoteExecutor) ReadFileSFTP(path string) ([]byte, error) {
+	if err := validateRemotePath(path); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		data     []byte
+		err      error
+		fallback bool
+	}
+
+	// The SFTP client runs over a session we own, so a timeout can close
+	// it and unblock the read instead of leaking the goroutine.
+	session, err := r.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		data, fallback, err := r.readFileSFTP(session, path)
+		done <- result{data: data, err: err, fallback: fallback}
+	}()
+
+	select {
+	case res := <-done:
+		if res.fallback {
+			return r.ReadFile(path)
+		}
+		return res.data, res.err
+	case <-time.After(r.commandTimeout):
+		session.Close()
+		return nil, fmt.Errorf("reading %s over SFTP timed out after %s", path, r.commandTimeout)
+	}
+}
+
+// readFileSFTP performs the SFTP read. fallback is true when the shell method should be tried instead.
+func (r *RemoteExecutor) readFileSFTP(session *ssh.Session, path string) (data []byte, fallback bool, err error) {
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, true, nil
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, true, nil
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, true, nil
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		return nil, true, nil
+	}
+	defer client.Close()
+
+	file, err := client.Open(path)
+	if err != nil {
+		return nil, true, nil
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() > r.maxReadSize {
+		return nil, false, fmt.Errorf("remote file %s exceeds maximum size of %d bytes", path, r.maxReadSize)
+	}
+
+	// Read one byte past the cap to detect files that grew after Stat
+	data, err = io.ReadAll(io.LimitReader(file, r.maxReadSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read file over SFTP: %w", err)
+	}
+	if int64(len(data)) > r.maxReadSize {
+		return nil, false, fmt.Errorf("remote file %s exceeds maximum size of %d bytes", path, r.maxReadSize)
+	}
+
+	return data, false, nil
+}
+
+// errCommandTimeout is returned when a remote command exceeds its timeout
+var errCommandTimeout = errors.New("remote command timed out")
+
+// runWithTimeout runs cmd on the session and kills it if it exceeds the command timeout
+func (r *RemoteExecutor) runWithTimeout(session *ssh.Session, cmd string) error {
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(r.commandTimeout):
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		return errCommandTimeout
+	}
+}
+
+// validateRemotePath rejects paths that cannot be passed safely to the remote shell
+func validateRemotePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("remote path is empty")
+	}
+	if strings.ContainsRune(path, 0) {
+		return fmt.Errorf("remote path contains a NUL byte: %q", path)
+	}
+	return nil
+}
+
+// shellQuote wraps s in single quotes so it is passed to the remote shell verbatim
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// limitedBuffer is a bytes.Buffer that refuses writes beyond limit bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int64
+	exceeded atomic.Bool // Read by the caller while the session may still be writing
+	onExceed func()
+}
+
+// Write implements io.Writer
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.limit {
+		if b.exceeded.CompareAndSwap(false, true) && b.onExceed != nil {
+			b.onExceed()
+		}
+		return 0, errReadLimitExceeded
+	}
+	return b.Buffer.Write(p)
+}
+
 // FileExists checks if a file exists on the remote server
 func (r *RemoteExecutor) FileExists(path string) (bool, error) {
+	if err := validateRemotePath(path); err != nil {
+		return false, err
+	}
+
 	session, err := r.client.NewSession()
 	if err != nil {
 		return false, fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	cmd := fmt.Sprintf("test -f %s && echo 'exists'", path)
+	cmd := fmt.Sprintf("test -f %s && echo 'exists'", shellQuote(path))
 	output, err := session.Output(cmd)
 	if err != nil {
 		return false, nil // File doesn't exist
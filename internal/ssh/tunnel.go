@@ -1,21 +1,27 @@
 ﻿package ssh
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/aligundogdu/matrixmigrate/internal/config"
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
 )
 
 // Tunnel represents an SSH tunnel with port forwarding
 type Tunnel struct {
 	client     *ssh.Client
+	jump       *ssh.Client // Jump host the client is connected through (optional)
 	localAddr  string
 	remoteAddr string
 	listener   net.Listener
@@ -23,12 +29,16 @@ type Tunnel struct {
 	wg         sync.WaitGroup
 	mu         sync.Mutex
 	closed     bool
+	failure    error // Why the tunnel stopped working, set by the background goroutines
 }
 
+// keepaliveTimeout bounds how long IsHealthy waits for the SSH server to answer
+const keepaliveTimeout = 5 * time.Second
+
 // TunnelConfig holds configuration for creating a tunnel
 type TunnelConfig struct {
 	SSHConfig   config.SSHConfig
-	LocalPort   int
+	LocalPort   int // 0 picks a free port; see Tunnel.LocalPort
 	RemoteHost  string
 	RemotePort  int
 	Passphrase  string
@@ -37,39 +47,29 @@ type TunnelConfig struct {
 
 // NewTunnel creates a new SSH tunnel
 func NewTunnel(cfg TunnelConfig) (*Tunnel, error) {
-	// Build auth methods
-	authMethods, err := buildAuthMethods(cfg.SSHConfig, cfg.Passphrase, cfg.Password)
+	// Listen first: a port conflict fails fast, and a free port picked here
+	// can't be taken by another process before the tunnel uses it
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.LocalPort))
 	if err != nil {
-		return nil, fmt.Errorf("failed to build auth methods: %w", err)
-	}
-
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            cfg.SSHConfig.User,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Add proper host key verification
-		Timeout:         30 * time.Second,
-	}
-
-	// Connect to SSH server
-	sshAddr := fmt.Sprintf("%s:%d", cfg.SSHConfig.Host, cfg.SSHConfig.Port)
-	client, err := ssh.Dial("tcp", sshAddr, sshConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+		if cfg.LocalPort != 0 && isAddrInUse(err) {
+			return nil, fmt.Errorf("local port %d already in use; set ssh.local_port to a free port, or remove it to pick one automatically", cfg.LocalPort)
+		}
+		return nil, fmt.Errorf("failed to create local listener: %w", err)
 	}
+	localAddr := listener.Addr().String()
 
-	// Create local listener
-	localAddr := fmt.Sprintf("127.0.0.1:%d", cfg.LocalPort)
-	listener, err := net.Listen("tcp", localAddr)
+	// Connect to SSH server, through the jump host if configured
+	client, jump, err := dial(cfg.SSHConfig, cfg.Passphrase, cfg.Password, 30*time.Second)
 	if err != nil {
-		client.Close()
-		return nil, fmt.Errorf("failed to create local listener: %w", err)
+		listener.Close()
+		return nil, err
 	}
 
 	remoteAddr := fmt.Sprintf("%s:%d", cfg.RemoteHost, cfg.RemotePort)
 
 	tunnel := &Tunnel{
 		client:     client,
+		jump:       jump,
 		localAddr:  localAddr,
 		remoteAddr: remoteAddr,
 		listener:   listener,
@@ -80,9 +80,28 @@ func NewTunnel(cfg TunnelConfig) (*Tunnel, error) {
 	tunnel.wg.Add(1)
 	go tunnel.acceptConnections()
 
+	// Notice when the SSH connection drops
+	go tunnel.watchClient()
+
 	return tunnel, nil
 }
 
+// isAddrInUse returns true if a listen error is caused by the address being
+// taken by another socket
+func isAddrInUse(err error) bool {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return true
+	}
+	// Windows reports WSAEADDRINUSE, which is not syscall.EADDRINUSE
+	msg := err.Error()
+	return strings.Contains(msg, "address already in use") || strings.Contains(msg, "Only one usage of each socket address")
+}
+
+// LocalPort returns the local port the tunnel listens on
+func (t *Tunnel) LocalPort() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
 // buildAuthMethods builds SSH authentication methods based on config
 func buildAuthMethods(cfg config.SSHConfig, passphrase, password string) ([]ssh.AuthMethod, error) {
 	var authMethods []ssh.AuthMethod
@@ -167,7 +186,9 @@ func (t *Tunnel) acceptConnections() {
 			if closed {
 				return
 			}
-			continue
+			// The listener is broken; nothing can connect through the tunnel anymore
+			t.fail(fmt.Errorf("local listener on %s failed: %w", t.localAddr, err))
+			return
 		}
 
 		t.wg.Add(1)
@@ -175,6 +196,62 @@ func (t *Tunnel) acceptConnections() {
 	}
 }
 
+// watchClient waits for the SSH connection to end and records it as a
+// failure unless the tunnel is being closed
+func (t *Tunnel) watchClient() {
+	err := t.client.Wait()
+	if err == nil {
+		err = io.EOF
+	}
+	t.fail(fmt.Errorf("SSH connection lost: %w", err))
+}
+
+// fail records the first failure of a tunnel that isn't closing
+func (t *Tunnel) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed && t.failure == nil {
+		t.failure = err
+	}
+}
+
+// Health checks that the tunnel can still forward connections: the listener
+// is accepting and the SSH server answers a keepalive request. It returns
+// the reason if it can't.
+func (t *Tunnel) Health() error {
+	t.mu.Lock()
+	closed, failure := t.closed, t.failure
+	t.mu.Unlock()
+	if closed {
+		return fmt.Errorf("tunnel is closed")
+	}
+	if failure != nil {
+		return failure
+	}
+
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := t.client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		if err != nil {
+			err = fmt.Errorf("SSH keepalive failed: %w", err)
+			t.fail(err)
+			return err
+		}
+	case <-time.After(keepaliveTimeout):
+		return fmt.Errorf("SSH server did not answer a keepalive within %s", keepaliveTimeout)
+	}
+	return nil
+}
+
+// IsHealthy reports whether the tunnel can still forward connections
+func (t *Tunnel) IsHealthy() bool {
+	return t.Health() == nil
+}
+
 // forward forwards a connection through the SSH tunnel
 func (t *Tunnel) forward(localConn net.Conn) {
 	defer t.wg.Done()
@@ -235,10 +312,13 @@ func (t *Tunnel) Close() error {
 	// Wait for all goroutines to finish
 	t.wg.Wait()
 
-	// Close SSH client
+	// Close SSH client, then the jump host it runs over
 	if t.client != nil {
 		t.client.Close()
 	}
+	if t.jump != nil {
+		t.jump.Close()
+	}
 
 	return nil
 }
@@ -250,27 +330,15 @@ func TestConnection(cfg config.SSHConfig, passphrase string) error {
 
 // TestConnectionWithPassword tests SSH connection with optional password
 func TestConnectionWithPassword(cfg config.SSHConfig, passphrase, password string) error {
-	// Build auth methods
-	authMethods, err := buildAuthMethods(cfg, passphrase, password)
-	if err != nil {
-		return err
-	}
-
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            cfg.User,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	// Connect to SSH server
-	sshAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	client, err := ssh.Dial("tcp", sshAddr, sshConfig)
+	// Connect to SSH server, through the jump host if configured
+	client, jump, err := dial(cfg, passphrase, password, 10*time.Second)
 	if err != nil {
 		return fmt.Errorf("SSH connection failed: %w", err)
 	}
-	defer client.Close()
+	client.Close()
+	if jump != nil {
+		jump.Close()
+	}
 
 	return nil
 }
@@ -288,14 +356,20 @@ func NewTunnelManager() *TunnelManager {
 	}
 }
 
-// CreateTunnel creates and registers a new tunnel
+// CreateTunnel creates and registers a new tunnel. A working tunnel of the
+// same name is reused; one that stopped working is closed and replaced.
 func (tm *TunnelManager) CreateTunnel(name string, cfg TunnelConfig) (*Tunnel, error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	// If tunnel already exists, return it (reuse existing connection)
 	if existing, exists := tm.tunnels[name]; exists {
-		return existing, nil
+		err := existing.Health()
+		if err == nil {
+			return existing, nil
+		}
+		logger.Warn("SSH tunnel %s is down, reconnecting: %v", name, err)
+		existing.Close()
+		delete(tm.tunnels, name)
 	}
 
 	tunnel, err := NewTunnel(cfg)
@@ -333,6 +407,31 @@ func (tm *TunnelManager) CloseTunnel(name string) error {
 	return nil
 }
 
+// Health checks all tunnels and returns an error naming the first one
+// that is no longer working
+func (tm *TunnelManager) Health() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	names := make([]string, 0, len(tm.tunnels))
+	for name := range tm.tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := tm.tunnels[name].Health(); err != nil {
+			return fmt.Errorf("SSH tunnel %s (%s) is down: %w", name, tm.tunnels[name].remoteAddr, err)
+		}
+	}
+	return nil
+}
+
+// IsHealthy reports whether all tunnels can still forward connections
+func (tm *TunnelManager) IsHealthy() bool {
+	return tm.Health() == nil
+}
+
 // CloseAll closes all tunnels
 func (tm *TunnelManager) CloseAll() error {
 	tm.mu.Lock()
@@ -348,13 +447,3 @@ func (tm *TunnelManager) CloseAll() error {
 
 	return lastErr
 }
-
-// GetLocalPort returns an available local port
-func GetLocalPort() (int, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return 0, err
-	}
-	defer listener.Close()
-	return listener.Addr().(*net.TCPAddr).Port, nil
-}
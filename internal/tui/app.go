@@ -1,7 +1,11 @@
 ﻿package tui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
@@ -9,6 +13,7 @@ import (
 
 	"github.com/aligundogdu/matrixmigrate/internal/config"
 	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
 	"github.com/aligundogdu/matrixmigrate/internal/migration"
 	"github.com/aligundogdu/matrixmigrate/internal/version"
 )
@@ -26,10 +31,13 @@ const (
 	ViewImportMessages
 	ViewTestConnection
 	ViewStatus
+	ViewLogs
+	ViewMessageRange
 	ViewSettings
 	ViewProgress
 	ViewError
 	ViewSuccess
+	ViewConfirm
 )
 
 // Model is the main application model
@@ -59,13 +67,52 @@ type Model struct {
 
 	// Messages
 	errorMessage   string
+	errorDetail    *matrix.APIError // Set when the failure came from a Matrix API call
 	successMessage string
 
+	// progress delivers progress of running operations; shared by all
+	// copies of the model
+	progress *progressSender
+
 	// Operation result for detailed stats
 	operationResult *migration.OperationResult
 
-	// Program reference for sending messages from goroutines
-	program *tea.Program
+	// dryRun previews imports without changing the homeserver (--dry-run)
+	dryRun bool
+
+	// cancel stops the running operation; nil while none is running
+	cancel     context.CancelFunc
+	cancelling bool
+
+	// Log viewer state
+	logLines  []string
+	logScroll int // Lines scrolled up from the end; 0 follows new output
+	logErr    string
+	logGen    int // Incremented each time the viewer opens
+
+	// Message date range form state
+	rangeTarget View                    // Message step to run once confirmed
+	rangeInputs [rangeFieldCount]string // From and to, as typed
+	rangeFocus  int
+	rangeErr    string
+
+	// Confirmation dialog state
+	confirmTarget View // Import step to run once confirmed
+	confirmText   string
+	confirmDetail string
+	confirmYes    bool               // Confirm is selected rather than cancel
+	confirmReset  migration.StepName // Step to reset once confirmed, instead of running confirmTarget
+
+	// Status view state
+	statusIndex int // Selected step
+
+	// Settings form state
+	settingsInputs   [settingsFieldCount]string
+	settingsInitial  [settingsFieldCount]string // Inputs when the form opened, or last saved
+	settingsFocus    int
+	settingsLanguage string // Language when the form opened, or last saved
+	settingsErr      string
+	settingsSaved    string
 
 	// Quitting
 	quitting bool
@@ -86,12 +133,13 @@ func (m Model) Init() tea.Cmd {
 }
 
 // NewModel creates a new application model
-func NewModel(cfg *config.Config) (Model, error) {
+func NewModel(cfg *config.Config, opts migration.RunOptions) (Model, error) {
 	// Create orchestrator
 	orchestrator, err := migration.NewOrchestrator(cfg)
 	if err != nil {
 		return Model{}, fmt.Errorf("failed to create orchestrator: %w", err)
 	}
+	orchestrator.SetRunOptions(opts)
 
 	// Create spinner
 	s := spinner.New()
@@ -102,6 +150,8 @@ func NewModel(cfg *config.Config) (Model, error) {
 		config:       cfg,
 		orchestrator: orchestrator,
 		view:         ViewMenu,
+		dryRun:       opts.DryRun,
+		progress:     &progressSender{},
 		spinner:      s,
 		width:        80,
 		height:       24,
@@ -173,6 +223,16 @@ func (m *Model) createMenuItems() []MenuItem {
 			Desc:  "View migration status",
 			View:  ViewStatus,
 		},
+		{
+			Title: locale.Menu.Settings,
+			Desc:  "View and edit the configuration",
+			View:  ViewSettings,
+		},
+		{
+			Title: locale.Menu.Logs,
+			Desc:  "Follow the migration log",
+			View:  ViewLogs,
+		},
 		{
 			Title: locale.Menu.Quit,
 			Desc:  "Exit the application",
@@ -208,8 +268,18 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case operationCompleteMsg:
-		if msg.err != nil {
+		if m.cancel != nil {
+			m.cancel()
+			m.cancel = nil
+		}
+		m.cancelling = false
+		if errors.Is(msg.err, context.Canceled) {
+			m.errorMessage = "Operation cancelled. Completed work was saved; run the step again to continue."
+			m.errorDetail = nil
+			m.view = ViewError
+		} else if msg.err != nil {
 			m.errorMessage = msg.err.Error()
+			m.errorDetail, _ = matrix.AsAPIError(msg.err)
 			m.view = ViewError
 		} else {
 			m.successMessage = msg.message
@@ -225,6 +295,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.testDone = true
 		m.view = ViewTestConnection
 		return m, nil
+
+	case logTickMsg:
+		// Stop refreshing once the log viewer is closed or reopened
+		if m.view != ViewLogs || msg.gen != m.logGen {
+			return m, nil
+		}
+		return m, loadLogLines(m.logGen)
+
+	case logLoadedMsg:
+		if msg.gen != m.logGen {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.logErr = msg.err.Error()
+		} else {
+			// Keep the viewport on the same lines while new output arrives
+			if m.logScroll > 0 && len(msg.lines) > len(m.logLines) {
+				m.logScroll += len(msg.lines) - len(m.logLines)
+			}
+			m.logErr = ""
+			m.logLines = msg.lines
+			m.scrollLogs(0)
+		}
+		if m.view != ViewLogs {
+			return m, nil
+		}
+		return m, scheduleLogRefresh(m.logGen)
 	}
 
 	return m, nil
@@ -232,6 +329,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // handleKeyPress handles keyboard input
 func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// The date range form takes text input, so it handles its own keys
+	if m.view == ViewMessageRange {
+		return m.handleRangeKey(msg)
+	}
+	if m.view == ViewSettings {
+		return m.handleSettingsKey(msg)
+	}
+	if m.view == ViewConfirm {
+		return m.handleConfirmKey(msg)
+	}
+
+	// While an operation runs, leaving cancels it; the view stays until it stops
+	if m.cancel != nil {
+		switch msg.String() {
+		case "ctrl+c", "q", "esc":
+			m.cancel()
+			m.cancelling = true
+		}
+		return m, nil
+	}
+
 	switch msg.String() {
 	case "ctrl+c", "q":
 		if m.view == ViewMenu {
@@ -249,6 +367,12 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.menuIndex = len(m.menuItems) - 1
 			}
 		}
+		if m.view == ViewLogs {
+			m.scrollLogs(1)
+		}
+		if m.view == ViewStatus && m.statusIndex > 0 {
+			m.statusIndex--
+		}
 		return m, nil
 
 	case "down", "j":
@@ -258,6 +382,42 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.menuIndex = 0
 			}
 		}
+		if m.view == ViewLogs {
+			m.scrollLogs(-1)
+		}
+		if m.view == ViewStatus && m.statusIndex < len(migration.AllSteps)-1 {
+			m.statusIndex++
+		}
+		return m, nil
+
+	case "r":
+		if m.view == ViewStatus {
+			m.openResetConfirm(migration.AllSteps[m.statusIndex])
+		}
+		return m, nil
+
+	case "pgup":
+		if m.view == ViewLogs {
+			m.scrollLogs(m.logViewHeight())
+		}
+		return m, nil
+
+	case "pgdown":
+		if m.view == ViewLogs {
+			m.scrollLogs(-m.logViewHeight())
+		}
+		return m, nil
+
+	case "g", "home":
+		if m.view == ViewLogs {
+			m.scrollLogs(len(m.logLines))
+		}
+		return m, nil
+
+	case "G", "end":
+		if m.view == ViewLogs {
+			m.logScroll = 0
+		}
 		return m, nil
 
 	case "enter", " ":
@@ -269,9 +429,23 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			if item.Action != nil {
 				return m, item.Action()
 			}
+			// Message steps first ask for an optional date range
+			if item.View == ViewExportMessages || item.View == ViewImportMessages {
+				m.openMessageRange(item.View)
+				return m, nil
+			}
+			if item.View == ViewSettings {
+				m.openSettings()
+				return m, nil
+			}
+			if m.needsConfirm(item.View) {
+				m.openConfirm(item.View)
+				return m, nil
+			}
 			m.previousView = m.view
 			m.view = item.View
-			return m, m.handleViewChange(item.View)
+			cmd := m.handleViewChange(item.View)
+			return m, cmd
 		}
 		if m.view == ViewError || m.view == ViewSuccess {
 			m.view = ViewMenu
@@ -309,6 +483,10 @@ func (m *Model) handleViewChange(view View) tea.Cmd {
 	case ViewStatus:
 		// Status view doesn't need a command
 		return nil
+	case ViewLogs:
+		m.logScroll = 0
+		m.logGen++
+		return loadLogLines(m.logGen)
 	}
 	return nil
 }
@@ -326,6 +504,14 @@ func (m Model) View() string {
 		return m.renderProgress()
 	case ViewStatus:
 		return m.renderStatus()
+	case ViewLogs:
+		return m.renderLogs()
+	case ViewMessageRange:
+		return m.renderMessageRange()
+	case ViewSettings:
+		return m.renderSettings()
+	case ViewConfirm:
+		return m.renderConfirm()
 	case ViewError:
 		return m.renderError()
 	case ViewSuccess:
@@ -450,7 +636,10 @@ func (m Model) renderProgress() string {
 		),
 	)
 
-	help := HelpStyle.Render("Please wait...")
+	help := HelpStyle.Render("Please wait... (q: cancel)")
+	if m.cancelling {
+		help = HelpStyle.Render("Cancelling, waiting for the current request to finish...")
+	}
 
 	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
 		lipgloss.JoinVertical(lipgloss.Center, content, help))
@@ -485,23 +674,20 @@ func (m Model) renderStatus() string {
 	state := m.orchestrator.GetState()
 
 	// Build status table
-	steps := []migration.StepName{
-		migration.StepExportAssets,
-		migration.StepImportAssets,
-		migration.StepExportMemberships,
-		migration.StepImportMemberships,
-	}
-
 	var rows string
-	for _, stepName := range steps {
+	for i, stepName := range migration.AllSteps {
 		step := state.GetStep(stepName)
 		icon := GetStatusIcon(string(step.Status))
 		style := GetStatusStyle(string(step.Status))
 
+		cursor := "  "
+		if i == m.statusIndex {
+			cursor = IconArrow + " "
+		}
 		name := string(stepName)
 		status := style.Render(icon + " " + string(step.Status))
 
-		rows += fmt.Sprintf("  %-25s %s\n", name, status)
+		rows += fmt.Sprintf("%s%-25s %s\n", cursor, name, status)
 	}
 
 	content := BoxStyle.Render(
@@ -513,7 +699,7 @@ func (m Model) renderStatus() string {
 		),
 	)
 
-	help := HelpStyle.Render("Press esc or q to go back")
+	help := HelpStyle.Render("↑/↓: select • r: reset step • esc/q: back")
 
 	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
 		lipgloss.JoinVertical(lipgloss.Center, content, help))
@@ -521,14 +707,31 @@ func (m Model) renderStatus() string {
 
 // renderError renders the error view
 func (m Model) renderError() string {
-	content := ErrorBoxStyle.Render(
-		lipgloss.JoinVertical(
-			lipgloss.Left,
-			ErrorStyle.Render(IconCross+" Error"),
+	lines := []string{
+		ErrorStyle.Render(IconCross + " Error"),
+		"",
+		m.errorMessage,
+	}
+
+	// Show the request context for homeserver errors
+	if d := m.errorDetail; d != nil {
+		lines = append(lines,
 			"",
-			m.errorMessage,
-		),
-	)
+			SubtitleStyle.Render("Details:"),
+			fmt.Sprintf("   • HTTP status: %d", d.StatusCode),
+		)
+		if d.Errcode != "" {
+			lines = append(lines, fmt.Sprintf("   • Errcode: %s", d.Errcode))
+		}
+		if d.Endpoint != "" {
+			lines = append(lines, fmt.Sprintf("   • Endpoint: %s %s", d.Method, d.Endpoint))
+		}
+		if hint := d.Remediation(); hint != "" {
+			lines = append(lines, "", lipgloss.NewStyle().Width(72).Render(WarningStyle.Render("Suggestion: ")+hint))
+		}
+	}
+
+	content := ErrorBoxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
 
 	help := HelpStyle.Render("Press enter to continue")
 
@@ -541,7 +744,11 @@ func (m Model) renderSuccess() string {
 	var sections []string
 
 	// Title
-	sections = append(sections, SuccessStyle.Render(IconCheck+" Success"))
+	if m.operationResult != nil && m.operationResult.DryRun {
+		sections = append(sections, WarningStyle.Render(IconCheck+" Dry Run - nothing was changed"))
+	} else {
+		sections = append(sections, SuccessStyle.Render(IconCheck+" Success"))
+	}
 	sections = append(sections, "")
 	sections = append(sections, m.successMessage)
 
@@ -565,6 +772,9 @@ func (m Model) renderSuccess() string {
 			if r.ChannelsExported > 0 {
 				sections = append(sections, fmt.Sprintf("   • Channels: %d", r.ChannelsExported))
 			}
+			if r.UsersExcluded > 0 {
+				sections = append(sections, DimStyle.Render(fmt.Sprintf("   ⊘ Users excluded by skip_users: %d", r.UsersExcluded)))
+			}
 			sections = append(sections, "")
 		}
 
@@ -577,11 +787,14 @@ func (m Model) renderSuccess() string {
 			if r.ChannelMembershipsExported > 0 {
 				sections = append(sections, fmt.Sprintf("   • Channel memberships: %d", r.ChannelMembershipsExported))
 			}
+			if r.MembershipsExcluded > 0 {
+				sections = append(sections, DimStyle.Render(fmt.Sprintf("   ⊘ Excluded by skip_users: %d", r.MembershipsExcluded)))
+			}
 			sections = append(sections, "")
 		}
 
 		// Import stats - Users
-		if r.UsersCreated > 0 || r.UsersSkipped > 0 || r.UsersFailed > 0 {
+		if r.UsersCreated > 0 || r.UsersSkipped > 0 || r.UsersFailed > 0 || r.UsersInvalid > 0 {
 			sections = append(sections, SubtitleStyle.Render("👥 Users:"))
 			if r.UsersCreated > 0 {
 				sections = append(sections, SuccessStyle.Render(fmt.Sprintf("   ✓ Created: %d", r.UsersCreated)))
@@ -592,6 +805,12 @@ func (m Model) renderSuccess() string {
 			if r.UsersFailed > 0 {
 				sections = append(sections, ErrorStyle.Render(fmt.Sprintf("   ✗ Failed: %d", r.UsersFailed)))
 			}
+			if r.UsersInvalid > 0 {
+				sections = append(sections, WarningStyle.Render(fmt.Sprintf("   ⚠ Invalid username: %d", r.UsersInvalid)))
+			}
+			if r.UsersDuplicateEmail > 0 {
+				sections = append(sections, WarningStyle.Render(fmt.Sprintf("   ⚠ Duplicate email: %d", r.UsersDuplicateEmail)))
+			}
 			sections = append(sections, "")
 		}
 
@@ -611,23 +830,46 @@ func (m Model) renderSuccess() string {
 		}
 
 		// Import stats - Rooms
-		if r.RoomsCreated > 0 || r.RoomsSkipped > 0 || r.RoomsFailed > 0 || r.RoomsLinked > 0 {
+		if r.RoomsCreated > 0 || r.RoomsSkipped > 0 || r.RoomsFailed > 0 || r.RoomsLinked > 0 || r.DirectRoomsCreated > 0 {
 			sections = append(sections, SubtitleStyle.Render("💬 Rooms:"))
 			if r.RoomsCreated > 0 {
 				sections = append(sections, SuccessStyle.Render(fmt.Sprintf("   ✓ Created: %d", r.RoomsCreated)))
 			}
+			if r.DirectRoomsCreated > 0 {
+				sections = append(sections, SuccessStyle.Render(fmt.Sprintf("   ✓ Direct messages: %d", r.DirectRoomsCreated)))
+			}
 			if r.RoomsLinked > 0 {
 				sections = append(sections, SuccessStyle.Render(fmt.Sprintf("   ✓ Linked to spaces: %d", r.RoomsLinked)))
 			}
 			if r.RoomsSkipped > 0 {
 				sections = append(sections, DimStyle.Render(fmt.Sprintf("   ⊘ Skipped: %d", r.RoomsSkipped)))
 			}
+			if r.RoomsSkippedEmpty > 0 {
+				sections = append(sections, DimStyle.Render(fmt.Sprintf("   ⊘ Without messages: %d", r.RoomsSkippedEmpty)))
+			}
 			if r.RoomsFailed > 0 {
 				sections = append(sections, ErrorStyle.Render(fmt.Sprintf("   ✗ Failed: %d", r.RoomsFailed)))
 			}
 			sections = append(sections, "")
 		}
 
+		// Reconciliation of source and destination counts
+		if len(r.Reconciliation) > 0 {
+			sections = append(sections, SubtitleStyle.Render("⚖ Mattermost → Matrix:"))
+			for _, row := range r.Reconciliation {
+				line := fmt.Sprintf("   %-7s %d → %d", row.Kind+":", row.Source, row.Migrated)
+				if row.Excluded > 0 {
+					line += fmt.Sprintf(" (%d excluded)", row.Excluded)
+				}
+				if missing := row.Missing(); missing > 0 {
+					sections = append(sections, WarningStyle.Render(fmt.Sprintf("⚠%s, %d missing", line[1:], missing)))
+				} else {
+					sections = append(sections, SuccessStyle.Render(fmt.Sprintf("✓%s", line[1:])))
+				}
+			}
+			sections = append(sections, "")
+		}
+
 		// Membership import stats
 		if r.MembersAdded > 0 || r.MembersSkipped > 0 || r.MembersFailed > 0 {
 			sections = append(sections, SubtitleStyle.Render("👤 Memberships:"))
@@ -640,6 +882,9 @@ func (m Model) renderSuccess() string {
 			if r.MembersFailed > 0 {
 				sections = append(sections, ErrorStyle.Render(fmt.Sprintf("   ✗ Failed: %d", r.MembersFailed)))
 			}
+			if r.MembersPromoted > 0 {
+				sections = append(sections, SuccessStyle.Render(fmt.Sprintf("   ★ Admins promoted: %d", r.MembersPromoted)))
+			}
 			sections = append(sections, "")
 		}
 
@@ -783,23 +1028,33 @@ type operationCompleteMsg struct {
 }
 
 // Run commands for various operations
+// startOperation returns the context of a new operation; quitting the
+// progress view cancels it
+func (m *Model) startOperation() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.cancelling = false
+	return ctx
+}
+
 func (m *Model) runExportAssets() tea.Cmd {
+	ctx := m.startOperation()
 	return func() tea.Msg {
-		sendProgress("Connecting to Mattermost...", 0, 0, "")
+		m.progress.Send("Connecting to Mattermost...", 0, 0, "")
 
 		// Connect to Mattermost
-		if err := m.orchestrator.ConnectMattermost(); err != nil {
+		if err := m.orchestrator.ConnectMattermost(m.progress.Send); err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		sendProgress("Exporting assets...", 0, 0, "")
+		m.progress.Send("Exporting assets...", 0, 0, "")
 
 		// Run export with live progress updates
 		progress := func(stage string, current, total int, item string) {
-			sendProgress(stage, current, total, item)
+			m.progress.Send(stage, current, total, item)
 		}
 
-		result, err := m.orchestrator.ExportAssets(progress)
+		result, err := m.orchestrator.ExportAssets(ctx, progress)
 		if err != nil {
 			return operationCompleteMsg{err: err}
 		}
@@ -809,46 +1064,51 @@ func (m *Model) runExportAssets() tea.Cmd {
 }
 
 func (m *Model) runImportAssets() tea.Cmd {
+	ctx := m.startOperation()
 	return func() tea.Msg {
-		sendProgress("Connecting to Matrix...", 0, 0, "")
+		m.progress.Send("Connecting to Matrix...", 0, 0, "")
 
 		// Connect to Matrix
-		if err := m.orchestrator.ConnectMatrix(); err != nil {
+		if err := m.orchestrator.ConnectMatrix(ctx, m.progress.Send); err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		sendProgress("Importing assets...", 0, 0, "")
+		m.progress.Send("Importing assets...", 0, 0, "")
 
 		// Run import with live progress updates
 		progress := func(stage string, current, total int, item string) {
-			sendProgress(stage, current, total, item)
+			m.progress.Send(stage, current, total, item)
 		}
 
-		result, err := m.orchestrator.ImportAssets(progress)
+		result, err := m.orchestrator.ImportAssets(ctx, progress)
 		if err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
+		if result.DryRun {
+			return operationCompleteMsg{message: "Dry run: these assets would be imported (no mapping saved)", result: result}
+		}
 		return operationCompleteMsg{message: "Assets imported successfully!", result: result}
 	}
 }
 
 func (m *Model) runExportMemberships() tea.Cmd {
+	ctx := m.startOperation()
 	return func() tea.Msg {
-		sendProgress("Connecting to Mattermost...", 0, 0, "")
+		m.progress.Send("Connecting to Mattermost...", 0, 0, "")
 
 		// Connect if not already
-		if err := m.orchestrator.ConnectMattermost(); err != nil {
+		if err := m.orchestrator.ConnectMattermost(m.progress.Send); err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		sendProgress("Exporting memberships...", 0, 0, "")
+		m.progress.Send("Exporting memberships...", 0, 0, "")
 
 		progress := func(stage string, current, total int, item string) {
-			sendProgress(stage, current, total, item)
+			m.progress.Send(stage, current, total, item)
 		}
 
-		result, err := m.orchestrator.ExportMemberships(progress)
+		result, err := m.orchestrator.ExportMemberships(ctx, progress)
 		if err != nil {
 			return operationCompleteMsg{err: err}
 		}
@@ -858,76 +1118,97 @@ func (m *Model) runExportMemberships() tea.Cmd {
 }
 
 func (m *Model) runImportMemberships() tea.Cmd {
+	ctx := m.startOperation()
 	return func() tea.Msg {
-		sendProgress("Connecting to Matrix...", 0, 0, "")
+		m.progress.Send("Connecting to Matrix...", 0, 0, "")
 
 		// Connect if not already
-		if err := m.orchestrator.ConnectMatrix(); err != nil {
+		if err := m.orchestrator.ConnectMatrix(ctx, m.progress.Send); err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		sendProgress("Importing memberships...", 0, 0, "")
+		m.progress.Send("Importing memberships...", 0, 0, "")
 
 		progress := func(stage string, current, total int, item string) {
-			sendProgress(stage, current, total, item)
+			m.progress.Send(stage, current, total, item)
 		}
 
-		result, err := m.orchestrator.ImportMemberships(progress)
+		result, err := m.orchestrator.ImportMemberships(ctx, progress)
 		if err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
+		if result.DryRun {
+			return operationCompleteMsg{message: "Dry run: these memberships would be imported", result: result}
+		}
 		return operationCompleteMsg{message: "Memberships imported successfully!", result: result}
 	}
 }
 
 func (m *Model) runExportMessages() tea.Cmd {
+	ctx := m.startOperation()
 	return func() tea.Msg {
-		sendProgress("Connecting to Mattermost...", 0, 0, "")
+		m.progress.Send("Connecting to Mattermost...", 0, 0, "")
 
 		// Connect if not already
-		if err := m.orchestrator.ConnectMattermost(); err != nil {
+		if err := m.orchestrator.ConnectMattermost(m.progress.Send); err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		sendProgress("Exporting messages...", 0, 0, "")
+		m.progress.Send("Exporting messages...", 0, 0, "")
 
 		progress := func(stage string, current, total int, item string) {
-			sendProgress(stage, current, total, item)
+			m.progress.Send(stage, current, total, item)
 		}
 
-		result, err := m.orchestrator.ExportMessages(progress)
+		result, err := m.orchestrator.ExportMessages(ctx, progress)
 		if err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
 		msg := fmt.Sprintf("Messages exported: %d messages, %d files", result.MessagesExported, result.FilesExported)
+		if result.MessagesExcluded > 0 {
+			msg += fmt.Sprintf(" (%d excluded by skip_users)", result.MessagesExcluded)
+		}
 		return operationCompleteMsg{message: msg}
 	}
 }
 
 func (m *Model) runImportMessages() tea.Cmd {
+	ctx := m.startOperation()
 	return func() tea.Msg {
-		sendProgress("Connecting to Matrix...", 0, 0, "")
+		m.progress.Send("Connecting to Matrix...", 0, 0, "")
 
 		// Connect if not already
-		if err := m.orchestrator.ConnectMatrix(); err != nil {
+		if err := m.orchestrator.ConnectMatrix(ctx, m.progress.Send); err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		sendProgress("Importing messages...", 0, 0, "")
+		m.progress.Send("Importing messages...", 0, 0, "")
 
 		progress := func(current, total int, channelName, status string) {
-			sendProgress(fmt.Sprintf("Messages: %s", status), current, total, channelName)
+			m.progress.Send(fmt.Sprintf("Messages: %s", status), current, total, channelName)
 		}
 
-		result, err := m.orchestrator.ImportMessages(progress)
+		result, err := m.orchestrator.ImportMessages(ctx, progress)
 		if err != nil {
 			return operationCompleteMsg{err: err}
 		}
 
-		msg := fmt.Sprintf("Messages imported: %d imported, %d skipped, %d failed, %d files linked",
+		msg := fmt.Sprintf("Messages imported: %d imported, %d skipped (already imported), %d failed, %d files linked",
 			result.MessagesImported, result.MessagesSkipped, result.MessagesFailed, result.FilesLinked)
+		if result.FilesUploaded > 0 || result.FilesFailed > 0 {
+			msg += fmt.Sprintf(", %d files uploaded (%d failed)", result.FilesUploaded, result.FilesFailed)
+		}
+		if result.MessagesOversize > 0 {
+			msg += fmt.Sprintf(", %d oversized", result.MessagesOversize)
+		}
+		if result.MessagesEncrypted > 0 {
+			msg += fmt.Sprintf(", %d skipped in %d encrypted rooms", result.MessagesEncrypted, result.RoomsEncrypted)
+		}
+		if result.PinnedEvents > 0 {
+			msg += fmt.Sprintf(", %d pinned", result.PinnedEvents)
+		}
 		return operationCompleteMsg{message: msg}
 	}
 }
@@ -944,30 +1225,61 @@ func (m *Model) runTestConnection() tea.Cmd {
 	}
 }
 
-// programInstance holds the running program for sending messages from goroutines
-var programInstance *tea.Program
-
-// Run starts the TUI application
-func Run(cfg *config.Config) error {
-	model, err := NewModel(cfg)
+// Run starts the TUI application with the given run options
+func Run(cfg *config.Config, opts migration.RunOptions) error {
+	model, err := NewModel(cfg, opts)
 	if err != nil {
 		return err
 	}
 
-	programInstance = tea.NewProgram(model, tea.WithAltScreen())
-	_, err = programInstance.Run()
+	// The model only holds a pointer to the sender, so setting the program
+	// after the model is copied into it still reaches every copy
+	program := tea.NewProgram(model, tea.WithAltScreen())
+	model.progress.program = program
+	_, err = program.Run()
 	return err
 }
 
-// sendProgress sends a progress message to the TUI from a goroutine
-func sendProgress(stage string, current, total int, item string) {
-	if programInstance != nil {
-		programInstance.Send(progressMsg{
-			stage:   stage,
-			current: current,
-			total:   total,
-			item:    item,
-		})
+// progressInterval is the minimum time between two updates of the same
+// progress stage; more frequent ones are dropped so that large imports
+// don't flood the update loop
+const progressInterval = 50 * time.Millisecond
+
+// progressSender delivers progress from operation goroutines to the running
+// program as progressMsg, so the progress view redraws live
+type progressSender struct {
+	program *tea.Program
+
+	mu        sync.Mutex
+	lastStage string
+	lastSent  time.Time
+}
+
+// Send sends a progress message to the TUI; it's safe to call from any
+// goroutine and can be used as a migration.ProgressCallback
+func (s *progressSender) Send(stage string, current, total int, item string) {
+	if s == nil || s.program == nil {
+		return
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	// Always deliver new stages and the final update of a stage
+	drop := stage == s.lastStage && current < total && now.Sub(s.lastSent) < progressInterval
+	if !drop {
+		s.lastStage = stage
+		s.lastSent = now
+	}
+	s.mu.Unlock()
+	if drop {
+		return
 	}
+
+	s.program.Send(progressMsg{
+		stage:   stage,
+		current: current,
+		total:   total,
+		item:    item,
+	})
 }
 
@@ -0,0 +1,186 @@
+package tui
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/matrix"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+// needsConfirm returns true if a step changes the homeserver and should be
+// confirmed first; dry runs change nothing
+func (m Model) needsConfirm(view View) bool {
+	return !m.dryRun && (view == ViewImportAssets || view == ViewImportMemberships)
+}
+
+// openConfirm shows what an import step is about to do and asks before
+// running it. Cancel is selected, so a stray enter does nothing.
+func (m *Model) openConfirm(target View) {
+	homeserver := m.config.Matrix.Homeserver
+
+	switch target {
+	case ViewImportAssets:
+		plan, err := m.orchestrator.PlanAssets(matrix.DefaultImportAssetsOptions())
+		if err != nil {
+			m.confirmText = fmt.Sprintf("About to import assets to %s.", homeserver)
+			m.confirmDetail = err.Error()
+			break
+		}
+		m.confirmText = fmt.Sprintf("About to create %d users, %d spaces and %d rooms on %s.",
+			plan.Users, plan.Spaces, plan.Rooms, homeserver)
+		m.confirmDetail = ""
+		if plan.UsersMapped+plan.SpacesMapped+plan.RoomsMapped > 0 {
+			m.confirmDetail = fmt.Sprintf("Already imported and skipped: %d users, %d spaces, %d rooms",
+				plan.UsersMapped, plan.SpacesMapped, plan.RoomsMapped)
+		}
+
+	case ViewImportMemberships:
+		plan, err := m.orchestrator.PlanMemberships()
+		if err != nil {
+			m.confirmText = fmt.Sprintf("About to apply memberships on %s.", homeserver)
+			m.confirmDetail = err.Error()
+			break
+		}
+		m.confirmText = fmt.Sprintf("About to apply %d team and %d channel memberships on %s.",
+			plan.TeamMappable, plan.ChannelMappable, homeserver)
+		m.confirmDetail = ""
+		if skipped := plan.TeamUnmappable + plan.ChannelUnmappable; skipped > 0 {
+			m.confirmDetail = fmt.Sprintf("%d memberships can't be mapped and are skipped", skipped)
+		}
+	}
+
+	m.confirmTarget = target
+	m.confirmReset = ""
+	m.confirmYes = false
+	m.view = ViewConfirm
+}
+
+// openResetConfirm asks before setting a step back to pending
+func (m *Model) openResetConfirm(step migration.StepName) {
+	state := m.orchestrator.GetState()
+	if state.GetStep(step).Status == migration.StatusPending && state.GetStepOutputFile(step) == "" {
+		return
+	}
+
+	m.confirmText = fmt.Sprintf("Reset %s to pending so it can be run again?", step)
+	m.confirmDetail = "Export files, mappings and the homeserver are left as they are."
+	m.confirmTarget = ViewStatus
+	m.confirmReset = step
+	m.confirmYes = false
+	m.view = ViewConfirm
+}
+
+// runReset resets the confirmed step and reports the completed steps that
+// now lack a prerequisite
+func (m Model) runReset() (tea.Model, tea.Cmd) {
+	step := m.confirmReset
+	m.confirmReset = ""
+
+	stale, err := m.orchestrator.ResetStep(step)
+	if err != nil {
+		m.errorMessage = err.Error()
+		m.errorDetail = nil
+		m.view = ViewError
+		return m, nil
+	}
+
+	m.successMessage = fmt.Sprintf("%s reset to pending", step)
+	for _, name := range stale {
+		m.successMessage += fmt.Sprintf("\n%s is completed but its prerequisites no longer are; reset it too to run it again", name)
+	}
+	m.operationResult = nil
+	m.menuItems = m.createMenuItems()
+	m.view = ViewSuccess
+	return m, nil
+}
+
+// handleConfirmKey handles keyboard input on the confirmation dialog
+func (m Model) handleConfirmKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.quitting = true
+		return m, tea.Quit
+
+	case "left", "right", "tab", "h", "l":
+		m.confirmYes = !m.confirmYes
+
+	case "y":
+		return m.runConfirmed()
+
+	case "n", "esc", "q":
+		m.view = m.cancelConfirmView()
+
+	case "enter", " ":
+		if m.confirmYes {
+			return m.runConfirmed()
+		}
+		m.view = m.cancelConfirmView()
+	}
+
+	return m, nil
+}
+
+// cancelConfirmView returns the view to go back to when the dialog is cancelled
+func (m Model) cancelConfirmView() View {
+	if m.confirmReset != "" {
+		return ViewStatus
+	}
+	return ViewMenu
+}
+
+// runConfirmed runs the confirmed step
+func (m Model) runConfirmed() (tea.Model, tea.Cmd) {
+	if m.confirmReset != "" {
+		return m.runReset()
+	}
+	m.previousView = ViewMenu
+	m.view = m.confirmTarget
+	cmd := m.handleViewChange(m.confirmTarget)
+	return m, cmd
+}
+
+// renderConfirm renders the confirmation dialog
+func (m Model) renderConfirm() string {
+	locale := i18n.Current()
+
+	title := locale.Menu.ImportAssets
+	if m.confirmReset != "" {
+		title = "Reset step"
+	} else if m.confirmTarget == ViewImportMemberships {
+		title = locale.Menu.ImportMemberships
+	}
+
+	confirm, cancel := ButtonStyle, ButtonActiveStyle
+	if m.confirmYes {
+		confirm, cancel = ButtonActiveStyle, ButtonStyle
+	}
+	buttons := lipgloss.JoinHorizontal(lipgloss.Top,
+		confirm.Render(locale.Menu.Confirm),
+		cancel.Render(locale.Menu.Cancel),
+	)
+
+	lines := []string{
+		TitleStyle.Render(title),
+		"",
+		m.confirmText,
+	}
+	if m.confirmDetail != "" {
+		lines = append(lines, MutedStyle.Render(m.confirmDetail))
+	}
+	lines = append(lines,
+		"",
+		WarningStyle.Render(locale.Messages.ConfirmProceed),
+		"",
+		buttons,
+	)
+
+	content := DialogBoxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	help := HelpStyle.Render("y: confirm • n/esc: cancel • ←/→: select • enter: choose")
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Center, content, help))
+}
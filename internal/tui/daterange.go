@@ -0,0 +1,112 @@
+package tui
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+// Fields of the message date range form
+const (
+	rangeFieldSince = iota
+	rangeFieldUntil
+	rangeFieldCount
+)
+
+// openMessageRange shows the date range form before running a message step.
+// The previous input is kept, so export and import can use the same range.
+func (m *Model) openMessageRange(target View) {
+	m.rangeTarget = target
+	m.rangeFocus = rangeFieldSince
+	m.rangeErr = ""
+	m.view = ViewMessageRange
+}
+
+// handleRangeKey handles keyboard input on the date range form
+func (m Model) handleRangeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		m.quitting = true
+		return m, tea.Quit
+
+	case tea.KeyEsc:
+		m.view = ViewMenu
+
+	case tea.KeyTab, tea.KeyDown:
+		m.rangeFocus = (m.rangeFocus + 1) % rangeFieldCount
+
+	case tea.KeyShiftTab, tea.KeyUp:
+		m.rangeFocus = (m.rangeFocus + rangeFieldCount - 1) % rangeFieldCount
+
+	case tea.KeyBackspace:
+		if runes := []rune(m.rangeInputs[m.rangeFocus]); len(runes) > 0 {
+			m.rangeInputs[m.rangeFocus] = string(runes[:len(runes)-1])
+		}
+
+	case tea.KeyCtrlU:
+		m.rangeInputs[m.rangeFocus] = ""
+
+	case tea.KeyRunes:
+		m.rangeInputs[m.rangeFocus] += string(msg.Runes)
+
+	case tea.KeyEnter:
+		r, err := migration.ParseMessageRange(m.rangeInputs[rangeFieldSince], m.rangeInputs[rangeFieldUntil], time.Now())
+		if err != nil {
+			m.rangeErr = err.Error()
+			return m, nil
+		}
+		m.rangeErr = ""
+		m.orchestrator.SetRunOptions(migration.RunOptions{MessageRange: r, DryRun: m.dryRun})
+		m.previousView = ViewMenu
+		m.view = m.rangeTarget
+		cmd := m.handleViewChange(m.rangeTarget)
+		return m, cmd
+	}
+
+	return m, nil
+}
+
+// renderMessageRange renders the date range form
+func (m Model) renderMessageRange() string {
+	locale := i18n.Current()
+
+	title := locale.Menu.ImportMessages
+	if m.rangeTarget == ViewExportMessages {
+		title = locale.Menu.ExportMessages
+	}
+
+	labels := [rangeFieldCount]string{"From: ", "To:   "}
+	lines := []string{
+		TitleStyle.Render(title),
+		MutedStyle.Render("Limit the messages to a date range"),
+		"",
+	}
+	for field := 0; field < rangeFieldCount; field++ {
+		value := m.rangeInputs[field]
+		if field == m.rangeFocus {
+			lines = append(lines, PrimaryStyle.Render("› "+labels[field])+value+"▌")
+		} else {
+			if value == "" {
+				value = DimStyle.Render("(no limit)")
+			}
+			lines = append(lines, "  "+labels[field]+value)
+		}
+	}
+	lines = append(lines,
+		"",
+		DimStyle.Render("YYYY-MM-DD, or days ago such as 90d. Leave empty for no limit."),
+	)
+	if m.rangeErr != "" {
+		lines = append(lines, "", ErrorStyle.Render(m.rangeErr))
+	}
+
+	content := BoxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	help := HelpStyle.Render("tab: switch field • ctrl+u: clear • enter: run • esc: back")
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Center, content, help))
+}
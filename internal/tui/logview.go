@@ -0,0 +1,185 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/logger"
+)
+
+const (
+	// logViewMaxLines is how many lines of the log file the viewer keeps
+	logViewMaxLines = 500
+	// logViewTailBytes is how much of the end of the log file is read per refresh
+	logViewTailBytes = 256 * 1024
+	// logViewRefresh is how often the log viewer re-reads the log file
+	logViewRefresh = time.Second
+)
+
+// logTickMsg triggers a refresh of the log viewer.
+// gen identifies the viewer session, so stale refresh loops stop.
+type logTickMsg struct {
+	gen int
+}
+
+// logLoadedMsg carries freshly read log lines
+type logLoadedMsg struct {
+	gen   int
+	lines []string
+	err   error
+}
+
+// scheduleLogRefresh schedules the next log viewer refresh
+func scheduleLogRefresh(gen int) tea.Cmd {
+	return tea.Tick(logViewRefresh, func(time.Time) tea.Msg {
+		return logTickMsg{gen: gen}
+	})
+}
+
+// loadLogLines reads the end of the log file in the background
+func loadLogLines(gen int) tea.Cmd {
+	return func() tea.Msg {
+		path := logger.Path()
+		if path == "" {
+			return logLoadedMsg{gen: gen, err: fmt.Errorf("logging is not enabled")}
+		}
+		lines, err := tailFile(path, logViewMaxLines, logViewTailBytes)
+		return logLoadedMsg{gen: gen, lines: lines, err: err}
+	}
+}
+
+// tailFile returns up to maxLines last lines of the file, reading at most maxBytes from its end
+func tailFile(path string, maxLines int, maxBytes int64) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := info.Size() - maxBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		// The first line is most likely cut off
+		lines = lines[1:]
+	}
+	if len(lines) > maxLines {
+		lines = lines[len(lines)-maxLines:]
+	}
+	return lines, nil
+}
+
+// logViewHeight returns how many log lines fit on screen
+func (m Model) logViewHeight() int {
+	height := m.height - 10
+	if height < 5 {
+		height = 5
+	}
+	return height
+}
+
+// scrollLogs moves the log viewer by delta lines (positive scrolls back in time)
+func (m *Model) scrollLogs(delta int) {
+	maxScroll := len(m.logLines) - m.logViewHeight()
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	m.logScroll += delta
+	if m.logScroll > maxScroll {
+		m.logScroll = maxScroll
+	}
+	if m.logScroll < 0 {
+		m.logScroll = 0
+	}
+}
+
+// renderLogs renders the log viewer
+func (m Model) renderLogs() string {
+	locale := i18n.Current()
+
+	var body string
+	switch {
+	case m.logErr != "":
+		body = ErrorStyle.Render(m.logErr)
+	case len(m.logLines) == 0:
+		body = DimStyle.Render("Log is empty")
+	default:
+		height := m.logViewHeight()
+		end := len(m.logLines) - m.logScroll
+		start := end - height
+		if start < 0 {
+			start = 0
+		}
+
+		width := m.width - 8
+		if width < 20 {
+			width = 20
+		}
+
+		rendered := make([]string, 0, end-start)
+		for _, line := range m.logLines[start:end] {
+			if runes := []rune(line); len(runes) > width {
+				line = string(runes[:width])
+			}
+			rendered = append(rendered, logLineStyle(line).Render(line))
+		}
+		body = strings.Join(rendered, "\n")
+	}
+
+	position := "following"
+	if m.logScroll > 0 {
+		position = fmt.Sprintf("%d lines up", m.logScroll)
+	}
+
+	content := BoxStyle.Render(
+		lipgloss.JoinVertical(
+			lipgloss.Left,
+			TitleStyle.Render(locale.Menu.Logs),
+			MutedStyle.Render(logger.Path()+" • "+position),
+			"",
+			body,
+		),
+	)
+
+	help := HelpStyle.Render("↑/↓: scroll • pgup/pgdn: page • g/G: top/bottom • esc: back")
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Center, content, help))
+}
+
+// logLineStyle picks a style based on the log level of a line
+func logLineStyle(line string) lipgloss.Style {
+	switch {
+	case strings.Contains(line, "] ERROR:"):
+		return ErrorStyle
+	case strings.Contains(line, "] WARN:"):
+		return WarningStyle
+	case strings.Contains(line, "] OK:"):
+		return SuccessStyle
+	default:
+		return lipgloss.NewStyle()
+	}
+}
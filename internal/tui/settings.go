@@ -0,0 +1,288 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/aligundogdu/matrixmigrate/internal/config"
+	"github.com/aligundogdu/matrixmigrate/internal/i18n"
+	"github.com/aligundogdu/matrixmigrate/internal/migration"
+)
+
+// Fields of the settings form
+const (
+	settingsFieldLanguage = iota
+	settingsFieldHomeserver
+	settingsFieldRateLimit
+	settingsFieldAssetsDir
+	settingsFieldMappingsDir
+	settingsFieldStateFile
+	settingsFieldCount
+)
+
+// settingsLabels are the labels of the settings form fields
+var settingsLabels = [settingsFieldCount]string{
+	"Language",
+	"Homeserver",
+	"Requests/sec",
+	"Assets dir",
+	"Mappings dir",
+	"State file",
+}
+
+// openSettings shows the settings form filled with the active config
+func (m *Model) openSettings() {
+	m.settingsInputs = [settingsFieldCount]string{
+		settingsFieldLanguage:    i18n.CurrentLanguage(),
+		settingsFieldHomeserver:  m.config.Matrix.Homeserver,
+		settingsFieldRateLimit:   strconv.FormatFloat(m.config.Matrix.RateLimit.RequestsPerSecond, 'f', -1, 64),
+		settingsFieldAssetsDir:   m.config.Data.AssetsDir,
+		settingsFieldMappingsDir: m.config.Data.MappingsDir,
+		settingsFieldStateFile:   m.config.Data.StateFile,
+	}
+	m.settingsInitial = m.settingsInputs
+	m.settingsLanguage = i18n.CurrentLanguage()
+	m.settingsFocus = settingsFieldLanguage
+	m.settingsErr = ""
+	m.settingsSaved = ""
+	m.view = ViewSettings
+}
+
+// settingsLocked returns true if a field can't be edited: the homeserver
+// and data locations are fixed once the migration has started, since the
+// state and mappings refer to them
+func (m Model) settingsLocked(field int) bool {
+	switch field {
+	case settingsFieldHomeserver, settingsFieldAssetsDir, settingsFieldMappingsDir, settingsFieldStateFile:
+		summary := m.orchestrator.GetState().Summary()
+		return summary.InProgress+summary.Completed+summary.Failed+summary.Cancelled+summary.Skipped > 0
+	}
+	return false
+}
+
+// handleSettingsKey handles keyboard input on the settings form
+func (m Model) handleSettingsKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		m.quitting = true
+		return m, tea.Quit
+
+	case tea.KeyEsc:
+		// Unsaved changes are dropped, including a language tried out live
+		if i18n.CurrentLanguage() != m.settingsLanguage {
+			m.setLanguage(m.settingsLanguage)
+		}
+		m.view = ViewMenu
+
+	case tea.KeyTab, tea.KeyDown:
+		m.settingsFocus = (m.settingsFocus + 1) % settingsFieldCount
+
+	case tea.KeyShiftTab, tea.KeyUp:
+		m.settingsFocus = (m.settingsFocus + settingsFieldCount - 1) % settingsFieldCount
+
+	case tea.KeyLeft, tea.KeyRight:
+		if m.settingsFocus == settingsFieldLanguage {
+			step := 1
+			if msg.Type == tea.KeyLeft {
+				step = -1
+			}
+			m.cycleLanguage(step)
+		}
+
+	case tea.KeyBackspace:
+		if m.settingsFocus == settingsFieldLanguage || m.settingsLocked(m.settingsFocus) {
+			return m, nil
+		}
+		if runes := []rune(m.settingsInputs[m.settingsFocus]); len(runes) > 0 {
+			m.settingsInputs[m.settingsFocus] = string(runes[:len(runes)-1])
+		}
+
+	case tea.KeyCtrlU:
+		if m.settingsFocus == settingsFieldLanguage || m.settingsLocked(m.settingsFocus) {
+			return m, nil
+		}
+		m.settingsInputs[m.settingsFocus] = ""
+
+	case tea.KeyRunes, tea.KeySpace:
+		if m.settingsFocus == settingsFieldLanguage {
+			if msg.Type == tea.KeySpace {
+				m.cycleLanguage(1)
+			}
+			return m, nil
+		}
+		if m.settingsLocked(m.settingsFocus) {
+			return m, nil
+		}
+		m.settingsInputs[m.settingsFocus] += string(msg.Runes)
+
+	case tea.KeyEnter:
+		m.saveSettings()
+	}
+
+	return m, nil
+}
+
+// cycleLanguage switches to the next (step 1) or previous (step -1)
+// supported language; the UI re-renders in it right away
+func (m *Model) cycleLanguage(step int) {
+	languages := i18n.GetSupportedLanguages()
+	current := 0
+	for i, lang := range languages {
+		if lang == m.settingsInputs[settingsFieldLanguage] {
+			current = i
+		}
+	}
+	lang := languages[(current+step+len(languages))%len(languages)]
+	m.settingsInputs[settingsFieldLanguage] = lang
+	m.setLanguage(lang)
+}
+
+// setLanguage switches the UI language and rebuilds the translated menu
+func (m *Model) setLanguage(lang string) {
+	if err := i18n.Init(lang); err != nil {
+		m.settingsErr = err.Error()
+		return
+	}
+	m.menuItems = m.createMenuItems()
+}
+
+// saveSettings validates the form, writes it to the config file and applies
+// it to the running application
+func (m *Model) saveSettings() {
+	m.settingsErr = ""
+	m.settingsSaved = ""
+
+	inputs := m.settingsInputs
+	for i := range inputs {
+		inputs[i] = strings.TrimSpace(inputs[i])
+	}
+	rate, err := strconv.ParseFloat(inputs[settingsFieldRateLimit], 64)
+	if err != nil || rate < 0 {
+		m.settingsErr = "Requests/sec must be a number, 0 or more (0: no limit)"
+		return
+	}
+	for _, field := range []int{settingsFieldHomeserver, settingsFieldAssetsDir, settingsFieldMappingsDir, settingsFieldStateFile} {
+		if inputs[field] == "" {
+			m.settingsErr = settingsLabels[field] + " must not be empty"
+			return
+		}
+	}
+
+	values := map[string]interface{}{
+		"language":                              inputs[settingsFieldLanguage],
+		"matrix.rate_limit.requests_per_second": rate,
+	}
+	// Only edited locations are written: the form is filled from the runtime
+	// config, which has --output-dir and ~ expansion applied
+	changed := func(field int) bool {
+		return inputs[field] != strings.TrimSpace(m.settingsInitial[field])
+	}
+	dataChanged := false
+	if !m.settingsLocked(settingsFieldHomeserver) {
+		for field, key := range map[int]string{
+			settingsFieldHomeserver:  "matrix.homeserver",
+			settingsFieldAssetsDir:   "data.assets_dir",
+			settingsFieldMappingsDir: "data.mappings_dir",
+			settingsFieldStateFile:   "data.state_file",
+		} {
+			if changed(field) {
+				values[key] = inputs[field]
+			}
+		}
+		dataChanged = changed(settingsFieldAssetsDir) || changed(settingsFieldMappingsDir) || changed(settingsFieldStateFile)
+	}
+
+	if err := config.SaveSettings(m.config.File, values); err != nil {
+		m.settingsErr = err.Error()
+		return
+	}
+
+	// Apply to the running application; connections made from now on use it
+	m.config.Language = inputs[settingsFieldLanguage]
+	m.settingsLanguage = inputs[settingsFieldLanguage]
+	m.settingsInitial = inputs
+	m.config.Matrix.RateLimit.RequestsPerSecond = rate
+	if _, ok := values["matrix.homeserver"]; ok {
+		m.config.Matrix.Homeserver = inputs[settingsFieldHomeserver]
+	}
+	if dataChanged {
+		m.config.SetDataDirs(inputs[settingsFieldAssetsDir], inputs[settingsFieldMappingsDir], inputs[settingsFieldStateFile])
+		if err := m.reloadOrchestrator(); err != nil {
+			m.settingsErr = fmt.Sprintf("Saved, but the new data directories can't be used: %v", err)
+			return
+		}
+	}
+	m.settingsSaved = fmt.Sprintf("Saved to %s (previous version in %s.bak)", m.config.File, m.config.File)
+}
+
+// reloadOrchestrator creates the data directories and recreates the
+// orchestrator, so it reads the state from the configured locations
+func (m *Model) reloadOrchestrator() error {
+	if err := m.config.EnsureDataDirs(); err != nil {
+		return err
+	}
+	orchestrator, err := migration.NewOrchestrator(m.config)
+	if err != nil {
+		return err
+	}
+	orchestrator.SetRunOptions(migration.RunOptions{DryRun: m.dryRun})
+	m.orchestrator.Close()
+	m.orchestrator = orchestrator
+	m.menuItems = m.createMenuItems()
+	return nil
+}
+
+// renderSettings renders the settings form
+func (m Model) renderSettings() string {
+	locale := i18n.Current()
+
+	file := m.config.File
+	if file == "" {
+		file = "(none, using defaults)"
+	}
+	lines := []string{
+		TitleStyle.Render(locale.Menu.Settings),
+		MutedStyle.Render("Config file: " + file),
+		"",
+	}
+	for field := 0; field < settingsFieldCount; field++ {
+		label := fmt.Sprintf("%-14s", settingsLabels[field]+":")
+		value := m.settingsInputs[field]
+		if field == settingsFieldLanguage {
+			value = "‹ " + value + " ›"
+		}
+		suffix := ""
+		if m.settingsLocked(field) {
+			suffix = DimStyle.Render(" (locked, migration started)")
+		}
+		if field == m.settingsFocus {
+			cursor := "▌"
+			if field == settingsFieldLanguage || m.settingsLocked(field) {
+				cursor = ""
+			}
+			lines = append(lines, PrimaryStyle.Render("› "+label)+value+cursor+suffix)
+		} else {
+			lines = append(lines, "  "+label+value+suffix)
+		}
+	}
+	lines = append(lines,
+		"",
+		DimStyle.Render("Saving rewrites the config file without its comments."),
+	)
+	if m.settingsErr != "" {
+		lines = append(lines, "", ErrorStyle.Render(m.settingsErr))
+	}
+	if m.settingsSaved != "" {
+		lines = append(lines, "", SuccessStyle.Render(m.settingsSaved))
+	}
+
+	content := BoxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	help := HelpStyle.Render("tab: switch field • ←/→: language • enter: save • esc: back")
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Center, content, help))
+}
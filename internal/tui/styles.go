@@ -237,7 +237,7 @@ func GetStatusStyle(status string) lipgloss.Style {
 		return StatusInProgressStyle
 	case "completed":
 		return StatusCompletedStyle
-	case "failed":
+	case "failed", "cancelled":
 		return StatusFailedStyle
 	case "skipped":
 		return StatusSkippedStyle
@@ -255,7 +255,7 @@ func GetStatusIcon(status string) string {
 		return IconProgress
 	case "completed":
 		return IconCheck
-	case "failed":
+	case "failed", "cancelled":
 		return IconCross
 	case "skipped":
 		return IconWarning
@@ -7,25 +7,55 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
+var (
+	gzipMu    sync.RWMutex
+	gzipLevel = gzip.DefaultCompression
+)
+
+// SetGzipLevel sets the compression level used when writing gzip files
+func SetGzipLevel(level int) error {
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("invalid gzip level %d", level)
+	}
+	gzipMu.Lock()
+	defer gzipMu.Unlock()
+	gzipLevel = level
+	return nil
+}
+
+// GzipLevel returns the compression level used when writing gzip files
+func GzipLevel() int {
+	gzipMu.RLock()
+	defer gzipMu.RUnlock()
+	return gzipLevel
+}
+
 // SaveGzipJSON saves data as gzipped JSON
 func SaveGzipJSON(filePath string, data interface{}) error {
 	// Ensure directory exists
 	dir := filepath.Dir(filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, DirMode()); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
 	// Create file
-	file, err := os.Create(filePath)
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FileMode())
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
+	if err := file.Chmod(FileMode()); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
 
 	// Create gzip writer
-	gzWriter := gzip.NewWriter(file)
+	gzWriter, err := gzip.NewWriterLevel(file, GzipLevel())
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %w", err)
+	}
 	defer gzWriter.Close()
 
 	// Encode JSON
@@ -80,7 +110,10 @@ func CompressFile(srcPath, dstPath string) error {
 	defer dst.Close()
 
 	// Create gzip writer
-	gzWriter := gzip.NewWriter(dst)
+	gzWriter, err := gzip.NewWriterLevel(dst, GzipLevel())
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %w", err)
+	}
 	defer gzWriter.Close()
 
 	// Copy data
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultFileMode is the permission used for exported data files
+	DefaultFileMode os.FileMode = 0600
+
+	// DefaultDirMode is the permission used for data directories
+	DefaultDirMode os.FileMode = 0700
+)
+
+var (
+	permMu   sync.RWMutex
+	fileMode = DefaultFileMode
+	dirMode  = DefaultDirMode
+)
+
+// SetPermissions sets the modes used for files and directories written by this package
+func SetPermissions(file, dir os.FileMode) {
+	permMu.Lock()
+	defer permMu.Unlock()
+	fileMode = file
+	dirMode = dir
+}
+
+// FileMode returns the mode used for data files
+func FileMode() os.FileMode {
+	permMu.RLock()
+	defer permMu.RUnlock()
+	return fileMode
+}
+
+// DirMode returns the mode used for data directories
+func DirMode() os.FileMode {
+	permMu.RLock()
+	defer permMu.RUnlock()
+	return dirMode
+}
+
+// WriteFile writes data to filePath, creating parent directories as needed.
+// The data is written to a temporary file first and renamed into place,
+// so readers never see a partially written file.
+func WriteFile(filePath string, data []byte) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, DirMode()); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(FileMode()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GzipJSONStreamWriter writes a gzipped JSON object field by field. Array
+// fields are written one element at a time, so large exports don't have to
+// be held in memory. The result reads like a file written by SaveGzipJSON.
+type GzipJSONStreamWriter struct {
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	path    string
+	fields  int  // Fields written so far
+	inArray bool // An array field is open
+	items   int  // Elements written to the open array
+	err     error
+}
+
+// NewGzipJSONStreamWriter creates filePath and starts the JSON object
+func NewGzipJSONStreamWriter(filePath string) (*GzipJSONStreamWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), DirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FileMode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	if err := file.Chmod(FileMode()); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	gz, err := gzip.NewWriterLevel(file, GzipLevel())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+
+	w := &GzipJSONStreamWriter{file: file, gz: gz, buf: bufio.NewWriter(gz), path: filePath}
+	w.write([]byte("{"))
+	return w, w.err
+}
+
+// write writes raw JSON, remembering the first error
+func (w *GzipJSONStreamWriter) write(data []byte) {
+	if w.err == nil {
+		_, w.err = w.buf.Write(data)
+	}
+}
+
+// writeKey starts a new field of the object
+func (w *GzipJSONStreamWriter) writeKey(name string) error {
+	if w.inArray {
+		return fmt.Errorf("field %q written while an array is open", name)
+	}
+	key, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	if w.fields > 0 {
+		w.write([]byte(",\n"))
+	}
+	w.write(key)
+	w.write([]byte(":"))
+	w.fields++
+	return w.err
+}
+
+// WriteField writes a complete field of the object
+func (w *GzipJSONStreamWriter) WriteField(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	if err := w.writeKey(name); err != nil {
+		return err
+	}
+	w.write(data)
+	return w.err
+}
+
+// BeginArray starts an array field; add its elements with WriteItem and
+// finish it with EndArray
+func (w *GzipJSONStreamWriter) BeginArray(name string) error {
+	if err := w.writeKey(name); err != nil {
+		return err
+	}
+	w.write([]byte("["))
+	w.inArray = true
+	w.items = 0
+	return w.err
+}
+
+// WriteItem appends an element to the open array
+func (w *GzipJSONStreamWriter) WriteItem(value interface{}) error {
+	if !w.inArray {
+		return fmt.Errorf("array element written outside an array")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode array element: %w", err)
+	}
+	if w.items > 0 {
+		w.write([]byte(",\n"))
+	} else {
+		w.write([]byte("\n"))
+	}
+	w.write(data)
+	w.items++
+	return w.err
+}
+
+// EndArray finishes the open array
+func (w *GzipJSONStreamWriter) EndArray() error {
+	if !w.inArray {
+		return fmt.Errorf("no array to end")
+	}
+	w.write([]byte("]"))
+	w.inArray = false
+	return w.err
+}
+
+// Close finishes the object and closes the file
+func (w *GzipJSONStreamWriter) Close() error {
+	if w.inArray {
+		w.EndArray()
+	}
+	w.write([]byte("}\n"))
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	if err := w.gz.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return fmt.Errorf("failed to write %s: %w", w.path, w.err)
+	}
+	return nil
+}
+
+// Discard closes the file and removes it, for an export that failed
+func (w *GzipJSONStreamWriter) Discard() {
+	w.gz.Close()
+	w.file.Close()
+	os.Remove(w.path)
+}
+
+// GzipJSONStreamReader reads a gzipped JSON object field by field, so array
+// fields can be decoded one element at a time instead of all at once.
+// It reads files written by SaveGzipJSON and GzipJSONStreamWriter alike.
+type GzipJSONStreamReader struct {
+	file    *os.File
+	gz      *gzip.Reader
+	dec     *json.Decoder
+	started bool
+}
+
+// NewGzipJSONStreamReader opens filePath for reading
+func NewGzipJSONStreamReader(filePath string) (*GzipJSONStreamReader, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+
+	return &GzipJSONStreamReader{file: file, gz: gz, dec: json.NewDecoder(bufio.NewReader(gz))}, nil
+}
+
+// NextField returns the name of the next field of the object, or false
+// after the last one. The field's value must be read with Decode, Skip or
+// ReadArray before calling NextField again.
+func (r *GzipJSONStreamReader) NextField() (string, bool, error) {
+	if !r.started {
+		if err := r.expectDelim('{'); err != nil {
+			return "", false, err
+		}
+		r.started = true
+	}
+	if !r.dec.More() {
+		return "", false, r.expectDelim('}')
+	}
+
+	token, err := r.dec.Token()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	name, ok := token.(string)
+	if !ok {
+		return "", false, fmt.Errorf("failed to decode JSON: expected a field name, got %v", token)
+	}
+	return name, true, nil
+}
+
+// Decode decodes the value of the current field into v
+func (r *GzipJSONStreamReader) Decode(v interface{}) error {
+	if err := r.dec.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return nil
+}
+
+// Skip reads past the value of the current field
+func (r *GzipJSONStreamReader) Skip() error {
+	var raw json.RawMessage
+	return r.Decode(&raw)
+}
+
+// expectDelim reads a delimiter token
+func (r *GzipJSONStreamReader) expectDelim(delim json.Delim) error {
+	token, err := r.dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("failed to decode JSON: expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// Close closes the file
+func (r *GzipJSONStreamReader) Close() error {
+	r.gz.Close()
+	return r.file.Close()
+}
+
+// ReadArray decodes the array value of the current field one element at a
+// time and passes each to fn. A null value is read as an empty array.
+func ReadArray[T any](r *GzipJSONStreamReader, fn func(T) error) error {
+	token, err := r.dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("failed to decode JSON: expected an array, got %v", token)
+	}
+
+	for r.dec.More() {
+		var item T
+		if err := r.dec.Decode(&item); err != nil {
+			return fmt.Errorf("failed to decode JSON: %w", err)
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return r.expectDelim(']')
+}